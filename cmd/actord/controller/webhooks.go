@@ -154,6 +154,6 @@ func (this *controller) invokeWebhookExecutor(topic string, wg *sync.WaitGroup,
 		log.Info("de-claimed owner of %s", topic)
 	}(topic)
 
-	exe := executor.NewWebhookExecutor(this.shortId, hook.Cluster, topic, hook.Endpoints, stopper, this.auditor)
+	exe := executor.NewWebhookExecutor(this.shortId, hook.Cluster, topic, hook.Endpoints, hook.Filter, stopper, this.auditor)
 	exe.Run()
 }
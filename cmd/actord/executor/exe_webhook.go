@@ -12,6 +12,8 @@ import (
 
 	"github.com/Shopify/sarama"
 	"github.com/funkygao/gafka"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+	"github.com/funkygao/gafka/cmd/kateway/filter"
 	"github.com/funkygao/gafka/cmd/kateway/gateway"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
 	"github.com/funkygao/gafka/cmd/kateway/meta"
@@ -30,29 +32,32 @@ type WebhookExecutor struct {
 	parentId       string // controller short id
 	cluster, topic string
 	endpoints      []string
+	filterExpr     string
 	stopper        <-chan struct{}
 	auditor        log.Logger
 
 	appid, appSignature, userAgent string
 
 	circuits   map[string]*breaker.Consecutive
+	msgFilter  *filter.Filter
 	fetcher    *consumergroup.ConsumerGroup
 	msgCh      chan *sarama.ConsumerMessage
 	httpClient *http.Client // it has builtin pooling
 }
 
-func NewWebhookExecutor(parentId, cluster, topic string, endpoints []string,
+func NewWebhookExecutor(parentId, cluster, topic string, endpoints []string, filterExpr string,
 	stopper <-chan struct{}, auditor log.Logger) *WebhookExecutor {
 	this := &WebhookExecutor{
-		parentId:  parentId,
-		cluster:   cluster,
-		topic:     topic,
-		stopper:   stopper,
-		endpoints: endpoints,
-		auditor:   auditor,
-		userAgent: fmt.Sprintf("actor.%s", gafka.BuildId),
-		msgCh:     make(chan *sarama.ConsumerMessage, 20),
-		circuits:  make(map[string]*breaker.Consecutive, len(endpoints)),
+		parentId:   parentId,
+		cluster:    cluster,
+		topic:      topic,
+		stopper:    stopper,
+		endpoints:  endpoints,
+		filterExpr: filterExpr,
+		auditor:    auditor,
+		userAgent:  fmt.Sprintf("actor.%s", gafka.BuildId),
+		msgCh:      make(chan *sarama.ConsumerMessage, 20),
+		circuits:   make(map[string]*breaker.Consecutive, len(endpoints)),
 		httpClient: &http.Client{
 			Timeout: time.Second * 4,
 			Transport: &http.Transport{
@@ -84,6 +89,14 @@ func (this *WebhookExecutor) Run() {
 		return
 	}
 
+	if this.filterExpr != "" {
+		var err error
+		if this.msgFilter, err = filter.Compile(this.filterExpr); err != nil {
+			log.Warn("%s disabled webhook: %s %v", this.topic, this.filterExpr, err)
+			return
+		}
+	}
+
 	this.appid = manager.Default.TopicAppid(this.topic)
 	if this.appid == "" {
 		log.Warn("invalid topic: %s", this.topic)
@@ -149,18 +162,25 @@ func (this *WebhookExecutor) pump(wg *sync.WaitGroup) {
 			return
 
 		case msg := <-this.msgCh:
-			for _, ep := range this.endpoints {
-				this.pushToEndpoint(msg, ep)
+			headers, bodyIdx, err := envelope.Open(msg.Value)
+			if err != nil {
+				log.Error("%s/%d %d %v", this.topic, msg.Partition, msg.Offset, err)
+			} else if this.msgFilter == nil || this.msgFilter.Match(headers, msg.Value[bodyIdx:]) {
+				for _, ep := range this.endpoints {
+					this.pushToEndpoint(msg, headers, msg.Value[bodyIdx:], ep)
+				}
 			}
 
+			// skipped msg also moves the offset ahead
 			this.fetcher.CommitUpto(msg)
 		}
 	}
 
 }
 
-func (this *WebhookExecutor) pushToEndpoint(msg *sarama.ConsumerMessage, uri string) (ok bool) {
-	log.Debug("%s sending[%s] %s", this.topic, uri, string(msg.Value))
+func (this *WebhookExecutor) pushToEndpoint(msg *sarama.ConsumerMessage, headers envelope.Headers,
+	payload []byte, uri string) (ok bool) {
+	log.Debug("%s sending[%s] %s", this.topic, uri, string(payload))
 
 	if this.circuits[uri].Open() {
 		log.Warn("%s %s circuit open", this.topic, uri)
@@ -171,7 +191,7 @@ func (this *WebhookExecutor) pushToEndpoint(msg *sarama.ConsumerMessage, uri str
 	defer mpool.BytesBufferPut(body)
 
 	body.Reset()
-	body.Write(msg.Value)
+	body.Write(payload)
 
 	// TODO user defined post body schema, e,g. ElasticSearch
 	req, err := http.NewRequest("POST", uri, body)
@@ -182,6 +202,9 @@ func (this *WebhookExecutor) pushToEndpoint(msg *sarama.ConsumerMessage, uri str
 
 	req.Header.Set(gateway.HttpHeaderOffset, strconv.FormatInt(msg.Offset, 10))
 	req.Header.Set(gateway.HttpHeaderPartition, strconv.FormatInt(int64(msg.Partition), 10))
+	if len(headers) > 0 {
+		req.Header.Set(gateway.HttpHeaderMsgTag, headers.String())
+	}
 	req.Header.Set("User-Agent", this.userAgent)
 	req.Header.Set("X-App-Signature", this.appSignature)
 	response, err := this.httpClient.Do(req)
@@ -0,0 +1,356 @@
+package command
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/funkygao/log4go"
+)
+
+const adminKeyHeader = "X-Ehaproxy-Key"
+
+var (
+	ErrBackendNotFound = errors.New("backend not found")
+	ErrInvalidOp       = errors.New("invalid op")
+	ErrInvalidWeight   = errors.New("weight must be within [0, 256]")
+)
+
+// backendOverride is the admin pinned state of a backend server which
+// survives the automatic weighting and config regeneration.
+type backendOverride struct {
+	state  string // drain|maint, empty means following kateway
+	weight int    // -1 means auto weighted
+}
+
+func (this *BackendServers) applyOverrides(overrides map[string]backendOverride) {
+	apply := func(svc string, backends []Backend) {
+		for i := range backends {
+			o, present := overrides[svc+"/"+backends[i].Name]
+			if !present {
+				continue
+			}
+
+			switch o.state {
+			case "drain":
+				// weight 0 keeps it drained across full reloads too
+				backends[i].Drain = true
+				backends[i].Weight = 0
+			case "maint":
+				backends[i].Maint = true
+			}
+			if o.weight >= 0 && !backends[i].Drain {
+				backends[i].Weight = o.weight
+			}
+		}
+	}
+
+	apply("pub", this.Pub)
+	apply("sub", this.Sub)
+	apply("man", this.Man)
+}
+
+func (this BackendServers) backends(svc string) []Backend {
+	switch svc {
+	case "pub":
+		return this.Pub
+	case "sub":
+		return this.Sub
+	case "man":
+		return this.Man
+	default:
+		return nil
+	}
+}
+
+func (this BackendServers) clone() BackendServers {
+	dup := func(backends []Backend) []Backend {
+		return append([]Backend(nil), backends...)
+	}
+
+	this.Pub = dup(this.Pub)
+	this.Sub = dup(this.Sub)
+	this.Man = dup(this.Man)
+	this.Dashboard = dup(this.Dashboard)
+	return this
+}
+
+func (this *Start) registerAdminHandlers() {
+	http.HandleFunc("/v1/admin/backends", this.adminAuth(this.listBackendsHandler))
+	http.HandleFunc("/v1/admin/backend", this.adminAuth(this.backendOpHandler))
+	http.HandleFunc("/v1/admin/weight", this.adminAuth(this.backendWeightHandler))
+	http.HandleFunc("/v1/admin/reload", this.adminAuth(this.reloadHandler))
+	http.HandleFunc("/v1/admin/config", this.adminAuth(this.configHandler))
+}
+
+func (this *Start) adminAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "ehaproxy")
+
+		if this.adminKey == "" {
+			writeAdminError(w, "admin api disabled", http.StatusForbidden)
+			return
+		}
+
+		key := r.Header.Get(adminKeyHeader)
+		if subtle.ConstantTimeCompare([]byte(key), []byte(this.adminKey)) != 1 {
+			log.Warn("admin %s %s %s: unauthorized", r.RemoteAddr, r.Method, r.URL)
+			writeAdminError(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		log.Info("admin %s %s %s", r.RemoteAddr, r.Method, r.URL)
+		h(w, r)
+	}
+}
+
+// GET /v1/admin/backends
+func (this *Start) listBackendsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeAdminError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats, err := this.serverStats()
+	if err != nil {
+		writeAdminError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	this.mu.Lock()
+	servers := this.lastServers.clone()
+	this.mu.Unlock()
+
+	type backendInfo struct {
+		Backend
+		State  string `json:"state"`
+		Status string `json:"status"` // reported by haproxy
+		Scur   int64  `json:"scur"`   // current sessions of all processes
+	}
+	v := make(map[string][]backendInfo)
+	for _, svc := range []string{"pub", "sub", "man"} {
+		v[svc] = make([]backendInfo, 0)
+		for _, b := range servers.backends(svc) {
+			st := stats[svc+"/"+b.Name]
+			v[svc] = append(v[svc], backendInfo{
+				Backend: b,
+				State:   b.state(),
+				Status:  st.status,
+				Scur:    st.scur,
+			})
+		}
+	}
+
+	writeAdminJson(w, v)
+}
+
+// POST /v1/admin/backend?svc=pub&server=p1&op=enable|disable|drain
+func (this *Start) backendOpHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeAdminError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	svc, server := q.Get("svc"), q.Get("server")
+	err := this.overrideBackend(svc, server, func(o *backendOverride) error {
+		switch q.Get("op") {
+		case "enable":
+			o.state = ""
+		case "disable":
+			o.state = "maint"
+		case "drain":
+			o.state = "drain"
+		default:
+			return ErrInvalidOp
+		}
+		return nil
+	})
+	if err != nil {
+		writeAdminOpError(w, err)
+		return
+	}
+
+	writeAdminJson(w, map[string]string{"ok": "1"})
+}
+
+// POST /v1/admin/weight?svc=pub&server=p1&weight=10
+//
+// weight=auto returns the weighting to kateway load.
+func (this *Start) backendWeightHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeAdminError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	weight := -1
+	if q.Get("weight") != "auto" {
+		var err error
+		weight, err = strconv.Atoi(q.Get("weight"))
+		if err != nil || weight < 0 || weight > maxWeight {
+			writeAdminError(w, ErrInvalidWeight.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := this.overrideBackend(q.Get("svc"), q.Get("server"), func(o *backendOverride) error {
+		o.weight = weight
+		return nil
+	})
+	if err != nil {
+		writeAdminOpError(w, err)
+		return
+	}
+
+	writeAdminJson(w, map[string]string{"ok": "1"})
+}
+
+// POST /v1/admin/reload
+func (this *Start) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeAdminError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := this.forceReload(); err != nil {
+		writeAdminError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeAdminJson(w, map[string]string{"ok": "1"})
+}
+
+// GET /v1/admin/config
+func (this *Start) configHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		writeAdminError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf8")
+	w.Write(b)
+}
+
+// overrideBackend pins the admin change of a backend server and applies it
+// through the stats socket.
+func (this *Start) overrideBackend(svc, server string, fn func(*backendOverride) error) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	found := false
+	for _, b := range this.lastServers.backends(svc) {
+		if b.Name == server {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrBackendNotFound
+	}
+
+	key := svc + "/" + server
+	o, present := this.overrides[key]
+	if !present {
+		o = backendOverride{weight: -1}
+	}
+	if err := fn(&o); err != nil {
+		return err
+	}
+
+	if o.state == "" && o.weight < 0 {
+		delete(this.overrides, key)
+	} else {
+		this.overrides[key] = o
+	}
+
+	// recalculate from the automatic weights and kateway states
+	servers := this.autoServers.clone()
+	servers.applyOverrides(this.overrides)
+
+	if err := this.createConfigFile(servers); err != nil {
+		return err
+	}
+	if err := this.applyRuntimeChanges(this.lastServers, servers); err != nil {
+		return err
+	}
+
+	this.lastServers = servers
+	return nil
+}
+
+type serverStat struct {
+	status string
+	scur   int64
+}
+
+// serverStats returns status of all servers aggregated from all haproxy processes.
+func (this *Start) serverStats() (map[string]serverStat, error) {
+	outputs, err := this.haproxyCmd("show stat -1 4 -1") // servers only
+	if err != nil {
+		return nil, err
+	}
+
+	return parseServerStats(outputs)
+}
+
+// parseServerStats parses the `show stat` CSV output of each haproxy
+// process, scur is summed up across processes.
+func parseServerStats(outputs []string) (map[string]serverStat, error) {
+	stats := make(map[string]serverStat)
+	for _, output := range outputs {
+		records, err := csv.NewReader(strings.NewReader(output)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+
+		cols := make(map[string]int)
+		for i, row := range records {
+			if i == 0 {
+				for j, col := range row {
+					cols[strings.TrimPrefix(col, "# ")] = j
+				}
+				continue
+			}
+
+			key := row[cols["pxname"]] + "/" + row[cols["svname"]]
+			st := stats[key]
+			st.status = row[cols["status"]]
+			scur, _ := strconv.ParseInt(row[cols["scur"]], 10, 64)
+			st.scur += scur
+			stats[key] = st
+		}
+	}
+
+	return stats, nil
+}
+
+func writeAdminOpError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrBackendNotFound:
+		writeAdminError(w, err.Error(), http.StatusNotFound)
+	case ErrInvalidOp:
+		writeAdminError(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeAdminError(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+func writeAdminJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf8")
+	b, _ := json.Marshal(v)
+	w.Write(b)
+}
+
+func writeAdminError(w http.ResponseWriter, errmsg string, code int) {
+	w.Header().Set("Content-Type", "application/json; charset=utf8")
+	w.WriteHeader(code)
+	b, _ := json.Marshal(map[string]string{"errmsg": errmsg})
+	w.Write(b)
+}
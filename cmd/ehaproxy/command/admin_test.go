@@ -0,0 +1,108 @@
+package command
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestAdminAuth(t *testing.T) {
+	var called int
+	h := func(w http.ResponseWriter, r *http.Request) {
+		called++
+	}
+
+	s := &Start{}
+	w := httptest.NewRecorder()
+	s.adminAuth(h)(w, httptest.NewRequest("GET", "/v1/admin/backends", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code) // admin api disabled
+
+	s.adminKey = "secret"
+	for key, code := range map[string]int{
+		"":        http.StatusUnauthorized,
+		"secre":   http.StatusUnauthorized,
+		"secret!": http.StatusUnauthorized,
+		"secret":  http.StatusOK,
+	} {
+		r := httptest.NewRequest("GET", "/v1/admin/backends", nil)
+		if key != "" {
+			r.Header.Set(adminKeyHeader, key)
+		}
+		w = httptest.NewRecorder()
+		s.adminAuth(h)(w, r)
+		assert.Equal(t, code, w.Code)
+	}
+	assert.Equal(t, 1, called)
+}
+
+func TestApplyOverrides(t *testing.T) {
+	servers := BackendServers{
+		Pub: []Backend{
+			Backend{Name: "p1", Weight: 32},
+			Backend{Name: "p2", Weight: 32},
+			Backend{Name: "p3", Weight: 32},
+		},
+		Sub: []Backend{
+			Backend{Name: "p1", Weight: 64},
+		},
+	}
+	servers.applyOverrides(map[string]backendOverride{
+		"pub/p1": backendOverride{state: "drain", weight: 10},
+		"pub/p2": backendOverride{weight: 100},
+		"sub/p1": backendOverride{state: "maint", weight: -1},
+		"man/p9": backendOverride{state: "maint", weight: -1},
+	})
+
+	assert.Equal(t, "drain", servers.Pub[0].state())
+	assert.Equal(t, 0, servers.Pub[0].Weight) // drained weight is not pinned
+	assert.Equal(t, "ready", servers.Pub[1].state())
+	assert.Equal(t, 100, servers.Pub[1].Weight)
+	assert.Equal(t, "ready", servers.Pub[2].state())
+	assert.Equal(t, 32, servers.Pub[2].Weight)
+	assert.Equal(t, "maint", servers.Sub[0].state())
+	assert.Equal(t, 64, servers.Sub[0].Weight)
+}
+
+func TestOverrideUnknownBackend(t *testing.T) {
+	s := &Start{
+		lastServers: BackendServers{Pub: []Backend{Backend{Name: "p1"}}},
+		overrides:   make(map[string]backendOverride),
+	}
+	err := s.overrideBackend("sub", "p1", func(o *backendOverride) error {
+		o.state = "drain"
+		return nil
+	})
+	assert.Equal(t, ErrBackendNotFound, err)
+	assert.Equal(t, 0, len(s.overrides))
+
+	err = s.overrideBackend("pub", "p1", func(o *backendOverride) error {
+		return ErrInvalidOp
+	})
+	assert.Equal(t, ErrInvalidOp, err)
+	assert.Equal(t, 0, len(s.overrides))
+}
+
+func TestParseServerStats(t *testing.T) {
+	// 2 haproxy processes
+	outputs := []string{
+		`# pxname,svname,qcur,qmax,scur,smax,slim,stot,status,
+pub,p1,0,0,3,10,,100,UP,
+pub,p2,0,0,0,0,,0,DRAIN,
+sub,p1,0,0,7,9,,20,MAINT,`,
+		`# pxname,svname,qcur,qmax,scur,smax,slim,stot,status,
+pub,p1,0,0,2,10,,100,UP,
+sub,p1,0,0,1,9,,20,MAINT,`,
+	}
+
+	stats, err := parseServerStats(outputs)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(stats))
+	assert.Equal(t, serverStat{status: "UP", scur: 5}, stats["pub/p1"])
+	assert.Equal(t, serverStat{status: "DRAIN", scur: 0}, stats["pub/p2"])
+	assert.Equal(t, serverStat{status: "MAINT", scur: 8}, stats["sub/p1"])
+
+	_, err = parseServerStats([]string{"# pxname,svname\npub,p1,UP"})
+	assert.NotEqual(t, nil, err)
+}
@@ -0,0 +1,282 @@
+package command
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/funkygao/go-metrics"
+	log "github.com/funkygao/log4go"
+)
+
+var ErrNoCertificate = errors.New("no valid certificate")
+
+// certManager collects PEM certificates from a local dir and/or zk,
+// validates them and installs the valid ones into the active dir
+// that haproxy `crt` loads.
+//
+// Each PEM must contain the certificate chain and the private key.
+type certManager struct {
+	ctx *Start
+
+	srcDir    string // local PEM dir, optional
+	zkPath    string // zk distributed PEMs, optional
+	activeDir string // symlink to the versioned dir of installed certs
+
+	fingerprint string
+	expiry      map[string]metrics.Gauge // name:days left
+	minExpiry   metrics.Gauge
+}
+
+func newCertManager(ctx *Start, srcDir, zkPath, activeDir string) *certManager {
+	return &certManager{
+		ctx:       ctx,
+		srcDir:    srcDir,
+		zkPath:    zkPath,
+		activeDir: activeDir,
+		expiry:    make(map[string]metrics.Gauge),
+		minExpiry: metrics.NewRegisteredGauge("certs.expire.mindays", metrics.DefaultRegistry),
+	}
+}
+
+// sync installs the latest valid certificates into active dir and returns
+// whether anything changed since last sync.
+func (this *certManager) sync() (changed bool, err error) {
+	pems, err := this.collect()
+	if err != nil {
+		return
+	}
+
+	valid := make(map[string][]byte, len(pems))
+	for name, b := range pems {
+		notAfter, err := validatePem(b)
+		if !notAfter.IsZero() {
+			// expired ones too, till they are removed from the source
+			this.reportExpiry(name, notAfter)
+		}
+		if err != nil {
+			log.Error("cert[%s] skipped: %v", name, err)
+			continue
+		}
+
+		valid[name] = b
+	}
+	this.pruneExpiry(pems)
+	if len(valid) == 0 {
+		return false, ErrNoCertificate
+	}
+
+	fp := pemsFingerprint(valid)
+	if fp == this.fingerprint {
+		return false, nil
+	}
+
+	if err = this.install(fp, valid); err != nil {
+		return
+	}
+
+	log.Info("certs installed: %d, fingerprint %s -> %s", len(valid), this.fingerprint, fp)
+	changed = this.fingerprint != "" // the 1st sync is not a change
+	this.fingerprint = fp
+	return
+}
+
+func (this *certManager) collect() (map[string][]byte, error) {
+	pems := make(map[string][]byte)
+	if this.srcDir != "" {
+		files, err := filepath.Glob(filepath.Join(this.srcDir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			b, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+
+			pems[filepath.Base(f)] = b
+		}
+	}
+
+	if this.zkPath != "" {
+		for name, data := range this.ctx.zkzone.ChildrenWithData(this.zkPath) {
+			if !strings.HasSuffix(name, ".pem") {
+				name += ".pem"
+			}
+
+			// zk distributed certs overrides local ones of the same name
+			pems[name] = data.Data()
+		}
+	}
+
+	return pems, nil
+}
+
+// install atomically replaces the active dir content with pems: pems are
+// written to a dir versioned by fingerprint and the active dir symlink is
+// renamed over to it, so haproxy never loads a partial or missing dir.
+func (this *certManager) install(fingerprint string, pems map[string][]byte) error {
+	versionDir := this.activeDir + "." + fingerprint
+	os.RemoveAll(versionDir)
+	if err := os.MkdirAll(versionDir, 0700); err != nil {
+		return err
+	}
+
+	for name, b := range pems {
+		if err := ioutil.WriteFile(filepath.Join(versionDir, name), b, 0600); err != nil {
+			return err
+		}
+	}
+
+	link := this.activeDir + ".link"
+	os.Remove(link)
+	if err := os.Symlink(filepath.Base(versionDir), link); err != nil {
+		return err
+	}
+
+	if fi, err := os.Lstat(this.activeDir); err == nil && fi.Mode()&os.ModeSymlink == 0 {
+		// a plain dir installed by older versions can't be renamed over
+		os.RemoveAll(this.activeDir)
+	}
+	if err := os.Rename(link, this.activeDir); err != nil {
+		return err
+	}
+
+	// the previous versions
+	dirs, _ := filepath.Glob(this.activeDir + ".*")
+	for _, dir := range dirs {
+		if dir != versionDir {
+			os.RemoveAll(dir)
+		}
+	}
+
+	return nil
+}
+
+func expiryMetricName(name string) string {
+	return fmt.Sprintf("certs.%s.expire.days", strings.TrimSuffix(name, ".pem"))
+}
+
+func (this *certManager) reportExpiry(name string, notAfter time.Time) {
+	days := int64(notAfter.Sub(time.Now()).Hours() / 24)
+	g, present := this.expiry[name]
+	if !present {
+		g = metrics.NewRegisteredGauge(expiryMetricName(name), metrics.DefaultRegistry)
+		this.expiry[name] = g
+	}
+	g.Update(days)
+
+	if days < 30 {
+		log.Warn("cert[%s] expires in %d days: %s", name, days, notAfter)
+	}
+}
+
+// pruneExpiry unregisters the expiry gauges of certs removed from the source
+// and reports the min days left of the present ones, negative if expired.
+func (this *certManager) pruneExpiry(pems map[string][]byte) {
+	for name := range this.expiry {
+		if _, present := pems[name]; !present {
+			metrics.DefaultRegistry.Unregister(expiryMetricName(name))
+			delete(this.expiry, name)
+		}
+	}
+
+	var minDays int64 = -1 // no cert at all
+	first := true
+	for _, g := range this.expiry {
+		if first || g.Value() < minDays {
+			minDays = g.Value()
+			first = false
+		}
+	}
+	this.minExpiry.Update(minDays)
+}
+
+// watch periodically syncs the certificates and reloads haproxy on change.
+func (this *certManager) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.ctx.quitCh:
+			return
+
+		case <-ticker.C:
+			changed, err := this.sync()
+			if err != nil {
+				log.Error("certs: %v", err)
+				continue
+			}
+
+			if changed {
+				log.Info("certs changed, reloading haproxy...")
+				if err = this.ctx.forceReload(); err != nil {
+					log.Error("certs reload: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// validatePem checks that the PEM holds a usable certificate and its
+// private key, and returns when the leaf certificate expires.
+func validatePem(b []byte) (notAfter time.Time, err error) {
+	var certPEM, keyPEM bytes.Buffer
+	for rest := b; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		if block.Type == "CERTIFICATE" {
+			pem.Encode(&certPEM, block)
+		} else if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			pem.Encode(&keyPEM, block)
+		}
+	}
+
+	pair, err := tls.X509KeyPair(certPEM.Bytes(), keyPEM.Bytes())
+	if err != nil {
+		return
+	}
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return
+	}
+
+	if time.Now().After(leaf.NotAfter) {
+		return leaf.NotAfter, fmt.Errorf("expired at %s", leaf.NotAfter)
+	}
+
+	return leaf.NotAfter, nil
+}
+
+func pemsFingerprint(pems map[string][]byte) string {
+	names := make([]string, 0, len(pems))
+	for name := range pems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := md5.New()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write(pems[name])
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
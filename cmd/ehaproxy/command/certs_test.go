@@ -0,0 +1,103 @@
+package command
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/go-metrics"
+)
+
+func selfSignedPem(t *testing.T, notAfter time.Time) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Equal(t, nil, err)
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kateway.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	assert.Equal(t, nil, err)
+
+	var b bytes.Buffer
+	pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&b, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return b.Bytes()
+}
+
+func TestValidatePem(t *testing.T) {
+	notAfter := time.Now().Add(time.Hour * 24 * 90).Truncate(time.Second)
+	got, err := validatePem(selfSignedPem(t, notAfter))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, notAfter.Unix(), got.Unix())
+
+	_, err = validatePem(selfSignedPem(t, time.Now().Add(-time.Minute)))
+	assert.NotEqual(t, nil, err)
+
+	_, err = validatePem([]byte("garbage"))
+	assert.NotEqual(t, nil, err)
+}
+
+func TestCertManagerSync(t *testing.T) {
+	root, err := ioutil.TempDir("", "ehaproxy")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(root)
+
+	src := filepath.Join(root, "src")
+	os.MkdirAll(src, 0700)
+	ioutil.WriteFile(filepath.Join(src, "a.pem"), selfSignedPem(t, time.Now().Add(time.Hour*48)), 0600)
+	ioutil.WriteFile(filepath.Join(src, "bad.pem"), []byte("bad"), 0600)
+
+	m := newCertManager(&Start{}, src, "", filepath.Join(root, activeCertsDir))
+	changed, err := m.sync()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, changed) // 1st sync
+	files, _ := filepath.Glob(filepath.Join(root, activeCertsDir, "*.pem"))
+	assert.Equal(t, 1, len(files))
+
+	changed, _ = m.sync()
+	assert.Equal(t, false, changed)
+
+	ioutil.WriteFile(filepath.Join(src, "b.pem"), selfSignedPem(t, time.Now().Add(time.Hour*48)), 0600)
+	changed, err = m.sync()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, changed)
+	assert.Equal(t, int64(1), m.minExpiry.Value())
+	fi, err := os.Lstat(filepath.Join(root, activeCertsDir))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, os.ModeSymlink, fi.Mode()&os.ModeSymlink)
+	versions, _ := filepath.Glob(filepath.Join(root, activeCertsDir+".*"))
+	assert.Equal(t, 1, len(versions))
+
+	// an expired cert is not installed but still reported
+	ioutil.WriteFile(filepath.Join(src, "c.pem"), selfSignedPem(t, time.Now().Add(-time.Hour*48)), 0600)
+	changed, err = m.sync()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, changed)
+	assert.Equal(t, int64(-2), m.expiry["c.pem"].Value())
+	assert.Equal(t, int64(-2), m.minExpiry.Value())
+	os.Remove(filepath.Join(src, "c.pem"))
+
+	// a removed cert is no longer reported
+	os.Remove(filepath.Join(src, "a.pem"))
+	changed, err = m.sync()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, changed)
+	assert.Equal(t, 1, len(m.expiry))
+	assert.Equal(t, nil, metrics.DefaultRegistry.Get(expiryMetricName("a.pem")))
+	assert.NotEqual(t, nil, metrics.DefaultRegistry.Get(expiryMetricName("b.pem")))
+	files, _ = filepath.Glob(filepath.Join(root, activeCertsDir, "*.pem"))
+	assert.Equal(t, 1, len(files))
+}
@@ -1,10 +1,13 @@
 package command
 
 const (
-	defaultPrefix  = "/var/wd/ehaproxy"
-	defaultLogfile = "ehaproxy.log"
-	configFile     = ".haproxy.cf"
-	haproxyPidFile = "haproxy.pid"
+	defaultPrefix   = "/var/wd/ehaproxy"
+	defaultLogfile  = "ehaproxy.log"
+	configFile      = ".haproxy.cf"
+	haproxyPidFile  = "haproxy.pid"
+	haproxySockFile = "/tmp/haproxy.sock"
+	activeCertsDir  = "certs"
+	zkCertsRoot     = "/_ehaproxy/certs"
 
 	dashboardPortHead = 10910
 )
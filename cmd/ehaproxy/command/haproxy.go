@@ -2,9 +2,13 @@ package command
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"reflect"
+	"strings"
 	"text/template"
+	"time"
 
 	log "github.com/funkygao/log4go"
 )
@@ -18,6 +22,19 @@ type BackendServers struct {
 	SubPort     int
 	ManPort     int
 	ForwardFor  bool
+	Balance     string
+
+	// haproxy bind address of each service, fd@N when handed over by ehaproxy
+	PubBind string
+	SubBind string
+	ManBind string
+
+	// https, empty bind means disabled
+	PubsBind      string
+	SubsBind      string
+	MansBind      string
+	CertDir       string
+	HttpsRedirect bool
 
 	Pub       []Backend
 	Sub       []Backend
@@ -25,6 +42,19 @@ type BackendServers struct {
 	Dashboard []Backend
 }
 
+// HashType returns the hash-type of hash based balance algorithms.
+// haproxy accepts runtime weight changes on them only with consistent hashing,
+// which also keeps most clients on their server when a weight changes.
+func (this BackendServers) HashType() string {
+	for _, algo := range []string{"source", "uri", "url_param", "hdr(", "rdp-cookie"} {
+		if strings.HasPrefix(this.Balance, algo) {
+			return "consistent"
+		}
+	}
+
+	return ""
+}
+
 func (this *BackendServers) reset() {
 	this.Pub = make([]Backend, 0)
 	this.Sub = make([]Backend, 0)
@@ -42,11 +72,55 @@ func (this *BackendServers) sort() {
 	this.Man = sortBackendByName(this.Man)
 }
 
+func (this *BackendServers) assignWeights() {
+	assignWeights(this.Pub)
+	assignWeights(this.Sub)
+	assignWeights(this.Man)
+}
+
+// sameTopology returns whether the 2 servers differ only in backend
+// weights and drain states, which can be changed without a reload.
+func (this BackendServers) sameTopology(that BackendServers) bool {
+	return reflect.DeepEqual(this.withoutLoad(), that.withoutLoad())
+}
+
+func (this BackendServers) withoutLoad() BackendServers {
+	strip := func(backends []Backend) []Backend {
+		r := make([]Backend, 0, len(backends))
+		for _, b := range backends {
+			b.Conns, b.Weight, b.Drain, b.Maint = 0, 0, false, false
+			r = append(r, b)
+		}
+		return r
+	}
+
+	this.Pub = strip(this.Pub)
+	this.Sub = strip(this.Sub)
+	this.Man = strip(this.Man)
+	return this
+}
+
 type Backend struct {
-	Name string
-	Addr string
-	Cpu  string
-	Port string
+	Name   string `json:"name"`
+	Addr   string `json:"addr"`
+	Cpu    string `json:"cpu"`
+	Port   string `json:"port"`
+	Conns  int    `json:"conns"`  // live conns reported by kateway
+	Weight int    `json:"weight"` // haproxy server weight
+	Drain  bool   `json:"drain"`  // kateway announced shutdown or drained by admin
+	Maint  bool   `json:"maint"`  // disabled by admin
+}
+
+// state returns the haproxy runtime state of the server.
+func (this Backend) state() string {
+	switch {
+	case this.Maint:
+		return "maint"
+	case this.Drain:
+		return "drain"
+	default:
+		return "ready"
+	}
 }
 
 func (this *Start) createConfigFile(servers BackendServers) error {
@@ -77,37 +151,55 @@ func (this *Start) createConfigFile(servers BackendServers) error {
 }
 
 func (this *Start) reloadHAproxy() (err error) {
-	var cmd *exec.Cmd = nil
-	waitStartCh := make(chan struct{})
-	if this.starting {
-		log.Info("haproxy starting")
-		cmd = exec.Command(this.command, "-f", configFile) // TODO use absolute path
-		this.starting = false
-
-		go func() {
-			<-waitStartCh
-			log.Info("haproxy started")
-			if err := cmd.Wait(); err != nil {
-				log.Error("haproxy: %v", err)
-			}
-		}()
-	} else {
-		shellScript := fmt.Sprintf("%s -f %s/%s -sf `cat %s/%s`",
-			this.command, this.root, configFile, this.root, haproxyPidFile)
-		log.Info("haproxy reloading: %s", shellScript)
-		cmd = exec.Command("/bin/sh", "-c", shellScript)
-		go func() {
-			<-waitStartCh
-			log.Info("haproxy reloaded")
-			if err := cmd.Wait(); err != nil {
-				log.Error("haproxy: %v", err)
-			}
-		}()
+	args := []string{"-f", fmt.Sprintf("%s/%s", this.root, configFile)}
+	if !this.starting {
+		// the old processes finish their in-flight requests and exit
+		pids, e := haproxyPids()
+		if e != nil && !os.IsNotExist(e) {
+			return e
+		}
+		if len(pids) > 0 {
+			args = append(args, "-sf")
+			args = append(args, pids...)
+		}
 	}
 
-	if err = cmd.Start(); err == nil {
-		waitStartCh <- struct{}{} // should close
+	cmd := exec.Command(this.command, args...)
+	cmd.ExtraFiles = this.listenerFiles()
+	log.Info("haproxy %s", strings.Join(cmd.Args, " "))
+
+	t0 := time.Now()
+	if err = cmd.Start(); err != nil {
+		return
+	}
+
+	starting := this.starting
+	this.starting = false
+	go func() {
+		// haproxy runs in daemon mode, the parent exits after forking workers
+		if err := cmd.Wait(); err != nil {
+			log.Error("haproxy: %v", err)
+			return
+		}
+
+		if starting {
+			log.Info("haproxy started in %s", time.Since(t0))
+		} else {
+			this.reloadLatency.UpdateSince(t0)
+			this.reloads.Inc(1)
+			log.Info("haproxy reloaded in %s", time.Since(t0))
+		}
+	}()
+
+	return
+}
+
+// haproxyPids returns the pid of all running haproxy processes.
+func haproxyPids() ([]string, error) {
+	b, err := ioutil.ReadFile(haproxyPidFile)
+	if err != nil {
+		return nil, err
 	}
 
-	return err
+	return strings.Fields(string(b)), nil
 }
@@ -0,0 +1,65 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestHashType(t *testing.T) {
+	for balance, hashType := range map[string]string{
+		"source":          "consistent",
+		"uri":             "consistent",
+		"hdr(X-Appid)":    "consistent",
+		"roundrobin":      "",
+		"leastconn":       "",
+		"url_param appid": "consistent",
+	} {
+		assert.Equal(t, hashType, BackendServers{Balance: balance}.HashType())
+	}
+}
+
+func TestWeightChangeWithoutReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ehaproxy")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	saved := runtimeCmd
+	defer func() {
+		runtimeCmd = saved
+	}()
+	var cmds []string
+	runtimeCmd = func(sock, cmd string) (string, error) {
+		cmds = append(cmds, cmd)
+		return "", nil
+	}
+
+	last := BackendServers{
+		Balance: "source",
+		Pub:     []Backend{Backend{Name: "p1", Addr: "10.1.1.1:9191", Weight: 32}},
+		Sub:     []Backend{Backend{Name: "p1", Addr: "10.1.1.1:9192", Weight: 32}},
+	}
+	// a reload would fail to exec the command and panic
+	s := &Start{command: "/nonexistent/haproxy", lastServers: last}
+
+	servers := last.clone()
+	servers.Pub[0].Weight = 64
+	s.apply(servers)
+
+	assert.Equal(t, true, len(cmds) > 0)
+	for _, cmd := range cmds {
+		assert.Equal(t, "set weight pub/p1 64", cmd)
+	}
+	assert.Equal(t, servers, s.lastServers)
+
+	// weights of source balance are dynamic only with consistent hashing
+	cf, err := ioutil.ReadFile(configFile)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, strings.Count(string(cf), "hash-type consistent"))
+}
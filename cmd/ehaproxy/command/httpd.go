@@ -18,6 +18,7 @@ import (
 func (this *Start) runMonitorServer(addr string) {
 	http.HandleFunc("/v1/ver", this.versionHandler)
 	http.HandleFunc("/v1/status", this.statusHandler)
+	this.registerAdminHandlers()
 
 	log.Info("status web server on %s ready", addr)
 	if err := http.ListenAndServe(addr, nil); err != nil {
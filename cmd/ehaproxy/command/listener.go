@@ -0,0 +1,83 @@
+package command
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	log "github.com/funkygao/log4go"
+)
+
+// inheritedListener is a listening socket owned by ehaproxy and handed
+// down to each generation of haproxy processes as `bind fd@N`.
+//
+// Because the socket outlives any haproxy process, there is no window
+// during reload in which the port is unbound, and the old haproxy can
+// finish its in-flight requests after -sf while the new one accepts.
+type inheritedListener struct {
+	name string
+	addr string
+	fd   int // fd number in the haproxy process
+
+	ln   *net.TCPListener
+	file *os.File
+}
+
+// bind returns the haproxy bind address of this listener.
+func (this *inheritedListener) bind() string {
+	return fmt.Sprintf("fd@%d", this.fd)
+}
+
+// listen opens a socket that will be inherited by haproxy.
+func (this *Start) listen(name string, port int) (string, error) {
+	addr := fmt.Sprintf("0.0.0.0:%d", port)
+	if !this.seamless {
+		return addr, nil
+	}
+
+	for _, l := range this.listeners {
+		if l.name == name {
+			return l.bind(), nil
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+
+	tcpLn := ln.(*net.TCPListener)
+	f, err := tcpLn.File() // dup(2)
+	if err != nil {
+		ln.Close()
+		return "", err
+	}
+
+	l := &inheritedListener{
+		name: name,
+		addr: addr,
+		fd:   3 + len(this.listeners), // exec.Cmd.ExtraFiles starts from fd 3
+		ln:   tcpLn,
+		file: f,
+	}
+	this.listeners = append(this.listeners, l)
+	log.Info("listener[%s] %s will be handed over as %s", name, addr, l.bind())
+
+	return l.bind(), nil
+}
+
+func (this *Start) listenerFiles() []*os.File {
+	files := make([]*os.File, 0, len(this.listeners))
+	for _, l := range this.listeners {
+		files = append(files, l.file)
+	}
+
+	return files
+}
+
+func (this *Start) closeListeners() {
+	for _, l := range this.listeners {
+		l.file.Close()
+		l.ln.Close()
+	}
+}
@@ -0,0 +1,154 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func TestInheritedListeners(t *testing.T) {
+	s := &Start{seamless: true}
+	defer s.closeListeners()
+
+	bind, err := s.listen("pub", 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "fd@3", bind)
+	bind, err = s.listen("sub", 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "fd@4", bind)
+
+	// listen is idempotent
+	bind, _ = s.listen("pub", 0)
+	assert.Equal(t, "fd@3", bind)
+	assert.Equal(t, 2, len(s.listenerFiles()))
+
+	// the inherited fd shares the listening socket with ehaproxy
+	ln, err := net.FileListener(s.listenerFiles()[0])
+	assert.Equal(t, nil, err)
+	defer ln.Close()
+	assert.Equal(t, s.listeners[0].ln.Addr().String(), ln.Addr().String())
+}
+
+func TestNonSeamlessListener(t *testing.T) {
+	s := &Start{}
+	bind, err := s.listen("pub", 10891)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "0.0.0.0:10891", bind)
+	assert.Equal(t, 0, len(s.listenerFiles()))
+}
+
+// TestHelperHaproxy is not a real test, it plays a haproxy process that
+// serves the inherited fd@3 and soft stops on SIGUSR1 like `-sf` does:
+// it stops accepting and exits after the accepted conns are closed.
+func TestHelperHaproxy(t *testing.T) {
+	gen := os.Getenv("EHAPROXY_TEST_HAPROXY")
+	if gen == "" {
+		return
+	}
+
+	ln, err := net.FileListener(os.NewFile(3, "fd@3"))
+	if err != nil {
+		os.Exit(1)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	go func() {
+		<-sig
+		ln.Close()
+		fmt.Println("stopped")
+	}()
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(conn net.Conn) {
+			defer wg.Done()
+			defer conn.Close()
+
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(conn, "%s:%s", gen, line)
+			}
+		}(conn)
+	}
+
+	wg.Wait()
+	os.Exit(0)
+}
+
+func TestConnHeldAcrossHandover(t *testing.T) {
+	s := &Start{seamless: true}
+	defer s.closeListeners()
+
+	_, err := s.listen("pub", 0)
+	assert.Equal(t, nil, err)
+	addr := s.listeners[0].ln.Addr().String()
+
+	haproxy := func(gen string) (*exec.Cmd, *bufio.Reader) {
+		cmd := exec.Command(os.Args[0], "-test.run=TestHelperHaproxy")
+		cmd.Env = append(os.Environ(), "EHAPROXY_TEST_HAPROXY="+gen)
+		cmd.ExtraFiles = s.listenerFiles()
+		stdout, err := cmd.StdoutPipe()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, cmd.Start())
+		return cmd, bufio.NewReader(stdout)
+	}
+
+	echo := func(conn net.Conn, r *bufio.Reader, msg string) string {
+		conn.SetDeadline(time.Now().Add(time.Second * 5))
+		fmt.Fprintf(conn, "%s\n", msg)
+		reply, _ := r.ReadString('\n')
+		return reply
+	}
+
+	old, oldOut := haproxy("old")
+	held, err := net.Dial("tcp", addr)
+	assert.Equal(t, nil, err)
+	heldR := bufio.NewReader(held)
+	assert.Equal(t, "old:a\n", echo(held, heldR, "a"))
+
+	// reload: the new generation inherits the same socket, then -sf the old
+	cur, _ := haproxy("new")
+	defer func() {
+		cur.Process.Kill()
+		cur.Wait()
+	}()
+	assert.Equal(t, nil, old.Process.Signal(syscall.SIGUSR1))
+	stopped, _ := oldOut.ReadString('\n')
+	assert.Equal(t, "stopped\n", stopped)
+
+	// the conn accepted by the old generation survives the handover
+	assert.Equal(t, "old:b\n", echo(held, heldR, "b"))
+
+	// and new conns are served by the new generation without refusal
+	for i := 0; i < 5; i++ {
+		conn, err := net.Dial("tcp", addr)
+		assert.Equal(t, nil, err)
+		reply := echo(conn, bufio.NewReader(conn), "c")
+		conn.Close()
+		assert.Equal(t, "new:c\n", reply)
+	}
+
+	// the old generation exits once its conns are done
+	held.Close()
+	assert.Equal(t, nil, old.Wait())
+}
@@ -3,6 +3,7 @@ package command
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"net"
@@ -10,6 +11,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -46,14 +48,36 @@ type Start struct {
 	starting   bool
 	forwardFor bool
 	httpAddr   string
+	balance    string
+	loadPoll   time.Duration
+	seamless   bool
+
+	pubBind, subBind, manBind string
+	listeners                 []*inheritedListener
+
+	pubsPort, subsPort, mansPort int
+	pubsBind, subsBind, mansBind string
+	certDir                      string
+	zkCerts                      bool
+	httpsRedirect                bool
+	certs                        *certManager
+
+	reloads       metrics.Counter
+	reloadLatency metrics.Timer
 
 	haproxyStatsUrl string
 	influxdbAddr    string
 	influxdbDbName  string
 
+	adminKey string
+
 	quitCh, closed chan struct{}
 	zkzone         *zk.ZkZone
-	lastServers    BackendServers
+
+	mu          sync.Mutex
+	lastServers BackendServers
+	autoServers BackendServers             // lastServers before admin overrides
+	overrides   map[string]backendOverride // svc/server:override by admin
 }
 
 func (this *Start) Run(args []string) (exitCode int) {
@@ -71,6 +95,16 @@ func (this *Start) Run(args []string) (exitCode int) {
 	cmdFlags.StringVar(&this.influxdbAddr, "influxaddr", "", "")
 	cmdFlags.StringVar(&this.influxdbDbName, "influxdb", "", "")
 	cmdFlags.StringVar(&this.httpAddr, "addr", ":10894", "monitor http server addr")
+	cmdFlags.StringVar(&this.balance, "balance", "source", "")
+	cmdFlags.DurationVar(&this.loadPoll, "loadpoll", time.Second*10, "")
+	cmdFlags.BoolVar(&this.seamless, "seamless", true, "")
+	cmdFlags.StringVar(&this.adminKey, "adminkey", "", "")
+	cmdFlags.IntVar(&this.pubsPort, "pubs", 0, "")
+	cmdFlags.IntVar(&this.subsPort, "subs", 0, "")
+	cmdFlags.IntVar(&this.mansPort, "mans", 0, "")
+	cmdFlags.StringVar(&this.certDir, "certdir", "", "")
+	cmdFlags.BoolVar(&this.zkCerts, "zkcerts", false, "")
+	cmdFlags.BoolVar(&this.httpsRedirect, "httpsredirect", false, "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -93,6 +127,28 @@ func (this *Start) Run(args []string) (exitCode int) {
 	this.setupLogging(this.logfile, "info", "panic")
 	this.starting = true
 	this.startedAt = time.Now()
+	this.overrides = make(map[string]backendOverride)
+	this.reloads = metrics.NewRegisteredCounter("haproxy.reload", metrics.DefaultRegistry)
+	this.reloadLatency = metrics.NewRegisteredTimer("haproxy.reload.latency", metrics.DefaultRegistry)
+
+	this.pubBind, err = this.listen("pub", this.pubPort)
+	swalllow(err)
+	this.subBind, err = this.listen("sub", this.subPort)
+	swalllow(err)
+	this.manBind, err = this.listen("man", this.manPort)
+	swalllow(err)
+	if this.pubsPort > 0 {
+		this.pubsBind, err = this.listen("pubs", this.pubsPort)
+		swalllow(err)
+	}
+	if this.subsPort > 0 {
+		this.subsBind, err = this.listen("subs", this.subsPort)
+		swalllow(err)
+	}
+	if this.mansPort > 0 {
+		this.mansBind, err = this.listen("mans", this.mansPort)
+		swalllow(err)
+	}
 
 	if this.haproxyStatsUrl != "" &&
 		this.influxdbAddr != "" && this.influxdbDbName != "" {
@@ -126,6 +182,9 @@ func (this *Start) Run(args []string) (exitCode int) {
 		log.Info("removing %s", configFile)
 		os.Remove(configFile)
 
+		log.Info("closing listeners")
+		this.closeListeners()
+
 		log.Info("removing lock[%s]", lockFilename)
 		locking.UnlockInstance(lockFilename)
 
@@ -160,16 +219,40 @@ func (this *Start) main() {
 
 	registry.Default = zkr.New(this.zkzone)
 
+	if this.tlsEnabled() {
+		zkPath := ""
+		if this.zkCerts {
+			zkPath = fmt.Sprintf("%s/%s", zkCertsRoot, this.zone)
+		}
+		this.certs = newCertManager(this, this.certDir, zkPath, fmt.Sprintf("%s/%s", this.root, activeCertsDir))
+		if _, err := this.certs.sync(); err != nil {
+			panic(err)
+		}
+
+		go this.certs.watch(time.Minute)
+	}
+
 	log.Info("ehaproxy[%s] starting...", gafka.BuildId)
 	go this.runMonitorServer(this.httpAddr)
 
-	zkConnected := false
+	loadTicker := time.NewTicker(this.loadPoll)
+	defer loadTicker.Stop()
+
+	var (
+		zkConnected     bool
+		instances       []string
+		instancesChange <-chan zklib.Event
+		err             error
+	)
 	for {
-		instances, instancesChange, err := registry.Default.WatchInstances()
-		if err != nil {
-			log.Error("zone[%s] %s", this.zkzone.Name(), err)
-			time.Sleep(time.Second)
-			continue
+		// the watch is armed only once per change, load ticks reuse it
+		if instancesChange == nil {
+			instances, instancesChange, err = registry.Default.WatchInstances()
+			if err != nil {
+				log.Error("zone[%s] %s", this.zkzone.Name(), err)
+				time.Sleep(time.Second)
+				continue
+			}
 		}
 
 		if zkConnected {
@@ -178,8 +261,6 @@ func (this *Start) main() {
 			} else {
 				// resilience to zk problem by local cache
 				log.Warn("backend all shutdown? skip this change")
-				time.Sleep(time.Second)
-				continue
 			}
 		}
 
@@ -197,6 +278,10 @@ func (this *Start) main() {
 
 		case <-instancesChange:
 			log.Info("instances changed!!")
+			instancesChange = nil
+
+		case <-loadTicker.C:
+			// kateway load and state changes are not watched, poll them
 		}
 	}
 
@@ -210,6 +295,17 @@ func (this *Start) reload(kwInstances []string) {
 		PubPort:     this.pubPort,
 		SubPort:     this.subPort,
 		ManPort:     this.manPort,
+		Balance:     this.balance,
+		PubBind:     this.pubBind,
+		SubBind:     this.subBind,
+		ManBind:     this.manBind,
+	}
+	if this.tlsEnabled() {
+		servers.PubsBind = this.pubsBind
+		servers.SubsBind = this.subsBind
+		servers.MansBind = this.mansBind
+		servers.CertDir = fmt.Sprintf("%s/%s", this.root, activeCertsDir)
+		servers.HttpsRedirect = this.httpsRedirect
 	}
 	servers.reset()
 	for _, kwNode := range kwInstances {
@@ -219,44 +315,49 @@ func (this *Start) reload(kwInstances []string) {
 			continue
 		}
 
-		info := make(map[string]string)
-		if err = json.Unmarshal([]byte(data), &info); err != nil {
+		var kw zk.KatewayMeta
+		if err = json.Unmarshal([]byte(data), &kw); err != nil {
 			log.Error("%s: %v", data, err)
 			continue
 		}
 
 		// pub
-		if info["pub"] != "" {
-			_, port, _ := net.SplitHostPort(info["pub"])
+		if kw.PubAddr != "" {
+			_, port, _ := net.SplitHostPort(kw.PubAddr)
 			be := Backend{
-				Name: "p" + info["id"],
-				Addr: info["pub"],
-				Cpu:  info["cpu"],
-				Port: port,
+				Name:  "p" + kw.Id,
+				Addr:  kw.PubAddr,
+				Cpu:   kw.Cpu,
+				Port:  port,
+				Conns: kw.PubConns,
+				Drain: kw.Draining(),
 			}
 			servers.Pub = append(servers.Pub, be)
 		}
 
 		// sub
-		if info["sub"] != "" {
-			_, port, _ := net.SplitHostPort(info["sub"])
+		if kw.SubAddr != "" {
+			_, port, _ := net.SplitHostPort(kw.SubAddr)
 			be := Backend{
-				Name: "s" + info["id"],
-				Addr: info["sub"],
-				Cpu:  info["cpu"],
-				Port: port,
+				Name:  "s" + kw.Id,
+				Addr:  kw.SubAddr,
+				Cpu:   kw.Cpu,
+				Port:  port,
+				Conns: kw.SubConns,
+				Drain: kw.Draining(),
 			}
 			servers.Sub = append(servers.Sub, be)
 		}
 
 		// man
-		if info["man"] != "" {
-			_, port, _ := net.SplitHostPort(info["man"])
+		if kw.ManAddr != "" {
+			_, port, _ := net.SplitHostPort(kw.ManAddr)
 			be := Backend{
-				Name: "m" + info["id"],
-				Addr: info["man"],
-				Cpu:  info["cpu"],
-				Port: port,
+				Name:  "m" + kw.Id,
+				Addr:  kw.ManAddr,
+				Cpu:   kw.Cpu,
+				Port:  port,
+				Drain: kw.Draining(),
 			}
 			servers.Man = append(servers.Man, be)
 		}
@@ -274,23 +375,71 @@ func (this *Start) reload(kwInstances []string) {
 		return
 	}
 
+	servers.sort()
+	servers.assignWeights()
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.autoServers = servers.clone()
+	servers.applyOverrides(this.overrides)
+	this.apply(servers)
+}
+
+// apply renders haproxy config for the servers and makes haproxy take it,
+// through the runtime API if possible. Caller must hold this.mu.
+func (this *Start) apply(servers BackendServers) {
 	if reflect.DeepEqual(this.lastServers, servers) {
-		log.Warn("backend servers stays unchanged")
+		log.Debug("backend servers stays unchanged")
 		return
 	}
 
+	lastServers := this.lastServers
 	this.lastServers = servers
 	if err := this.createConfigFile(servers); err != nil {
 		log.Error(err)
 		return
 	}
 
+	if !this.starting && lastServers.sameTopology(servers) {
+		// only weights or drain states changed, no need to reload
+		err := this.applyRuntimeChanges(lastServers, servers)
+		if err == nil {
+			return
+		}
+
+		log.Error("runtime api: %v, fallback to reload", err)
+	}
+
 	if err := this.reloadHAproxy(); err != nil {
 		log.Error("reloading haproxy: %v", err)
 		panic(err)
 	}
 }
 
+func (this *Start) tlsEnabled() bool {
+	return this.pubsPort > 0 || this.subsPort > 0 || this.mansPort > 0
+}
+
+var ErrNoBackendServers = errors.New("no backend servers yet")
+
+// forceReload regenerates haproxy config from the current backend servers
+// and reloads haproxy even if nothing changed.
+func (this *Start) forceReload() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.lastServers.empty() {
+		return ErrNoBackendServers
+	}
+
+	if err := this.createConfigFile(this.lastServers); err != nil {
+		return err
+	}
+
+	return this.reloadHAproxy()
+}
+
 func (this *Start) shutdown() {
 	// kill haproxy
 	log.Info("killling haproxy processes")
@@ -353,6 +502,45 @@ Options:
       Default false.
       If true, haproxy will add X-Forwarded-For http header.
 
+    -balance algorithm
+      Default source.
+      haproxy balance algorithm of pub and sub.
+      Hash based algorithms use consistent hashing so that weights can be
+      changed without reload.
+
+    -loadpoll interval
+      Default 10s.
+      Interval to poll kateway load to adjust backend weights.
+
+    -adminkey key
+      Admin API is enabled only when key is provided.
+      Admin requests must carry the key in %s http header.
+
+    -pubs pub server https listen port
+      Default 0, disabled.
+
+    -subs sub server https listen port
+      Default 0, disabled.
+
+    -mans manager server https listen port
+      Default 0, disabled.
+
+    -certdir dir
+      Directory of PEM files each with certificate chain and private key.
+
+    -zkcerts
+      Default false.
+      If true, also use PEM files distributed in zk under %s/{zone}.
+
+    -httpsredirect
+      Default false.
+      If true, redirect http requests to https for services with https enabled.
+
+    -seamless
+      Default true.
+      ehaproxy owns the listening sockets and hands them down to haproxy,
+      so that reload never refuses connections and in-flight requests survive.
+
     -pub pub server listen port
 
     -sub sub server listen port
@@ -365,6 +553,6 @@ Options:
     -log log file
       Default %s
 
-`, this.Cmd, this.Cmd, ctx.ZkDefaultZone(), defaultPrefix, defaultLogfile)
+`, this.Cmd, this.Cmd, ctx.ZkDefaultZone(), adminKeyHeader, zkCertsRoot, defaultPrefix, defaultLogfile)
 	return strings.TrimSpace(help)
 }
@@ -0,0 +1,120 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/funkygao/gafka/ctx"
+	log "github.com/funkygao/log4go"
+)
+
+// haproxyCmd sends a command to the runtime API stats socket of
+// every haproxy process, and returns the output of each process.
+func (this *Start) haproxyCmd(cmd string) (outputs []string, err error) {
+	for i := 0; i < ctx.NumCPU(); i++ {
+		sock := fmt.Sprintf("%s.%d", haproxySockFile, i+1) // process id starts from 1
+		output, e := runtimeCmd(sock, cmd)
+		if e != nil {
+			return nil, e
+		}
+
+		outputs = append(outputs, output)
+	}
+
+	return
+}
+
+// runtimeCmd is statsSocketCmd, replaced in tests.
+var runtimeCmd = statsSocketCmd
+
+func statsSocketCmd(sock string, cmd string) (string, error) {
+	conn, err := net.DialTimeout("unix", sock, time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(time.Second * 4))
+	if _, err = conn.Write([]byte(cmd + "\n")); err != nil {
+		return "", err
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err = scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n")), nil
+}
+
+// setServerCmd runs a command that outputs nothing on success.
+func (this *Start) setServerCmd(cmd string) error {
+	outputs, err := this.haproxyCmd(cmd)
+	if err != nil {
+		return err
+	}
+
+	for _, output := range outputs {
+		if output != "" {
+			return fmt.Errorf("%s: %s", cmd, output)
+		}
+	}
+
+	log.Debug("haproxy: %s", cmd)
+	return nil
+}
+
+func (this *Start) setServerWeight(backend, server string, weight int) error {
+	return this.setServerCmd(fmt.Sprintf("set weight %s/%s %d", backend, server, weight))
+}
+
+// setServerState sets server state to one of ready, drain or maint.
+func (this *Start) setServerState(backend, server string, state string) error {
+	return this.setServerCmd(fmt.Sprintf("set server %s/%s state %s", backend, server, state))
+}
+
+// applyRuntimeChanges applies the weight and drain changes of backends
+// through runtime API instead of a full reload.
+func (this *Start) applyRuntimeChanges(last, servers BackendServers) error {
+	for _, svc := range []struct {
+		name       string
+		last, this []Backend
+	}{
+		{"pub", last.Pub, servers.Pub},
+		{"sub", last.Sub, servers.Sub},
+		{"man", last.Man, servers.Man},
+	} {
+		lastBackends := make(map[string]Backend, len(svc.last))
+		for _, b := range svc.last {
+			lastBackends[b.Name] = b
+		}
+
+		for _, b := range svc.this {
+			old := lastBackends[b.Name]
+			if state := b.state(); old.state() != state {
+				if err := this.setServerState(svc.name, b.Name, state); err != nil {
+					return err
+				}
+
+				log.Info("%s/%s %s -> %s", svc.name, b.Name, old.state(), state)
+			}
+
+			if !b.Drain && old.Weight != b.Weight {
+				if err := this.setServerWeight(svc.name, b.Name, b.Weight); err != nil {
+					return err
+				}
+
+				log.Info("%s/%s weight %d -> %d", svc.name, b.Name, old.Weight, b.Weight)
+			}
+		}
+	}
+
+	return nil
+}
@@ -4,6 +4,10 @@ global
     log 127.0.0.1 local3 warning
     stats bind-process {{.CpuNum}}
     stats socket /tmp/haproxy.sock mode 0600 level admin
+    # runtime API of each process: weight and state changes without reload
+{{range .Dashboard}}
+    stats socket /tmp/haproxy.sock.{{.Name}} mode 0600 level admin process {{.Name}}
+{{end}}
 
     maxconn  51200
     ulimit-n 102434
@@ -15,6 +19,10 @@ global
     user  haproxy
     group haproxy
     #chroot {{.HaproxyRoot}}
+{{if .CertDir}}
+    tune.ssl.default-dh-param 2048
+    ssl-default-bind-options no-sslv3
+{{end}}
 
 defaults
     log global
@@ -57,26 +65,50 @@ listen 127.0.0.1:{{.Port}}
 {{end}}
     
 listen pub
-    bind 0.0.0.0:{{.PubPort}}
-    balance source
+    bind {{.PubBind}}
+{{if .PubsBind}}
+    bind {{.PubsBind}} ssl crt {{$.CertDir}}
+{{if $.HttpsRedirect}}
+    redirect scheme https code 301 if !{ ssl_fc }
+{{end}}
+{{end}}
+    balance {{.Balance}}
+{{with .HashType}}
+    hash-type {{.}}
+{{end}}
     #cookie PUB insert indirect # indirect means not sending cookie to backend
 {{range .Pub}}
-    server {{.Name}} {{.Addr}} weight {{.Cpu}}
+    server {{.Name}} {{.Addr}} weight {{.Weight}}{{if .Maint}} disabled{{end}}
 {{end}}
 
 listen sub
-    bind 0.0.0.0:{{.SubPort}}
-    balance source
+    bind {{.SubBind}}
+{{if .SubsBind}}
+    bind {{.SubsBind}} ssl crt {{$.CertDir}}
+{{if $.HttpsRedirect}}
+    redirect scheme https code 301 if !{ ssl_fc }
+{{end}}
+{{end}}
+    balance {{.Balance}}
+{{with .HashType}}
+    hash-type {{.}}
+{{end}}
     #balance source # uri
     #compression algo gzip
     #compression type text/html text/plain application/json
     #cookie SUB insert indirect
 {{range .Sub}}
-    server {{.Name}} {{.Addr}} weight {{.Cpu}}
+    server {{.Name}} {{.Addr}} weight {{.Weight}}{{if .Maint}} disabled{{end}}
 {{end}}
 
 listen man
-    bind 0.0.0.0:{{.ManPort}}
+    bind {{.ManBind}}
+{{if .MansBind}}
+    bind {{.MansBind}} ssl crt {{$.CertDir}}
+{{if $.HttpsRedirect}}
+    redirect scheme https code 301 if !{ ssl_fc }
+{{end}}
+{{end}}
 {{range .Man}}
-    server {{.Name}} {{.Addr}} weight {{.Cpu}}
+    server {{.Name}} {{.Addr}} weight {{.Weight}}{{if .Maint}} disabled{{end}}
 {{end}}
@@ -0,0 +1,75 @@
+package command
+
+import (
+	"strconv"
+)
+
+const (
+	weightPerCpu = 8
+	maxWeight    = 256 // haproxy server weight range is [0, 256]
+	minWeight    = 1
+)
+
+// assignWeights derives haproxy server weight of each backend from the
+// kateway reported cpu and live conns.
+//
+// A kateway gets weightPerCpu per cpu core, then the weight is scaled by
+// how loaded it is compared with the average conns per core of its peers,
+// so that a hot kateway receives less new requests.
+// Draining backends always get weight 0.
+func assignWeights(backends []Backend) {
+	var totalConns, totalCpu int
+	for _, b := range backends {
+		if b.Drain {
+			continue
+		}
+
+		totalConns += b.Conns
+		totalCpu += b.cpuNum()
+	}
+
+	var avgConnsPerCpu float64
+	if totalCpu > 0 {
+		avgConnsPerCpu = float64(totalConns) / float64(totalCpu)
+	}
+
+	for i := range backends {
+		b := &backends[i]
+		if b.Drain {
+			b.Weight = 0
+			continue
+		}
+
+		b.Weight = backendWeight(b.cpuNum(), b.Conns, avgConnsPerCpu)
+	}
+}
+
+func backendWeight(cpu, conns int, avgConnsPerCpu float64) int {
+	w := float64(cpu * weightPerCpu)
+	if avgConnsPerCpu > 0 {
+		connsPerCpu := float64(conns) / float64(cpu)
+		if connsPerCpu < avgConnsPerCpu/4 {
+			connsPerCpu = avgConnsPerCpu / 4 // at most 4x boost for the idle
+		}
+
+		w *= avgConnsPerCpu / connsPerCpu
+	}
+
+	switch {
+	case w > maxWeight:
+		return maxWeight
+	case w < minWeight:
+		return minWeight
+	default:
+		return int(w + 0.5)
+	}
+}
+
+func (this Backend) cpuNum() int {
+	n, err := strconv.Atoi(this.Cpu)
+	if err != nil || n <= 0 {
+		return 1
+	}
+
+	return n
+}
@@ -0,0 +1,28 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestAssignWeights(t *testing.T) {
+	all := []Backend{
+		Backend{Name: "p1", Cpu: "8", Conns: 800},
+		Backend{Name: "p2", Cpu: "8", Conns: 200},
+		Backend{Name: "p3", Cpu: "4", Conns: 0},
+		Backend{Name: "p4", Cpu: "8", Conns: 10, Drain: true},
+	}
+	assignWeights(all)
+
+	// avg 50 conns per cpu
+	assert.Equal(t, 32, all[0].Weight)
+	assert.Equal(t, 128, all[1].Weight)
+	assert.Equal(t, 128, all[2].Weight) // idle boosted at most 4x
+	assert.Equal(t, 0, all[3].Weight)
+}
+
+func TestBackendWeightWithoutLoad(t *testing.T) {
+	assert.Equal(t, 8*weightPerCpu, backendWeight(8, 0, 0))
+	assert.Equal(t, maxWeight, backendWeight(64, 0, 0))
+}
@@ -3,27 +3,123 @@ package command
 import (
 	"flag"
 	"fmt"
+	"net/http"
+	"os"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/funkygao/gafka/cmd/gk/command/agent"
+	"github.com/funkygao/gafka/ctx"
+	"github.com/funkygao/gafka/zk"
 	"github.com/funkygao/gocli"
+	"github.com/funkygao/golib/signal"
+	log "github.com/funkygao/log4go"
 )
 
 type Agent struct {
 	Ui  cli.Ui
 	Cmd string
+
+	zone     string
+	conf     string
+	procRoot string
+	httpAddr string
+	interval time.Duration
+	logLevel string
 }
 
 func (this *Agent) Run(args []string) (exitCode int) {
 	cmdFlags := flag.NewFlagSet("agent", flag.ContinueOnError)
 	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
+	cmdFlags.StringVar(&this.zone, "z", ctx.ZkDefaultZone(), "")
+	cmdFlags.StringVar(&this.conf, "conf", "/var/wd/kfk_*/config/server.properties", "")
+	cmdFlags.StringVar(&this.procRoot, "proc", "/proc", "")
+	cmdFlags.StringVar(&this.httpAddr, "http", ":10120", "")
+	cmdFlags.DurationVar(&this.interval, "interval", time.Second*10, "")
+	cmdFlags.StringVar(&this.logLevel, "level", "info", "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
-	// netstat -s | grep 'listen queue of a socket overflowed'
-	// ss -no state established | tail -n +2 | awk '{print $1,$2}'
+	setupLogging("agent.log", this.logLevel, "panic")
+
+	brokers, err := agent.LoadBrokers(this.conf)
+	swallow(err)
+	if len(brokers) == 0 {
+		log.Warn("no local broker found by %s", this.conf)
+	}
+
+	ip, err := ctx.LocalIP()
+	swallow(err)
+	host := ip.String()
+
+	collector := agent.NewCollector(this.procRoot, host, brokers)
+	swallow(collector.Collect(time.Now()))
+
+	go func() {
+		log.Info("agent http ready on %s", this.httpAddr)
+		if err := http.ListenAndServe(this.httpAddr, collector.Handler()); err != nil {
+			log.Critical("http: %v", err)
+		}
+	}()
+
+	meta := zk.AgentMeta{Host: host, HttpAddr: this.advertisedAddr(host)}
+	for _, b := range brokers {
+		meta.Brokers = append(meta.Brokers, fmt.Sprintf("%s:%d", b.Cluster, b.Id))
+	}
+	zkzone := zk.NewZkZone(zk.DefaultConfig(this.zone, ctx.ZoneZkAddrs(this.zone)))
+	defer zkzone.Close()
+	this.register(zkzone, meta)
+
+	quit := make(chan struct{})
+	signal.RegisterHandler(func(sig os.Signal) {
+		log.Info("received signal: %s", strings.ToUpper(sig.String()))
+		close(quit)
+	}, syscall.SIGINT, syscall.SIGTERM)
+
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			log.Info("agent stopped")
+			return
+
+		case now := <-ticker.C:
+			if err := collector.Collect(now); err != nil {
+				log.Error("collect: %v", err)
+			}
+
+			// the ephemeral znode is gone after zk session expiration
+			this.register(zkzone, meta)
+		}
+	}
+}
+
+func (this *Agent) register(zkzone *zk.ZkZone, meta zk.AgentMeta) {
+	agents, err := zkzone.AgentInfos()
+	if err != nil {
+		log.Error("agents: %v", err)
+		return
+	}
+	if _, present := agents[meta.Host]; present {
+		return
+	}
+
+	if err = zkzone.RegisterAgent(meta); err != nil {
+		log.Error("register: %v", err)
+	} else {
+		log.Info("registered in zk: %+v", meta)
+	}
+}
+
+func (this *Agent) advertisedAddr(host string) string {
+	if strings.HasPrefix(this.httpAddr, ":") {
+		return host + this.httpAddr
+	}
 
-	return
+	return this.httpAddr
 }
 
 func (*Agent) Synopsis() string {
@@ -36,6 +132,31 @@ Usage: %s agent [options]
 
     %s
 
+    Collects NIC pps, listen queue overflows, tcp retransmits, disk util
+    and await of each kafka log dir, iowait and page cache hit ratio from
+    /proc, tagged with the local broker ids.
+    The agent registers in zk and exposes the latest sample over http:
+    GET /v1/status
+
+Options:
+
+    -z zone
+
+    -conf server.properties glob pattern
+      Defaults /var/wd/kfk_*/config/server.properties
+
+    -http addr
+      Defaults :10120
+
+    -interval duration
+      Sampling interval, defaults 10s.
+
+    -proc dir
+      Defaults /proc
+
+    -level log level
+      Defaults info.
+
 `, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
 }
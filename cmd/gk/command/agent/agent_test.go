@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func round(f float64) float64 {
+	return math.Floor(f*10000+0.5) / 10000
+}
+
+func TestLoadBrokers(t *testing.T) {
+	brokers, err := LoadBrokers("testdata/kfk_*/config/server.properties")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Broker{{
+		Cluster: "demo",
+		Id:      3,
+		Port:    10001,
+		LogDirs: []string{"/data1/kafka-logs", "/data1/kafka-logs2"},
+	}}, brokers)
+}
+
+func TestMountDevice(t *testing.T) {
+	proc := procFS{root: "testdata/proc1"}
+	dev, err := proc.mountDevice("/data1/kafka-logs")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "sdb1", dev)
+	dev, _ = proc.mountDevice("/data10/kafka-logs")
+	assert.Equal(t, "sda1", dev)
+}
+
+func TestCollect(t *testing.T) {
+	brokers, _ := LoadBrokers("testdata/kfk_*/config/server.properties")
+	c := NewCollector("testdata/proc1", "10.1.1.1", brokers)
+	t0 := time.Now()
+	assert.Equal(t, nil, c.Collect(t0))
+	_, err := c.Last()
+	assert.Equal(t, ErrNotReady, err)
+
+	c.proc.root = "testdata/proc2"
+	assert.Equal(t, nil, c.Collect(t0.Add(10*time.Second)))
+	s, err := c.Last()
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, 10*time.Second, s.Interval)
+	assert.Equal(t, 1, len(s.Nics)) // lo excluded
+	assert.Equal(t, NicStat{RxPps: 1000, TxPps: 2000, RxBps: 100000, TxBps: 1000000}, s.Nics["eth0"])
+	assert.Equal(t, uint64(5), s.ListenOverflows)
+	assert.Equal(t, uint64(5), s.ListenDrops)
+	assert.Equal(t, float64(5), s.TcpRetrans)
+	assert.Equal(t, 0.5, s.TcpRetransRatio)
+	assert.Equal(t, float64(5), s.IOWait)
+
+	assert.Equal(t, 2, len(s.Disks))
+	d := s.Disks[0]
+	assert.Equal(t, 3, d.Broker)
+	assert.Equal(t, "sdb1", d.Device)
+	assert.Equal(t, float64(50), d.Util)
+	assert.Equal(t, float64(1), d.Await)
+	assert.Equal(t, float64(204800), d.ReadBps)
+	assert.Equal(t, float64(1024000), d.WriteBps)
+
+	// the 2 log dirs on the same device count once
+	assert.Equal(t, 0.7952, round(s.PageCacheHitRatio))
+}
+
+func TestHandler(t *testing.T) {
+	c := NewCollector("testdata/proc1", "10.1.1.1", nil)
+	srv := httptest.NewServer(c.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v1/status")
+	assert.Equal(t, nil, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	t0 := time.Now()
+	c.Collect(t0)
+	c.Collect(t0.Add(time.Second))
+	resp, err = http.Get(srv.URL + "/v1/status")
+	assert.Equal(t, nil, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var s Sample
+	assert.Equal(t, nil, json.NewDecoder(resp.Body).Decode(&s))
+	assert.Equal(t, "10.1.1.1", s.Host)
+}
@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var clusterExp = regexp.MustCompile(`kfk_(?P<cluster>[^/]+)/config/server.properties$`)
+
+// Broker is a local kafka broker instance found from its server.properties.
+type Broker struct {
+	Cluster string   `json:"cluster"`
+	Id      int      `json:"id"`
+	Port    int      `json:"port,omitempty"`
+	LogDirs []string `json:"log_dirs"`
+}
+
+// LoadBrokers finds all local brokers whose server.properties match the
+// glob pattern, e.g. /var/wd/kfk_*/config/server.properties
+func LoadBrokers(pattern string) ([]Broker, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	brokers := make([]Broker, 0, len(files))
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := parseServerProperties(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if m := clusterExp.FindStringSubmatch(file); m != nil {
+			b.Cluster = m[1]
+		}
+		brokers = append(brokers, b)
+	}
+
+	return brokers, nil
+}
+
+func parseServerProperties(r io.Reader) (b Broker, err error) {
+	props := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		props[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	if b.Id, err = strconv.Atoi(props["broker.id"]); err != nil {
+		return
+	}
+	b.Port, _ = strconv.Atoi(props["port"])
+
+	dirs := props["log.dirs"]
+	if dirs == "" {
+		dirs = props["log.dir"]
+	}
+	for _, dir := range strings.Split(dirs, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			b.LogDirs = append(b.LogDirs, dir)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,208 @@
+package agent
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const sectorSize = 512
+
+var ErrNotReady = errors.New("collecting, retry later")
+
+// NicStat is the throughput of a network interface.
+type NicStat struct {
+	RxPps float64 `json:"rx_pps"`
+	TxPps float64 `json:"tx_pps"`
+	RxBps float64 `json:"rx_bps"`
+	TxBps float64 `json:"tx_bps"`
+}
+
+// DiskStat is the I/O stat of the device that holds a kafka log dir.
+type DiskStat struct {
+	Broker   int     `json:"broker"`
+	Dir      string  `json:"dir"`
+	Device   string  `json:"device"`
+	Util     float64 `json:"util"`  // percent
+	Await    float64 `json:"await"` // ms per I/O
+	ReadBps  float64 `json:"read_bps"`
+	WriteBps float64 `json:"write_bps"`
+}
+
+// Sample is the host diagnostics within an interval.
+type Sample struct {
+	Host     string        `json:"host"`
+	Time     time.Time     `json:"time"`
+	Interval time.Duration `json:"interval"`
+	Brokers  []Broker      `json:"brokers"`
+
+	Nics            map[string]NicStat `json:"nics"`
+	ListenOverflows uint64             `json:"listen_overflows"`
+	ListenDrops     uint64             `json:"listen_drops"`
+	TcpRetrans      float64            `json:"tcp_retrans"`       // segments per second
+	TcpRetransRatio float64            `json:"tcp_retrans_ratio"` // percent of out segments
+	IOWait          float64            `json:"iowait"`            // percent
+	Disks           []DiskStat         `json:"disks"`
+
+	// PageCacheHitRatio is estimated as 1 - disk reads/network out: on a
+	// broker nearly all network out is fetch response served either from
+	// page cache or the disk.
+	PageCacheHitRatio float64 `json:"pagecache_hit_ratio"`
+}
+
+// Collector samples the host diagnostics by diffing successive snapshots
+// of /proc counters.
+type Collector struct {
+	proc    procFS
+	host    string
+	brokers []Broker
+
+	mu       sync.RWMutex
+	prev     *counters
+	prevTime time.Time
+	last     *Sample
+}
+
+func NewCollector(procRoot, host string, brokers []Broker) *Collector {
+	return &Collector{
+		proc:    procFS{root: procRoot},
+		host:    host,
+		brokers: brokers,
+	}
+}
+
+// Collect takes a snapshot at now and updates the latest sample.
+func (this *Collector) Collect(now time.Time) error {
+	cur, err := this.proc.snapshot()
+	if err != nil {
+		return err
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.prev != nil {
+		sample, err := this.diff(this.prev, cur, now.Sub(this.prevTime))
+		if err != nil {
+			return err
+		}
+		sample.Time = now
+		this.last = sample
+	}
+
+	this.prev, this.prevTime = cur, now
+	return nil
+}
+
+// Last returns the latest sample.
+func (this *Collector) Last() (*Sample, error) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	if this.last == nil {
+		return nil, ErrNotReady
+	}
+	return this.last, nil
+}
+
+func (this *Collector) diff(prev, cur *counters, interval time.Duration) (*Sample, error) {
+	secs := interval.Seconds()
+	if secs <= 0 {
+		return nil, ErrNotReady
+	}
+	rate := func(a, b uint64) float64 {
+		return float64(delta(a, b)) / secs
+	}
+
+	s := &Sample{
+		Host:     this.host,
+		Interval: interval,
+		Brokers:  this.brokers,
+		Nics:     make(map[string]NicStat),
+		Disks:    make([]DiskStat, 0),
+	}
+
+	var netOut float64
+	for name, c := range cur.nics {
+		p, present := prev.nics[name]
+		if !present || name == "lo" {
+			continue
+		}
+
+		nic := NicStat{
+			RxPps: rate(p.rxPackets, c.rxPackets),
+			TxPps: rate(p.txPackets, c.txPackets),
+			RxBps: rate(p.rxBytes, c.rxBytes),
+			TxBps: rate(p.txBytes, c.txBytes),
+		}
+		s.Nics[name] = nic
+		netOut += nic.TxBps
+	}
+
+	s.ListenOverflows = delta(prev.listenOverflows, cur.listenOverflows)
+	s.ListenDrops = delta(prev.listenDrops, cur.listenDrops)
+	s.TcpRetrans = rate(prev.retransSegs, cur.retransSegs)
+	if outSegs := delta(prev.outSegs, cur.outSegs); outSegs > 0 {
+		s.TcpRetransRatio = percent(delta(prev.retransSegs, cur.retransSegs), outSegs)
+	}
+	if total := delta(prev.cpuTotal, cur.cpuTotal); total > 0 {
+		s.IOWait = percent(delta(prev.cpuIowait, cur.cpuIowait), total)
+	}
+
+	var diskRead float64
+	seen := make(map[string]bool)
+	for _, b := range this.brokers {
+		for _, dir := range b.LogDirs {
+			device, err := this.proc.mountDevice(dir)
+			if err != nil {
+				return nil, err
+			}
+
+			ds := DiskStat{Broker: b.Id, Dir: dir, Device: device}
+			p, c := prev.disks[device], cur.disks[device]
+			ds.Util = percent(delta(p.msIo, c.msIo), uint64(interval/time.Millisecond))
+			if ios := delta(p.reads+p.writes, c.reads+c.writes); ios > 0 {
+				ds.Await = float64(delta(p.msReading+p.msWriting, c.msReading+c.msWriting)) / float64(ios)
+			}
+			ds.ReadBps = rate(p.sectorsRead, c.sectorsRead) * sectorSize
+			ds.WriteBps = rate(p.sectorsWritten, c.sectorsWritten) * sectorSize
+			s.Disks = append(s.Disks, ds)
+
+			if !seen[device] {
+				// log dirs might share the same device
+				seen[device] = true
+				diskRead += ds.ReadBps
+			}
+		}
+	}
+
+	s.PageCacheHitRatio = 1
+	if netOut > 0 {
+		s.PageCacheHitRatio = 1 - diskRead/netOut
+		if s.PageCacheHitRatio < 0 {
+			s.PageCacheHitRatio = 0
+		}
+	}
+
+	return s, nil
+}
+
+// delta tolerates counter reset.
+func delta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return 0
+	}
+	return cur - prev
+}
+
+func percent(part, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	p := float64(part) * 100 / float64(total)
+	if p > 100 {
+		p = 100
+	}
+	return p
+}
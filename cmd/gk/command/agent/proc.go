@@ -0,0 +1,240 @@
+package agent
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type nicCounter struct {
+	rxBytes, rxPackets uint64
+	txBytes, txPackets uint64
+}
+
+type diskCounter struct {
+	reads, writes               uint64
+	sectorsRead, sectorsWritten uint64
+	msReading, msWriting        uint64
+	msIo                        uint64 // time spent doing I/Os
+}
+
+// counters is a snapshot of the cumulative kernel counters.
+type counters struct {
+	nics  map[string]nicCounter
+	disks map[string]diskCounter
+
+	listenOverflows, listenDrops uint64
+	retransSegs, outSegs         uint64
+
+	cpuTotal, cpuIowait uint64
+}
+
+// procFS reads kernel counters under a proc root, which is /proc except
+// for tests.
+type procFS struct {
+	root string
+}
+
+func (this procFS) open(name string) (*bufio.Scanner, func(), error) {
+	f, err := os.Open(filepath.Join(this.root, name))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return bufio.NewScanner(f), func() { f.Close() }, nil
+}
+
+func (this procFS) snapshot() (*counters, error) {
+	c := &counters{}
+	var err error
+	if c.nics, err = this.netDev(); err != nil {
+		return nil, err
+	}
+	if c.disks, err = this.diskStats(); err != nil {
+		return nil, err
+	}
+
+	netstat, err := this.keyedStats("net/netstat")
+	if err != nil {
+		return nil, err
+	}
+	c.listenOverflows = netstat["TcpExt"]["ListenOverflows"]
+	c.listenDrops = netstat["TcpExt"]["ListenDrops"]
+
+	snmp, err := this.keyedStats("net/snmp")
+	if err != nil {
+		return nil, err
+	}
+	c.retransSegs = snmp["Tcp"]["RetransSegs"]
+	c.outSegs = snmp["Tcp"]["OutSegs"]
+
+	if c.cpuTotal, c.cpuIowait, err = this.cpu(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// netDev parses /proc/net/dev:
+// face |bytes packets errs drop fifo frame compressed multicast|bytes packets ...
+func (this procFS) netDev() (map[string]nicCounter, error) {
+	scanner, closer, err := this.open("net/dev")
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	nics := make(map[string]nicCounter)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			// header lines
+			continue
+		}
+
+		fields := strings.Fields(parts[1])
+		if len(fields) < 10 {
+			continue
+		}
+
+		nics[strings.TrimSpace(parts[0])] = nicCounter{
+			rxBytes:   parseUint(fields[0]),
+			rxPackets: parseUint(fields[1]),
+			txBytes:   parseUint(fields[8]),
+			txPackets: parseUint(fields[9]),
+		}
+	}
+
+	return nics, scanner.Err()
+}
+
+// keyedStats parses the header/value line pairs of /proc/net/netstat and
+// /proc/net/snmp, e.g.
+// Tcp: RtoAlgorithm RtoMin ... RetransSegs
+// Tcp: 1 200 ... 1234
+func (this procFS) keyedStats(name string) (map[string]map[string]uint64, error) {
+	scanner, closer, err := this.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	stats := make(map[string]map[string]uint64)
+	var header []string
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		if header == nil || header[0] != fields[0] {
+			header = fields
+			continue
+		}
+
+		prefix := strings.TrimSuffix(fields[0], ":")
+		stats[prefix] = make(map[string]uint64)
+		for i := 1; i < len(fields) && i < len(header); i++ {
+			stats[prefix][header[i]] = parseUint(fields[i])
+		}
+		header = nil
+	}
+
+	return stats, scanner.Err()
+}
+
+// cpu parses the aggregated cpu line of /proc/stat:
+// cpu user nice system idle iowait irq softirq steal guest guest_nice
+func (this procFS) cpu() (total, iowait uint64, err error) {
+	scanner, closer, err := this.open("stat")
+	if err != nil {
+		return
+	}
+	defer closer()
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[0] != "cpu" {
+			continue
+		}
+
+		for i := 1; i < len(fields) && i <= 8; i++ {
+			// guest time is already accounted in user time
+			total += parseUint(fields[i])
+		}
+		iowait = parseUint(fields[5])
+		return
+	}
+
+	return 0, 0, scanner.Err()
+}
+
+// diskStats parses /proc/diskstats:
+// major minor name reads merged sectors ms writes merged sectors ms inflight ms_io weighted_ms
+func (this procFS) diskStats() (map[string]diskCounter, error) {
+	scanner, closer, err := this.open("diskstats")
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	disks := make(map[string]diskCounter)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 14 {
+			continue
+		}
+
+		disks[fields[2]] = diskCounter{
+			reads:          parseUint(fields[3]),
+			sectorsRead:    parseUint(fields[5]),
+			msReading:      parseUint(fields[6]),
+			writes:         parseUint(fields[7]),
+			sectorsWritten: parseUint(fields[9]),
+			msWriting:      parseUint(fields[10]),
+			msIo:           parseUint(fields[12]),
+		}
+	}
+
+	return disks, scanner.Err()
+}
+
+// mountDevice returns the block device name in diskstats that holds dir,
+// found by the longest mount point prefix in /proc/mounts.
+func (this procFS) mountDevice(dir string) (string, error) {
+	scanner, closer, err := this.open("mounts")
+	if err != nil {
+		return "", err
+	}
+	defer closer()
+
+	var device, mountPoint string
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+
+		mp := fields[1]
+		if dir != mp && !strings.HasPrefix(dir, strings.TrimSuffix(mp, "/")+"/") {
+			continue
+		}
+		if len(mp) > len(mountPoint) {
+			mountPoint = mp
+			device = filepath.Base(fields[0])
+		}
+	}
+
+	return device, scanner.Err()
+}
+
+func parseUint(s string) uint64 {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		// e,g. Tcp MaxConn is -1
+		return 0
+	}
+	return n
+}
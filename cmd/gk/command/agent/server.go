@@ -0,0 +1,34 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Handler exposes the latest sample so that kguard and `gk host` can
+// diagnose the host remotely.
+//
+// GET /v1/status
+// GET /v1/ping
+func (this *Collector) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	mux.HandleFunc("/v1/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf8")
+
+		sample, err := this.Last()
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			b, _ := json.Marshal(map[string]string{"errmsg": err.Error()})
+			w.Write(b)
+			return
+		}
+
+		b, _ := json.Marshal(sample)
+		w.Write(b)
+	})
+
+	return mux
+}
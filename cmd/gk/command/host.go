@@ -1,9 +1,14 @@
 package command
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,125 +17,563 @@ import (
 	"github.com/funkygao/gafka/zk"
 	"github.com/funkygao/gocli"
 	"github.com/funkygao/golib/color"
+	"github.com/ryanuber/columnize"
 )
 
 type Host struct {
 	Ui  cli.Ui
 	Cmd string
 
-	zone string
-	host string
+	zone     string
+	host     string
+	jsonMode bool
+	names    []string // reverse dns names of host
+}
+
+type hostBroker struct {
+	Cluster    string `json:"cluster"`
+	Id         string `json:"id"`
+	Addr       string `json:"addr"`
+	Live       bool   `json:"live"`
+	Registered bool   `json:"registered"`
+	Controller bool   `json:"controller"`
+	Epoch      string `json:"epoch,omitempty"`
+	Uptime     string `json:"uptime,omitempty"`
+}
+
+type hostPartition struct {
+	Cluster         string  `json:"cluster"`
+	Topic           string  `json:"topic"`
+	Partition       int32   `json:"partition"`
+	Leader          bool    `json:"leader"`
+	Replicas        []int32 `json:"replicas"`
+	Isr             []int   `json:"isr"`
+	UnderReplicated bool    `json:"under_replicated"`
+}
+
+type hostConsumer struct {
+	Cluster    string   `json:"cluster"`
+	Group      string   `json:"group"`
+	ConsumerId string   `json:"consumer"`
+	Topics     []string `json:"topics"`
+	Owned      int      `json:"owned_partitions"`
+}
+
+type hostZkSession struct {
+	Server      string `json:"server"`
+	Client      string `json:"client"`
+	Sid         string `json:"sid"`
+	Established string `json:"est"`
+	Timeout     string `json:"timeout"`
+	LastOp      string `json:"lop"`
+	AvgLatency  string `json:"avglat"`
+	MaxLatency  string `json:"maxlat"`
+}
+
+type hostReport struct {
+	Host       string          `json:"host"`
+	Names      []string        `json:"names"`
+	Brokers    []hostBroker    `json:"brokers"`
+	Partitions []hostPartition `json:"partitions"`
+	Consumers  []hostConsumer  `json:"consumers"`
+	Kateways   []string        `json:"kateways"`
+	Actors     []string        `json:"actors"`
+	Kguard     bool            `json:"kguard_leader"`
+	ZkSessions []hostZkSession `json:"zk_sessions"`
+
+	// partial failures that leave a cluster section incomplete, key is cluster
+	Errors map[string][]string `json:"errors,omitempty"`
+
+	// latest sample of the gk agent on the host if registered
+	Agent json.RawMessage `json:"agent,omitempty"`
 }
 
 func (this *Host) Run(args []string) (exitCode int) {
 	cmdFlags := flag.NewFlagSet("host", flag.ContinueOnError)
 	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
-	cmdFlags.StringVar(&this.zone, "z", "", "")
+	cmdFlags.StringVar(&this.zone, "z", ctx.ZkDefaultZone(), "")
 	cmdFlags.StringVar(&this.host, "ip", "", "")
+	cmdFlags.BoolVar(&this.jsonMode, "json", false, "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
 	if validateArgs(this, this.Ui).
-		require("-z", "-h").
+		require("-ip").
 		invalid(args) {
 		return 2
 	}
 
-	for {
-		this.diagnose()
-		this.Ui.Output(color.Green("%s", strings.Repeat("=", 40)))
+	if names, err := net.LookupAddr(this.host); err == nil {
+		for _, name := range names {
+			this.names = append(this.names, strings.TrimSuffix(name, "."))
+		}
+	}
 
-		time.Sleep(time.Second * 5)
+	zkzone := zk.NewZkZone(zk.DefaultConfig(this.zone, ctx.ZoneZkAddrs(this.zone)))
+	defer zkzone.Close()
+
+	report := this.diagnose(zkzone)
+	if this.jsonMode {
+		b, _ := json.MarshalIndent(report, "", "    ")
+		this.Ui.Output(string(b))
+		return
 	}
 
+	this.render(report)
 	return
 }
 
-func (this *Host) diagnose() {
-	clusters := make([]string, 0)
-	leadingTopics := make(map[string][]int32) // topic:partitionID
-	msgs := make(map[string]int64)
+func (this *Host) diagnose(zkzone *zk.ZkZone) *hostReport {
+	report := &hostReport{
+		Host:       this.host,
+		Names:      this.names,
+		Brokers:    make([]hostBroker, 0),
+		Partitions: make([]hostPartition, 0),
+		Consumers:  make([]hostConsumer, 0),
+		Kateways:   make([]string, 0),
+		Actors:     make([]string, 0),
+		ZkSessions: make([]hostZkSession, 0),
+	}
+
+	this.diagnoseBrokers(zkzone, report)
+	zkzone.ForSortedClusters(func(zkcluster *zk.ZkCluster) {
+		this.diagnoseConsumers(zkcluster, report)
+	})
+	this.diagnosePubsub(zkzone, report)
+
+	cons := zkzone.RunZkFourLetterCommand("cons")
+	servers := make([]string, 0, len(cons))
+	for server := range cons {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	for _, server := range servers {
+		report.ZkSessions = append(report.ZkSessions, parseZkCons(server, this.host, cons[server])...)
+	}
+
+	report.Agent = this.agentSample(zkzone)
+	return report
+}
+
+func (this *Host) diagnoseBrokers(zkzone *zk.ZkZone, report *hostReport) {
+	controllers := make(map[string]*zk.ControllerMeta)
+	zkzone.ForSortedControllers(func(cluster string, controller *zk.ControllerMeta) {
+		controllers[cluster] = controller
+	})
 
-	// check if it is registered
-	zkzone := zk.NewZkZone(zk.DefaultConfig(this.zone, ctx.ZoneZkAddrs(this.zone)))
 	zkzone.ForSortedBrokers(func(cluster string, liveBrokers map[string]*zk.BrokerZnode) {
 		zkcluster := zkzone.NewCluster(cluster)
 
-		for _, broker := range liveBrokers {
+		var ids []int32
+		for id, broker := range liveBrokers {
 			if broker.Host != this.host {
 				continue
 			}
 
-			this.Ui.Output(broker.String())
+			b := hostBroker{
+				Cluster: cluster,
+				Id:      id,
+				Addr:    broker.Addr(),
+				Live:    true,
+				Uptime:  time.Since(broker.Uptime()).String(),
+			}
+			if c := controllers[cluster]; c != nil && c.Broker.Id == id {
+				b.Controller = true
+				b.Epoch = c.Epoch
+			}
+			report.Brokers = append(report.Brokers, b)
+
+			brokerId, _ := strconv.Atoi(id)
+			ids = append(ids, int32(brokerId))
+		}
+
+		for _, b := range zkcluster.RegisteredInfo().Roster {
+			if b.Host != this.host {
+				continue
+			}
+
+			found := false
+			for i := range report.Brokers {
+				if report.Brokers[i].Cluster == cluster && report.Brokers[i].Id == strconv.Itoa(b.Id) {
+					report.Brokers[i].Registered = true
+					found = true
+				}
+			}
+			if !found {
+				// registered but not alive
+				report.Brokers = append(report.Brokers, hostBroker{
+					Cluster:    cluster,
+					Id:         strconv.Itoa(b.Id),
+					Addr:       b.Addr(),
+					Registered: true,
+				})
+			}
+		}
+
+		if len(ids) > 0 {
+			this.diagnosePartitions(zkcluster, ids, report)
+		}
+	})
+}
+
+// diagnosePartitions finds the partitions the brokers lead or follow.
+func (this *Host) diagnosePartitions(zkcluster *zk.ZkCluster, ids []int32, report *hostReport) {
+	kfk, err := sarama.NewClient(zkcluster.BrokerList(), saramaConfig())
+	if err != nil {
+		report.addError(zkcluster.Name(), err.Error())
+		return
+	}
+	defer kfk.Close()
+
+	hosted := func(id int32) bool {
+		for _, i := range ids {
+			if i == id {
+				return true
+			}
+		}
+		return false
+	}
 
-			clusters = append(clusters, cluster)
+	topics, err := kfk.Topics()
+	if err != nil {
+		report.addError(zkcluster.Name(), fmt.Sprintf("topics: %v", err))
+		return
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		partitions, err := kfk.Partitions(topic)
+		if err != nil {
+			report.addError(zkcluster.Name(), fmt.Sprintf("topic[%s]: %v", topic, err))
+			continue
+		}
 
-			kfk, err := sarama.NewClient([]string{broker.Addr()}, saramaConfig())
+		for _, partitionID := range partitions {
+			replicas, err := kfk.Replicas(topic, partitionID)
 			if err != nil {
-				this.Ui.Error(err.Error())
 				continue
 			}
 
-			topics, err := kfk.Topics()
-			swallow(err)
-			for _, topic := range topics {
-				partions, err := kfk.WritablePartitions(topic)
-				swallow(err)
-				for _, partitionID := range partions {
-					leader, err := kfk.Leader(topic, partitionID)
-					swallow(err)
-					host, _, err := net.SplitHostPort(leader.Addr())
-					swallow(err)
-					if host != this.host {
-						continue
-					}
+			onHost := false
+			for _, r := range replicas {
+				if hosted(r) {
+					onHost = true
+					break
+				}
+			}
+			if !onHost {
+				continue
+			}
+
+			p := hostPartition{
+				Cluster:   zkcluster.Name(),
+				Topic:     topic,
+				Partition: partitionID,
+				Replicas:  replicas,
+			}
+			if leader, err := kfk.Leader(topic, partitionID); err == nil {
+				p.Leader = hosted(leader.ID())
+			}
+			p.Isr, _, _ = zkcluster.Isr(topic, partitionID)
+			p.UnderReplicated = len(p.Isr) != len(replicas)
+			report.Partitions = append(report.Partitions, p)
+		}
+	}
+}
+
+// diagnoseConsumers finds the consumer group members running on the host.
+func (this *Host) diagnoseConsumers(zkcluster *zk.ZkCluster, report *hostReport) {
+	groups := zkcluster.ConsumerGroups()
+	sortedGroups := make([]string, 0, len(groups))
+	for group := range groups {
+		sortedGroups = append(sortedGroups, group)
+	}
+	sort.Strings(sortedGroups)
 
-					latestOffset, err := kfk.GetOffset(topic, partitionID, sarama.OffsetNewest)
-					swallow(err)
-					oldestOffset, err := kfk.GetOffset(topic, partitionID, sarama.OffsetOldest)
-					swallow(err)
+	for _, group := range sortedGroups {
+		for consumerId, c := range groups[group] {
+			if !this.matches(consumerId) {
+				continue
+			}
 
-					msgs[topic] += (latestOffset - oldestOffset)
-					if _, present := leadingTopics[topic]; !present {
-						leadingTopics[topic] = make([]int32, 0)
+			hc := hostConsumer{
+				Cluster:    zkcluster.Name(),
+				Group:      group,
+				ConsumerId: consumerId,
+			}
+			for topic := range c.Subscription {
+				hc.Topics = append(hc.Topics, topic)
+				for _, owner := range zkcluster.OwnersOfGroupByTopic(group, topic) {
+					if owner == consumerId {
+						hc.Owned++
 					}
-					leadingTopics[topic] = append(leadingTopics[topic], partitionID)
 				}
 			}
+			sort.Strings(hc.Topics)
+			report.Consumers = append(report.Consumers, hc)
+		}
+	}
+}
 
-			kfk.Close()
+func (this *Host) diagnosePubsub(zkzone *zk.ZkZone, report *hostReport) {
+	kateways, err := zkzone.KatewayInfos()
+	if err != nil {
+		this.Ui.Error(err.Error())
+	}
+	for _, kw := range kateways {
+		if kw.Ip == this.host {
+			report.Kateways = append(report.Kateways, fmt.Sprintf("%s ver:%s state:%s",
+				kw.Id, kw.Ver, kw.State))
 		}
+	}
 
-		registeredBrokers := zkcluster.RegisteredInfo().Roster
-		for _, b := range registeredBrokers {
-			if b.Host == this.host {
-				this.Ui.Output(fmt.Sprintf("registered in cluster: %s", cluster))
+	// actor id is hostname:uuid, value holds the listen addr
+	for id, data := range zkzone.ChildrenWithData(zk.PubsubActors) {
+		var actor struct {
+			Addr string `json:"addr"`
+		}
+		json.Unmarshal(data.Data(), &actor)
+		if this.matches(strings.SplitN(id, ":", 2)[0]) || strings.HasPrefix(actor.Addr, this.host+":") {
+			report.Actors = append(report.Actors, id)
+		}
+	}
+	sort.Strings(report.Actors)
+
+	// the leader znode holds the ip of the elected kguard, the candidates
+	// are anonymous
+	if leader, _, err := zkzone.Conn().Get("/" + zk.KguardLeaderPath); err == nil {
+		report.Kguard = this.matches(string(leader))
+	}
+}
+
+// agentSample fetches the latest sample from gk agent on the host.
+func (this *Host) agentSample(zkzone *zk.ZkZone) json.RawMessage {
+	agents, err := zkzone.AgentInfos()
+	if err != nil {
+		return nil
+	}
+	agent, present := agents[this.host]
+	if !present {
+		return nil
+	}
+
+	client := http.Client{Timeout: time.Second * 5}
+	resp, err := client.Get(fmt.Sprintf("http://%s/v1/status", agent.HttpAddr))
+	if err != nil {
+		this.Ui.Warn(fmt.Sprintf("agent %s: %v", agent.HttpAddr, err))
+		return nil
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	return json.RawMessage(b)
+}
+
+// matches checks if a hostname or id refers to the host.
+func (this *Host) matches(s string) bool {
+	if containsToken(s, this.host) {
+		return true
+	}
+
+	for _, name := range this.names {
+		short := strings.SplitN(name, ".", 2)[0]
+		if containsToken(s, name) || containsToken(s, short) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsToken checks if tok is in s as a whole ip or hostname, e,g.
+// 10.1.1.1 is not in 10.1.1.10.
+func containsToken(s, tok string) bool {
+	if tok == "" {
+		return false
+	}
+
+	for i := 0; ; {
+		idx := strings.Index(s[i:], tok)
+		if idx == -1 {
+			return false
+		}
+
+		start, end := i+idx, i+idx+len(tok)
+		if (start == 0 || !isHostChar(s[start-1])) && (end == len(s) || !isHostChar(s[end])) {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+func isHostChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.'
+}
+
+// parseZkCons extracts the sessions of the client host from output of
+// zk 'cons' four letter word:
+// /10.1.1.1:54321[1](queued=0,recved=9,sent=9,sid=0x1558fe8d8cd0001,lop=PING,est=1469006543210,to=30000,...)
+func parseZkCons(server, host, output string) []hostZkSession {
+	var sessions []hostZkSession
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "/"+host+":") {
+			continue
+		}
+
+		lp, rp := strings.IndexByte(line, '('), strings.LastIndexByte(line, ')')
+		if lp < 0 || rp < lp {
+			continue
+		}
+
+		lb := strings.IndexByte(line, '[')
+		if lb < 0 || lb > lp {
+			continue
+		}
+
+		session := hostZkSession{Server: server, Client: line[1:lb]}
+		for _, kv := range strings.Split(line[lp+1:rp], ",") {
+			p := strings.SplitN(kv, "=", 2)
+			if len(p) != 2 {
+				continue
+			}
+
+			switch p[0] {
+			case "sid":
+				session.Sid = p[1]
+			case "est":
+				if ms, err := strconv.ParseInt(p[1], 10, 64); err == nil {
+					session.Established = time.Unix(ms/1000, 0).Format("2006-01-02 15:04:05")
+				}
+			case "to":
+				session.Timeout = p[1] + "ms"
+			case "lop":
+				session.LastOp = p[1]
+			case "avglat":
+				session.AvgLatency = p[1] + "ms"
+			case "maxlat":
+				session.MaxLatency = p[1] + "ms"
 			}
 		}
-	})
+		sessions = append(sessions, session)
+	}
+
+	return sessions
+}
 
-	this.Ui.Output(color.Cyan("clusters\n%s", strings.Repeat("-", 80)))
-	for _, c := range clusters {
-		this.Ui.Output(c)
+func (this *hostReport) addError(cluster, err string) {
+	if this.Errors == nil {
+		this.Errors = make(map[string][]string)
+	}
+	this.Errors[cluster] = append(this.Errors[cluster], err)
+}
+
+func (this *Host) render(report *hostReport) {
+	section := func(title string) {
+		this.Ui.Output(color.Cyan("%s\n%s", title, strings.Repeat("-", 80)))
 	}
 
-	this.Ui.Output(color.Cyan("clusters\n%s", strings.Repeat("-", 80)))
-	for t, ps := range leadingTopics {
-		this.Ui.Output(fmt.Sprintf("%35s M:%-10d P:%+v", t, msgs[t], ps))
+	section(fmt.Sprintf("host %s %v", report.Host, report.Names))
+	lines := []string{"Cluster|Broker|Addr|Live|Registered|Controller|Uptime"}
+	for _, b := range report.Brokers {
+		controller := ""
+		if b.Controller {
+			controller = "epoch:" + b.Epoch
+		}
+		lines = append(lines, fmt.Sprintf("%s|%s|%s|%v|%v|%s|%s", b.Cluster, b.Id, b.Addr,
+			b.Live, b.Registered, controller, b.Uptime))
+	}
+	this.Ui.Output(columnize.SimpleFormat(lines))
+
+	section("partitions")
+	var leading, underReplicated int
+	lines = []string{"Cluster|Topic|Partition|Role|Replicas|Isr"}
+	for _, p := range report.Partitions {
+		role := "follower"
+		if p.Leader {
+			role = "leader"
+			leading++
+		}
+		isr := fmt.Sprintf("%+v", p.Isr)
+		if p.UnderReplicated {
+			underReplicated++
+			isr = color.Red(isr)
+		}
+		lines = append(lines, fmt.Sprintf("%s|%s|%d|%s|%+v|%s", p.Cluster, p.Topic, p.Partition,
+			role, p.Replicas, isr))
+	}
+	this.Ui.Output(columnize.SimpleFormat(lines))
+	this.Ui.Output(fmt.Sprintf("total:%d leading:%d under replicated:%d",
+		len(report.Partitions), leading, underReplicated))
+	clusters := make([]string, 0, len(report.Errors))
+	for cluster := range report.Errors {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+	for _, cluster := range clusters {
+		for _, err := range report.Errors[cluster] {
+			this.Ui.Output(color.Red("%s incomplete: %s", cluster, err))
+		}
+	}
+
+	section("consumers")
+	lines = []string{"Cluster|Group|Consumer|Owned|Topics"}
+	for _, c := range report.Consumers {
+		lines = append(lines, fmt.Sprintf("%s|%s|%s|%d|%s", c.Cluster, c.Group, c.ConsumerId,
+			c.Owned, strings.Join(c.Topics, ",")))
+	}
+	this.Ui.Output(columnize.SimpleFormat(lines))
+
+	section("pubsub")
+	for _, kw := range report.Kateways {
+		this.Ui.Output(fmt.Sprintf("kateway %s", kw))
+	}
+	for _, actor := range report.Actors {
+		this.Ui.Output(fmt.Sprintf("actord %s", actor))
+	}
+	if report.Kguard {
+		this.Ui.Output("kguard leader")
+	}
+
+	section("zk sessions")
+	lines = []string{"Server|Client|Sid|Established|Timeout|LastOp|AvgLat|MaxLat"}
+	for _, s := range report.ZkSessions {
+		lines = append(lines, fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s|%s", s.Server, s.Client, s.Sid,
+			s.Established, s.Timeout, s.LastOp, s.AvgLatency, s.MaxLatency))
+	}
+	this.Ui.Output(columnize.SimpleFormat(lines))
+
+	if len(report.Agent) > 0 {
+		section("agent")
+		this.Ui.Output(string(report.Agent))
 	}
 }
 
 func (*Host) Synopsis() string {
-	return "Diagnose a broker by ip address TODO"
+	return "Diagnose a broker by ip address"
 }
 
 func (this *Host) Help() string {
 	help := fmt.Sprintf(`
-Usage: %s host -z zone -ip addr
+Usage: %s host [options] -ip addr
 
     %s
 
+    Reports the brokers living on the host, partitions it leads or follows,
+    controller status, consumer group members, kateway/actord/kguard
+    instances and zk sessions from the host.
+
+Options:
+
+    -z zone
+
+    -ip addr
+
+    -json
+      Output the report in json.
+
 `, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
 }
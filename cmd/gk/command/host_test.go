@@ -0,0 +1,45 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestParseZkCons(t *testing.T) {
+	output := ` /10.1.1.1:54321[1](queued=0,recved=9,sent=9,sid=0x1558fe8d8cd0001,lop=PING,est=1469006543210,to=30000,lcxid=0x2,lzxid=0xffffffffffffffff,lresp=1469006553210,llat=0,minlat=0,avglat=1,maxlat=5)
+ /10.1.1.10:54322[1](queued=0,recved=1,sent=1,sid=0x1558fe8d8cd0002,lop=GETD,est=1469006543210,to=6000)
+ /127.0.0.1:49152[0](queued=0,recved=1,sent=0)
+`
+	sessions := parseZkCons("zk1:2181", "10.1.1.1", output)
+	assert.Equal(t, 1, len(sessions))
+	assert.Equal(t, "zk1:2181", sessions[0].Server)
+	assert.Equal(t, "10.1.1.1:54321", sessions[0].Client)
+	assert.Equal(t, "0x1558fe8d8cd0001", sessions[0].Sid)
+	assert.Equal(t, "30000ms", sessions[0].Timeout)
+	assert.Equal(t, "PING", sessions[0].LastOp)
+	assert.Equal(t, "5ms", sessions[0].MaxLatency)
+
+	// malformed
+	sessions = parseZkCons("zk1:2181", "10.1.1.1", " /10.1.1.1:54321(queued=0,recved=9,sent=9)\n")
+	assert.Equal(t, 0, len(sessions))
+}
+
+func TestHostMatches(t *testing.T) {
+	h := &Host{host: "10.1.1.1", names: []string{"web1.example.com"}}
+	assert.Equal(t, true, h.matches("10.1.1.1"))
+	assert.Equal(t, true, h.matches("group_10.1.1.1-1469006543210-5e8b1a2c"))
+	assert.Equal(t, true, h.matches("web1:4f1c0bd6"))
+	assert.Equal(t, true, h.matches("web1.example.com"))
+	assert.Equal(t, false, h.matches("10.1.1.10"))
+	assert.Equal(t, false, h.matches("110.1.1.1:4f1c0bd6"))
+	assert.Equal(t, false, h.matches("web10:4f1c0bd6"))
+}
+
+func TestHostReportAddError(t *testing.T) {
+	var report hostReport
+	report.addError("c1", "topics: broken")
+	report.addError("c1", "topic[t1]: broken")
+	assert.Equal(t, 2, len(report.Errors["c1"]))
+	assert.Equal(t, 0, len(report.Errors["c2"]))
+}
@@ -184,7 +184,7 @@ func (this *Job) printResourcesAndActors() {
 
 	// webhooks
 	lines = lines[:0]
-	header = "Topic|Endpoints|Ctime|Mtime"
+	header = "Topic|Endpoints|Filter|Ctime|Mtime"
 	lines = append(lines, header)
 	webhoooks := this.zkzone.ChildrenWithData(zk.PubsubWebhooks)
 	sortedName = sortedName[:0]
@@ -198,10 +198,10 @@ func (this *Job) printResourcesAndActors() {
 		var hook zk.WebhookMeta
 		hook.From(zdata.Data())
 		if appid := manager.Default.TopicAppid(topic); appid == "" {
-			lines = append(lines, fmt.Sprintf("?%s|%+v|%s|%s", topic, hook.Endpoints,
+			lines = append(lines, fmt.Sprintf("?%s|%+v|%s|%s|%s", topic, hook.Endpoints, hook.Filter,
 				zdata.Ctime(), zdata.Mtime()))
 		} else {
-			lines = append(lines, fmt.Sprintf("%s|%+v|%s|%s", topic, hook.Endpoints,
+			lines = append(lines, fmt.Sprintf("%s|%+v|%s|%s|%s", topic, hook.Endpoints, hook.Filter,
 				zdata.Ctime(), zdata.Mtime()))
 		}
 	}
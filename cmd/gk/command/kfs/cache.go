@@ -0,0 +1,61 @@
+package kfs
+
+import (
+	"sync"
+	"time"
+)
+
+type cacheItem struct {
+	value   interface{}
+	expires time.Time
+}
+
+// attrCache caches kafka/zk metadata for a short while so that the
+// frequent Attr/Lookup/ReadDirAll calls of the kernel will not turn into
+// metadata round trips each time.
+type attrCache struct {
+	sync.Mutex
+
+	ttl   time.Duration
+	items map[string]cacheItem
+}
+
+func newAttrCache(ttl time.Duration) *attrCache {
+	return &attrCache{
+		ttl:   ttl,
+		items: make(map[string]cacheItem),
+	}
+}
+
+func (this *attrCache) get(key string) (interface{}, bool) {
+	this.Lock()
+	defer this.Unlock()
+
+	item, present := this.items[key]
+	if !present {
+		return nil, false
+	}
+
+	if time.Now().After(item.expires) {
+		delete(this.items, key)
+		return nil, false
+	}
+
+	return item.value, true
+}
+
+func (this *attrCache) set(key string, value interface{}) {
+	if this.ttl <= 0 {
+		return
+	}
+
+	this.Lock()
+	this.items[key] = cacheItem{value: value, expires: time.Now().Add(this.ttl)}
+	this.Unlock()
+}
+
+func (this *attrCache) invalidate(key string) {
+	this.Lock()
+	delete(this.items, key)
+	this.Unlock()
+}
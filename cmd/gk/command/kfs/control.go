@@ -0,0 +1,73 @@
+package kfs
+
+import (
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	log "github.com/funkygao/log4go"
+	"golang.org/x/net/context"
+)
+
+// ControlFile is the .offset file that sets the read start point of the
+// partition files, e.g.
+// echo newest > .offset
+// echo "orders.0 -100" > .offset
+type ControlFile struct {
+	fs   *KafkaFS
+	attr fuse.Attr
+}
+
+func (this *KafkaFS) newControlFile() *ControlFile {
+	return &ControlFile{fs: this, attr: this.newAttr(0644)}
+}
+
+func (f *ControlFile) Attr(ctx context.Context, o *fuse.Attr) error {
+	*o = f.attr
+	o.Size = uint64(len(f.content()))
+	return nil
+}
+
+// Setattr accepts truncation so that `echo x > .offset` works.
+func (f *ControlFile) Setattr(ctx context.Context, req *fuse.SetattrRequest,
+	resp *fuse.SetattrResponse) error {
+	return f.Attr(ctx, &resp.Attr)
+}
+
+func (f *ControlFile) Open(ctx context.Context, req *fuse.OpenRequest,
+	resp *fuse.OpenResponse) (fs.Handle, error) {
+	resp.Flags |= fuse.OpenDirectIO
+	return f, nil
+}
+
+func (f *ControlFile) ReadAll(ctx context.Context) ([]byte, error) {
+	return f.content(), nil
+}
+
+func (f *ControlFile) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	f.fs.startMu.Lock()
+	defer f.fs.startMu.Unlock()
+
+	// apply on a copy so that a bad line changes nothing
+	points := make(startPoints, len(f.fs.startPoints))
+	for k, v := range f.fs.startPoints {
+		points[k] = v
+	}
+	if err := points.apply(req.Data); err != nil {
+		log.Warn("%s: %v", offsetControlFile, err)
+
+		return fuse.Errno(syscall.EINVAL)
+	}
+
+	f.fs.startPoints = points
+	resp.Size = len(req.Data)
+	log.Info("start points: %s", string(points.Bytes()))
+	return nil
+}
+
+func (f *ControlFile) content() []byte {
+	f.fs.startMu.RLock()
+	defer f.fs.startMu.RUnlock()
+
+	return f.fs.startPoints.Bytes()
+}
@@ -2,8 +2,7 @@ package kfs
 
 import (
 	"fmt"
-	"os"
-	"strconv"
+	"sort"
 	"sync"
 
 	"bazil.org/fuse"
@@ -36,50 +35,55 @@ func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 
 	log.Trace("Dir Lookup, name=%s", name)
 
-	// split the name into topic and partitionId
-	partitionOffset := -1
-	for i := len(name) - 1; i > 0; i-- {
-		if name[i] == '.' {
-			partitionOffset = i
-		}
+	switch name {
+	case consumerGroupsDir:
+		return d.fs.newGroupsDir(), nil
+
+	case offsetControlFile:
+		return d.fs.newControlFile(), nil
 	}
-	if partitionOffset == -1 {
+
+	topic, partitionId, err := parsePartitionFileName(name)
+	if err != nil {
 		return nil, fuse.ENOENT
 	}
 
-	topic := name[:partitionOffset]
-	partitionId, _ := strconv.Atoi(name[partitionOffset+1:])
+	partitions, err := d.partitions(topic)
+	if err != nil {
+		return nil, fuse.ENOENT
+	}
+	for _, p := range partitions {
+		if p == partitionId {
+			return d.fs.newFile(d, topic, partitionId, d.fs.fileMode()), nil
+		}
+	}
 
-	return d.fs.newFile(d, topic, int32(partitionId), os.FileMode(0555)), nil
+	return nil, fuse.ENOENT
 }
 
 func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	d.RLock()
 	defer d.RUnlock()
 
-	var out []fuse.Dirent
-	if err := d.reconnectKafkaIfNecessary(); err != nil {
-		return nil, err
+	out := []fuse.Dirent{
+		{Name: consumerGroupsDir, Type: fuse.DT_Dir},
+		{Name: offsetControlFile, Type: fuse.DT_File},
 	}
 
-	topics, err := d.Topics()
+	topics, err := d.topics()
 	if err != nil {
-		log.Error(err)
-
 		return nil, err
 	}
 
 	for _, topic := range topics {
-		partitions, err := d.Partitions(topic)
+		partitions, err := d.partitions(topic)
 		if err != nil {
-			log.Error(err)
-
 			return nil, err
 		}
 
 		for _, p := range partitions {
 			de := fuse.Dirent{
-				Name: fmt.Sprintf("%s.%d", topic, p),
+				Name: partitionFileName(topic, p),
 				Type: fuse.DT_File,
 			}
 
@@ -107,7 +111,81 @@ func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 	return fuse.EPERM
 }
 
-// TODO when to close the kafka client?
+func (d *Dir) topics() ([]string, error) {
+	const key = "topics"
+	if v, present := d.fs.cache.get(key); present {
+		return v.([]string), nil
+	}
+
+	if err := d.reconnectKafkaIfNecessary(); err != nil {
+		return nil, err
+	}
+
+	topics, err := d.Topics()
+	if err != nil {
+		log.Error(err)
+
+		return nil, err
+	}
+
+	sort.Strings(topics)
+	d.fs.cache.set(key, topics)
+	return topics, nil
+}
+
+func (d *Dir) partitions(topic string) ([]int32, error) {
+	key := "partitions:" + topic
+	if v, present := d.fs.cache.get(key); present {
+		return v.([]int32), nil
+	}
+
+	if err := d.reconnectKafkaIfNecessary(); err != nil {
+		return nil, err
+	}
+
+	partitions, err := d.Partitions(topic)
+	if err != nil {
+		log.Error(err)
+
+		return nil, err
+	}
+
+	d.fs.cache.set(key, partitions)
+	return partitions, nil
+}
+
+// offsetRange returns the oldest and newest offset of a partition, cached
+// unless fresh is true.
+func (d *Dir) offsetRange(topic string, partitionId int32, fresh bool) (oldest, newest int64, err error) {
+	key := fmt.Sprintf("offsets:%s.%d", topic, partitionId)
+	if !fresh {
+		if v, present := d.fs.cache.get(key); present {
+			r := v.([2]int64)
+			return r[0], r[1], nil
+		}
+	}
+
+	if err = d.reconnectKafkaIfNecessary(); err != nil {
+		return
+	}
+
+	if newest, err = d.GetOffset(topic, partitionId, sarama.OffsetNewest); err != nil {
+		log.Error(err)
+		return
+	}
+	if oldest, err = d.GetOffset(topic, partitionId, sarama.OffsetOldest); err != nil {
+		log.Error(err)
+		return
+	}
+
+	d.fs.cache.set(key, [2]int64{oldest, newest})
+	return
+}
+
+func (d *Dir) invalidateOffsetRange(topic string, partitionId int32) {
+	d.fs.cache.invalidate(fmt.Sprintf("offsets:%s.%d", topic, partitionId))
+}
+
 func (d *Dir) reconnectKafkaIfNecessary() error {
 	if d.Client != nil {
 		return nil
@@ -2,6 +2,7 @@ package kfs
 
 import (
 	"sync"
+	"syscall"
 	"time"
 
 	"bazil.org/fuse"
@@ -11,147 +12,250 @@ import (
 	"golang.org/x/net/context"
 )
 
+// File is a kafka partition presented as newline delimited records.
+// Appending to it produces messages to the partition.
 type File struct {
 	sync.RWMutex
 	attr fuse.Attr
 
-	fs       *KafkaFS
-	dir      *Dir
-	consumer sarama.PartitionConsumer
-
-	opened  bool
-	content []byte
-	closeCh chan struct{}
+	fs  *KafkaFS
+	dir *Dir
 
 	topic       string
 	partitionId int32
 }
 
+// fileHandle is an opened partition file: the content is a snapshot of
+// the partition from the start point up to the newest offset on open.
+type fileHandle struct {
+	sync.Mutex
+
+	f       *File
+	content []byte
+	wbuf    []byte // partial line pending produce
+}
+
 func (f *File) Attr(ctx context.Context, o *fuse.Attr) error {
 	f.RLock()
 	defer f.RUnlock()
 
 	*o = f.attr
 
-	// calculate size
-	if !f.opened {
-		if err := f.dir.reconnectKafkaIfNecessary(); err != nil {
-			return err
-		}
-
-		latestOffset, err := f.dir.GetOffset(f.topic, f.partitionId, sarama.OffsetNewest)
-		if err != nil {
-			log.Error(err)
-
-			return err
-		}
-		oldestOffset, err := f.dir.GetOffset(f.topic, f.partitionId, sarama.OffsetOldest)
-		if err != nil {
-			log.Error(err)
-
-			return err
-		}
-
-		o.Size = uint64(latestOffset - oldestOffset)
-	} else {
-		o.Size = uint64(len(f.content))
+	oldest, newest, err := f.dir.offsetRange(f.topic, f.partitionId, false)
+	if err != nil {
+		return err
 	}
+	o.Size = uint64(newest - oldest)
 
 	log.Trace("File Attr, topic=%s, partitionId=%d, size=%d", f.topic, f.partitionId, o.Size)
 
 	return nil
 }
 
+func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest,
+	resp *fuse.SetattrResponse) error {
+	if req.Valid.Size() {
+		// a partition can only be appended
+		return fuse.EPERM
+	}
+
+	return f.Attr(ctx, &resp.Attr)
+}
+
 func (f *File) Open(ctx context.Context, req *fuse.OpenRequest,
 	resp *fuse.OpenResponse) (fs.Handle, error) {
 	log.Trace("File Open, req=%#v, topic=%s, partitionId=%d", req,
 		f.topic, f.partitionId)
 
-	// Allow kernel to use buffer cache
-	resp.Flags &^= fuse.OpenDirectIO
-	f.opened = true
-	f.closeCh = make(chan struct{})
+	if !req.Flags.IsReadOnly() {
+		if !f.fs.cf.Writable || req.Flags&fuse.OpenTruncate != 0 {
+			return nil, fuse.EPERM
+		}
+	}
 
-	go f.readContent()
-	time.Sleep(time.Second * 2) // TODO
+	// size of the file is unknown until read, bypass the page cache
+	resp.Flags |= fuse.OpenDirectIO
 
-	return f, nil
-}
+	h := &fileHandle{f: f}
+	if req.Flags.IsWriteOnly() {
+		return h, nil
+	}
 
-func (f *File) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
-	log.Trace("File Release, req=%#v, topic=%s, partitionId=%d", req,
-		f.topic, f.partitionId)
-	f.opened = false
-	close(f.closeCh)
-	f.content = make([]byte, 0, 16<<10)
-	return f.consumer.Close()
+	content, err := f.readContent()
+	if err != nil {
+		return nil, err
+	}
+	h.content = content
+
+	return h, nil
 }
 
-func (f *File) ReadAll(ctx context.Context) ([]byte, error) {
-	f.RLock()
-	defer f.RUnlock()
+func (h *fileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	h.Lock()
+	defer h.Unlock()
+
+	log.Trace("File Read, offset=%d, size=%d, topic=%s, partitionId=%d", req.Offset,
+		req.Size, h.f.topic, h.f.partitionId)
 
-	log.Trace("File ReadAll, topic=%s, partitionId=%d", f.topic, f.partitionId)
+	if req.Offset >= int64(len(h.content)) {
+		resp.Data = nil
+		return nil
+	}
 
-	return f.content, nil
+	end := req.Offset + int64(req.Size)
+	if end > int64(len(h.content)) {
+		end = int64(len(h.content))
+	}
+	resp.Data = h.content[req.Offset:end]
+	return nil
 }
 
-func (f *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
-	f.RLock()
-	defer f.RUnlock()
+// Write produces each complete line as a message, the offset of the write
+// request is ignored because a partition is append only.
+func (h *fileHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	if !h.f.fs.cf.Writable {
+		return fuse.EPERM
+	}
 
-	log.Trace("File Read, req=%#v, topic=%s, partitionId=%d", req,
-		f.topic, f.partitionId)
+	h.Lock()
+	defer h.Unlock()
 
-	resp.Data = resp.Data[:req.Size]
-	resp.Data = f.content[req.Offset : req.Size+int(req.Offset)]
+	lines, rest := splitLines(append(h.wbuf, req.Data...))
+	h.wbuf = append([]byte(nil), rest...)
+	if err := h.f.produce(lines); err != nil {
+		return err
+	}
+
+	resp.Size = len(req.Data)
 	return nil
 }
 
-func (f *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
-	return fuse.EPERM
+func (h *fileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.flush()
+}
+
+func (h *fileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	log.Trace("File Release, topic=%s, partitionId=%d", h.f.topic, h.f.partitionId)
+
+	h.Lock()
+	defer h.Unlock()
+
+	h.content = nil
+	return h.flush()
+}
+
+func (h *fileHandle) flush() error {
+	if len(h.wbuf) == 0 {
+		return nil
+	}
+
+	line := h.wbuf
+	h.wbuf = nil
+	return h.f.produce([][]byte{line})
 }
 
-func (f *File) readContent() {
-	if err := f.reconsume(sarama.OffsetOldest); err != nil {
+func (f *File) produce(lines [][]byte) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	p, err := f.fs.syncProducer()
+	if err != nil {
 		log.Error(err)
-		return
+
+		return fuse.EIO
 	}
 
-	var msg *sarama.ConsumerMessage
-	for {
-		select {
-		case <-f.closeCh:
-			log.Trace("File readContent quit, topic=%s, partitionId=%d",
-				f.topic, f.partitionId)
-			return
-
-		case msg = <-f.consumer.Messages():
-			f.content = append(f.content, msg.Value...)
-			f.content = append(f.content, '\n')
+	msgs := make([]*sarama.ProducerMessage, 0, len(lines))
+	for _, line := range lines {
+		key, value, err := decodeRecord(f.fs.cf.Framing, line)
+		if err != nil {
+			log.Warn("%s.%d %v", f.topic, f.partitionId, err)
+
+			return fuse.Errno(syscall.EINVAL)
 		}
+
+		msg := &sarama.ProducerMessage{
+			Topic:     f.topic,
+			Partition: f.partitionId,
+			Value:     sarama.ByteEncoder(value),
+		}
+		if key != nil {
+			msg.Key = sarama.ByteEncoder(key)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	defer f.dir.invalidateOffsetRange(f.topic, f.partitionId)
+
+	if err = p.SendMessages(msgs); err != nil {
+		log.Error("%s.%d %v", f.topic, f.partitionId, err)
+
+		return fuse.EIO
 	}
+
+	log.Trace("produced %d messages to %s.%d", len(msgs), f.topic, f.partitionId)
+	return nil
 }
 
-func (f *File) reconsume(offset int64) error {
-	if err := f.dir.reconnectKafkaIfNecessary(); err != nil {
-		return err
+// readContent consumes the partition from the start point up to the
+// newest offset when it is called.
+func (f *File) readContent() ([]byte, error) {
+	oldest, newest, err := f.dir.offsetRange(f.topic, f.partitionId, true)
+	if err != nil {
+		return nil, fuse.EIO
+	}
+
+	start := f.fs.startOffset(f.topic, f.partitionId, oldest, newest)
+	if start >= newest {
+		return nil, nil
 	}
 
 	consumer, err := sarama.NewConsumerFromClient(f.dir.Client)
 	if err != nil {
 		log.Error(err)
 
-		return err
+		return nil, fuse.EIO
 	}
+	defer consumer.Close()
 
-	cp, err := consumer.ConsumePartition(f.topic, f.partitionId, offset)
+	cp, err := consumer.ConsumePartition(f.topic, f.partitionId, start)
 	if err != nil {
 		log.Error(err)
 
-		return err
+		return nil, fuse.EIO
 	}
+	defer cp.Close()
 
-	f.consumer = cp
-	return nil
+	var content []byte
+	idle := time.NewTimer(time.Second * 10)
+	defer idle.Stop()
+	for {
+		select {
+		case msg := <-cp.Messages():
+			content = append(content, encodeRecord(f.fs.cf.Framing, msg.Offset, msg.Key, msg.Value)...)
+			if msg.Offset >= newest-1 {
+				return content, nil
+			}
+
+			if len(content) >= maxFileContent {
+				log.Warn("%s.%d content truncated at offset %d", f.topic, f.partitionId, msg.Offset)
+				return content, nil
+			}
+
+			idle.Reset(time.Second * 10)
+
+		case err := <-cp.Errors():
+			log.Error("%s.%d %v", f.topic, f.partitionId, err)
+			return nil, fuse.EIO
+
+		case <-idle.C:
+			// compacted or aborted tail, return what we have
+			log.Warn("%s.%d idle before newest offset %d", f.topic, f.partitionId, newest)
+			return content, nil
+		}
+	}
 }
@@ -0,0 +1,70 @@
+package kfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	FramingRaw  = "raw"
+	FramingJson = "json"
+)
+
+type jsonRecord struct {
+	Offset int64  `json:"offset"`
+	Key    string `json:"key,omitempty"`
+	Value  string `json:"value"`
+}
+
+// encodeRecord renders a message as a newline terminated record of the
+// partition file.
+func encodeRecord(framing string, offset int64, key, value []byte) []byte {
+	switch framing {
+	case FramingJson:
+		b, _ := json.Marshal(jsonRecord{Offset: offset, Key: string(key), Value: string(value)})
+		return append(b, '\n')
+
+	default:
+		r := make([]byte, 0, len(value)+1)
+		r = append(r, value...)
+		return append(r, '\n')
+	}
+}
+
+// decodeRecord parses a line written to the partition file into the key
+// and value of the message to produce. The offset of json framing is
+// ignored if present.
+func decodeRecord(framing string, line []byte) (key, value []byte, err error) {
+	switch framing {
+	case FramingJson:
+		var r jsonRecord
+		if err = json.Unmarshal(line, &r); err != nil {
+			return nil, nil, fmt.Errorf("invalid json record: %v", err)
+		}
+		if r.Key != "" {
+			key = []byte(r.Key)
+		}
+		return key, []byte(r.Value), nil
+
+	default:
+		value = make([]byte, len(line))
+		copy(value, line)
+		return nil, value, nil
+	}
+}
+
+// splitLines returns the complete lines in buf and the trailing partial line.
+func splitLines(buf []byte) (lines [][]byte, rest []byte) {
+	for {
+		idx := bytes.IndexByte(buf, '\n')
+		if idx == -1 {
+			return lines, buf
+		}
+
+		if idx > 0 {
+			lines = append(lines, buf[:idx])
+		}
+		buf = buf[idx+1:]
+	}
+}
@@ -2,36 +2,72 @@ package kfs
 
 import (
 	"os"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"github.com/Shopify/sarama"
 	"github.com/funkygao/gafka/ctx"
 	"github.com/funkygao/gafka/zk"
 	log "github.com/funkygao/log4go"
 	"golang.org/x/net/context"
 )
 
+const (
+	consumerGroupsDir = "consumer-groups"
+	offsetControlFile = ".offset"
+
+	// max bytes of a partition file content loaded on open
+	maxFileContent = 64 << 20
+)
+
+type Config struct {
+	Zone, Cluster string
+
+	// Writable allows appending to partition files to produce messages.
+	Writable bool
+
+	// Framing of the partition file records: raw|json
+	Framing string
+
+	// AttrTTL is how long the metadata of kafka is cached.
+	AttrTTL time.Duration
+}
+
 type KafkaFS struct {
 	zkcluster *zk.ZkCluster
 	root      *Dir
+	cf        Config
 
 	// id generator
 	inodeId uint64
 
 	size int64
+
+	cache *attrCache
+
+	startMu     sync.RWMutex
+	startPoints startPoints
+
+	producerMu sync.Mutex
+	producer   sarama.SyncProducer
 }
 
-func New(zone, cluster string) *KafkaFS {
-	log.Info("starting kfs zone:%s, cluster:%s", zone, cluster)
+func New(cf Config) *KafkaFS {
+	log.Info("starting kfs %+v", cf)
 
-	this := &KafkaFS{}
+	this := &KafkaFS{
+		cf:          cf,
+		cache:       newAttrCache(cf.AttrTTL),
+		startPoints: newStartPoints(),
+	}
 
 	ctx.LoadFromHome()
-	zkzone := zk.NewZkZone(zk.DefaultConfig(zone, ctx.ZoneZkAddrs(zone)))
-	this.zkcluster = zkzone.NewCluster(cluster) // panic if invalid cluster
+	zkzone := zk.NewZkZone(zk.DefaultConfig(cf.Zone, ctx.ZoneZkAddrs(cf.Zone)))
+	this.zkcluster = zkzone.NewCluster(cf.Cluster) // panic if invalid cluster
 
 	this.root = this.newDir(os.FileMode(0777))
 	return this
@@ -45,44 +81,78 @@ func (this *KafkaFS) nextInodeId() uint64 {
 	return atomic.AddUint64(&this.inodeId, 1)
 }
 
-func (this *KafkaFS) newDir(mode os.FileMode) *Dir {
+func (this *KafkaFS) newAttr(mode os.FileMode) fuse.Attr {
 	now := time.Now()
+	return fuse.Attr{
+		Valid:  this.cf.AttrTTL,
+		Inode:  this.nextInodeId(),
+		Atime:  now,
+		Mtime:  now,
+		Ctime:  now,
+		Crtime: now,
+		Mode:   mode,
+	}
+}
+
+func (this *KafkaFS) newDir(mode os.FileMode) *Dir {
 	dir := &Dir{
-		attr: fuse.Attr{
-			Inode:  this.nextInodeId(),
-			Atime:  now,
-			Mtime:  now,
-			Ctime:  now,
-			Crtime: now,
-			Mode:   os.ModeDir | mode,
-		},
-		fs: this,
+		attr: this.newAttr(os.ModeDir | mode),
+		fs:   this,
 	}
 	dir.reconnectKafkaIfNecessary()
-	topics, _ := dir.Topics()
+	topics, _ := dir.topics()
 	dir.attr.Size = uint64(len(topics))
 	return dir
 }
 
 func (this *KafkaFS) newFile(dir *Dir, topic string, partitionId int32, mode os.FileMode) *File {
-	now := time.Now()
 	return &File{
-		attr: fuse.Attr{
-			Inode:  this.nextInodeId(),
-			Atime:  now,
-			Mtime:  now,
-			Ctime:  now,
-			Crtime: now,
-			Mode:   mode,
-		},
+		attr:        this.newAttr(mode),
 		fs:          this,
 		dir:         dir,
 		topic:       topic,
-		content:     make([]byte, 0, 16<<10),
 		partitionId: partitionId,
 	}
 }
 
+func (this *KafkaFS) fileMode() os.FileMode {
+	if this.cf.Writable {
+		return os.FileMode(0644)
+	}
+	return os.FileMode(0444)
+}
+
+// startOffset resolves the read start point of a partition set by the
+// offset control file.
+func (this *KafkaFS) startOffset(topic string, partitionId int32, oldest, newest int64) int64 {
+	this.startMu.RLock()
+	defer this.startMu.RUnlock()
+
+	return this.startPoints.resolve(topic, partitionId, oldest, newest)
+}
+
+// syncProducer lazily creates the producer that appends to partition files.
+func (this *KafkaFS) syncProducer() (sarama.SyncProducer, error) {
+	this.producerMu.Lock()
+	defer this.producerMu.Unlock()
+
+	if this.producer != nil {
+		return this.producer, nil
+	}
+
+	cf := sarama.NewConfig()
+	cf.Producer.Partitioner = sarama.NewManualPartitioner
+	cf.Producer.RequiredAcks = sarama.WaitForLocal
+	cf.Producer.Return.Successes = true
+	p, err := sarama.NewSyncProducer(this.zkcluster.BrokerList(), cf)
+	if err != nil {
+		return nil, err
+	}
+
+	this.producer = p
+	return p, nil
+}
+
 func (this *KafkaFS) Statfs(ctx context.Context, req *fuse.StatfsRequest,
 	res *fuse.StatfsResponse) error {
 	s := syscall.Statfs_t{}
@@ -93,3 +163,16 @@ func (this *KafkaFS) Statfs(ctx context.Context, req *fuse.StatfsRequest,
 	res.Bsize = uint32(s.Bsize)
 	return nil
 }
+
+// Close releases the kafka connections.
+func (this *KafkaFS) Close() {
+	this.producerMu.Lock()
+	if this.producer != nil {
+		this.producer.Close()
+	}
+	this.producerMu.Unlock()
+
+	if this.root.Client != nil {
+		this.root.Client.Close()
+	}
+}
@@ -0,0 +1,142 @@
+package kfs
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	log "github.com/funkygao/log4go"
+	"golang.org/x/net/context"
+)
+
+// GroupsDir is the consumer-groups directory which has a sub directory for
+// each consumer group.
+type GroupsDir struct {
+	fs   *KafkaFS
+	attr fuse.Attr
+}
+
+// GroupDir has a file for each partition the group committed offset of,
+// whose content is the committed offset.
+type GroupDir struct {
+	fs    *KafkaFS
+	group string
+	attr  fuse.Attr
+}
+
+// offsetFile is a read only file with content of the committed offset.
+type offsetFile struct {
+	attr    fuse.Attr
+	content []byte
+}
+
+func (this *KafkaFS) newGroupsDir() *GroupsDir {
+	return &GroupsDir{fs: this, attr: this.newAttr(os.ModeDir | 0555)}
+}
+
+func (this *KafkaFS) groups() []string {
+	const key = "groups"
+	if v, present := this.cache.get(key); present {
+		return v.([]string)
+	}
+
+	var groups []string
+	for group := range this.zkcluster.ConsumerGroups() {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	this.cache.set(key, groups)
+	return groups
+}
+
+// groupOffsets returns {topic.partitionId: offset} of a consumer group.
+func (this *KafkaFS) groupOffsets(group string) map[string]int64 {
+	key := "group:" + group
+	if v, present := this.cache.get(key); present {
+		return v.(map[string]int64)
+	}
+
+	r := make(map[string]int64)
+	for topic, offsets := range this.zkcluster.ConsumerOffsetsOfGroup(group) {
+		for partitionId, offset := range offsets {
+			r[fmt.Sprintf("%s.%s", topic, partitionId)] = offset
+		}
+	}
+
+	this.cache.set(key, r)
+	return r
+}
+
+func (d *GroupsDir) Attr(ctx context.Context, o *fuse.Attr) error {
+	*o = d.attr
+	return nil
+}
+
+func (d *GroupsDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	log.Trace("GroupsDir Lookup, name=%s", name)
+
+	for _, group := range d.fs.groups() {
+		if group == name {
+			return &GroupDir{fs: d.fs, group: group, attr: d.fs.newAttr(os.ModeDir | 0555)}, nil
+		}
+	}
+
+	return nil, fuse.ENOENT
+}
+
+func (d *GroupsDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	var out []fuse.Dirent
+	for _, group := range d.fs.groups() {
+		out = append(out, fuse.Dirent{Name: group, Type: fuse.DT_Dir})
+	}
+	return out, nil
+}
+
+func (d *GroupDir) Attr(ctx context.Context, o *fuse.Attr) error {
+	*o = d.attr
+	return nil
+}
+
+func (d *GroupDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	log.Trace("GroupDir Lookup, group=%s name=%s", d.group, name)
+
+	offset, present := d.fs.groupOffsets(d.group)[name]
+	if !present {
+		return nil, fuse.ENOENT
+	}
+
+	f := &offsetFile{
+		attr:    d.fs.newAttr(0444),
+		content: []byte(strconv.FormatInt(offset, 10) + "\n"),
+	}
+	f.attr.Size = uint64(len(f.content))
+	return f, nil
+}
+
+func (d *GroupDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	offsets := d.fs.groupOffsets(d.group)
+	names := make([]string, 0, len(offsets))
+	for name := range offsets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]fuse.Dirent, 0, len(names))
+	for _, name := range names {
+		out = append(out, fuse.Dirent{Name: name, Type: fuse.DT_File})
+	}
+	return out, nil
+}
+
+func (f *offsetFile) Attr(ctx context.Context, o *fuse.Attr) error {
+	*o = f.attr
+	return nil
+}
+
+func (f *offsetFile) ReadAll(ctx context.Context) ([]byte, error) {
+	return f.content, nil
+}
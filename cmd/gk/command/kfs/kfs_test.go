@@ -0,0 +1,95 @@
+package kfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func TestFraming(t *testing.T) {
+	assert.Equal(t, "hello\n", string(encodeRecord(FramingRaw, 5, []byte("k"), []byte("hello"))))
+	assert.Equal(t, `{"offset":5,"key":"k","value":"hello"}`+"\n",
+		string(encodeRecord(FramingJson, 5, []byte("k"), []byte("hello"))))
+	assert.Equal(t, `{"offset":5,"value":"hello"}`+"\n",
+		string(encodeRecord(FramingJson, 5, nil, []byte("hello"))))
+
+	key, value, err := decodeRecord(FramingJson, []byte(`{"key":"k","value":"v"}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "k", string(key))
+	assert.Equal(t, "v", string(value))
+
+	key, value, err = decodeRecord(FramingRaw, []byte(`{"key":"k"}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, key == nil)
+	assert.Equal(t, `{"key":"k"}`, string(value))
+
+	_, _, err = decodeRecord(FramingJson, []byte("hello"))
+	assert.NotEqual(t, nil, err)
+}
+
+func TestSplitLines(t *testing.T) {
+	lines, rest := splitLines([]byte("a\nbc\n\nd"))
+	assert.Equal(t, 2, len(lines))
+	assert.Equal(t, "a", string(lines[0]))
+	assert.Equal(t, "bc", string(lines[1]))
+	assert.Equal(t, "d", string(rest))
+
+	lines, rest = splitLines([]byte("a\n"))
+	assert.Equal(t, 1, len(lines))
+	assert.Equal(t, 0, len(rest))
+}
+
+func TestParsePartitionFileName(t *testing.T) {
+	topic, partitionId, err := parsePartitionFileName("app.orders.12")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "app.orders", topic)
+	assert.Equal(t, int32(12), partitionId)
+
+	_, _, err = parsePartitionFileName("orders")
+	assert.Equal(t, ErrInvalidPartitionFile, err)
+	_, _, err = parsePartitionFileName("orders.x")
+	assert.Equal(t, ErrInvalidPartitionFile, err)
+}
+
+func TestStartPoints(t *testing.T) {
+	sp := newStartPoints()
+	assert.Equal(t, int64(10), sp.resolve("orders", 0, 10, 100))
+
+	assert.Equal(t, nil, sp.apply([]byte("newest\norders.1 -20\norders.2 5\n")))
+	assert.Equal(t, int64(100), sp.resolve("orders", 0, 10, 100))
+	assert.Equal(t, int64(80), sp.resolve("orders", 1, 10, 100))
+	assert.Equal(t, int64(90), sp.resolve("orders", 1, 90, 100)) // clamped
+	assert.Equal(t, int64(10), sp.resolve("orders", 2, 10, 100))
+	assert.Equal(t, "newest\norders.1 -20\norders.2 5\n", string(sp.Bytes()))
+
+	assert.Equal(t, ErrInvalidStartPoint, sp.apply([]byte("latest")))
+	assert.Equal(t, ErrInvalidPartitionFile, sp.apply([]byte("orders 5")))
+	assert.Equal(t, ErrInvalidStartPoint, sp.apply([]byte("orders.1 5 6")))
+}
+
+func TestAttrCache(t *testing.T) {
+	c := newAttrCache(time.Millisecond * 50)
+	_, present := c.get("k")
+	assert.Equal(t, false, present)
+
+	c.set("k", 1)
+	v, present := c.get("k")
+	assert.Equal(t, true, present)
+	assert.Equal(t, 1, v.(int))
+
+	time.Sleep(time.Millisecond * 60)
+	_, present = c.get("k")
+	assert.Equal(t, false, present)
+
+	c.set("k", 1)
+	c.invalidate("k")
+	_, present = c.get("k")
+	assert.Equal(t, false, present)
+
+	// ttl 0 disables the cache
+	c = newAttrCache(0)
+	c.set("k", 1)
+	_, present = c.get("k")
+	assert.Equal(t, false, present)
+}
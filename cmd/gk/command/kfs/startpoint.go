@@ -0,0 +1,173 @@
+package kfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidStartPoint    = errors.New("invalid start point")
+	ErrInvalidPartitionFile = errors.New("invalid partition file name")
+)
+
+const (
+	startOldest = iota
+	startNewest
+	startAbsolute
+	startRelative // N messages before newest
+)
+
+type startPoint struct {
+	kind int
+	n    int64
+}
+
+func parseStartPoint(s string) (startPoint, error) {
+	switch s {
+	case "oldest":
+		return startPoint{kind: startOldest}, nil
+
+	case "newest":
+		return startPoint{kind: startNewest}, nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return startPoint{}, ErrInvalidStartPoint
+	}
+
+	if n < 0 {
+		return startPoint{kind: startRelative, n: -n}, nil
+	}
+	return startPoint{kind: startAbsolute, n: n}, nil
+}
+
+func (this startPoint) String() string {
+	switch this.kind {
+	case startOldest:
+		return "oldest"
+
+	case startNewest:
+		return "newest"
+
+	case startRelative:
+		return strconv.FormatInt(-this.n, 10)
+
+	default:
+		return strconv.FormatInt(this.n, 10)
+	}
+}
+
+// startPoints is the read start point of partition files keyed by
+// topic.partitionId, the empty key holds the default of all partitions.
+type startPoints map[string]startPoint
+
+func newStartPoints() startPoints {
+	return startPoints{"": startPoint{kind: startOldest}}
+}
+
+func (this startPoints) resolve(topic string, partitionId int32, oldest, newest int64) int64 {
+	sp, present := this[partitionFileName(topic, partitionId)]
+	if !present {
+		sp = this[""]
+	}
+
+	var offset int64
+	switch sp.kind {
+	case startOldest:
+		offset = oldest
+
+	case startNewest:
+		offset = newest
+
+	case startRelative:
+		offset = newest - sp.n
+
+	default:
+		offset = sp.n
+	}
+
+	if offset < oldest {
+		offset = oldest
+	}
+	if offset > newest {
+		offset = newest
+	}
+	return offset
+}
+
+// apply parses lines of the offset control file:
+// "<point>" sets the default start point and "<topic.partition> <point>"
+// sets that of a single partition, where point is oldest|newest|offset|-N.
+func (this startPoints) apply(data []byte) error {
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 0:
+			continue
+
+		case 1:
+			sp, err := parseStartPoint(fields[0])
+			if err != nil {
+				return err
+			}
+			this[""] = sp
+
+		case 2:
+			if _, _, err := parsePartitionFileName(fields[0]); err != nil {
+				return err
+			}
+			sp, err := parseStartPoint(fields[1])
+			if err != nil {
+				return err
+			}
+			this[fields[0]] = sp
+
+		default:
+			return ErrInvalidStartPoint
+		}
+	}
+
+	return nil
+}
+
+func (this startPoints) Bytes() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n", this[""])
+
+	keys := make([]string, 0, len(this))
+	for k := range this {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s %s\n", k, this[k])
+	}
+	return b.Bytes()
+}
+
+func partitionFileName(topic string, partitionId int32) string {
+	return fmt.Sprintf("%s.%d", topic, partitionId)
+}
+
+// parsePartitionFileName splits file name topic.N into topic and partition id.
+// Topic name itself might contain dots.
+func parsePartitionFileName(name string) (topic string, partitionId int32, err error) {
+	idx := strings.LastIndex(name, ".")
+	if idx <= 0 {
+		return "", 0, ErrInvalidPartitionFile
+	}
+
+	id, err := strconv.ParseInt(name[idx+1:], 10, 32)
+	if err != nil || id < 0 {
+		return "", 0, ErrInvalidPartitionFile
+	}
+
+	return name[:idx], int32(id), nil
+}
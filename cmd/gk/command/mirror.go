@@ -24,6 +24,9 @@ type Mirror struct {
 	bandwidthLimit     int64
 	progressStep       int64
 	showStatus         bool
+	confFile           string
+	failoverGroup      string
+	dryRun             bool
 }
 
 func (this *Mirror) Run(args []string) (exitCode int) {
@@ -42,10 +45,17 @@ func (this *Mirror) Run(args []string) (exitCode int) {
 	cmdFlags.Int64Var(&this.bandwidthLimit, "net", 100, "")
 	cmdFlags.BoolVar(&this.autoCommit, "commit", true, "")
 	cmdFlags.Int64Var(&this.progressStep, "step", 10000, "")
+	cmdFlags.StringVar(&this.confFile, "conf", "", "")
+	cmdFlags.StringVar(&this.failoverGroup, "failover", "", "")
+	cmdFlags.BoolVar(&this.dryRun, "dryrun", false, "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
+	if this.confFile != "" {
+		return this.runDaemon()
+	}
+
 	if validateArgs(this, this.Ui).
 		require("-z1", "-z2", "-c1", "-c2").
 		invalid(args) {
@@ -86,6 +96,27 @@ func (this *Mirror) Run(args []string) (exitCode int) {
 	return m.Main()
 }
 
+func (this *Mirror) runDaemon() (exitCode int) {
+	cf, err := mirror.LoadDaemonConfig(this.confFile)
+	if err != nil {
+		this.Ui.Error(err.Error())
+		return 1
+	}
+
+	d := mirror.NewDaemon(cf)
+	if this.failoverGroup != "" {
+		setupLogging("stdout", this.logLevel, "")
+		if err = d.Failover(this.failoverGroup, this.dryRun); err != nil {
+			this.Ui.Error(err.Error())
+			return 1
+		}
+		return
+	}
+
+	setupLogging("mirror.log", this.logLevel, "panic")
+	return d.Main()
+}
+
 func (*Mirror) Synopsis() string {
 	return "Continuously copy data between two remote Kafka clusters"
 }
@@ -98,6 +129,8 @@ Usage: %s mirror [options]
 
     e,g.
     gk mirror -z1 prod -c1 logstash -z2 mirror -c2 aggregator -net 100 -step 2000
+    gk mirror -conf mirror.json
+    gk mirror -conf mirror.json -failover group -dryrun
 
 Options:
 
@@ -135,6 +168,22 @@ Options:
       Auto commit the checkpoint offset.
       Defaults true.
 
+    -conf config file
+      Run as daemon mirroring all routes in the json config file.
+      Each source partition is mirrored to the same partition of the target
+      topic, which is pre-created with the same partitions and retention.
+      Source offsets are committed only after target acked, and the
+      source to target offset mapping is checkpointed in the target zone.
+      -z1 -z2 -c1 -c2 -t -exclude -net -compress are configured per route.
+
+    -failover group
+      Work with -conf.
+      Translate committed offsets of the consumer group from source
+      topics to target topics according to the checkpoints.
+
+    -dryrun
+      Work with -failover, show the translated offsets only.
+
 `, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
 }
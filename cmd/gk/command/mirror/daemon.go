@@ -0,0 +1,683 @@
+package mirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka"
+	"github.com/funkygao/gafka/ctx"
+	"github.com/funkygao/gafka/sla"
+	"github.com/funkygao/gafka/zk"
+	"github.com/funkygao/golib/gofmt"
+	"github.com/funkygao/golib/ratelimiter"
+	"github.com/funkygao/golib/signal"
+	log "github.com/funkygao/log4go"
+)
+
+const (
+	pumpBatchSize = 500
+	pumpBatchWait = time.Millisecond * 100
+)
+
+// Daemon runs many mirror routes from a config file.
+//
+// Unlike the classic mirror, each source partition is copied to the same
+// partition of the target topic by a dedicated pump with sync produce, and
+// the source offset is committed only after the target acked.
+// The source->target offset mapping is checkpointed in the target zone, so
+// that the pump resumes without duplicates and consumer groups can fail over
+// to the target zone.
+type Daemon struct {
+	cf *DaemonConfig
+
+	quit chan struct{}
+	once sync.Once
+
+	zonesLock sync.Mutex
+	zones     map[string]*zk.ZkZone
+
+	runnersLock sync.Mutex
+	runners     map[string]*routeRunner // route name:running round
+}
+
+func NewDaemon(cf *DaemonConfig) *Daemon {
+	if cf.checkpointInterval == 0 {
+		cf.checkpointInterval = defaultCheckpointInterval
+	}
+
+	return &Daemon{
+		cf:      cf,
+		quit:    make(chan struct{}),
+		zones:   make(map[string]*zk.ZkZone),
+		runners: make(map[string]*routeRunner),
+	}
+}
+
+func (this *Daemon) Main() (exitCode int) {
+	signal.RegisterHandler(func(sig os.Signal) {
+		log.Info("received signal: %s", strings.ToUpper(sig.String()))
+		log.Info("quiting...")
+
+		this.once.Do(func() {
+			close(this.quit)
+		})
+	}, syscall.SIGINT, syscall.SIGTERM)
+
+	log.Info("starting mirror daemon@%s with %d routes", gafka.BuildId, len(this.cf.Routes))
+
+	var wg sync.WaitGroup
+	for _, route := range this.cf.Routes {
+		wg.Add(1)
+		go func(r *Route) {
+			defer wg.Done()
+			this.RunRoute(r, this.quit)
+		}(route)
+	}
+	wg.Wait()
+
+	this.Close()
+
+	log.Info("bye mirror daemon@%s", gafka.BuildId)
+	log.Close()
+	return
+}
+
+func (this *Daemon) zkzone(zone string) *zk.ZkZone {
+	this.zonesLock.Lock()
+	defer this.zonesLock.Unlock()
+
+	if z, present := this.zones[zone]; present {
+		return z
+	}
+
+	z := zk.NewZkZone(zk.DefaultConfig(zone, ctx.ZoneZkAddrs(zone)))
+	this.zones[zone] = z
+	return z
+}
+
+// Close closes the zk zones opened by the routes.
+func (this *Daemon) Close() {
+	this.zonesLock.Lock()
+	defer this.zonesLock.Unlock()
+
+	for name, z := range this.zones {
+		z.Close()
+		delete(this.zones, name)
+	}
+}
+
+// routeRunner is a running round of a route till source topics change.
+type routeRunner struct {
+	*Route
+	daemon *Daemon
+
+	c1, c2 *zk.ZkCluster
+	z2     *zk.ZkZone
+
+	consumer sarama.Consumer
+	target   sarama.Client
+	producer sarama.SyncProducer
+
+	bandwidth *ratelimiter.LeakyBucket
+
+	transferN     int64
+	transferBytes int64
+
+	lagsLock sync.Mutex
+	lags     map[string]*int64 // topic/partition:lag
+}
+
+// RunRoute mirrors a route till stop, the daemon can be embedded in other
+// programs to run routes on demand.
+func (this *Daemon) RunRoute(r *Route, stop <-chan struct{}) {
+	defer func() {
+		this.runnersLock.Lock()
+		delete(this.runners, r.Name)
+		this.runnersLock.Unlock()
+	}()
+
+	c1 := this.zkzone(r.Source.Zone).NewCluster(r.Source.Cluster)
+	c2 := this.zkzone(r.Target.Zone).NewCluster(r.Target.Cluster)
+
+	for round := 1; ; round++ {
+		topics, topicsChanges, err := c1.WatchTopics()
+		if err != nil {
+			log.Error("[%s] #%d watch topics: %v", r.Name, round, err)
+			if sleep(stop, time.Second*10) {
+				return
+			}
+			continue
+		}
+
+		rr := &routeRunner{Route: r, daemon: this, c1: c1, c2: c2, z2: c2.ZkZone(),
+			lags: make(map[string]*int64)}
+		this.runnersLock.Lock()
+		this.runners[r.Name] = rr
+		this.runnersLock.Unlock()
+
+		roundStop := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			rr.run(topics, roundStop)
+			close(stopped)
+		}()
+
+		select {
+		case <-topicsChanges:
+			log.Warn("[%s] #%d topics changed, restarting...", r.Name, round)
+			close(roundStop)
+			<-stopped
+
+		case <-stop:
+			close(roundStop)
+			<-stopped
+			return
+
+		case <-stopped:
+			// route encounters problems, just retry
+			if sleep(stop, time.Second*10) {
+				return
+			}
+		}
+	}
+}
+
+// sleep returns true if stopped.
+func sleep(stop <-chan struct{}, d time.Duration) bool {
+	select {
+	case <-stop:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+// RouteStat is the progress of a running route.
+type RouteStat struct {
+	Name          string `json:"name"`
+	Source        string `json:"source"`
+	Target        string `json:"target"`
+	Partitions    int    `json:"partitions"`
+	TransferN     int64  `json:"transfer_msgs"`
+	TransferBytes int64  `json:"transfer_bytes"`
+	Lag           int64  `json:"lag"` // source messages not copied yet
+}
+
+// Stats returns the progress of the running routes.
+func (this *Daemon) Stats() []RouteStat {
+	this.runnersLock.Lock()
+	defer this.runnersLock.Unlock()
+
+	r := make([]RouteStat, 0, len(this.runners))
+	for _, rr := range this.runners {
+		stat := RouteStat{
+			Name:          rr.Name,
+			Source:        rr.Source.String(),
+			Target:        rr.Target.String(),
+			TransferN:     atomic.LoadInt64(&rr.transferN),
+			TransferBytes: atomic.LoadInt64(&rr.transferBytes),
+		}
+
+		rr.lagsLock.Lock()
+		stat.Partitions = len(rr.lags)
+		for _, lag := range rr.lags {
+			stat.Lag += atomic.LoadInt64(lag)
+		}
+		rr.lagsLock.Unlock()
+
+		r = append(r, stat)
+	}
+
+	return r
+}
+
+func (this *routeRunner) run(sourceTopics []string, stop chan struct{}) {
+	if this.Transform != nil && !this.SharedTarget {
+		log.Error("[%s] transform requires shared target", this.Name)
+		return
+	}
+
+	var err error
+	if this.consumer, err = sarama.NewConsumer(this.c1.BrokerList(), sarama.NewConfig()); err != nil {
+		log.Error("[%s] source: %v", this.Name, err)
+		return
+	}
+	defer this.consumer.Close()
+
+	if this.target, err = sarama.NewClient(this.c2.BrokerList(), sarama.NewConfig()); err != nil {
+		log.Error("[%s] target: %v", this.Name, err)
+		return
+	}
+	defer this.target.Close()
+
+	if this.producer, err = this.makeProducer(); err != nil {
+		log.Error("[%s] target: %v", this.Name, err)
+		return
+	}
+	defer this.producer.Close()
+
+	if this.Bandwidth > 0 {
+		limit := (1 << 20) * this.Bandwidth / 8
+		this.bandwidth = ratelimiter.NewLeakyBucket(limit*10, time.Second*10)
+	}
+
+	var wg sync.WaitGroup
+	for _, topic := range sourceTopics {
+		targetTopic, ok := this.targetTopic(topic)
+		if !ok {
+			continue
+		}
+
+		partitions, err := this.ensureTargetTopic(topic, targetTopic)
+		if err != nil {
+			log.Error("[%s] %s -> %s: %v", this.Name, topic, targetTopic, err)
+			continue
+		}
+
+		for _, partitionId := range partitions {
+			lag := new(int64)
+			this.lagsLock.Lock()
+			this.lags[fmt.Sprintf("%s/%d", topic, partitionId)] = lag
+			this.lagsLock.Unlock()
+
+			wg.Add(1)
+			go func(topic, targetTopic string, partitionId int32) {
+				defer wg.Done()
+				this.pump(topic, targetTopic, partitionId, lag, stop)
+			}(topic, targetTopic, partitionId)
+		}
+	}
+
+	log.Info("[%s] %s -> %s started", this.Name, this.Source, this.Target)
+
+	wg.Wait()
+	log.Info("[%s] total transferred: %s %smsgs", this.Name,
+		gofmt.ByteSize(this.transferBytes), gofmt.Comma(this.transferN))
+}
+
+func (this *routeRunner) makeProducer() (sarama.SyncProducer, error) {
+	cf := sarama.NewConfig()
+	cf.Metadata.RefreshFrequency = time.Minute * 10
+	cf.Net.DialTimeout = time.Second * 30
+	cf.Net.WriteTimeout = time.Second * 30
+	cf.Net.ReadTimeout = time.Second * 30
+	cf.Net.MaxOpenRequests = 1 // keep the order
+
+	// never retry inside sarama, the pump resyncs with target on failure
+	// to avoid duplicates
+	cf.Producer.Retry.Max = 0
+	cf.Producer.RequiredAcks = sarama.WaitForAll
+	cf.Producer.Partitioner = sarama.NewManualPartitioner
+	cf.Producer.Return.Successes = true
+	cf.Producer.Return.Errors = true
+
+	switch this.Compress {
+	case "gzip":
+		cf.Producer.Compression = sarama.CompressionGZIP
+
+	case "snappy":
+		cf.Producer.Compression = sarama.CompressionSnappy
+	}
+
+	return sarama.NewSyncProducer(this.c2.BrokerList(), cf)
+}
+
+// ensureTargetTopic pre-creates the target topic with the partition count
+// and retention of the source topic, instead of relying on auto creation.
+func (this *routeRunner) ensureTargetTopic(topic, targetTopic string) ([]int32, error) {
+	partitions := this.c1.Partitions(topic)
+	if len(partitions) == 0 {
+		return nil, fmt.Errorf("source topic has no partitions")
+	}
+
+	if targetPartitions := this.c2.Partitions(targetTopic); len(targetPartitions) > 0 {
+		if len(targetPartitions) != len(partitions) {
+			return nil, fmt.Errorf("partitions mismatch: %d -> %d", len(partitions), len(targetPartitions))
+		}
+
+		return partitions, nil
+	}
+
+	ts := sla.DefaultSla()
+	ts.Partitions = len(partitions)
+	ts.Replicas = this.Replicas
+	if ts.Partitions > 20 {
+		// sla limits the max partitions of a topic
+		return nil, fmt.Errorf("too many partitions: %d", ts.Partitions)
+	}
+	if config, present := this.c1.ConfiggedTopics()[topic]; present {
+		if hours := retentionHours(config.Config); hours > 0 {
+			ts.RetentionHours = hours
+		}
+	}
+
+	output, err := this.c2.AddTopic(targetTopic, ts)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("[%s] created %s/%s %+v: %s", this.Name, this.Target, targetTopic, *ts, strings.Join(output, " "))
+
+	if len(ts.DumpForAlterTopic()) > 0 {
+		if output, err = this.c2.AlterTopic(targetTopic, ts); err != nil {
+			return nil, err
+		}
+		log.Info("[%s] altered %s/%s: %s", this.Name, this.Target, targetTopic, strings.Join(output, " "))
+	}
+
+	// wait for the target metadata propagation
+	for i := 0; i < 10; i++ {
+		if len(this.c2.Partitions(targetTopic)) == len(partitions) {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+
+	return partitions, nil
+}
+
+// retentionHours parses retention.ms from topic config in zk:
+// {"version":1,"config":{"retention.ms":"86400000"}}
+func retentionHours(config string) float64 {
+	var v struct {
+		Config map[string]string `json:"config"`
+	}
+	if err := json.Unmarshal([]byte(config), &v); err != nil {
+		return 0
+	}
+
+	ms, err := strconv.ParseInt(v.Config["retention.ms"], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return float64(ms) / 3600000
+}
+
+// pumpMsg is a source message to copy to the target.
+type pumpMsg struct {
+	offset     int64 // of the source
+	key, value []byte
+}
+
+// pump copies a source partition to the same partition of the target topic.
+func (this *routeRunner) pump(topic, targetTopic string, partitionId int32, lag *int64, stop chan struct{}) {
+	tag := fmt.Sprintf("[%s] %s/%d -> %s", this.Name, topic, partitionId, targetTopic)
+
+	cp, err := this.z2.LoadMirrorCheckpoint(this.Name, topic, partitionId)
+	if err != nil {
+		log.Error("%s checkpoint: %v", tag, err)
+		return
+	}
+
+	targetNewest, err := this.target.GetOffset(targetTopic, partitionId, sarama.OffsetNewest)
+	if err != nil {
+		log.Error("%s: %v", tag, err)
+		return
+	}
+
+	start, skip, fresh := resumePoint(cp, targetNewest)
+	if this.SharedTarget {
+		// the extra messages in target are written by others
+		skip = 0
+	}
+	if fresh {
+		start = sarama.OffsetOldest
+		if targetNewest > 0 && !this.SharedTarget {
+			log.Warn("%s target not empty without checkpoint: %d", tag, targetNewest)
+		}
+		cp = &zk.MirrorCheckpoint{TargetTopic: targetTopic, SourceOffset: -1, TargetOffset: targetNewest - 1}
+	}
+	if skip > 0 {
+		log.Warn("%s skip %d messages acked by target after checkpoint", tag, skip)
+	}
+
+	pc, err := this.consumer.ConsumePartition(topic, partitionId, start)
+	if err == sarama.ErrOffsetOutOfRange {
+		log.Warn("%s offset %d out of range, reset to oldest", tag, start)
+		pc, err = this.consumer.ConsumePartition(topic, partitionId, sarama.OffsetOldest)
+	}
+	if err != nil {
+		log.Error("%s: %v", tag, err)
+		return
+	}
+	defer pc.Close()
+
+	log.Trace("%s from offset %d", tag, start)
+
+	var (
+		dirty          bool
+		lastCheckpoint = time.Now()
+		batch          = make([]*sarama.ConsumerMessage, 0, pumpBatchSize)
+		msgs           = make([]pumpMsg, 0, pumpBatchSize)
+	)
+	defer func() {
+		if dirty {
+			this.checkpoint(tag, topic, partitionId, cp)
+		}
+	}()
+
+	for {
+		batch = batch[:0]
+
+		// block for the 1st message
+		select {
+		case <-stop:
+			return
+
+		case msg := <-pc.Messages():
+			batch = append(batch, msg)
+
+		case <-time.After(this.daemon.cf.checkpointInterval):
+		}
+
+		// then gather a batch
+		timeout := time.After(pumpBatchWait)
+	BATCH:
+		for len(batch) > 0 && len(batch) < pumpBatchSize {
+			select {
+			case msg := <-pc.Messages():
+				batch = append(batch, msg)
+			case <-timeout:
+				break BATCH
+			}
+		}
+
+		if skip > 0 && len(batch) > 0 {
+			n := int64(len(batch))
+			if n > skip {
+				n = skip
+			}
+			// the skipped messages are already in target
+			cp.SourceOffset = batch[n-1].Offset
+			cp.TargetOffset += n
+			skip -= n
+			batch = batch[n:]
+			dirty = true
+		}
+
+		if len(batch) > 0 {
+			msgs = msgs[:0]
+			for _, msg := range batch {
+				value := msg.Value
+				if this.Transform != nil {
+					var ok bool
+					if value, ok = this.Transform(msg); !ok {
+						continue
+					}
+				}
+
+				msgs = append(msgs, pumpMsg{offset: msg.Offset, key: msg.Key, value: value})
+			}
+
+			if len(msgs) > 0 && !this.send(tag, targetTopic, partitionId, msgs, cp, stop) {
+				return
+			}
+
+			// the skipped messages are done as well
+			cp.SourceOffset = batch[len(batch)-1].Offset
+			dirty = true
+		}
+
+		atomic.StoreInt64(lag, pc.HighWaterMarkOffset()-(cp.SourceOffset+1))
+
+		if dirty && time.Since(lastCheckpoint) >= this.daemon.cf.checkpointInterval {
+			this.checkpoint(tag, topic, partitionId, cp)
+			dirty = false
+			lastCheckpoint = time.Now()
+		}
+	}
+}
+
+// send produces the batch to target in sync and updates the checkpoint.
+// On failure it finds out how many messages of the batch target has
+// actually acked by the target newest offset, and resends the rest only.
+// A shared target can't tell, the whole batch is resent.
+// Returns false if stopped before the batch is fully acked.
+func (this *routeRunner) send(tag, targetTopic string, partitionId int32,
+	batch []pumpMsg, cp *zk.MirrorCheckpoint, stop chan struct{}) bool {
+	backoff := time.Second
+	for len(batch) > 0 {
+		msgs := make([]*sarama.ProducerMessage, len(batch))
+		var bytesN int
+		for i, msg := range batch {
+			msgs[i] = &sarama.ProducerMessage{
+				Topic:     targetTopic,
+				Partition: partitionId,
+				Key:       sarama.ByteEncoder(msg.key),
+				Value:     sarama.ByteEncoder(msg.value),
+			}
+			bytesN += len(msg.key) + len(msg.value) + 20 // payload overhead
+		}
+
+		if this.bandwidth != nil && !this.bandwidth.Pour(bytesN) {
+			log.Warn("%s bandwidth reached, backoff 1s", tag)
+			time.Sleep(time.Second)
+		}
+
+		err := this.producer.SendMessages(msgs)
+		if err == nil {
+			cp.SourceOffset = batch[len(batch)-1].offset
+			cp.TargetOffset = msgs[len(msgs)-1].Offset
+			atomic.AddInt64(&this.transferN, int64(len(batch)))
+			atomic.AddInt64(&this.transferBytes, int64(bytesN))
+			return true
+		}
+
+		log.Error("%s: %v", tag, err)
+
+		select {
+		case <-stop:
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+
+		if this.SharedTarget {
+			continue
+		}
+
+		newest, err := this.target.GetOffset(targetTopic, partitionId, sarama.OffsetNewest)
+		if err != nil {
+			log.Error("%s resync: %v", tag, err)
+			continue
+		}
+
+		acked := newest - (cp.TargetOffset + 1)
+		if acked <= 0 {
+			continue
+		}
+		if acked > int64(len(batch)) {
+			log.Critical("%s target has %d unknown messages, another writer?", tag, acked-int64(len(batch)))
+			acked = int64(len(batch))
+		}
+
+		log.Warn("%s %d of %d messages acked before failure", tag, acked, len(batch))
+		cp.SourceOffset = batch[acked-1].offset
+		cp.TargetOffset += acked
+		batch = batch[acked:]
+	}
+
+	return true
+}
+
+// checkpoint saves the offset mapping in target zone, then commits the
+// source offset so that lags of the mirror group are monitored as usual.
+func (this *routeRunner) checkpoint(tag, topic string, partitionId int32, cp *zk.MirrorCheckpoint) {
+	if cp.SourceOffset < 0 {
+		return
+	}
+
+	if this.SharedTarget {
+		remember(cp)
+	}
+
+	if err := this.z2.SaveMirrorCheckpoint(this.Name, topic, partitionId, *cp); err != nil {
+		log.Error("%s checkpoint: %v", tag, err)
+		return
+	}
+
+	if err := this.c1.CommitConsumerOffset(this.group(), topic, partitionId, cp.SourceOffset); err != nil {
+		log.Error("%s commit: %v", tag, err)
+	}
+
+	log.Debug("%s checkpoint %d -> %d", tag, cp.SourceOffset, cp.TargetOffset)
+}
+
+// Failover translates the committed source offsets of a consumer group to
+// the target topics of each route, so that the group resumes in the target
+// zone where it left off in the source zone.
+func (this *Daemon) Failover(group string, dryRun bool) error {
+	for _, r := range this.cf.Routes {
+		c1 := this.zkzone(r.Source.Zone).NewCluster(r.Source.Cluster)
+		c2 := this.zkzone(r.Target.Zone).NewCluster(r.Target.Cluster)
+		z2 := c2.ZkZone()
+
+		for topic, offsets := range c1.ConsumerOffsetsOfGroup(group) {
+			targetTopic, ok := r.targetTopic(topic)
+			if !ok {
+				continue
+			}
+
+			for partition, offset := range offsets {
+				partitionId, err := strconv.Atoi(partition)
+				if err != nil {
+					return err
+				}
+
+				cp, err := z2.LoadMirrorCheckpoint(r.Name, topic, int32(partitionId))
+				if err != nil {
+					return err
+				}
+				if cp == nil {
+					log.Warn("[%s] %s/%d never mirrored", r.Name, topic, partitionId)
+					continue
+				}
+
+				targetOffset := translateOffset(cp, offset)
+				if r.SharedTarget {
+					targetOffset = TranslateSharedOffset(cp, offset)
+				}
+				log.Info("[%s] %s %s/%d:%d -> %s/%d:%d", r.Name, group, topic, partitionId, offset,
+					targetTopic, partitionId, targetOffset)
+				if dryRun || targetOffset < 0 {
+					continue
+				}
+
+				if err = c2.CommitConsumerOffset(group, targetTopic, int32(partitionId), targetOffset); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -30,7 +30,7 @@ import (
 // target kafka auto.create.topics.enable=true
 //
 // TODO
-// * sync pub to assure no message lost
+// * sync pub to assure no message lost: see Daemon
 // * pub pool
 // * we might add a data channel between pub and sub
 type Mirror struct {
@@ -0,0 +1,90 @@
+package mirror
+
+import (
+	"github.com/funkygao/gafka/zk"
+)
+
+// resumePoint decides where a partition pump resumes from its checkpoint.
+//
+// The checkpoint is saved after the target acked, but the target might have
+// acked more messages than checkpointed before a crash. Since the pump is the
+// only writer of the target partition and produces in order, the extra
+// messages in target are exactly the messages following the checkpointed
+// source offset, which should be skipped to avoid duplicates.
+func resumePoint(cp *zk.MirrorCheckpoint, targetNewest int64) (sourceStart int64, skip int64, fresh bool) {
+	if cp == nil {
+		return 0, 0, true
+	}
+
+	skip = targetNewest - (cp.TargetOffset + 1)
+	if skip < 0 {
+		// target lost data, e,g. unclean leader election
+		skip = 0
+	}
+
+	return cp.SourceOffset + 1, skip, false
+}
+
+// translateOffset maps a consumed source offset to the target offset so that
+// a consumer group can fail over to the target zone.
+// The mapping assumes the source partition has no offset gaps since the
+// checkpointed message.
+func translateOffset(cp *zk.MirrorCheckpoint, sourceOffset int64) int64 {
+	if sourceOffset >= cp.SourceOffset {
+		// the rest are not mirrored yet, will be consumed in target later
+		return cp.TargetOffset
+	}
+
+	r := cp.TargetOffset - (cp.SourceOffset - sourceOffset)
+	if r < 0 {
+		return -1
+	}
+	return r
+}
+
+// maxOffsetMapSize is how many checkpoints the offset map of a shared target
+// route keeps, about 80m at the default checkpoint interval.
+const maxOffsetMapSize = 1000
+
+// remember appends the current mapping of the checkpoint to its offset map.
+func remember(cp *zk.MirrorCheckpoint) {
+	if n := len(cp.History); n > 0 && cp.History[n-1][0] == cp.SourceOffset {
+		return
+	}
+
+	cp.History = append(cp.History, [2]int64{cp.SourceOffset, cp.TargetOffset})
+	if n := len(cp.History); n > maxOffsetMapSize {
+		cp.History = cp.History[n-maxOffsetMapSize:]
+	}
+}
+
+// TranslateSharedOffset maps a consumed source offset to the target offset
+// by the offset map of a shared target route.
+//
+// A checkpoint tells that the source messages upto its source offset are in
+// the target upto its target offset, so the latest checkpoint not beyond the
+// source offset is taken, messages between are consumed again in target.
+// Returns -1 if the source offset is older than the offset map.
+func TranslateSharedOffset(cp *zk.MirrorCheckpoint, sourceOffset int64) int64 {
+	for i := len(cp.History) - 1; i >= 0; i-- {
+		if cp.History[i][0] <= sourceOffset {
+			return cp.History[i][1]
+		}
+	}
+
+	return -1
+}
+
+// ReverseSharedOffset maps a consumed target offset back to the source offset
+// by the offset map of a shared target route: the source messages upto the
+// returned offset are all consumed in target.
+// Returns -1 if the target offset is older than the offset map.
+func ReverseSharedOffset(cp *zk.MirrorCheckpoint, targetOffset int64) int64 {
+	for i := len(cp.History) - 1; i >= 0; i-- {
+		if cp.History[i][1] <= targetOffset {
+			return cp.History[i][0]
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,165 @@
+package mirror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const defaultCheckpointInterval = time.Second * 5
+
+// DaemonConfig is the config file of mirror daemon mode, e,g.
+// {
+// "checkpoint_interval": "5s",
+// "routes": [
+// {
+// "name": "trade_dr",
+// "source": {"zone": "prod", "cluster": "trade"},
+// "target": {"zone": "dr", "cluster": "trade"},
+// "topics": {"orders": "orders", "users": "dr.users"},
+// "replicas": 2,
+// "compress": "snappy",
+// "bandwidth": 100
+// }
+// ]
+// }
+type DaemonConfig struct {
+	Routes []*Route `json:"routes"`
+
+	CheckpointInterval string `json:"checkpoint_interval"`
+	checkpointInterval time.Duration
+}
+
+type Endpoint struct {
+	Zone    string `json:"zone"`
+	Cluster string `json:"cluster"`
+}
+
+func (this Endpoint) String() string {
+	return this.Zone + "/" + this.Cluster
+}
+
+// Route mirrors topics of a source cluster to a target cluster.
+type Route struct {
+	// Name identifies the checkpoints and consumer group of the route.
+	Name   string   `json:"name"`
+	Source Endpoint `json:"source"`
+	Target Endpoint `json:"target"`
+
+	// Topics maps source topic to target topic, empty target means the
+	// same name. Empty topics means all topics of the source cluster.
+	Topics  map[string]string `json:"topics"`
+	Exclude []string          `json:"exclude"`
+
+	// Replicas of the pre-created target topics.
+	Replicas int `json:"replicas"`
+
+	Compress string `json:"compress"`
+
+	// Bandwidth limit in Mbps, 0 means unlimited.
+	Bandwidth int64 `json:"bandwidth"`
+
+	// SharedTarget means the target topics are also published by others,
+	// e,g. zones in active-active, so the target offsets can't tell how far
+	// the route has copied. The pump resumes from the checkpoint and resends
+	// a failed batch as a whole, duplicates are possible. The checkpoints are
+	// kept as an offset map for consumer groups fail over.
+	SharedTarget bool `json:"shared_target"`
+
+	// Accept selects the source topics besides Topics and Exclude, nil
+	// accepts all.
+	Accept func(topic string) bool `json:"-"`
+
+	// Transform rewrites the value of a source message before copied to the
+	// target, the message is skipped if ok is false. nil copies as is.
+	// Skipping messages requires SharedTarget.
+	Transform func(msg *sarama.ConsumerMessage) (value []byte, ok bool) `json:"-"`
+}
+
+func LoadDaemonConfig(fn string) (*DaemonConfig, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDaemonConfig(b)
+}
+
+func parseDaemonConfig(b []byte) (*DaemonConfig, error) {
+	cf := &DaemonConfig{}
+	if err := json.Unmarshal(b, cf); err != nil {
+		return nil, err
+	}
+
+	cf.checkpointInterval = defaultCheckpointInterval
+	if cf.CheckpointInterval != "" {
+		var err error
+		if cf.checkpointInterval, err = time.ParseDuration(cf.CheckpointInterval); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cf.Routes) == 0 {
+		return nil, errors.New("empty routes")
+	}
+
+	names := make(map[string]struct{})
+	for _, r := range cf.Routes {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("%s.%s.%s.%s", r.Source.Zone, r.Source.Cluster, r.Target.Zone, r.Target.Cluster)
+		}
+		if _, present := names[r.Name]; present {
+			return nil, fmt.Errorf("duplicated route: %s", r.Name)
+		}
+		names[r.Name] = struct{}{}
+
+		if r.Source.Zone == "" || r.Source.Cluster == "" || r.Target.Zone == "" || r.Target.Cluster == "" {
+			return nil, fmt.Errorf("route %s: empty source or target", r.Name)
+		}
+		if r.Replicas == 0 {
+			r.Replicas = 2
+		}
+	}
+
+	return cf, nil
+}
+
+// group is the consumer group that records the committed source offsets.
+func (this *Route) group() string {
+	return "_mirror_." + this.Name
+}
+
+// targetTopic returns the target topic name of a source topic and whether it
+// should be mirrored.
+func (this *Route) targetTopic(topic string) (string, bool) {
+	if _, internal := internalTopics[topic]; internal {
+		return "", false
+	}
+
+	for _, t := range this.Exclude {
+		if t == topic {
+			return "", false
+		}
+	}
+
+	if this.Accept != nil && !this.Accept(topic) {
+		return "", false
+	}
+
+	if len(this.Topics) == 0 {
+		return topic, true
+	}
+
+	target, present := this.Topics[topic]
+	if !present {
+		return "", false
+	}
+	if target == "" {
+		target = topic
+	}
+	return target, true
+}
@@ -0,0 +1,112 @@
+package mirror
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/zk"
+)
+
+func TestParseDaemonConfig(t *testing.T) {
+	cf, err := parseDaemonConfig([]byte(`{"routes":[{"source":{"zone":"prod","cluster":"trade"},"target":{"zone":"dr","cluster":"trade"}}]}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Second*5, cf.checkpointInterval)
+	assert.Equal(t, "prod.trade.dr.trade", cf.Routes[0].Name)
+	assert.Equal(t, 2, cf.Routes[0].Replicas)
+	assert.Equal(t, "_mirror_.prod.trade.dr.trade", cf.Routes[0].group())
+
+	_, err = parseDaemonConfig([]byte(`{"routes":[]}`))
+	assert.NotEqual(t, nil, err)
+
+	_, err = parseDaemonConfig([]byte(`{"routes":[{"name":"a","source":{"zone":"prod","cluster":"c"},"target":{"zone":"dr","cluster":"c"}},{"name":"a","source":{"zone":"prod","cluster":"c"},"target":{"zone":"dr","cluster":"c"}}]}`))
+	assert.NotEqual(t, nil, err)
+
+	_, err = parseDaemonConfig([]byte(`{"routes":[{"source":{"zone":"prod"},"target":{"zone":"dr","cluster":"c"}}]}`))
+	assert.NotEqual(t, nil, err)
+}
+
+func TestRouteTargetTopic(t *testing.T) {
+	r := &Route{Exclude: []string{"t3"}}
+	target, ok := r.targetTopic("t1")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "t1", target)
+	_, ok = r.targetTopic("t3")
+	assert.Equal(t, false, ok)
+	_, ok = r.targetTopic("__consumer_offsets")
+	assert.Equal(t, false, ok)
+
+	r.Topics = map[string]string{"t1": "", "t2": "dr.t2"}
+	target, ok = r.targetTopic("t1")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "t1", target)
+	target, ok = r.targetTopic("t2")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "dr.t2", target)
+	_, ok = r.targetTopic("t4")
+	assert.Equal(t, false, ok)
+
+	r.Accept = func(topic string) bool { return topic != "t2" }
+	_, ok = r.targetTopic("t1")
+	assert.Equal(t, true, ok)
+	_, ok = r.targetTopic("t2")
+	assert.Equal(t, false, ok)
+}
+
+func TestResumePoint(t *testing.T) {
+	_, _, fresh := resumePoint(nil, 100)
+	assert.Equal(t, true, fresh)
+
+	cp := &zk.MirrorCheckpoint{SourceOffset: 50, TargetOffset: 9}
+	start, skip, fresh := resumePoint(cp, 10)
+	assert.Equal(t, false, fresh)
+	assert.Equal(t, int64(51), start)
+	assert.Equal(t, int64(0), skip)
+
+	// target acked 3 more messages after checkpoint
+	_, skip, _ = resumePoint(cp, 13)
+	assert.Equal(t, int64(3), skip)
+
+	// target lost data
+	_, skip, _ = resumePoint(cp, 5)
+	assert.Equal(t, int64(0), skip)
+}
+
+func TestTranslateOffset(t *testing.T) {
+	cp := &zk.MirrorCheckpoint{SourceOffset: 50, TargetOffset: 9}
+	assert.Equal(t, int64(9), translateOffset(cp, 50))
+	assert.Equal(t, int64(9), translateOffset(cp, 60))
+	assert.Equal(t, int64(7), translateOffset(cp, 48))
+	assert.Equal(t, int64(-1), translateOffset(cp, 30))
+}
+
+func TestSharedOffsetMap(t *testing.T) {
+	cp := &zk.MirrorCheckpoint{}
+	for _, pair := range [][2]int64{{10, 3}, {10, 3}, {20, 8}, {30, 20}} {
+		cp.SourceOffset, cp.TargetOffset = pair[0], pair[1]
+		remember(cp)
+	}
+	assert.Equal(t, 3, len(cp.History))
+
+	assert.Equal(t, int64(-1), TranslateSharedOffset(cp, 5))
+	assert.Equal(t, int64(3), TranslateSharedOffset(cp, 10))
+	assert.Equal(t, int64(8), TranslateSharedOffset(cp, 29))
+	assert.Equal(t, int64(20), TranslateSharedOffset(cp, 100))
+
+	assert.Equal(t, int64(-1), ReverseSharedOffset(cp, 2))
+	assert.Equal(t, int64(10), ReverseSharedOffset(cp, 7))
+	assert.Equal(t, int64(20), ReverseSharedOffset(cp, 8))
+	assert.Equal(t, int64(30), ReverseSharedOffset(cp, 100))
+
+	for i := int64(0); i < maxOffsetMapSize; i++ {
+		cp.SourceOffset++
+		remember(cp)
+	}
+	assert.Equal(t, maxOffsetMapSize, len(cp.History))
+	assert.Equal(t, int64(31), cp.History[0][0])
+}
+
+func TestRetentionHours(t *testing.T) {
+	assert.Equal(t, float64(24), retentionHours(`{"version":1,"config":{"retention.ms":"86400000"}}`))
+	assert.Equal(t, float64(0), retentionHours(`{"version":1,"config":{}}`))
+}
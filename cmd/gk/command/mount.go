@@ -25,6 +25,9 @@ type Mount struct {
 	cluster    string
 	logLevel   string
 	mountPoint string
+	writable   bool
+	framing    string
+	attrTTL    time.Duration
 }
 
 func (this *Mount) Run(args []string) (exitCode int) {
@@ -33,6 +36,9 @@ func (this *Mount) Run(args []string) (exitCode int) {
 	cmdFlags.StringVar(&this.zone, "z", ctx.ZkDefaultZone(), "")
 	cmdFlags.StringVar(&this.cluster, "c", "", "")
 	cmdFlags.StringVar(&this.logLevel, "l", "info", "")
+	cmdFlags.BoolVar(&this.writable, "w", false, "")
+	cmdFlags.StringVar(&this.framing, "framing", kfs.FramingRaw, "")
+	cmdFlags.DurationVar(&this.attrTTL, "attrttl", time.Second*5, "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -49,14 +55,20 @@ func (this *Mount) Run(args []string) (exitCode int) {
 		return 1
 	}
 
+	if this.framing != kfs.FramingRaw && this.framing != kfs.FramingJson {
+		this.Ui.Error("invalid framing: " + this.framing)
+		return 1
+	}
+
 	setupLogging("stdout", this.logLevel, "")
 
+	// partition files enforce read only unless -w while the .offset control
+	// file is always writable
 	c, err := fuse.Mount(
 		this.mountPoint,
 		fuse.FSName("kfs"),
 		fuse.Subtype("kfs"),
 		fuse.VolumeName("Kafka FS"),
-		fuse.ReadOnly(),
 		fuse.AllowOther(),
 	)
 	if err != nil {
@@ -86,7 +98,14 @@ func (this *Mount) Run(args []string) (exitCode int) {
 	}, syscall.SIGINT, syscall.SIGTERM)
 
 	srv := fs.New(c, &fs.Config{})
-	fs := kfs.New(this.zone, this.cluster)
+	fs := kfs.New(kfs.Config{
+		Zone:     this.zone,
+		Cluster:  this.cluster,
+		Writable: this.writable,
+		Framing:  this.framing,
+		AttrTTL:  this.attrTTL,
+	})
+	defer fs.Close()
 
 	if err := srv.Serve(fs); err != nil {
 		log.Error(err)
@@ -110,6 +129,18 @@ Usage: %s mount [options] mount_point
 
     %s
 
+    Each partition is presented as file topic.partition of newline delimited
+    records, reading it consumes from the start point up to the newest offset.
+    With -w, appending to it produces messages to that partition:
+    echo hello >> /kfs/orders.0
+
+    /kfs/consumer-groups/<group>/<topic.partition> shows committed offsets.
+
+    /kfs/.offset sets the read start point of partition files:
+    echo newest > /kfs/.offset
+    echo "orders.0 -100" > /kfs/.offset
+    where start point is oldest|newest|offset|-N(N messages before newest).
+
 Pre-requisites:
     yum install -y fuse
     modprobe fuse
@@ -124,7 +155,16 @@ Options:
 
     -l log level
       Default 'info'.
-      
+
+    -w
+      Writable: appending to partition files produces messages.
+
+    -framing raw|json
+      Record framing of partition files, defaults raw.
+      json record: {"offset":1,"key":"k","value":"v"}, offset is ignored on write.
+
+    -attrttl duration
+      How long kafka metadata is cached, defaults 5s.
 
 `, this.Cmd, this.Synopsis(), ctx.ZkDefaultZone())
 	return strings.TrimSpace(help)
@@ -3,6 +3,7 @@ package command
 import (
 	"flag"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -24,6 +25,7 @@ func (this *Offset) Run(args []string) (exitCode int) {
 		group     string
 		partition string
 		offset    int64
+		storage   string
 	)
 	cmdFlags := flag.NewFlagSet("offset", flag.ContinueOnError)
 	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
@@ -33,47 +35,118 @@ func (this *Offset) Run(args []string) (exitCode int) {
 	cmdFlags.StringVar(&group, "g", "", "")
 	cmdFlags.Int64Var(&offset, "offset", -1, "")
 	cmdFlags.StringVar(&partition, "p", "", "")
+	cmdFlags.StringVar(&storage, "storage", "", "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
 	if validateArgs(this, this.Ui).
-		require("-z", "-c", "-t", "-g", "-p", "-offset").
+		require("-z", "-c").
+		on("-offset", "-t", "-g", "-p").
 		requireAdminRights("-z").
 		invalid(args) {
 		return 2
 	}
 
+	zkzone := zk.NewZkZone(zk.DefaultConfig(zone, ctx.ZoneZkAddrs(zone)))
+	zkcluster := zkzone.NewCluster(cluster)
+
+	if storage != "" {
+		if err := zkcluster.SetOffsetStorageOfGroup(group, storage); err != nil {
+			this.Ui.Error(err.Error())
+			return 1
+		}
+
+		this.Ui.Output("done")
+		return
+	}
+
+	if offset == -1 {
+		this.showOffsets(zkcluster, group, topic)
+		return
+	}
+
 	if offset < 0 {
 		this.Ui.Error("offset must be positive")
 		return
 	}
 
-	if p, err := strconv.Atoi(partition); err != nil {
-		this.Ui.Error("invalid partition")
-		return
-	} else if p < 0 || p > 100 {
+	p, err := strconv.Atoi(partition)
+	if err != nil || p < 0 || p > 100 {
 		this.Ui.Error("invalid partition")
 		return
 	}
 
-	zkzone := zk.NewZkZone(zk.DefaultConfig(zone, ctx.ZoneZkAddrs(zone)))
-	zkcluster := zkzone.NewCluster(cluster)
-	zkcluster.ResetConsumerGroupOffset(topic, group, partition, offset)
+	store := zkcluster.NewOffsetStore()
+	defer store.Close()
+
+	if err = store.ResetOffset(group, topic, int32(p), offset); err != nil {
+		this.Ui.Error(err.Error())
+		return 1
+	}
+
 	this.Ui.Output("done")
 	return
 }
 
+func (this *Offset) showOffsets(zkcluster *zk.ZkCluster, group, topic string) {
+	storage, err := zkcluster.OffsetStorageOfGroup(group)
+	if err != nil {
+		this.Ui.Error(err.Error())
+		return
+	}
+
+	if group == "" {
+		this.Ui.Output(fmt.Sprintf("%s default storage: %s", zkcluster.Name(), storage))
+		return
+	}
+
+	this.Ui.Output(fmt.Sprintf("%s storage: %s", group, storage))
+	for t, offsets := range zkcluster.ConsumerOffsetsOfGroup(group) {
+		if topic != "" && t != topic {
+			continue
+		}
+
+		sortedPartitionIds := make([]string, 0, len(offsets))
+		for partitionId := range offsets {
+			sortedPartitionIds = append(sortedPartitionIds, partitionId)
+		}
+		sort.Strings(sortedPartitionIds)
+
+		for _, partitionId := range sortedPartitionIds {
+			this.Ui.Output(fmt.Sprintf("%8s%s/%s %d", " ", t, partitionId, offsets[partitionId]))
+		}
+	}
+}
+
 func (*Offset) Synopsis() string {
-	return "Manually set consumer group offset"
+	return "Manually set consumer group offset or offset storage"
 }
 
 func (this *Offset) Help() string {
 	help := fmt.Sprintf(`
-Usage: %s offset -z zone -c cluster -t topic -g group -p partition -offset offset
+Usage: %s offset -z zone -c cluster [options]
 
     %s
 
+Options:
+
+    -g group
+      Show the offset storage and offsets of the group.
+      Without -g, the cluster default offset storage.
+
+    -t topic
+
+    -p partition
+
+    -offset offset
+      Reset offset of the group on -t/-p.
+
+    -storage zk|dual|kafka
+      Switch the offset storage of the group, the cluster default if without -g.
+      Migrate from zk to kafka step by step: zk -> dual -> kafka
+      dual writes offsets to both zk and kafka and reads from zk.
+
 `, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
 }
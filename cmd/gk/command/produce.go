@@ -1,6 +1,8 @@
 package command
 
 import (
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -8,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/gk/command/segment"
 	"github.com/funkygao/gafka/ctx"
 	"github.com/funkygao/gafka/zk"
 	"github.com/funkygao/gocli"
@@ -22,6 +25,7 @@ type Produce struct {
 	benchMode            bool
 	benchmarkMaster      string
 	ackAll               bool
+	replayFile           string
 	zkcluster            *zk.ZkCluster
 }
 
@@ -34,6 +38,7 @@ func (this *Produce) Run(args []string) (exitCode int) {
 	cmdFlags.BoolVar(&this.ackAll, "ackall", false, "")
 	cmdFlags.BoolVar(&this.benchMode, "bench", false, "")
 	cmdFlags.StringVar(&this.benchmarkMaster, "master", "", "")
+	cmdFlags.StringVar(&this.replayFile, "f", "", "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -61,9 +66,6 @@ func (this *Produce) Run(args []string) (exitCode int) {
 		return
 	}
 
-	msg, err := this.Ui.Ask("Input>")
-	swallow(err)
-
 	cf := sarama.NewConfig()
 	cf.Producer.RequiredAcks = sarama.WaitForLocal
 	if this.ackAll {
@@ -73,6 +75,13 @@ func (this *Produce) Run(args []string) (exitCode int) {
 	swallow(err)
 	defer p.Close()
 
+	if this.replayFile != "" {
+		return this.replay(p)
+	}
+
+	msg, err := this.Ui.Ask("Input>")
+	swallow(err)
+
 	partition, offset, err := p.SendMessage(&sarama.ProducerMessage{
 		Topic: this.topic,
 		Value: sarama.StringEncoder(msg),
@@ -87,6 +96,41 @@ func (this *Produce) Run(args []string) (exitCode int) {
 	return
 }
 
+// replay produces the json lines extracted by 'gk segment -extract' in order.
+func (this *Produce) replay(p sarama.SyncProducer) (exitCode int) {
+	f, err := os.Open(this.replayFile)
+	swallow(err)
+	defer f.Close()
+
+	var n int
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1<<20), 100<<20)
+	for scanner.Scan() {
+		var rec segment.Record
+		if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			this.Ui.Error(fmt.Sprintf("line %d: %v", n+1, err))
+			return 1
+		}
+
+		msg := &sarama.ProducerMessage{
+			Topic: this.topic,
+			Value: sarama.ByteEncoder(rec.Value),
+		}
+		if len(rec.Key) > 0 {
+			msg.Key = sarama.ByteEncoder(rec.Key)
+		}
+		if _, _, err = p.SendMessage(msg); err != nil {
+			this.Ui.Error(fmt.Sprintf("offset %d: %v", rec.Offset, err))
+			return 1
+		}
+		n++
+	}
+	swallow(scanner.Err())
+
+	this.Ui.Output(fmt.Sprintf("ok, %d messages replayed", n))
+	return
+}
+
 func (this *Produce) benchmarkProducer(seq int) {
 	cf := sarama.NewConfig()
 	cf.Producer.RequiredAcks = sarama.WaitForLocal
@@ -136,6 +180,9 @@ Usage: %s produce [options]
 
     -master benchmark master address
 
+    -f file
+      Replay the messages extracted by 'gk segment -extract'.
+
 `, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
 }
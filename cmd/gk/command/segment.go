@@ -2,37 +2,35 @@ package command
 
 import (
 	"bufio"
-	"bytes"
-	"compress/gzip"
-	"encoding/binary"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/gk/command/segment"
 	"github.com/funkygao/gocli"
 	"github.com/funkygao/golib/gofmt"
-	"github.com/golang/snappy"
 	"github.com/pmylund/sortutil"
 )
 
-var snappyMagic = []byte{130, 83, 78, 65, 80, 80, 89, 0} // SNAPPY
-
 // TODO calculate how much data produced each day "github.com/hashicorp/go-memdb"
 type Segment struct {
 	Ui  cli.Ui
 	Cmd string
 
-	rootPath string
-	filename string
-	limit    int
+	rootPath      string
+	filename      string
+	limit         int
+	verify        bool
+	repair        bool
+	indexInterval int64
+	extractMode   bool
+	outFile       string
 }
 
 func (this *Segment) Run(args []string) (exitCode int) {
@@ -41,11 +39,29 @@ func (this *Segment) Run(args []string) (exitCode int) {
 	cmdFlags.StringVar(&this.rootPath, "s", "", "")
 	cmdFlags.IntVar(&this.limit, "n", -1, "")
 	cmdFlags.StringVar(&this.filename, "f", "", "")
+	cmdFlags.BoolVar(&this.verify, "verify", false, "")
+	cmdFlags.BoolVar(&this.repair, "repair", false, "")
+	cmdFlags.Int64Var(&this.indexInterval, "interval", segment.DefaultIndexInterval, "")
+	cmdFlags.BoolVar(&this.extractMode, "extract", false, "")
+	cmdFlags.StringVar(&this.outFile, "o", "", "")
+	var rg segment.Range
+	var since, until string
+	cmdFlags.Int64Var(&rg.FromOffset, "from", 0, "")
+	cmdFlags.Int64Var(&rg.ToOffset, "to", 0, "")
+	cmdFlags.StringVar(&since, "since", "", "")
+	cmdFlags.StringVar(&until, "until", "", "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
 	if this.rootPath != "" {
+		if this.verify {
+			if !this.verifyDir() {
+				return 1
+			}
+			return
+		}
+
 		this.printSummary()
 		return
 	}
@@ -56,7 +72,34 @@ func (this *Segment) Run(args []string) (exitCode int) {
 		return 2
 	}
 
-	this.readSegment(this.filename)
+	switch {
+	case this.verify:
+		if !this.verifySegment(this.filename) {
+			return 1
+		}
+
+	case this.repair:
+		this.rebuildIndex(this.filename)
+		if !this.verifySegment(this.filename) {
+			return 1
+		}
+
+	case this.extractMode:
+		var err error
+		if since != "" {
+			rg.Since, err = time.ParseInLocation("2006-01-02 15:04:05", since, time.Local)
+			swallow(err)
+		}
+		if until != "" {
+			rg.Until, err = time.ParseInLocation("2006-01-02 15:04:05", until, time.Local)
+			swallow(err)
+		}
+
+		this.extract(this.filename, rg)
+
+	default:
+		this.readSegment(this.filename)
+	}
 
 	return
 }
@@ -68,111 +111,123 @@ func (this *Segment) readSegment(filename string) {
 	swallow(err)
 	defer f.Close()
 
-	const (
-		maxKeySize = 10 << 10
-		maxValSize = 2 << 20
-	)
-
 	var (
-		buf = make([]byte, 12)
-		key = make([]byte, maxKeySize)
-		val = make([]byte, maxValSize)
-
 		msgN        int64
-		firstOffset uint64 = math.MaxUint64 // sentry
-		endOffset   uint64
+		firstOffset int64 = -1
+		endOffset   int64
 	)
-	r := bufio.NewReader(f)
+	r := segment.NewReader(f, 0)
 	for {
-		_, err := r.Read(buf)
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				panic(err)
-			}
+		msg, err := r.Next()
+		if err == io.EOF {
+			break
 		}
+		swallow(err)
 
-		// offset, size, crc32, magic, attrs, key-len, key-content, msg-len, msg-content
-		// crc32 = crc32(magic, attrs, key-len, key-content, msg-len, msg-content)
-
-		// offset+size 8+4
-		offset := binary.BigEndian.Uint64(buf[:8])
-		size := binary.BigEndian.Uint32(buf[8:12])
+		msgs, err := msg.Messages()
+		swallow(err)
 
-		// crc32+magic+attrs+keySize[key] 4+1+1+4
-		r.Read(buf[0:10])
+		codec := ""
+		switch msg.Codec() {
+		case segment.CodecGzip:
+			codec = "gzip "
+		case segment.CodecSnappy:
+			codec = "snappy "
+		}
+		for _, m := range msgs {
+			fmt.Printf("offset:%d size:%d %s%s\n", m.Offset, msg.Size, codec, string(m.Value))
+		}
 
-		attr := buf[5]
-		keySize := binary.BigEndian.Uint32(buf[6:10])
-		if keySize > 0 && keySize != math.MaxUint32 {
-			_, err = r.Read(key[:keySize])
-			swallow(err)
+		if firstOffset == -1 {
+			firstOffset = msg.Offset
 		}
+		endOffset = msg.Offset
+		msgN++
 
-		// valSize[val] 4
-		_, err = r.Read(buf[:4])
-		swallow(err)
-		valSize := binary.BigEndian.Uint32(buf[:4])
-		if valSize > 0 {
-			_, err = r.Read(val[:valSize])
-			swallow(err)
+		if this.limit > 0 && msgN >= int64(this.limit) {
+			break
 		}
+	}
 
-		switch sarama.CompressionCodec(attr) {
-		case sarama.CompressionNone:
-			fmt.Printf("offset:%d size:%d %s\n", offset, size, string(val[:valSize]))
+	fmt.Printf("Total Messages: %d, %d - %d\n", msgN, firstOffset, endOffset)
+}
 
-		case sarama.CompressionGZIP:
-			reader, err := gzip.NewReader(bytes.NewReader(val[:valSize]))
-			swallow(err)
-			v, err := ioutil.ReadAll(reader)
-			swallow(err)
+func (this *Segment) verifySegment(filename string) (ok bool) {
+	report, err := segment.VerifyFile(filename)
+	swallow(err)
 
-			fmt.Printf("offset:%d size:%d gzip %s\n", offset, size, string(v))
+	this.Ui.Output(fmt.Sprintf("%s size:%s valid:%s msgs:%d offsets:%d-%d", filename,
+		gofmt.ByteSize(report.FileSize), gofmt.ByteSize(report.ValidSize),
+		report.Messages, report.FirstOffset, report.LastOffset))
+	if report.Tail != nil {
+		this.Ui.Warn(fmt.Sprintf("    tail %s, %d bytes unreadable", *report.Tail, report.FileSize-report.ValidSize))
+	}
+	for _, p := range report.Corrupt {
+		this.Ui.Warn(fmt.Sprintf("    corrupt %s", p))
+	}
+	for _, p := range report.Unordered {
+		this.Ui.Warn(fmt.Sprintf("    unordered %s", p))
+	}
+	for _, p := range report.IndexProblems {
+		this.Ui.Warn(fmt.Sprintf("    index %s", p))
+	}
+	if !report.Ok() {
+		return false
+	}
 
-		case sarama.CompressionSnappy:
-			v, err := this.snappyDecode(val[:valSize])
-			swallow(err)
+	this.Ui.Info("    ok")
+	return true
+}
 
-			fmt.Printf("offset:%d size:%d snappy %s\n", offset, size, string(v))
+func (this *Segment) verifyDir() (ok bool) {
+	ok = true
+	err := filepath.Walk(this.rootPath, func(path string, f os.FileInfo, err error) error {
+		if f == nil {
+			return err
+		}
+		if f.IsDir() || !this.isKafkaLogSegment(f.Name()) {
+			return nil
 		}
 
-		if firstOffset == math.MaxUint64 {
-			firstOffset = offset
+		if !this.verifySegment(path) {
+			ok = false
 		}
-		endOffset = offset
-		msgN++
-	}
+		return nil
+	})
+	swallow(err)
+	return
+}
 
-	fmt.Printf("Total Messages: %d, %d - %d\n", msgN, firstOffset, endOffset)
+func (this *Segment) rebuildIndex(filename string) {
+	entries, err := segment.RebuildIndex(filename, this.indexInterval)
+	swallow(err)
+
+	this.Ui.Info(fmt.Sprintf("%s rebuilt with %d entries", segment.IndexFileOf(filename), len(entries)))
 }
 
-func (*Segment) snappyDecode(src []byte) ([]byte, error) {
-	if bytes.Equal(src[:8], snappyMagic) {
-		var (
-			pos   = uint32(16)
-			max   = uint32(len(src))
-			dst   = make([]byte, 0, len(src))
-			chunk []byte
-			err   error
-		)
-		for pos < max {
-			size := binary.BigEndian.Uint32(src[pos : pos+4])
-			pos += 4
-
-			chunk, err = snappy.Decode(chunk, src[pos:pos+size])
-			if err != nil {
-				return nil, err
-			}
-			pos += size
-			dst = append(dst, chunk...)
-		}
+func (this *Segment) extract(filename string, rg segment.Range) {
+	f, err := os.Open(filename) // readonly
+	swallow(err)
+	defer f.Close()
+
+	var w io.Writer = os.Stdout
+	if this.outFile != "" {
+		out, err := os.Create(this.outFile)
+		swallow(err)
+		defer out.Close()
 
-		return dst, nil
+		bw := bufio.NewWriter(out)
+		defer bw.Flush()
+		w = bw
 	}
 
-	return snappy.Decode(nil, src)
+	stats, err := segment.Extract(f, rg, w)
+	swallow(err)
+
+	if this.outFile != "" {
+		this.Ui.Info(fmt.Sprintf("%d messages extracted to %s, %d corrupt skipped",
+			stats.Extracted, this.outFile, stats.Skipped))
+	}
 }
 
 func (this *Segment) isKafkaLogSegment(fn string) bool {
@@ -264,7 +319,7 @@ func (this *Segment) printSummary() {
 }
 
 func (*Segment) Synopsis() string {
-	return "Scan the kafka segments and display summary"
+	return "Scan, verify and repair the kafka segments"
 }
 
 func (this *Segment) Help() string {
@@ -273,6 +328,13 @@ Usage: %s segment [options]
 
     %s
 
+    e,g.
+    gk segment -f 00000000000000000000.log -verify
+    gk segment -s /var/kafka/logs/orders-0 -verify
+    gk segment -f 00000000000000000000.log -repair
+    gk segment -f 00000000000000000000.log -extract -from 100 -to 200 -o msgs.json
+    gk produce -c cluster -t topic -f msgs.json
+
     -f segment file name
 
     -s dir
@@ -282,6 +344,39 @@ Usage: %s segment [options]
     -n limit
       Default unlimited.
 
+    -verify
+      Verify message crc, offset monotonicity, truncated tail and the
+      .index file of the segment, or all segments with -s.
+      Exit code is 1 if any problem found.
+
+    -repair
+      Rebuild the .index file from the intact part of the log.
+      The original index is kept with .bak suffix.
+      Stop the broker before repairing.
+
+    -interval bytes
+      Index interval bytes of -repair.
+      Defaults 4096, the index.interval.bytes of kafka.
+
+    -extract
+      Extract messages as json lines that 'gk produce -f' can replay.
+      Compressed messages are decompressed, corrupt messages skipped.
+
+    -from offset
+      Work with -extract, inclusive.
+
+    -to offset
+      Work with -extract, inclusive.
+
+    -since 'yyyy-mm-dd hh:mm:ss'
+      Work with -extract, messages without timestamp are excluded.
+
+    -until 'yyyy-mm-dd hh:mm:ss'
+      Work with -extract.
+
+    -o file
+      Work with -extract, defaults stdout.
+
 `, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
 }
@@ -0,0 +1,63 @@
+package segment
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+)
+
+var snappyMagic = []byte{130, 83, 78, 65, 80, 80, 89, 0} // SNAPPY
+
+func decompress(codec int8, b []byte) ([]byte, error) {
+	switch codec {
+	case CodecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+
+	case CodecSnappy:
+		return snappyDecode(b)
+
+	default:
+		return nil, ErrUnsupportedCodec
+	}
+}
+
+// snappyDecode handles both the xerial framing of the java client and raw snappy.
+func snappyDecode(src []byte) ([]byte, error) {
+	if len(src) < 16 || !bytes.Equal(src[:8], snappyMagic) {
+		return snappy.Decode(nil, src)
+	}
+
+	var (
+		pos   = 16
+		dst   = make([]byte, 0, len(src))
+		chunk []byte
+		err   error
+	)
+	for pos < len(src) {
+		if pos+4 > len(src) {
+			return nil, ErrBadMessage
+		}
+		size := int(binary.BigEndian.Uint32(src[pos:]))
+		pos += 4
+		if pos+size > len(src) {
+			return nil, ErrBadMessage
+		}
+
+		chunk, err = snappy.Decode(chunk, src[pos:pos+size])
+		if err != nil {
+			return nil, err
+		}
+		pos += size
+		dst = append(dst, chunk...)
+	}
+
+	return dst, nil
+}
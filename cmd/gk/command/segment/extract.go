@@ -0,0 +1,113 @@
+package segment
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Record is a message extracted from a segment, one json per line so that
+// `gk produce -f` can replay it.
+type Record struct {
+	Offset    int64  `json:"offset"`
+	Timestamp int64  `json:"timestamp,omitempty"` // ms
+	Key       []byte `json:"key,omitempty"`
+	Value     []byte `json:"value"`
+}
+
+// Range selects messages by offset and time, both inclusive.
+// Zero values mean unbounded. Messages of magic 0 have no timestamp and
+// never match a time range.
+type Range struct {
+	FromOffset, ToOffset int64 // ToOffset 0 means unbounded
+	Since, Until         time.Time
+}
+
+func (this Range) hasTime() bool {
+	return !this.Since.IsZero() || !this.Until.IsZero()
+}
+
+func (this Range) match(msg *Message) bool {
+	if msg.Offset < this.FromOffset || (this.ToOffset > 0 && msg.Offset > this.ToOffset) {
+		return false
+	}
+
+	if !this.hasTime() {
+		return true
+	}
+	if msg.Timestamp < 0 {
+		return false
+	}
+
+	t := time.Unix(0, msg.Timestamp*int64(time.Millisecond))
+	if !this.Since.IsZero() && t.Before(this.Since) {
+		return false
+	}
+	if !this.Until.IsZero() && t.After(this.Until) {
+		return false
+	}
+	return true
+}
+
+// ExtractStats is the result of Extract.
+type ExtractStats struct {
+	Extracted int64
+	Skipped   int64 // corrupt messages that can't be extracted
+}
+
+// Extract writes the intact messages within the range as json lines,
+// compressed messages are decompressed.
+func Extract(log io.Reader, rg Range, w io.Writer) (stats ExtractStats, err error) {
+	enc := json.NewEncoder(w)
+	r := NewReader(log, 0)
+	for {
+		msg, err := r.Next()
+		switch err {
+		case nil:
+
+		case io.EOF, ErrTruncated, ErrBadSize:
+			return stats, nil
+
+		default:
+			stats.Skipped++
+			continue
+		}
+
+		if msg.Offset < rg.FromOffset {
+			// a compressed message carries the offset of its last inner message
+			continue
+		}
+
+		msgs, err := msg.Messages()
+		if err != nil {
+			stats.Skipped++
+			continue
+		}
+
+		for _, m := range msgs {
+			if msg.Magic > 0 && (m.Timestamp < 0 || msg.Attributes&logAppendTimeMask != 0) {
+				// inner messages share the timestamp of the wrapper
+				m.Timestamp = msg.Timestamp
+			}
+			if !rg.match(m) {
+				continue
+			}
+
+			if err = enc.Encode(Record{Offset: m.Offset, Timestamp: timestampOf(m), Key: m.Key, Value: m.Value}); err != nil {
+				return stats, err
+			}
+			stats.Extracted++
+		}
+
+		if rg.ToOffset > 0 && msg.Offset >= rg.ToOffset {
+			return stats, nil
+		}
+	}
+}
+
+func timestampOf(msg *Message) int64 {
+	if msg.Timestamp < 0 {
+		return 0
+	}
+	return msg.Timestamp
+}
@@ -0,0 +1,155 @@
+package segment
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	indexEntrySize = 8 // relative offset + position
+
+	// DefaultIndexInterval is the default index.interval.bytes of kafka.
+	DefaultIndexInterval = 4096
+)
+
+// IndexEntry maps an offset to the byte position of its message in the log.
+type IndexEntry struct {
+	Offset   int64 // absolute
+	Position int64
+}
+
+// BaseOffset parses the base offset from the segment file name, e,g.
+// 00000000000000368769.log
+func BaseOffset(filename string) (int64, error) {
+	base := filepath.Base(filename)
+	if idx := strings.Index(base, "."); idx != -1 {
+		base = base[:idx]
+	}
+	return strconv.ParseInt(base, 10, 64)
+}
+
+// IndexFileOf returns the index file name of a log segment.
+func IndexFileOf(logFile string) string {
+	return strings.TrimSuffix(logFile, ".log") + ".index"
+}
+
+// ReadIndex reads the entries of an index file.
+// Index file of the active segment is preallocated with zeros, the reading
+// stops at the first unused entry.
+func ReadIndex(r io.Reader, baseOffset int64) ([]IndexEntry, error) {
+	var (
+		entries []IndexEntry
+		buf     [indexEntrySize]byte
+		br      = bufio.NewReader(r)
+	)
+	for {
+		n, err := io.ReadFull(br, buf[:])
+		if n == 0 && err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, ErrTruncated
+		}
+
+		rel := binary.BigEndian.Uint32(buf[:4])
+		pos := binary.BigEndian.Uint32(buf[4:])
+		if rel == 0 && pos == 0 && len(entries) > 0 {
+			return entries, nil
+		}
+
+		entries = append(entries, IndexEntry{Offset: baseOffset + int64(rel), Position: int64(pos)})
+	}
+}
+
+func WriteIndex(w io.Writer, baseOffset int64, entries []IndexEntry) error {
+	var buf [indexEntrySize]byte
+	for _, e := range entries {
+		if e.Offset < baseOffset || e.Offset-baseOffset > 1<<31-1 {
+			return fmt.Errorf("offset %d out of index range", e.Offset)
+		}
+
+		binary.BigEndian.PutUint32(buf[:4], uint32(e.Offset-baseOffset))
+		binary.BigEndian.PutUint32(buf[4:], uint32(e.Position))
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildIndex builds index entries from the intact part of a log the way
+// kafka recovers a segment: an entry whenever more than interval bytes are
+// appended since the last entry.
+func BuildIndex(r io.Reader, interval int64) ([]IndexEntry, error) {
+	var (
+		entries   []IndexEntry
+		lastEntry int64
+		lr        = NewReader(r, 0)
+	)
+	for {
+		pos := lr.Position()
+		msg, err := lr.Next()
+		switch err {
+		case nil, ErrBadCrc, ErrBadMessage, ErrBadMagic:
+		case io.EOF, ErrTruncated, ErrBadSize:
+			return entries, nil
+		default:
+			return entries, err
+		}
+
+		if pos-lastEntry > interval {
+			entries = append(entries, IndexEntry{Offset: msg.FirstOffset(), Position: pos})
+			lastEntry = pos
+		}
+	}
+}
+
+// RebuildIndex rewrites the index file of a log segment from the log.
+// The original index file if any is kept with .bak suffix.
+func RebuildIndex(logFile string, interval int64) (entries []IndexEntry, err error) {
+	baseOffset, err := BaseOffset(logFile)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(logFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if entries, err = BuildIndex(f, interval); err != nil {
+		return
+	}
+
+	indexFile := IndexFileOf(logFile)
+	tmp, err := os.Create(indexFile + ".tmp")
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(tmp)
+	if err = WriteIndex(w, baseOffset, entries); err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	if _, err = os.Stat(indexFile); err == nil {
+		if err = os.Rename(indexFile, indexFile+".bak"); err != nil {
+			return
+		}
+	}
+	err = os.Rename(tmp.Name(), indexFile)
+	return
+}
@@ -0,0 +1,220 @@
+// Package segment reads, verifies and repairs kafka log segment files.
+//
+// A log segment is a message set:
+// offset(8) size(4) crc(4) magic(1) attrs(1) [timestamp(8)] keyLen(4) key valLen(4) val
+// where crc covers everything after itself and timestamp exists only in magic 1.
+// A compressed message wraps an inner message set in its value, and carries
+// the offset of the last inner message.
+package segment
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	CodecNone   int8 = 0
+	CodecGzip   int8 = 1
+	CodecSnappy int8 = 2
+	CodecLZ4    int8 = 3
+
+	codecMask         = 0x07
+	logAppendTimeMask = 0x08
+
+	logOverhead = 12 // offset + size
+
+	minMessageSize = 4 + 1 + 1 + 4 + 4 // magic 0 with null key and value
+	maxMessageSize = 100 << 20
+)
+
+var (
+	ErrTruncated        = errors.New("truncated message")
+	ErrBadSize          = errors.New("invalid message size")
+	ErrBadCrc           = errors.New("crc mismatch")
+	ErrBadMagic         = errors.New("unknown magic")
+	ErrBadMessage       = errors.New("malformed message")
+	ErrUnsupportedCodec = errors.New("unsupported compression codec")
+)
+
+// Message is a shallow message in a log segment.
+type Message struct {
+	Offset   int64
+	Position int64 // byte position of the entry in the log file
+	Size     int32 // bytes following the offset and size header
+
+	Crc        uint32
+	Magic      int8
+	Attributes int8
+	Timestamp  int64 // ms, -1 if magic 0
+	Key, Value []byte
+}
+
+// EntrySize is the bytes of the message in the log file.
+func (this *Message) EntrySize() int64 {
+	return logOverhead + int64(this.Size)
+}
+
+func (this *Message) Codec() int8 {
+	return this.Attributes & codecMask
+}
+
+// Messages returns the inner messages with absolute offsets of a compressed
+// message, or the message itself if not compressed.
+func (this *Message) Messages() ([]*Message, error) {
+	if this.Codec() == CodecNone {
+		return []*Message{this}, nil
+	}
+
+	set, err := decompress(this.Codec(), this.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []*Message
+	r := NewReader(bytes.NewReader(set), 0)
+	for {
+		msg, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		return nil, ErrBadMessage
+	}
+
+	if this.Magic > 0 {
+		// inner offsets are relative since magic 1
+		last := msgs[len(msgs)-1].Offset
+		for _, msg := range msgs {
+			msg.Offset = this.Offset - last + msg.Offset
+		}
+	}
+	return msgs, nil
+}
+
+// FirstOffset is the offset of the first inner message, which is what kafka
+// puts in the index for compressed messages.
+func (this *Message) FirstOffset() int64 {
+	if this.Codec() == CodecNone {
+		return this.Offset
+	}
+
+	msgs, err := this.Messages()
+	if err != nil {
+		return this.Offset
+	}
+	return msgs[0].Offset
+}
+
+// Reader iterates the shallow messages of a log segment.
+type Reader struct {
+	r   *bufio.Reader
+	pos int64
+}
+
+// NewReader creates a reader whose underlying stream starts at position pos
+// of the log file.
+func NewReader(r io.Reader, pos int64) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 64<<10), pos: pos}
+}
+
+// Position is the end of the last message that is completely read.
+func (this *Reader) Position() int64 {
+	return this.pos
+}
+
+// Next returns the next message and io.EOF at the clean end.
+//
+// ErrTruncated and ErrBadSize are fatal because the framing is lost.
+// ErrBadCrc and ErrBadMessage come with the message and the reader can go on.
+func (this *Reader) Next() (*Message, error) {
+	var hdr [logOverhead]byte
+	n, err := io.ReadFull(this.r, hdr[:])
+	if n == 0 && err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, ErrTruncated
+	}
+
+	msg := &Message{
+		Offset:    int64(binary.BigEndian.Uint64(hdr[:8])),
+		Position:  this.pos,
+		Size:      int32(binary.BigEndian.Uint32(hdr[8:])),
+		Timestamp: -1,
+	}
+	if msg.Size < minMessageSize || msg.Size > maxMessageSize {
+		return msg, ErrBadSize
+	}
+
+	body := make([]byte, msg.Size)
+	if _, err = io.ReadFull(this.r, body); err != nil {
+		return msg, ErrTruncated
+	}
+	this.pos += msg.EntrySize()
+
+	msg.Crc = binary.BigEndian.Uint32(body)
+	if err = msg.decode(body[4:]); err != nil {
+		return msg, err
+	}
+	if crc32.ChecksumIEEE(body[4:]) != msg.Crc {
+		return msg, ErrBadCrc
+	}
+	return msg, nil
+}
+
+func (this *Message) decode(b []byte) error {
+	this.Magic = int8(b[0])
+	this.Attributes = int8(b[1])
+	b = b[2:]
+
+	switch this.Magic {
+	case 0:
+	case 1:
+		if len(b) < 8 {
+			return ErrBadMessage
+		}
+		this.Timestamp = int64(binary.BigEndian.Uint64(b))
+		b = b[8:]
+
+	default:
+		return ErrBadMagic
+	}
+
+	var ok bool
+	if this.Key, b, ok = readBytes(b); !ok {
+		return ErrBadMessage
+	}
+	if this.Value, b, ok = readBytes(b); !ok {
+		return ErrBadMessage
+	}
+	if len(b) != 0 {
+		return ErrBadMessage
+	}
+	return nil
+}
+
+// readBytes reads a length prefixed bytes where -1 length means null.
+func readBytes(b []byte) (v []byte, rest []byte, ok bool) {
+	if len(b) < 4 {
+		return nil, b, false
+	}
+
+	n := int32(binary.BigEndian.Uint32(b))
+	b = b[4:]
+	if n < 0 {
+		return nil, b, true
+	}
+	if int(n) > len(b) {
+		return nil, b, false
+	}
+	return b[:n], b[n:], true
+}
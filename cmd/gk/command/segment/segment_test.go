@@ -0,0 +1,213 @@
+package segment
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+type fixtureMessage struct {
+	offset     int64
+	magic      int8
+	attrs      int8
+	timestamp  int64
+	key, value []byte
+}
+
+func (this fixtureMessage) bytes() []byte {
+	var body bytes.Buffer
+	body.WriteByte(byte(this.magic))
+	body.WriteByte(byte(this.attrs))
+	if this.magic == 1 {
+		binary.Write(&body, binary.BigEndian, this.timestamp)
+	}
+	for _, b := range [][]byte{this.key, this.value} {
+		if b == nil {
+			binary.Write(&body, binary.BigEndian, int32(-1))
+		} else {
+			binary.Write(&body, binary.BigEndian, int32(len(b)))
+			body.Write(b)
+		}
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, this.offset)
+	binary.Write(&buf, binary.BigEndian, int32(body.Len()+4))
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(body.Bytes()))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+func fixtureLog(msgs ...fixtureMessage) []byte {
+	var buf bytes.Buffer
+	for _, m := range msgs {
+		buf.Write(m.bytes())
+	}
+	return buf.Bytes()
+}
+
+// gzipped wraps messages with relative inner offsets as magic 1 does.
+func gzipped(offset, timestamp int64, inner ...fixtureMessage) fixtureMessage {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(fixtureLog(inner...))
+	w.Close()
+	return fixtureMessage{offset: offset, magic: 1, attrs: CodecGzip, timestamp: timestamp, value: buf.Bytes()}
+}
+
+func sampleLog() []byte {
+	return fixtureLog(
+		fixtureMessage{offset: 100, value: []byte("hello")},
+		fixtureMessage{offset: 101, key: []byte("k"), value: []byte("world")},
+		fixtureMessage{offset: 102, magic: 1, timestamp: 1000, value: []byte("ts")},
+		gzipped(105, 2000,
+			fixtureMessage{offset: 0, magic: 1, timestamp: 2000, value: []byte("a")},
+			fixtureMessage{offset: 1, magic: 1, timestamp: 2000, value: []byte("b")},
+			fixtureMessage{offset: 2, magic: 1, timestamp: 2000, value: []byte("c")}),
+	)
+}
+
+func TestBaseOffset(t *testing.T) {
+	base, err := BaseOffset("/data/kafka/t-0/00000000000000368769.log")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(368769), base)
+	assert.Equal(t, "/data/00000000000000000100.index", IndexFileOf("/data/00000000000000000100.log"))
+}
+
+func TestReaderAndMessages(t *testing.T) {
+	r := NewReader(bytes.NewReader(sampleLog()), 0)
+	var offsets []int64
+	for {
+		msg, err := r.Next()
+		if err != nil {
+			break
+		}
+		msgs, err := msg.Messages()
+		assert.Equal(t, nil, err)
+		for _, m := range msgs {
+			offsets = append(offsets, m.Offset)
+		}
+	}
+	assert.Equal(t, []int64{100, 101, 102, 103, 104, 105}, offsets)
+	assert.Equal(t, int64(len(sampleLog())), r.Position())
+}
+
+func TestVerifyClean(t *testing.T) {
+	log := sampleLog()
+	index, err := BuildIndex(bytes.NewReader(log), 10)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(index))
+	assert.Equal(t, int64(103), index[2].Offset) // first inner offset of the compressed
+
+	report := Verify(bytes.NewReader(log), int64(len(log)), 100, index)
+	assert.Equal(t, true, report.Ok())
+	assert.Equal(t, int64(4), report.Messages)
+	assert.Equal(t, int64(100), report.FirstOffset)
+	assert.Equal(t, int64(105), report.LastOffset)
+	assert.Equal(t, int64(len(log)), report.ValidSize)
+}
+
+func TestVerifyCorruption(t *testing.T) {
+	log := sampleLog()
+
+	// bad crc
+	bad := append([]byte{}, log...)
+	bad[30] ^= 0xff
+	report := Verify(bytes.NewReader(bad), int64(len(bad)), 100, nil)
+	assert.Equal(t, 1, len(report.Corrupt))
+	assert.Equal(t, int64(100), report.Corrupt[0].Offset)
+	assert.Equal(t, int64(4), report.Messages)
+
+	// truncated tail
+	bad = log[:len(log)-5]
+	report = Verify(bytes.NewReader(bad), int64(len(bad)), 100, nil)
+	assert.NotEqual(t, (*Problem)(nil), report.Tail)
+	assert.Equal(t, ErrTruncated, report.Tail.Err)
+	assert.Equal(t, int64(3), report.Messages)
+	assert.Equal(t, report.Tail.Position, report.ValidSize)
+
+	// offsets not monotonic
+	bad = fixtureLog(fixtureMessage{offset: 5, value: []byte("x")}, fixtureMessage{offset: 5, value: []byte("y")})
+	report = Verify(bytes.NewReader(bad), int64(len(bad)), 0, nil)
+	assert.Equal(t, 1, len(report.Unordered))
+
+	// index points to a wrong place
+	index := []IndexEntry{{Offset: 101, Position: 3}, {Offset: 200, Position: int64(len(log)) + 100}}
+	report = Verify(bytes.NewReader(log), int64(len(log)), 100, index)
+	assert.Equal(t, 2, len(report.IndexProblems))
+	assert.Equal(t, false, report.Ok())
+}
+
+func TestRebuildIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "segment")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "00000000000000000100.log")
+	assert.Equal(t, nil, ioutil.WriteFile(logFile, sampleLog(), 0644))
+	assert.Equal(t, nil, ioutil.WriteFile(IndexFileOf(logFile), []byte("garbage"), 0644))
+
+	report, err := VerifyFile(logFile)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, report.Ok())
+
+	entries, err := RebuildIndex(logFile, 10)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(entries))
+
+	report, err = VerifyFile(logFile)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, report.Ok())
+
+	_, err = os.Stat(IndexFileOf(logFile) + ".bak")
+	assert.Equal(t, nil, err)
+
+	f, _ := os.Open(IndexFileOf(logFile))
+	defer f.Close()
+	read, err := ReadIndex(f, 100)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, entries, read)
+}
+
+func TestReadIndexPreallocated(t *testing.T) {
+	var buf bytes.Buffer
+	WriteIndex(&buf, 100, []IndexEntry{{100, 0}, {105, 4096}})
+	buf.Write(make([]byte, indexEntrySize*10))
+	entries, err := ReadIndex(&buf, 100)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(entries))
+}
+
+func TestExtract(t *testing.T) {
+	var out bytes.Buffer
+	stats, err := Extract(bytes.NewReader(sampleLog()), Range{FromOffset: 101, ToOffset: 104}, &out)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(4), stats.Extracted)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 4, len(lines))
+	var rec Record
+	assert.Equal(t, nil, json.Unmarshal([]byte(lines[0]), &rec))
+	assert.Equal(t, int64(101), rec.Offset)
+	assert.Equal(t, "k", string(rec.Key))
+	assert.Equal(t, "world", string(rec.Value))
+	assert.Equal(t, nil, json.Unmarshal([]byte(lines[3]), &rec))
+	assert.Equal(t, int64(104), rec.Offset)
+	assert.Equal(t, "b", string(rec.Value))
+
+	// time range excludes magic 0 messages
+	out.Reset()
+	stats, err = Extract(bytes.NewReader(sampleLog()), Range{Since: time.Unix(1, 0)}, &out)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(4), stats.Extracted)
+}
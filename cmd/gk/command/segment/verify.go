@@ -0,0 +1,165 @@
+package segment
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Problem is an integrity problem found at a position of a segment file.
+type Problem struct {
+	Position int64
+	Offset   int64
+	Err      error
+}
+
+func (this Problem) String() string {
+	return fmt.Sprintf("position:%d offset:%d %v", this.Position, this.Offset, this.Err)
+}
+
+// Report is the result of verifying a log segment and its index.
+type Report struct {
+	FileSize    int64
+	ValidSize   int64 // bytes before the truncated tail or lost framing
+	Messages    int64
+	FirstOffset int64
+	LastOffset  int64
+
+	// Tail is the problem that stops the scanning: ErrTruncated or ErrBadSize.
+	Tail *Problem
+
+	Corrupt       []Problem // bad crc or malformed messages
+	Unordered     []Problem // offsets not monotonic
+	IndexProblems []Problem
+}
+
+func (this *Report) Ok() bool {
+	return this.Tail == nil && len(this.Corrupt) == 0 && len(this.Unordered) == 0 &&
+		len(this.IndexProblems) == 0
+}
+
+// Verify checks a log segment message by message: crc, framing and offset
+// monotonicity. index can be nil if the segment has no index file.
+func Verify(log io.Reader, size int64, baseOffset int64, index []IndexEntry) *Report {
+	report := &Report{FileSize: size, FirstOffset: -1, LastOffset: -1}
+	iv := &indexVerifier{report: report, entries: index, prevOffset: baseOffset - 1}
+
+	r := NewReader(log, 0)
+	for {
+		msg, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+
+		switch err {
+		case nil:
+
+		case ErrTruncated, ErrBadSize:
+			p := Problem{Position: r.Position(), Offset: -1, Err: err}
+			if msg != nil {
+				p.Offset = msg.Offset
+			}
+			report.Tail = &p
+
+		default:
+			report.Corrupt = append(report.Corrupt, Problem{Position: msg.Position, Offset: msg.Offset, Err: err})
+		}
+		if report.Tail != nil {
+			break
+		}
+
+		report.Messages++
+		if report.FirstOffset == -1 {
+			report.FirstOffset = msg.Offset
+			if msg.Offset < baseOffset {
+				report.Unordered = append(report.Unordered, Problem{Position: msg.Position, Offset: msg.Offset,
+					Err: fmt.Errorf("offset below base offset %d", baseOffset)})
+			}
+		} else if msg.Offset <= report.LastOffset {
+			report.Unordered = append(report.Unordered, Problem{Position: msg.Position, Offset: msg.Offset,
+				Err: fmt.Errorf("offset not after %d", report.LastOffset)})
+		}
+
+		iv.message(msg, report.LastOffset)
+		report.LastOffset = msg.Offset
+	}
+
+	report.ValidSize = r.Position()
+	iv.finish()
+	return report
+}
+
+// VerifyFile verifies a log segment file and its index file if exists.
+func VerifyFile(logFile string) (*Report, error) {
+	baseOffset, err := BaseOffset(logFile)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(logFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var index []IndexEntry
+	var indexErr error
+	if fi, err := os.Open(IndexFileOf(logFile)); err == nil {
+		index, indexErr = ReadIndex(fi, baseOffset)
+		fi.Close()
+	}
+
+	report := Verify(f, stat.Size(), baseOffset, index)
+	if indexErr != nil {
+		report.IndexProblems = append(report.IndexProblems, Problem{Position: -1, Offset: -1, Err: indexErr})
+	}
+	return report, nil
+}
+
+// indexVerifier checks that each index entry points to the start of a message
+// whose offsets cover the entry offset.
+type indexVerifier struct {
+	report     *Report
+	entries    []IndexEntry
+	i          int
+	prevOffset int64
+}
+
+func (this *indexVerifier) problem(e IndexEntry, format string, args ...interface{}) {
+	this.report.IndexProblems = append(this.report.IndexProblems, Problem{Position: e.Position,
+		Offset: e.Offset, Err: fmt.Errorf(format, args...)})
+}
+
+func (this *indexVerifier) message(msg *Message, prevOffset int64) {
+	if prevOffset < 0 {
+		prevOffset = this.prevOffset
+	}
+
+	for ; this.i < len(this.entries); this.i++ {
+		e := this.entries[this.i]
+		if e.Position > msg.Position {
+			return
+		}
+
+		if e.Position < msg.Position {
+			this.problem(e, "position not at a message boundary")
+			continue
+		}
+
+		// a compressed message is indexed by its first inner offset
+		if e.Offset > msg.Offset || e.Offset <= prevOffset {
+			this.problem(e, "offset mismatch with message offset %d", msg.Offset)
+		}
+	}
+}
+
+func (this *indexVerifier) finish() {
+	for ; this.i < len(this.entries); this.i++ {
+		this.problem(this.entries[this.i], "beyond valid log size %d", this.report.ValidSize)
+	}
+}
@@ -3,42 +3,85 @@ package command
 import (
 	"flag"
 	"fmt"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/funkygao/gafka/cmd/gk/command/sniff"
 	"github.com/funkygao/gocli"
+	"github.com/funkygao/golib/color"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
+	"github.com/ryanuber/columnize"
 )
 
 type Sniff struct {
 	Ui  cli.Ui
 	Cmd string
+
+	raw   bool
+	top   int
+	stats *sniff.Stats
 }
 
 func (this *Sniff) Run(args []string) (exitCode int) {
 	var (
-		device string
-		filter string
-		sleep  time.Duration
+		device     string
+		filter     string
+		pcapFile   string
+		kafkaPorts string
+		zkPorts    string
+		snaplen    int
+		interval   time.Duration
+		sleep      time.Duration
 	)
 	cmdFlags := flag.NewFlagSet("sniff", flag.ContinueOnError)
 	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
 	cmdFlags.StringVar(&device, "i", "", "")
 	cmdFlags.StringVar(&filter, "f", "", "")
+	cmdFlags.StringVar(&pcapFile, "r", "", "")
+	cmdFlags.StringVar(&kafkaPorts, "kafka", "9092", "")
+	cmdFlags.StringVar(&zkPorts, "zk", "2181", "")
+	cmdFlags.IntVar(&snaplen, "snaplen", 65535, "")
+	cmdFlags.IntVar(&this.top, "top", 0, "")
+	cmdFlags.DurationVar(&interval, "interval", time.Second*5, "")
+	cmdFlags.BoolVar(&this.raw, "raw", false, "")
 	cmdFlags.DurationVar(&sleep, "s", 0, "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
-	if validateArgs(this, this.Ui).
+	if pcapFile == "" && validateArgs(this, this.Ui).
 		require("-i", "-f").
 		invalid(args) {
 		return 2
 	}
 
-	handle, err := pcap.OpenLive(device, 1<<10, false, time.Second*30)
+	kports, err := parsePorts(kafkaPorts)
+	swallow(err)
+	zports, err := parsePorts(zkPorts)
+	swallow(err)
+
+	if this.top > 0 {
+		this.stats = sniff.NewStats()
+	}
+	sniffer := sniff.New(kports, zports, this.handleEvent)
+
+	if pcapFile != "" {
+		f, err := os.Open(pcapFile)
+		swallow(err)
+		defer f.Close()
+
+		swallow(sniffer.Replay(f))
+		if this.stats != nil {
+			this.showTop()
+		}
+		return
+	}
+
+	handle, err := pcap.OpenLive(device, int32(snaplen), false, time.Second*30)
 	swallow(err)
 	defer handle.Close()
 
@@ -46,15 +89,75 @@ func (this *Sniff) Run(args []string) (exitCode int) {
 
 	// Use the handle as a packet source to process all packets
 	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-	for packet := range packetSource.Packets() {
-		this.handlePacket(packet)
-
-		if sleep > 0 {
-			time.Sleep(sleep)
+	packets := packetSource.Packets()
+	flushTicker := time.NewTicker(time.Second * 10)
+	defer flushTicker.Stop()
+	topTicker := time.NewTicker(interval)
+	defer topTicker.Stop()
+	for {
+		select {
+		case packet, ok := <-packets:
+			if !ok {
+				sniffer.Close()
+				return
+			}
+
+			if this.raw {
+				this.handlePacket(packet)
+			} else {
+				sniffer.HandlePacket(packet)
+			}
+
+			if sleep > 0 {
+				time.Sleep(sleep)
+			}
+
+		case <-flushTicker.C:
+			// give up on lost packets and dangling requests
+			sniffer.Flush(time.Now().Add(-time.Minute))
+
+		case <-topTicker.C:
+			if this.stats != nil {
+				this.Ui.Output(fmt.Sprintf("\n%s", time.Now().Format("15:04:05")))
+				this.showTop()
+				this.stats.Reset()
+			}
 		}
 	}
+}
+
+func (this *Sniff) handleEvent(evt *sniff.Event) {
+	if this.stats != nil {
+		this.stats.Add(evt)
+		return
+	}
+
+	if evt.Err != "" {
+		this.Ui.Output(color.Red("%s", evt))
+	} else if evt.Request {
+		this.Ui.Output(evt.String())
+	} else {
+		this.Ui.Output(color.Green("%s", evt))
+	}
+}
+
+func (this *Sniff) showTop() {
+	clients, apis := this.stats.Top(this.top)
+
+	lines := []string{"Client|Requests|Responses|Bytes|AvgLatency|MaxLatency"}
+	for _, c := range clients {
+		lines = append(lines, fmt.Sprintf("%s|%d|%d|%d|%s|%s", c.Key, c.Requests,
+			c.Responses, c.Bytes, c.AvgLatency(), c.MaxLatency))
+	}
+	this.Ui.Output(columnize.SimpleFormat(lines))
+	this.Ui.Output("")
 
-	return
+	lines = []string{"Api|Requests|Responses|Bytes|AvgLatency|MaxLatency"}
+	for _, c := range apis {
+		lines = append(lines, fmt.Sprintf("%s|%d|%d|%d|%s|%s", c.Key, c.Requests,
+			c.Responses, c.Bytes, c.AvgLatency(), c.MaxLatency))
+	}
+	this.Ui.Output(columnize.SimpleFormat(lines))
 }
 
 func (this *Sniff) handlePacket(packet gopacket.Packet) {
@@ -79,8 +182,26 @@ func (this *Sniff) handlePacket(packet gopacket.Packet) {
 	this.Ui.Output(fmt.Sprintf("%s", string(applicationLayer.Payload())))
 }
 
+func parsePorts(s string) ([]int, error) {
+	var ports []int
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, port)
+	}
+
+	return ports, nil
+}
+
 func (this *Sniff) Synopsis() string {
-	return fmt.Sprintf("Sniff traffic on a network with libpcap")
+	return fmt.Sprintf("Sniff and decode kafka/zookeeper traffic with libpcap")
 }
 
 func (this *Sniff) Help() string {
@@ -89,16 +210,41 @@ Usage: %s sniff [options]
 
     %s
 
+    TCP streams are reassembled and each kafka/zookeeper frame is decoded
+    into api, version, correlation id, client id, topics/partitions or znode
+    paths, with the request/response latency.
+
 Options:
 
     -i interface
 
     -f filter
-      e,g. tcp and port 80
+      e,g. tcp and port 9092
+
+    -r pcap file
+      Decode a pcap file offline instead of live capture.
+
+    -kafka ports
+      Comma seperated kafka ports. Defaults 9092.
+
+    -zk ports
+      Comma seperated zookeeper ports. Defaults 2181.
+
+    -snaplen bytes
+      Defaults 65535.
+
+    -top n
+      Summarise the top n talkers by client and by api/topic instead of
+      printing each frame.
+
+    -interval duration
+      Refresh interval of -top in live mode. Defaults 5s.
+
+    -raw
+      Dump raw tcp payload without decoding.
 
     -s sleep duration
       e,g 5ms 1s
-   
 
 `, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
@@ -0,0 +1,95 @@
+package sniff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	ProtoKafka     = "kafka"
+	ProtoZookeeper = "zk"
+)
+
+// TopicPartitions is the partitions of a topic carried in a kafka request.
+type TopicPartitions struct {
+	Topic      string
+	Partitions []int32
+}
+
+// Event is a decoded request or response on the wire.
+type Event struct {
+	Time    time.Time
+	Proto   string
+	Client  string // ip:port
+	Server  string // ip:port
+	Request bool
+
+	Api     string // kafka api name or zookeeper op name
+	Version int16  // kafka api version
+	Id      int32  // kafka correlation id or zookeeper xid
+
+	ClientId string            // kafka client id
+	Group    string            // kafka consumer group
+	Topics   []TopicPartitions // kafka
+	Paths    []string          // zookeeper
+	Session  int64             // zookeeper session id
+
+	Bytes   int           // frame size including length prefix
+	Latency time.Duration // response only, -1 if request not seen
+	Err     string
+}
+
+func (this *Event) String() string {
+	var b bytes.Buffer
+	dir := "->"
+	if !this.Request {
+		dir = "<-"
+	}
+	fmt.Fprintf(&b, "%s %s %s %s %s %s", this.Time.Format("15:04:05.000"), this.Proto,
+		this.Client, dir, this.Server, this.Api)
+	if this.Proto == ProtoKafka {
+		fmt.Fprintf(&b, " v%d corr:%d", this.Version, this.Id)
+	} else {
+		fmt.Fprintf(&b, " xid:%d", this.Id)
+	}
+
+	if this.ClientId != "" {
+		fmt.Fprintf(&b, " client:%s", this.ClientId)
+	}
+	if this.Group != "" {
+		fmt.Fprintf(&b, " group:%s", this.Group)
+	}
+	if this.Session != 0 {
+		fmt.Fprintf(&b, " session:0x%x", this.Session)
+	}
+	for _, tp := range this.Topics {
+		fmt.Fprintf(&b, " %s%v", tp.Topic, tp.Partitions)
+	}
+	if len(this.Paths) > 0 {
+		fmt.Fprintf(&b, " %s", strings.Join(this.Paths, ","))
+	}
+	fmt.Fprintf(&b, " %dB", this.Bytes)
+	if !this.Request && this.Latency >= 0 {
+		fmt.Fprintf(&b, " %s", this.Latency)
+	}
+	if this.Err != "" {
+		fmt.Fprintf(&b, " err:%s", this.Err)
+	}
+
+	return b.String()
+}
+
+// TopicNames returns the topics of the event, or zookeeper paths.
+func (this *Event) TopicNames() []string {
+	if this.Proto == ProtoZookeeper {
+		return this.Paths
+	}
+
+	r := make([]string, 0, len(this.Topics))
+	for _, tp := range this.Topics {
+		r = append(r, tp.Topic)
+	}
+	return r
+}
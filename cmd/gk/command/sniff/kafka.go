@@ -0,0 +1,323 @@
+package sniff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// kafka api keys, see https://kafka.apache.org/protocol#protocol_api_keys
+const (
+	apiProduce      = 0
+	apiFetch        = 1
+	apiMetadata     = 3
+	apiOffsetCommit = 8
+	apiOffsetFetch  = 9
+)
+
+// maxFetchVersion is the latest Fetch version decoded, v12+ switches to the
+// flexible encoding: compact strings/arrays and tagged fields.
+const maxFetchVersion = 11
+
+var kafkaApis = map[int16]string{
+	0:  "Produce",
+	1:  "Fetch",
+	2:  "Offsets",
+	3:  "Metadata",
+	4:  "LeaderAndIsr",
+	5:  "StopReplica",
+	6:  "UpdateMetadata",
+	7:  "ControlledShutdown",
+	8:  "OffsetCommit",
+	9:  "OffsetFetch",
+	10: "GroupCoordinator",
+	11: "JoinGroup",
+	12: "Heartbeat",
+	13: "LeaveGroup",
+	14: "SyncGroup",
+	15: "DescribeGroups",
+	16: "ListGroups",
+	17: "SaslHandshake",
+	18: "ApiVersions",
+}
+
+var kafkaErrors = map[int16]string{
+	-1: "Unknown",
+	1:  "OffsetOutOfRange",
+	2:  "CorruptMessage",
+	3:  "UnknownTopicOrPartition",
+	5:  "LeaderNotAvailable",
+	6:  "NotLeaderForPartition",
+	7:  "RequestTimedOut",
+	9:  "ReplicaNotAvailable",
+	10: "MessageTooLarge",
+	17: "InvalidTopic",
+	18: "RecordListTooLarge",
+	19: "NotEnoughReplicas",
+	20: "NotEnoughReplicasAfterAppend",
+	21: "InvalidRequiredAcks",
+	29: "TopicAuthorizationFailed",
+}
+
+func kafkaApiName(key int16) string {
+	if name, present := kafkaApis[key]; present {
+		return name
+	}
+
+	return fmt.Sprintf("Api(%d)", key)
+}
+
+func kafkaErrName(code int16) string {
+	if name, present := kafkaErrors[code]; present {
+		return name
+	}
+
+	return fmt.Sprintf("err(%d)", code)
+}
+
+// decodeKafkaRequest decodes a kafka request frame without the length prefix.
+//
+// RequestHeader => api_key api_version correlation_id client_id
+func decodeKafkaRequest(frame []byte, evt *Event) error {
+	r := newReader(frame)
+	apiKey := r.int16()
+	evt.Version = r.int16()
+	evt.Id = r.int32()
+	evt.ClientId = r.string()
+	if r.err != nil {
+		return r.err
+	}
+
+	evt.Api = kafkaApiName(apiKey)
+	switch apiKey {
+	case apiProduce:
+		decodeProduce(r, evt)
+	case apiFetch:
+		decodeFetch(r, evt)
+	case apiMetadata:
+		decodeMetadata(r, evt)
+	case apiOffsetCommit:
+		decodeOffsetCommit(r, evt)
+	case apiOffsetFetch:
+		decodeOffsetFetch(r, evt)
+	}
+
+	return r.err
+}
+
+// decodeKafkaResponse decodes the correlation id of a response frame,
+// the body can only be understood with the request: see
+// decodeKafkaResponseBody.
+//
+// ResponseHeader => correlation_id
+func decodeKafkaResponse(frame []byte, evt *Event) error {
+	r := newReader(frame)
+	evt.Id = r.int32()
+	return r.err
+}
+
+// decodeKafkaResponseBody decodes the partition error codes of the response
+// whose api and version are learned from the request.
+func decodeKafkaResponseBody(frame []byte, evt *Event) error {
+	r := newReader(frame)
+	r.int32() // correlation_id
+
+	var errs []string
+	partitionErr := func(topic string, partition int32, code int16) {
+		switch {
+		case code == 0:
+		case topic == "":
+			// response level error
+			errs = append(errs, kafkaErrName(code))
+		default:
+			errs = append(errs, fmt.Sprintf("%s/%d:%s", topic, partition, kafkaErrName(code)))
+		}
+	}
+
+	switch evt.Api {
+	case kafkaApiName(apiProduce):
+		decodeProduceResponse(r, evt.Version, partitionErr)
+	case kafkaApiName(apiFetch):
+		decodeFetchResponse(r, evt.Version, partitionErr)
+	}
+
+	if len(errs) > 0 {
+		evt.Err = strings.Join(errs, ",")
+	}
+	return r.err
+}
+
+// topicPartitions decodes [topic [partition ...]] where each partition is
+// followed by fields that partitionTail skips.
+func topicPartitions(r *reader, evt *Event, partitionTail func(*reader)) {
+	n := r.arrayLen()
+	for i := 0; i < n && r.err == nil; i++ {
+		tp := TopicPartitions{Topic: r.string()}
+		m := r.arrayLen()
+		for j := 0; j < m && r.err == nil; j++ {
+			tp.Partitions = append(tp.Partitions, r.int32())
+			if partitionTail != nil {
+				partitionTail(r)
+			}
+		}
+		evt.Topics = append(evt.Topics, tp)
+	}
+}
+
+// ProduceRequest => [transactional_id] acks timeout [topic [partition message_set]]
+func decodeProduce(r *reader, evt *Event) {
+	if evt.Version >= 3 {
+		r.string() // transactional_id
+	}
+	r.int16() // acks
+	r.int32() // timeout
+	topicPartitions(r, evt, func(r *reader) {
+		r.buffer() // message set
+	})
+}
+
+// FetchRequest => replica_id max_wait_time min_bytes [max_bytes] [isolation_level]
+// [session_id session_epoch]
+// [topic [partition [current_leader_epoch] fetch_offset [log_start_offset] max_bytes]]
+// [forgotten_topics_data [topic [partition]]] [rack_id]
+func decodeFetch(r *reader, evt *Event) {
+	if evt.Version > maxFetchVersion {
+		// unknown layout, only the header is reported
+		return
+	}
+
+	r.int32() // replica_id
+	r.int32() // max_wait_time
+	r.int32() // min_bytes
+	if evt.Version >= 3 {
+		r.int32() // max_bytes
+	}
+	if evt.Version >= 4 {
+		r.int8() // isolation_level
+	}
+	if evt.Version >= 7 {
+		r.int32() // session_id
+		r.int32() // session_epoch
+	}
+	topicPartitions(r, evt, func(r *reader) {
+		if evt.Version >= 9 {
+			r.int32() // current_leader_epoch
+		}
+		r.int64() // fetch_offset
+		if evt.Version >= 5 {
+			r.int64() // log_start_offset
+		}
+		r.int32() // max_bytes
+	})
+	if evt.Version >= 7 {
+		// the topics leaving the fetch session, not fetched
+		n := r.arrayLen()
+		for i := 0; i < n && r.err == nil; i++ {
+			r.string() // topic
+			for j, m := 0, r.arrayLen(); j < m && r.err == nil; j++ {
+				r.int32() // partition
+			}
+		}
+	}
+	if evt.Version >= 11 {
+		r.string() // rack_id
+	}
+}
+
+// MetadataRequest => [topic], null means all topics since v1
+func decodeMetadata(r *reader, evt *Event) {
+	n := r.arrayLen()
+	for i := 0; i < n && r.err == nil; i++ {
+		evt.Topics = append(evt.Topics, TopicPartitions{Topic: r.string()})
+	}
+}
+
+// OffsetCommitRequest => group_id [generation_id member_id] [retention_time]
+// [topic [partition offset [timestamp] metadata]]
+func decodeOffsetCommit(r *reader, evt *Event) {
+	evt.Group = r.string()
+	if evt.Version >= 1 {
+		r.int32()  // generation_id
+		r.string() // member_id
+	}
+	if evt.Version >= 2 {
+		r.int64() // retention_time
+	}
+	topicPartitions(r, evt, func(r *reader) {
+		r.int64() // offset
+		if evt.Version == 1 {
+			r.int64() // timestamp
+		}
+		r.string() // metadata
+	})
+}
+
+// OffsetFetchRequest => group_id [topic [partition]]
+func decodeOffsetFetch(r *reader, evt *Event) {
+	evt.Group = r.string()
+	topicPartitions(r, evt, nil)
+}
+
+// ProduceResponse => [topic [partition error_code base_offset [log_append_time]
+// [log_start_offset]]] [throttle_time_ms]
+func decodeProduceResponse(r *reader, version int16, partitionErr func(string, int32, int16)) {
+	n := r.arrayLen()
+	for i := 0; i < n && r.err == nil; i++ {
+		topic := r.string()
+		m := r.arrayLen()
+		for j := 0; j < m && r.err == nil; j++ {
+			partition, code := r.int32(), r.int16()
+			r.int64() // base_offset
+			if version >= 2 {
+				r.int64() // log_append_time
+			}
+			if version >= 5 {
+				r.int64() // log_start_offset
+			}
+			partitionErr(topic, partition, code)
+		}
+	}
+}
+
+// FetchResponse => [throttle_time_ms] [error_code session_id]
+// [topic [partition error_code high_watermark [last_stable_offset]
+// [log_start_offset] [aborted_transactions] [preferred_read_replica] record_set]]
+func decodeFetchResponse(r *reader, version int16, partitionErr func(string, int32, int16)) {
+	if version > maxFetchVersion {
+		return
+	}
+
+	if version >= 1 {
+		r.int32() // throttle_time_ms
+	}
+	if version >= 7 {
+		if code := r.int16(); code != 0 {
+			partitionErr("", -1, code)
+		}
+		r.int32() // session_id
+	}
+
+	n := r.arrayLen()
+	for i := 0; i < n && r.err == nil; i++ {
+		topic := r.string()
+		m := r.arrayLen()
+		for j := 0; j < m && r.err == nil; j++ {
+			partition, code := r.int32(), r.int16()
+			r.int64() // high_watermark
+			if version >= 4 {
+				r.int64() // last_stable_offset
+				if version >= 5 {
+					r.int64() // log_start_offset
+				}
+				// [producer_id first_offset], null if none
+				for k, aborted := 0, r.arrayLen(); k < aborted && r.err == nil; k++ {
+					r.skip(16)
+				}
+			}
+			if version >= 11 {
+				r.int32() // preferred_read_replica
+			}
+			r.buffer() // record_set
+			partitionErr(topic, partition, code)
+		}
+	}
+}
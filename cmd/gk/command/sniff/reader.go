@@ -0,0 +1,120 @@
+package sniff
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var ErrTruncated = errors.New("truncated frame")
+
+// reader decodes big endian primitives shared by the kafka and zookeeper
+// wire protocols. The first error is sticky, subsequent reads return zero values.
+type reader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func newReader(b []byte) *reader {
+	return &reader{b: b}
+}
+
+func (r *reader) need(n int) bool {
+	if r.err != nil {
+		return false
+	}
+	if n < 0 || r.off+n > len(r.b) {
+		r.err = ErrTruncated
+		return false
+	}
+	return true
+}
+
+func (r *reader) int8() int8 {
+	if !r.need(1) {
+		return 0
+	}
+	v := int8(r.b[r.off])
+	r.off++
+	return v
+}
+
+func (r *reader) bool() bool {
+	return r.int8() != 0
+}
+
+func (r *reader) int16() int16 {
+	if !r.need(2) {
+		return 0
+	}
+	v := int16(binary.BigEndian.Uint16(r.b[r.off:]))
+	r.off += 2
+	return v
+}
+
+func (r *reader) int32() int32 {
+	if !r.need(4) {
+		return 0
+	}
+	v := int32(binary.BigEndian.Uint32(r.b[r.off:]))
+	r.off += 4
+	return v
+}
+
+func (r *reader) int64() int64 {
+	if !r.need(8) {
+		return 0
+	}
+	v := int64(binary.BigEndian.Uint64(r.b[r.off:]))
+	r.off += 8
+	return v
+}
+
+func (r *reader) skip(n int) {
+	if r.need(n) {
+		r.off += n
+	}
+}
+
+// string reads a kafka string with int16 length, -1 means null.
+func (r *reader) string() string {
+	n := int(r.int16())
+	if n < 0 || !r.need(n) {
+		return ""
+	}
+	s := string(r.b[r.off : r.off+n])
+	r.off += n
+	return s
+}
+
+// ustring reads a zookeeper ustring with int32 length, -1 means null.
+func (r *reader) ustring() string {
+	n := int(r.int32())
+	if n < 0 || !r.need(n) {
+		return ""
+	}
+	s := string(r.b[r.off : r.off+n])
+	r.off += n
+	return s
+}
+
+// buffer skips a zookeeper buffer or kafka bytes with int32 length
+// and returns the length.
+func (r *reader) buffer() int {
+	n := int(r.int32())
+	if n > 0 {
+		r.skip(n)
+	}
+	return n
+}
+
+// arrayLen reads an int32 array length, -1 means null.
+func (r *reader) arrayLen() int {
+	n := int(r.int32())
+	if r.err == nil && n > len(r.b)-r.off {
+		// each element takes at least 1 byte
+		r.err = ErrTruncated
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,24 @@
+package sniff
+
+import (
+	"io"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// Replay decodes all the packets of a pcap file offline.
+func (this *Sniffer) Replay(r io.Reader) error {
+	pr, err := pcapgo.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	packetSource := gopacket.NewPacketSource(pr, pr.LinkType())
+	for packet := range packetSource.Packets() {
+		this.HandlePacket(packet)
+	}
+
+	this.Close()
+	return nil
+}
@@ -0,0 +1,246 @@
+package sniff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+const (
+	maxFrameSize   = 100 << 20
+	pendingTimeout = time.Minute
+)
+
+// Sniffer reassembles tcp streams of kafka and zookeeper connections and
+// decodes each frame into an Event.
+type Sniffer struct {
+	kafkaPorts map[uint16]bool
+	zkPorts    map[uint16]bool
+	handler    func(*Event)
+
+	assembler *tcpassembly.Assembler
+	pending   map[pendingKey]*Event // requests awaiting response
+}
+
+type pendingKey struct {
+	conn string
+	id   int32
+}
+
+// New creates a Sniffer that decodes kafka protocol on kafkaPorts and
+// zookeeper protocol on zkPorts, and calls handler for each decoded Event.
+func New(kafkaPorts, zkPorts []int, handler func(*Event)) *Sniffer {
+	this := &Sniffer{
+		kafkaPorts: make(map[uint16]bool),
+		zkPorts:    make(map[uint16]bool),
+		handler:    handler,
+		pending:    make(map[pendingKey]*Event),
+	}
+	for _, p := range kafkaPorts {
+		this.kafkaPorts[uint16(p)] = true
+	}
+	for _, p := range zkPorts {
+		this.zkPorts[uint16(p)] = true
+	}
+
+	this.assembler = tcpassembly.NewAssembler(tcpassembly.NewStreamPool(this))
+	return this
+}
+
+// HandlePacket feeds a captured packet into the tcp reassembly.
+func (this *Sniffer) HandlePacket(packet gopacket.Packet) {
+	if packet.NetworkLayer() == nil || packet.TransportLayer() == nil {
+		return
+	}
+
+	tcp, ok := packet.TransportLayer().(*layers.TCP)
+	if !ok {
+		return
+	}
+
+	ts := packet.Metadata().Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	this.assembler.AssembleWithTimestamp(packet.NetworkLayer().NetworkFlow(), tcp, ts)
+}
+
+// Flush gives up waiting for lost packets older than t and expires
+// requests that never got a response.
+func (this *Sniffer) Flush(t time.Time) {
+	this.assembler.FlushOlderThan(t)
+
+	for k, evt := range this.pending {
+		if t.Sub(evt.Time) > pendingTimeout {
+			delete(this.pending, k)
+		}
+	}
+}
+
+// Close flushes all the buffered streams.
+func (this *Sniffer) Close() {
+	this.assembler.FlushAll()
+}
+
+// New implements tcpassembly.StreamFactory.
+func (this *Sniffer) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
+	src, dst := tcpFlow.Endpoints()
+	srcPort := binary.BigEndian.Uint16(src.Raw())
+	dstPort := binary.BigEndian.Uint16(dst.Raw())
+	srcIp, dstIp := netFlow.Endpoints()
+	srcAddr := fmt.Sprintf("%s:%d", srcIp, srcPort)
+	dstAddr := fmt.Sprintf("%s:%d", dstIp, dstPort)
+
+	s := &stream{sniffer: this}
+	switch {
+	case this.kafkaPorts[dstPort]:
+		s.proto, s.request, s.client, s.server = ProtoKafka, true, srcAddr, dstAddr
+	case this.kafkaPorts[srcPort]:
+		s.proto, s.request, s.client, s.server = ProtoKafka, false, dstAddr, srcAddr
+	case this.zkPorts[dstPort]:
+		s.proto, s.request, s.client, s.server = ProtoZookeeper, true, srcAddr, dstAddr
+	case this.zkPorts[srcPort]:
+		s.proto, s.request, s.client, s.server = ProtoZookeeper, false, dstAddr, srcAddr
+	default:
+		s.discard = true
+	}
+
+	return s
+}
+
+func (this *Sniffer) onFrame(s *stream, frame []byte, seen time.Time) {
+	evt := &Event{
+		Time:    seen,
+		Proto:   s.proto,
+		Client:  s.client,
+		Server:  s.server,
+		Request: s.request,
+		Bytes:   len(frame) + 4,
+		Latency: -1,
+	}
+
+	var err error
+	switch {
+	case s.proto == ProtoKafka && s.request:
+		err = decodeKafkaRequest(frame, evt)
+
+	case s.proto == ProtoKafka:
+		err = decodeKafkaResponse(frame, evt)
+
+	case s.request && s.frames == 0 && isZkConnect(frame, 44):
+		err = decodeZkConnect(frame, evt)
+
+	case s.request:
+		err = decodeZkRequest(frame, evt)
+
+	case s.frames == 0 && isZkConnect(frame, 36):
+		err = decodeZkConnected(frame, evt)
+
+	default:
+		err = decodeZkResponse(frame, evt)
+	}
+	s.frames++
+
+	if err != nil {
+		evt.Err = err.Error()
+	}
+
+	key := pendingKey{conn: s.client + "-" + s.server, id: evt.Id}
+	if s.request {
+		if err == nil && evt.Api != "connect" {
+			this.pending[key] = evt
+		}
+	} else if req, present := this.pending[key]; present && evt.Id != zkXidWatchEvent {
+		delete(this.pending, key)
+
+		evt.Api = req.Api
+		evt.Version = req.Version
+		evt.ClientId = req.ClientId
+		evt.Group = req.Group
+		evt.Topics = req.Topics
+		if len(evt.Paths) == 0 {
+			evt.Paths = req.Paths
+		}
+		evt.Latency = evt.Time.Sub(req.Time)
+
+		if s.proto == ProtoKafka && err == nil {
+			if err = decodeKafkaResponseBody(frame, evt); err != nil {
+				evt.Err = err.Error()
+			}
+		}
+	} else if evt.Api == "" {
+		evt.Api = "?"
+	}
+
+	this.handler(evt)
+}
+
+// isZkConnect tells whether the first frame of a zookeeper stream is the
+// session handshake, whose size is fixed with an optional readOnly flag.
+func isZkConnect(frame []byte, size int) bool {
+	return (len(frame) == size || len(frame) == size+1) &&
+		binary.BigEndian.Uint32(frame) == 0 // protocolVersion
+}
+
+// stream is one direction of a tcp connection.
+type stream struct {
+	sniffer *Sniffer
+
+	proto          string
+	request        bool
+	client, server string
+	discard        bool
+
+	buf    []byte
+	frames int
+}
+
+// Reassembled implements tcpassembly.Stream.
+func (s *stream) Reassembled(reassemblies []tcpassembly.Reassembly) {
+	if s.discard {
+		return
+	}
+
+	for _, r := range reassemblies {
+		if r.Skip != 0 {
+			// lost bytes, we cannot find the frame boundary any more
+			// and resync from the next packet
+			s.buf = s.buf[:0]
+		}
+
+		s.buf = append(s.buf, r.Bytes...)
+		s.consume(r.Seen)
+	}
+}
+
+func (s *stream) consume(seen time.Time) {
+	off := 0
+	for len(s.buf)-off >= 4 {
+		size := int(int32(binary.BigEndian.Uint32(s.buf[off:])))
+		if size < 0 || size > maxFrameSize {
+			// garbage, resync
+			off = len(s.buf)
+			break
+		}
+
+		if len(s.buf)-off < 4+size {
+			break
+		}
+
+		s.sniffer.onFrame(s, s.buf[off+4:off+4+size], seen)
+		off += 4 + size
+	}
+
+	if off > 0 {
+		s.buf = append(s.buf[:0], s.buf[off:]...)
+	}
+}
+
+// ReassemblyComplete implements tcpassembly.Stream.
+func (s *stream) ReassemblyComplete() {
+	s.buf = nil
+}
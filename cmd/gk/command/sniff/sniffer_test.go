@@ -0,0 +1,262 @@
+package sniff
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+// testdata/kafka_zk.pcap holds:
+// - kafka 10.0.0.1:50000 -> 10.0.0.2:9092: Produce v2 split across 2 segments,
+// Metadata v0 and OffsetCommit v2 in 1 segment
+// - zk 10.0.0.1:50001 -> 10.0.0.3:2181: connect, create /brokers/ids/1,
+// delete /brokers/ids/9 that fails with NoNode
+func replayFixture(t *testing.T) []*Event {
+	f, err := os.Open("testdata/kafka_zk.pcap")
+	assert.Equal(t, nil, err)
+	defer f.Close()
+
+	var events []*Event
+	s := New([]int{9092}, []int{2181}, func(evt *Event) {
+		t.Logf("%s", evt)
+		events = append(events, evt)
+	})
+	assert.Equal(t, nil, s.Replay(f))
+	return events
+}
+
+func TestReplayKafka(t *testing.T) {
+	var events []*Event
+	for _, evt := range replayFixture(t) {
+		if evt.Proto == ProtoKafka {
+			events = append(events, evt)
+		}
+	}
+	assert.Equal(t, 6, len(events))
+
+	produce := events[0]
+	assert.Equal(t, true, produce.Request)
+	assert.Equal(t, "Produce", produce.Api)
+	assert.Equal(t, int16(2), produce.Version)
+	assert.Equal(t, int32(7), produce.Id)
+	assert.Equal(t, "kateway", produce.ClientId)
+	assert.Equal(t, "10.0.0.1:50000", produce.Client)
+	assert.Equal(t, "10.0.0.2:9092", produce.Server)
+	assert.Equal(t, []TopicPartitions{{Topic: "orders", Partitions: []int32{0, 3}}}, produce.Topics)
+
+	resp := events[1]
+	assert.Equal(t, false, resp.Request)
+	assert.Equal(t, "Produce", resp.Api)
+	assert.Equal(t, int32(7), resp.Id)
+	assert.Equal(t, 2900*time.Microsecond, resp.Latency) // since the last segment of request
+
+	assert.Equal(t, "Metadata", events[2].Api)
+	assert.Equal(t, []string{"orders", "payments"}, events[2].TopicNames())
+	assert.Equal(t, "OffsetCommit", events[3].Api)
+	assert.Equal(t, "g1", events[3].Group)
+	assert.Equal(t, []TopicPartitions{{Topic: "orders", Partitions: []int32{0}}}, events[3].Topics)
+	assert.Equal(t, "Metadata", events[4].Api)
+	assert.Equal(t, "OffsetCommit", events[5].Api)
+	assert.Equal(t, "", events[5].Err)
+}
+
+func TestReplayZookeeper(t *testing.T) {
+	var events []*Event
+	for _, evt := range replayFixture(t) {
+		if evt.Proto == ProtoZookeeper {
+			events = append(events, evt)
+		}
+	}
+	assert.Equal(t, 6, len(events))
+
+	assert.Equal(t, "connect", events[0].Api)
+	assert.Equal(t, "connect", events[1].Api)
+	assert.Equal(t, int64(0x1580a1b2c3d4), events[1].Session)
+	assert.Equal(t, "create", events[2].Api)
+	assert.Equal(t, []string{"/brokers/ids/1"}, events[2].Paths)
+	assert.Equal(t, "create", events[3].Api)
+	assert.Equal(t, 2*time.Millisecond, events[3].Latency)
+	assert.Equal(t, "delete", events[4].Api)
+	assert.Equal(t, []string{"/brokers/ids/9"}, events[4].Paths)
+	assert.Equal(t, "NoNode", events[5].Err)
+}
+
+func TestZkMulti(t *testing.T) {
+	i32 := func(v int32) []byte {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(v))
+		return b
+	}
+	zstr := func(s string) []byte {
+		return append(i32(int32(len(s))), s...)
+	}
+
+	var frame []byte
+	frame = append(frame, i32(5)...) // xid
+	frame = append(frame, i32(zkOpMulti)...)
+	frame = append(frame, i32(zkOpCheck)...)
+	frame = append(frame, 0)
+	frame = append(frame, i32(-1)...)
+	frame = append(frame, zstr("/a")...)
+	frame = append(frame, i32(3)...)
+	frame = append(frame, i32(zkOpSetData)...)
+	frame = append(frame, 0)
+	frame = append(frame, i32(-1)...)
+	frame = append(frame, zstr("/b")...)
+	frame = append(frame, zstr("data")...)
+	frame = append(frame, i32(-1)...)
+	frame = append(frame, i32(-1)...) // done
+	frame = append(frame, 1)
+	frame = append(frame, i32(-1)...)
+
+	var evt Event
+	assert.Equal(t, nil, decodeZkRequest(frame, &evt))
+	assert.Equal(t, "multi", evt.Api)
+	assert.Equal(t, []string{"/a", "/b"}, evt.Paths)
+}
+
+func TestTruncatedKafkaRequest(t *testing.T) {
+	var evt Event
+	assert.Equal(t, ErrTruncated, decodeKafkaRequest([]byte{0, 0, 0, 2, 0, 0, 0, 1, 0, 9, 'k'}, &evt))
+}
+
+func TestKafkaFetchRequest(t *testing.T) {
+	i16 := func(v int16) []byte {
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, uint16(v))
+		return b
+	}
+	i32 := func(v int32) []byte {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(v))
+		return b
+	}
+	i64 := make([]byte, 8)
+	kstr := func(s string) []byte {
+		return append(i16(int16(len(s))), s...)
+	}
+
+	// Fetch v11: orders/0,2 fetched, payments/1 forgotten
+	var frame []byte
+	frame = append(frame, i16(apiFetch)...)
+	frame = append(frame, i16(11)...)
+	frame = append(frame, i32(9)...)
+	frame = append(frame, kstr("kateway")...)
+	frame = append(frame, i32(-1)...)  // replica_id
+	frame = append(frame, i32(500)...) // max_wait_time
+	frame = append(frame, i32(1)...)   // min_bytes
+	frame = append(frame, i32(1<<20)...)
+	frame = append(frame, 0)         // isolation_level
+	frame = append(frame, i32(3)...) // session_id
+	frame = append(frame, i32(1)...) // session_epoch
+	frame = append(frame, i32(1)...)
+	frame = append(frame, kstr("orders")...)
+	frame = append(frame, i32(2)...)
+	for _, p := range []int32{0, 2} {
+		frame = append(frame, i32(p)...)
+		frame = append(frame, i32(5)...) // current_leader_epoch
+		frame = append(frame, i64...)    // fetch_offset
+		frame = append(frame, i64...)    // log_start_offset
+		frame = append(frame, i32(1<<20)...)
+	}
+	frame = append(frame, i32(1)...) // forgotten_topics_data
+	frame = append(frame, kstr("payments")...)
+	frame = append(frame, i32(1)...)
+	frame = append(frame, i32(1)...)
+	frame = append(frame, kstr("rack1")...)
+
+	var evt Event
+	assert.Equal(t, nil, decodeKafkaRequest(frame, &evt))
+	assert.Equal(t, "Fetch", evt.Api)
+	assert.Equal(t, []TopicPartitions{{Topic: "orders", Partitions: []int32{0, 2}}}, evt.Topics)
+
+	evt = Event{}
+	assert.Equal(t, ErrTruncated, decodeKafkaRequest(frame[:len(frame)-4], &evt))
+
+	// Fetch v12 is flexible encoded, skipped after the header
+	frame = append(i16(apiFetch), i16(12)...)
+	frame = append(frame, i32(10)...)
+	frame = append(frame, kstr("kateway")...)
+	frame = append(frame, 0, 1, 2)
+	evt = Event{}
+	assert.Equal(t, nil, decodeKafkaRequest(frame, &evt))
+	assert.Equal(t, "Fetch", evt.Api)
+	assert.Equal(t, int16(12), evt.Version)
+	assert.Equal(t, 0, len(evt.Topics))
+}
+
+func TestKafkaResponseErrors(t *testing.T) {
+	i16 := func(v int16) []byte {
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, uint16(v))
+		return b
+	}
+	i32 := func(v int32) []byte {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(v))
+		return b
+	}
+	i64 := make([]byte, 8)
+	kstr := func(s string) []byte {
+		return append(i16(int16(len(s))), s...)
+	}
+
+	// Produce v2: orders/0 ok, orders/3 NotLeaderForPartition
+	var frame []byte
+	frame = append(frame, i32(7)...)
+	frame = append(frame, i32(1)...)
+	frame = append(frame, kstr("orders")...)
+	frame = append(frame, i32(2)...)
+	for _, p := range [][2]int32{{0, 0}, {3, 6}} {
+		frame = append(frame, i32(p[0])...)
+		frame = append(frame, i16(int16(p[1]))...)
+		frame = append(frame, i64...) // base_offset
+		frame = append(frame, i64...) // log_append_time
+	}
+	frame = append(frame, i32(0)...) // throttle_time_ms
+
+	evt := Event{Api: "Produce", Version: 2}
+	assert.Equal(t, nil, decodeKafkaResponseBody(frame, &evt))
+	assert.Equal(t, "orders/3:NotLeaderForPartition", evt.Err)
+
+	// Fetch v4: orders/1 OffsetOutOfRange with no aborted transactions
+	frame = frame[:0]
+	frame = append(frame, i32(8)...)
+	frame = append(frame, i32(0)...) // throttle_time_ms
+	frame = append(frame, i32(1)...)
+	frame = append(frame, kstr("orders")...)
+	frame = append(frame, i32(1)...)
+	frame = append(frame, i32(1)...)
+	frame = append(frame, i16(1)...)
+	frame = append(frame, i64...)     // high_watermark
+	frame = append(frame, i64...)     // last_stable_offset
+	frame = append(frame, i32(-1)...) // aborted_transactions
+	frame = append(frame, i32(3)...)
+	frame = append(frame, "abc"...)
+
+	evt = Event{Api: "Fetch", Version: 4}
+	assert.Equal(t, nil, decodeKafkaResponseBody(frame, &evt))
+	assert.Equal(t, "orders/1:OffsetOutOfRange", evt.Err)
+
+	evt = Event{Api: "Fetch", Version: 4}
+	assert.Equal(t, ErrTruncated, decodeKafkaResponseBody(frame[:30], &evt))
+}
+
+func TestStatsTopTalkers(t *testing.T) {
+	stats := NewStats()
+	for _, evt := range replayFixture(t) {
+		stats.Add(evt)
+	}
+
+	clients, apis := stats.Top(3)
+	assert.Equal(t, 2, len(clients))
+	assert.Equal(t, "kafka 10.0.0.1", clients[0].Key)
+	assert.Equal(t, int64(3), clients[0].Requests)
+	assert.Equal(t, int64(3), clients[0].Responses)
+	assert.Equal(t, 3, len(apis))
+	assert.Equal(t, "kafka Produce orders", apis[0].Key)
+	assert.Equal(t, 2900*time.Microsecond, apis[0].AvgLatency())
+}
@@ -0,0 +1,114 @@
+package sniff
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Counter aggregates the traffic of a talker.
+type Counter struct {
+	Key        string
+	Requests   int64
+	Responses  int64
+	Bytes      int64
+	Latency    time.Duration // sum of response latency
+	MaxLatency time.Duration
+}
+
+func (this *Counter) AvgLatency() time.Duration {
+	if this.Responses == 0 {
+		return 0
+	}
+
+	return this.Latency / time.Duration(this.Responses)
+}
+
+func (this *Counter) add(evt *Event) {
+	this.Bytes += int64(evt.Bytes)
+	if evt.Request {
+		this.Requests++
+		return
+	}
+
+	this.Responses++
+	if evt.Latency > 0 {
+		this.Latency += evt.Latency
+		if evt.Latency > this.MaxLatency {
+			this.MaxLatency = evt.Latency
+		}
+	}
+}
+
+// Stats summarises the top talkers by client host and by api and topic.
+type Stats struct {
+	byClient map[string]*Counter
+	byApi    map[string]*Counter
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		byClient: make(map[string]*Counter),
+		byApi:    make(map[string]*Counter),
+	}
+}
+
+func (this *Stats) Add(evt *Event) {
+	host, _, err := net.SplitHostPort(evt.Client)
+	if err != nil {
+		host = evt.Client
+	}
+	counter(this.byClient, evt.Proto+" "+host).add(evt)
+
+	names := evt.TopicNames()
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	for _, name := range names {
+		counter(this.byApi, strings.Join([]string{evt.Proto, evt.Api, name}, " ")).add(evt)
+	}
+}
+
+// Top returns the top n talkers by client and by api, sorted by bytes.
+func (this *Stats) Top(n int) (clients, apis []*Counter) {
+	return top(this.byClient, n), top(this.byApi, n)
+}
+
+func (this *Stats) Reset() {
+	this.byClient = make(map[string]*Counter)
+	this.byApi = make(map[string]*Counter)
+}
+
+func counter(m map[string]*Counter, key string) *Counter {
+	c, present := m[key]
+	if !present {
+		c = &Counter{Key: key}
+		m[key] = c
+	}
+	return c
+}
+
+type counters []*Counter
+
+func (this counters) Len() int      { return len(this) }
+func (this counters) Swap(i, j int) { this[i], this[j] = this[j], this[i] }
+func (this counters) Less(i, j int) bool {
+	if this[i].Bytes == this[j].Bytes {
+		return this[i].Key < this[j].Key
+	}
+	return this[i].Bytes > this[j].Bytes
+}
+
+func top(m map[string]*Counter, n int) []*Counter {
+	r := make(counters, 0, len(m))
+	for _, c := range m {
+		r = append(r, c)
+	}
+	sort.Sort(r)
+
+	if n > 0 && len(r) > n {
+		r = r[:n]
+	}
+	return r
+}
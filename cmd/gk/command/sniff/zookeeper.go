@@ -0,0 +1,205 @@
+package sniff
+
+import (
+	"fmt"
+)
+
+// zookeeper op codes, see org.apache.zookeeper.ZooDefs.OpCode
+const (
+	zkOpNotification = 0
+	zkOpCreate       = 1
+	zkOpDelete       = 2
+	zkOpExists       = 3
+	zkOpGetData      = 4
+	zkOpSetData      = 5
+	zkOpGetAcl       = 6
+	zkOpSetAcl       = 7
+	zkOpGetChildren  = 8
+	zkOpSync         = 9
+	zkOpPing         = 11
+	zkOpGetChildren2 = 12
+	zkOpCheck        = 13
+	zkOpMulti        = 14
+	zkOpCreate2      = 15
+	zkOpClose        = -11
+	zkOpSetAuth      = 100
+	zkOpSetWatches   = 101
+
+	zkXidWatchEvent = -1
+	zkXidPing       = -2
+)
+
+var zkOps = map[int32]string{
+	zkOpNotification: "notification",
+	zkOpCreate:       "create",
+	zkOpDelete:       "delete",
+	zkOpExists:       "exists",
+	zkOpGetData:      "getData",
+	zkOpSetData:      "setData",
+	zkOpGetAcl:       "getACL",
+	zkOpSetAcl:       "setACL",
+	zkOpGetChildren:  "getChildren",
+	zkOpSync:         "sync",
+	zkOpPing:         "ping",
+	zkOpGetChildren2: "getChildren2",
+	zkOpCheck:        "check",
+	zkOpMulti:        "multi",
+	zkOpCreate2:      "create2",
+	zkOpClose:        "closeSession",
+	zkOpSetAuth:      "setAuth",
+	zkOpSetWatches:   "setWatches",
+}
+
+var zkErrors = map[int32]string{
+	-4:   "ConnectionLoss",
+	-101: "NoNode",
+	-102: "NoAuth",
+	-103: "BadVersion",
+	-108: "NoChildrenForEphemerals",
+	-110: "NodeExists",
+	-111: "NotEmpty",
+	-112: "SessionExpired",
+	-118: "SessionMoved",
+}
+
+func zkOpName(op int32) string {
+	if name, present := zkOps[op]; present {
+		return name
+	}
+
+	return fmt.Sprintf("op(%d)", op)
+}
+
+func zkErrName(code int32) string {
+	if name, present := zkErrors[code]; present {
+		return name
+	}
+
+	return fmt.Sprintf("err(%d)", code)
+}
+
+// decodeZkConnect decodes the first frame a client sends.
+//
+// ConnectRequest => protocolVersion lastZxidSeen timeOut sessionId passwd [readOnly]
+func decodeZkConnect(frame []byte, evt *Event) error {
+	r := newReader(frame)
+	r.int32() // protocolVersion
+	r.int64() // lastZxidSeen
+	r.int32() // timeOut
+	evt.Session = r.int64()
+	evt.Api = "connect"
+	return r.err
+}
+
+// decodeZkConnected decodes the first frame a server replies.
+//
+// ConnectResponse => protocolVersion timeOut sessionId passwd [readOnly]
+func decodeZkConnected(frame []byte, evt *Event) error {
+	r := newReader(frame)
+	r.int32() // protocolVersion
+	r.int32() // timeOut
+	evt.Session = r.int64()
+	evt.Api = "connect"
+	return r.err
+}
+
+// decodeZkRequest decodes a zookeeper client request.
+//
+// RequestHeader => xid type
+func decodeZkRequest(frame []byte, evt *Event) error {
+	r := newReader(frame)
+	evt.Id = r.int32()
+	op := r.int32()
+	if r.err != nil {
+		return r.err
+	}
+
+	evt.Api = zkOpName(op)
+	if op == zkOpMulti {
+		decodeZkMulti(r, evt)
+	} else {
+		decodeZkOp(r, op, evt)
+	}
+
+	return r.err
+}
+
+// decodeZkOp decodes the request body of op and collects its path.
+func decodeZkOp(r *reader, op int32, evt *Event) {
+	switch op {
+	case zkOpCreate, zkOpCreate2:
+		// path data acl flags
+		evt.Paths = append(evt.Paths, r.ustring())
+		r.buffer()
+		n := r.arrayLen()
+		for i := 0; i < n && r.err == nil; i++ {
+			r.int32()   // perms
+			r.ustring() // scheme
+			r.ustring() // id
+		}
+		r.int32()
+
+	case zkOpDelete, zkOpCheck:
+		// path version
+		evt.Paths = append(evt.Paths, r.ustring())
+		r.int32()
+
+	case zkOpSetData:
+		// path data version
+		evt.Paths = append(evt.Paths, r.ustring())
+		r.buffer()
+		r.int32()
+
+	case zkOpExists, zkOpGetData, zkOpGetChildren, zkOpGetChildren2:
+		// path watch
+		evt.Paths = append(evt.Paths, r.ustring())
+		r.bool()
+
+	case zkOpGetAcl, zkOpSync, zkOpSetAcl:
+		evt.Paths = append(evt.Paths, r.ustring())
+	}
+}
+
+// MultiRequest => [MultiHeader op] MultiHeader(done=true)
+// MultiHeader => type done err
+func decodeZkMulti(r *reader, evt *Event) {
+	for r.err == nil {
+		op := r.int32()
+		done := r.bool()
+		r.int32() // err
+		if done || r.err != nil {
+			return
+		}
+
+		decodeZkOp(r, op, evt)
+	}
+}
+
+// decodeZkResponse decodes a zookeeper server response.
+//
+// ReplyHeader => xid zxid err
+func decodeZkResponse(frame []byte, evt *Event) error {
+	r := newReader(frame)
+	evt.Id = r.int32()
+	r.int64() // zxid
+	if code := r.int32(); code != 0 {
+		evt.Err = zkErrName(code)
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	switch evt.Id {
+	case zkXidPing:
+		evt.Api = zkOpName(zkOpPing)
+
+	case zkXidWatchEvent:
+		// WatcherEvent => type state path
+		r.int32()
+		r.int32()
+		evt.Api = "watchEvent"
+		evt.Paths = append(evt.Paths, r.ustring())
+	}
+
+	return r.err
+}
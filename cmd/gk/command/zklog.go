@@ -1,13 +1,18 @@
 package command
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/funkygao/gafka/cmd/gk/command/zklog"
 	"github.com/funkygao/gocli"
-	"github.com/funkygao/zkclient/zookeeper"
+	"github.com/funkygao/golib/color"
 )
 
 type ZkLog struct {
@@ -16,13 +21,21 @@ type ZkLog struct {
 
 	filename string
 	limit    int
+	jsonMode bool
+	filter   zklog.Filter
 }
 
 func (this *ZkLog) Run(args []string) (exitCode int) {
+	var session, from, to string
 	cmdFlags := flag.NewFlagSet("zklog", flag.ContinueOnError)
 	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
 	cmdFlags.IntVar(&this.limit, "n", -1, "")
 	cmdFlags.StringVar(&this.filename, "f", "", "")
+	cmdFlags.StringVar(&this.filter.PathPrefix, "p", "", "")
+	cmdFlags.StringVar(&session, "session", "", "")
+	cmdFlags.StringVar(&from, "from", "", "")
+	cmdFlags.StringVar(&to, "to", "", "")
+	cmdFlags.BoolVar(&this.jsonMode, "json", false, "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -33,22 +46,111 @@ func (this *ZkLog) Run(args []string) (exitCode int) {
 		return 2
 	}
 
-	this.readTransactionLog(this.filename)
+	var err error
+	if session != "" {
+		// zk prints session id in hex: 0x1558fe8d8cd0001
+		this.filter.Session, err = strconv.ParseInt(session, 0, 64)
+		swallow(err)
+	}
+	if from != "" {
+		this.filter.From, err = time.ParseInLocation("2006-01-02 15:04:05", from, time.Local)
+		swallow(err)
+	}
+	if to != "" {
+		this.filter.To, err = time.ParseInLocation("2006-01-02 15:04:05", to, time.Local)
+		swallow(err)
+	}
+
+	f, err := os.Open(this.filename) // readonly
+	swallow(err)
+	defer f.Close()
+
+	hdr, err := zklog.ReadFileHeader(f)
+	swallow(err)
+	_, err = f.Seek(0, os.SEEK_SET)
+	swallow(err)
+
+	if hdr.IsSnapshot() {
+		this.readSnapshot(f)
+	} else {
+		this.readTransactionLog(f)
+	}
 
 	return
 }
 
-func (this *ZkLog) readTransactionLog(filename string) {
-	f, err := os.Open(filename) // readonly
+func (this *ZkLog) readTransactionLog(f io.Reader) {
+	r, err := zklog.NewTxnLogReader(f)
 	swallow(err)
-	defer f.Close()
 
-	fhdr := &zookeeper.FileHeader{}
-	fhdr.Deserialize(f)
+	if !this.jsonMode {
+		this.Ui.Info(fmt.Sprintf("txnlog version:%d dbid:%d", r.Header.Version, r.Header.Dbid))
+	}
+
+	n := 0
+	for this.limit < 0 || n < this.limit {
+		txn, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			this.Ui.Error(err.Error())
+			return
+		}
+
+		if !this.filter.Match(txn) {
+			continue
+		}
+
+		n++
+		if this.jsonMode {
+			b, _ := json.Marshal(txn)
+			this.Ui.Output(string(b))
+		} else if txn.Type == "delete" || txn.Type == "closeSession" || txn.Type == "error" {
+			this.Ui.Output(color.Yellow("%s", txn))
+		} else {
+			this.Ui.Output(txn.String())
+		}
+	}
 
-	// crcValue:64
-	for {
+	if r.Truncated {
+		this.Ui.Warn("log ends with a partially flushed record")
+	}
+	if !this.jsonMode {
+		this.Ui.Info(fmt.Sprintf("%d txns", n))
+	}
+}
 
+func (this *ZkLog) readSnapshot(f io.Reader) {
+	n := 0
+	snap, err := zklog.ReadSnapshot(f, func(node *zklog.Node) {
+		if !this.filter.MatchNode(node) || (this.limit >= 0 && n >= this.limit) {
+			return
+		}
+
+		n++
+		if this.jsonMode {
+			b, _ := json.Marshal(node)
+			this.Ui.Output(string(b))
+			return
+		}
+
+		ephemeral := ""
+		if node.Stat.EphemeralOwner != 0 {
+			ephemeral = fmt.Sprintf(" ephemeral:0x%x", node.Stat.EphemeralOwner)
+		}
+		this.Ui.Output(fmt.Sprintf("%s mzxid:0x%x mtime:%s v%d%s %q", node.Path,
+			node.Stat.Mzxid, node.Stat.Mtime.Format("2006-01-02 15:04:05"),
+			node.Stat.Version, ephemeral, node.Data))
+	})
+	if err != nil {
+		this.Ui.Error(err.Error())
+		return
+	}
+
+	if !this.jsonMode {
+		this.Ui.Info(fmt.Sprintf("snapshot version:%d dbid:%d sessions:%d znodes:%d matched:%d",
+			snap.Header.Version, snap.Header.Dbid, len(snap.Sessions), snap.Nodes, n))
 	}
 }
 
@@ -62,11 +164,33 @@ Usage: %s zklog [options]
 
     %s
 
-    -f segment file name   
+    Txnlog or snapshot is detected by the file header magic.
+    Records are verified by checksum.
+
+    e,g. who deleted the broker znode
+    %s zklog -f log.2c00000001 -p /brokers/ids/
+
+Options:
+
+    -f txnlog or snapshot file name
 
     -n limit
       Default unlimited.
 
-`, this.Cmd, this.Synopsis())
+    -p znode path prefix
+
+    -session session id
+      e,g. 0x1558fe8d8cd0001
+      For snapshot, it filters ephemeral znodes owned by the session.
+
+    -from time
+      e,g. '2016-07-01 10:00:00'
+
+    -to time
+
+    -json
+      Output each record as a json line.
+
+`, this.Cmd, this.Synopsis(), this.Cmd)
 	return strings.TrimSpace(help)
 }
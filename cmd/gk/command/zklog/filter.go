@@ -0,0 +1,52 @@
+package zklog
+
+import (
+	"strings"
+	"time"
+)
+
+// Filter selects txns by znode path prefix, session and time range.
+// Zero value fields match all.
+type Filter struct {
+	PathPrefix string
+	Session    int64
+	From, To   time.Time
+}
+
+func (this *Filter) Match(txn *Txn) bool {
+	if this.Session != 0 && txn.Session != this.Session {
+		return false
+	}
+	if !this.From.IsZero() && txn.Time.Before(this.From) {
+		return false
+	}
+	if !this.To.IsZero() && txn.Time.After(this.To) {
+		return false
+	}
+
+	if this.PathPrefix == "" {
+		return true
+	}
+	for _, path := range txn.Paths() {
+		if strings.HasPrefix(path, this.PathPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchNode applies the path prefix and session(as ephemeral owner)
+// on a snapshot znode.
+func (this *Filter) MatchNode(node *Node) bool {
+	if this.Session != 0 && node.Stat.EphemeralOwner != this.Session {
+		return false
+	}
+	if !this.From.IsZero() && node.Stat.Mtime.Before(this.From) {
+		return false
+	}
+	if !this.To.IsZero() && node.Stat.Mtime.After(this.To) {
+		return false
+	}
+
+	return strings.HasPrefix(node.Path, this.PathPrefix)
+}
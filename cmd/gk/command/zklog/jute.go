@@ -0,0 +1,125 @@
+package zklog
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var ErrTruncated = errors.New("truncated record")
+
+const maxBufferLen = 100 << 20 // jute.maxbuffer is 1MB by default, be tolerant
+
+// juteReader decodes the jute binary archive that zookeeper persists
+// txnlog and snapshot with. The first error is sticky, subsequent reads
+// return zero values.
+type juteReader struct {
+	r   io.Reader
+	buf [8]byte
+	err error
+}
+
+func newJuteReader(r io.Reader) *juteReader {
+	return &juteReader{r: r}
+}
+
+func (this *juteReader) read(n int) []byte {
+	if this.err != nil {
+		return nil
+	}
+
+	if _, err := io.ReadFull(this.r, this.buf[:n]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrTruncated
+		}
+		this.err = err
+		return nil
+	}
+	return this.buf[:n]
+}
+
+func (this *juteReader) bool() bool {
+	b := this.read(1)
+	return b != nil && b[0] != 0
+}
+
+func (this *juteReader) byte() byte {
+	if b := this.read(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (this *juteReader) int32() int32 {
+	if b := this.read(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (this *juteReader) int64() int64 {
+	if b := this.read(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// buffer reads an int32 length prefixed byte array, -1 length means nil.
+func (this *juteReader) buffer() []byte {
+	n := this.int32()
+	if this.err != nil || n < 0 {
+		return nil
+	}
+	if n > maxBufferLen {
+		this.err = ErrTruncated
+		return nil
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(this.r, b); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			err = ErrTruncated
+		}
+		this.err = err
+		return nil
+	}
+	return b
+}
+
+func (this *juteReader) string() string {
+	return string(this.buffer())
+}
+
+func (this *juteReader) acls() []ACL {
+	n := this.int32()
+	if this.err != nil || n <= 0 {
+		return nil
+	}
+
+	acls := make([]ACL, 0, n)
+	for i := int32(0); i < n && this.err == nil; i++ {
+		acls = append(acls, ACL{
+			Perms:  this.int32(),
+			Scheme: this.string(),
+			Id:     this.string(),
+		})
+	}
+	return acls
+}
+
+// ACL is a zookeeper access control entry.
+type ACL struct {
+	Perms  int32  `json:"perms"`
+	Scheme string `json:"scheme"`
+	Id     string `json:"id"`
+}
+
+func (this ACL) String() string {
+	perms := []byte("-----")
+	for i, c := range []byte("rwcda") {
+		if this.Perms&(1<<uint(i)) != 0 {
+			perms[i] = c
+		}
+	}
+	return this.Scheme + ":" + this.Id + ":" + string(perms)
+}
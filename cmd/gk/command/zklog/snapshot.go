@@ -0,0 +1,127 @@
+package zklog
+
+import (
+	"bufio"
+	"hash"
+	"hash/adler32"
+	"io"
+	"time"
+)
+
+// Stat is the persisted stat of a znode.
+type Stat struct {
+	Czxid          int64     `json:"czxid"`
+	Mzxid          int64     `json:"mzxid"`
+	Ctime          time.Time `json:"ctime"`
+	Mtime          time.Time `json:"mtime"`
+	Version        int32     `json:"version"`
+	Cversion       int32     `json:"cversion"`
+	Aversion       int32     `json:"aversion"`
+	EphemeralOwner int64     `json:"ephemeralOwner,omitempty"`
+	Pzxid          int64     `json:"pzxid"`
+}
+
+// Node is a znode of the snapshot data tree.
+type Node struct {
+	Path string `json:"path"`
+	Data string `json:"data,omitempty"`
+	Acl  []ACL  `json:"acl,omitempty"`
+	Stat Stat   `json:"stat"`
+}
+
+// Snapshot is the summary of a snapshot file.
+type Snapshot struct {
+	Header   FileHeader
+	Sessions map[int64]int32 // session id:timeout ms
+	Nodes    int
+}
+
+// checksumReader feeds all read bytes into the adler32 until paused.
+type checksumReader struct {
+	r      io.Reader
+	h      hash.Hash32
+	paused bool
+}
+
+func (this *checksumReader) Read(p []byte) (int, error) {
+	n, err := this.r.Read(p)
+	if !this.paused && n > 0 {
+		this.h.Write(p[:n])
+	}
+	return n, err
+}
+
+// ReadSnapshot walks the data tree of a snapshot file in the 3.4 format,
+// calls fn for each znode and verifies the trailing checksum.
+func ReadSnapshot(rd io.Reader, fn func(*Node)) (*Snapshot, error) {
+	cr := &checksumReader{r: bufio.NewReader(rd), h: adler32.New()}
+	r := newJuteReader(cr)
+
+	hdr, err := readFileHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if !hdr.IsSnapshot() {
+		return nil, ErrBadMagic
+	}
+
+	snap := &Snapshot{Header: hdr, Sessions: make(map[int64]int32)}
+	for i, n := int32(0), r.int32(); i < n && r.err == nil; i++ {
+		id := r.int64()
+		snap.Sessions[id] = r.int32()
+	}
+
+	// ReferenceCountedACLCache: acl index:acl list
+	aclCache := make(map[int64][]ACL)
+	for i, n := int32(0), r.int32(); i < n && r.err == nil; i++ {
+		idx := r.int64()
+		aclCache[idx] = r.acls()
+	}
+
+	for r.err == nil {
+		path := r.string()
+		if path == "/" {
+			// end of data tree
+			break
+		}
+
+		node := &Node{Path: path}
+		node.Data = r.string()
+		node.Acl = aclCache[r.int64()]
+		node.Stat = Stat{
+			Czxid:          r.int64(),
+			Mzxid:          r.int64(),
+			Ctime:          msTime(r.int64()),
+			Mtime:          msTime(r.int64()),
+			Version:        r.int32(),
+			Cversion:       r.int32(),
+			Aversion:       r.int32(),
+			EphemeralOwner: r.int64(),
+			Pzxid:          r.int64(),
+		}
+		if r.err != nil {
+			break
+		}
+
+		if node.Path == "" {
+			node.Path = "/"
+		}
+		snap.Nodes++
+		if fn != nil {
+			fn(node)
+		}
+	}
+	if r.err != nil {
+		return snap, r.err
+	}
+
+	expected := cr.h.Sum32()
+	cr.paused = true
+	if crc := r.int64(); r.err != nil {
+		return snap, r.err
+	} else if uint32(crc) != expected {
+		return snap, ErrBadChecksum
+	}
+
+	return snap, nil
+}
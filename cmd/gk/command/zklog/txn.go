@@ -0,0 +1,218 @@
+package zklog
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// txn types of org.apache.zookeeper.ZooDefs.OpCode that are persisted.
+const (
+	opCreate          = 1
+	opDelete          = 2
+	opSetData         = 5
+	opSetACL          = 7
+	opCheck           = 13
+	opMulti           = 14
+	opCreate2         = 15
+	opReconfig        = 16
+	opCreateContainer = 19
+	opDeleteContainer = 20
+	opCreateTTL       = 21
+	opCreateSession   = -10
+	opCloseSession    = -11
+	opError           = -1
+)
+
+var opNames = map[int32]string{
+	opCreate:          "create",
+	opDelete:          "delete",
+	opSetData:         "setData",
+	opSetACL:          "setACL",
+	opCheck:           "check",
+	opMulti:           "multi",
+	opCreate2:         "create2",
+	opReconfig:        "reconfig",
+	opCreateContainer: "createContainer",
+	opDeleteContainer: "deleteContainer",
+	opCreateTTL:       "createTTL",
+	opCreateSession:   "createSession",
+	opCloseSession:    "closeSession",
+	opError:           "error",
+}
+
+func opName(op int32) string {
+	if name, present := opNames[op]; present {
+		return name
+	}
+	return fmt.Sprintf("op(%d)", op)
+}
+
+// Txn is a decoded zookeeper transaction.
+type Txn struct {
+	Session int64     `json:"session"`
+	Cxid    int32     `json:"cxid"`
+	Zxid    int64     `json:"zxid"`
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+
+	Path      string `json:"path,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Acl       []ACL  `json:"acl,omitempty"`
+	Ephemeral bool   `json:"ephemeral,omitempty"`
+	Version   int32  `json:"version,omitempty"`
+	Timeout   int32  `json:"timeout,omitempty"` // createSession
+	Ttl       int64  `json:"ttl,omitempty"`     // createTTL
+	Err       int32  `json:"err,omitempty"`     // error
+
+	Ops []*Txn `json:"ops,omitempty"` // multi
+}
+
+// Paths returns all the znode paths touched by the txn.
+func (this *Txn) Paths() []string {
+	var paths []string
+	if this.Path != "" {
+		paths = append(paths, this.Path)
+	}
+	for _, op := range this.Ops {
+		paths = append(paths, op.Paths()...)
+	}
+	return paths
+}
+
+func (this *Txn) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s session:0x%x cxid:0x%x zxid:0x%x %s",
+		this.Time.Format("2006-01-02 15:04:05.000"), this.Session, this.Cxid, this.Zxid, this.Type)
+	this.describe(&b)
+	return b.String()
+}
+
+func (this *Txn) describe(b *bytes.Buffer) {
+	if this.Path != "" {
+		fmt.Fprintf(b, " %s", this.Path)
+	}
+
+	switch this.Type {
+	case "create", "create2", "createContainer", "createTTL", "setData":
+		fmt.Fprintf(b, " %q", this.Data)
+	}
+	if this.Type == "setData" || this.Type == "setACL" || this.Type == "check" {
+		fmt.Fprintf(b, " v%d", this.Version)
+	}
+	if this.Ephemeral {
+		b.WriteString(" ephemeral")
+	}
+	if this.Ttl > 0 {
+		fmt.Fprintf(b, " ttl:%dms", this.Ttl)
+	}
+	if len(this.Acl) > 0 {
+		acls := make([]string, 0, len(this.Acl))
+		for _, acl := range this.Acl {
+			acls = append(acls, acl.String())
+		}
+		fmt.Fprintf(b, " [%s]", strings.Join(acls, ","))
+	}
+	if this.Timeout > 0 {
+		fmt.Fprintf(b, " timeout:%dms", this.Timeout)
+	}
+	if this.Type == "error" {
+		fmt.Fprintf(b, " err:%d", this.Err)
+	}
+
+	for _, op := range this.Ops {
+		fmt.Fprintf(b, "\n    %s", op.Type)
+		op.describe(b)
+	}
+}
+
+// decodeTxn decodes the serialized TxnHeader and the txn body.
+func decodeTxn(payload []byte) (*Txn, error) {
+	r := newJuteReader(bytes.NewReader(payload))
+	txn := &Txn{
+		Session: r.int64(),
+		Cxid:    r.int32(),
+		Zxid:    r.int64(),
+		Time:    msTime(r.int64()),
+	}
+	op := r.int32()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	decodeTxnBody(r, op, txn)
+	return txn, r.err
+}
+
+func decodeTxnBody(r *juteReader, op int32, txn *Txn) {
+	txn.Type = opName(op)
+
+	switch op {
+	case opCreate, opCreate2:
+		txn.Path = r.string()
+		txn.Data = r.string()
+		txn.Acl = r.acls()
+		txn.Ephemeral = r.bool()
+		r.int32() // parentCVersion
+
+	case opCreateContainer:
+		txn.Path = r.string()
+		txn.Data = r.string()
+		txn.Acl = r.acls()
+		r.int32() // parentCVersion
+
+	case opCreateTTL:
+		txn.Path = r.string()
+		txn.Data = r.string()
+		txn.Acl = r.acls()
+		r.int32() // parentCVersion
+		txn.Ttl = r.int64()
+
+	case opDelete, opDeleteContainer:
+		txn.Path = r.string()
+
+	case opSetData:
+		txn.Path = r.string()
+		txn.Data = r.string()
+		txn.Version = r.int32()
+
+	case opSetACL:
+		txn.Path = r.string()
+		txn.Acl = r.acls()
+		txn.Version = r.int32()
+
+	case opCheck:
+		txn.Path = r.string()
+		txn.Version = r.int32()
+
+	case opCreateSession:
+		txn.Timeout = r.int32()
+
+	case opError:
+		txn.Err = r.int32()
+
+	case opMulti:
+		n := r.int32()
+		for i := int32(0); i < n && r.err == nil; i++ {
+			subOp := r.int32()
+			sub := r.buffer()
+			if r.err != nil {
+				break
+			}
+
+			subTxn := &Txn{}
+			sr := newJuteReader(bytes.NewReader(sub))
+			decodeTxnBody(sr, subOp, subTxn)
+			if sr.err != nil {
+				r.err = sr.err
+				break
+			}
+			txn.Ops = append(txn.Ops, subTxn)
+		}
+	}
+}
+
+func msTime(ms int64) time.Time {
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+}
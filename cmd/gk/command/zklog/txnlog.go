@@ -0,0 +1,126 @@
+package zklog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io"
+)
+
+const (
+	TxnLogMagic   int32 = 0x5a4b4c47 // ZKLG
+	SnapshotMagic int32 = 0x5a4b534e // ZKSN
+
+	endOfRecord = 'B'
+)
+
+var (
+	ErrBadMagic    = errors.New("bad magic number")
+	ErrBadChecksum = errors.New("checksum mismatch")
+	ErrBadEOR      = errors.New("bad end of record")
+)
+
+// FileHeader is the header of both txnlog and snapshot files.
+type FileHeader struct {
+	Magic   int32
+	Version int32
+	Dbid    int64
+}
+
+func (this FileHeader) IsSnapshot() bool {
+	return this.Magic == SnapshotMagic
+}
+
+func readFileHeader(r *juteReader) (hdr FileHeader, err error) {
+	hdr.Magic = r.int32()
+	hdr.Version = r.int32()
+	hdr.Dbid = r.int64()
+	if r.err != nil {
+		return hdr, r.err
+	}
+
+	if hdr.Magic != TxnLogMagic && hdr.Magic != SnapshotMagic {
+		return hdr, ErrBadMagic
+	}
+	return
+}
+
+// ReadFileHeader reads the header to tell whether it is txnlog or snapshot.
+func ReadFileHeader(r io.Reader) (FileHeader, error) {
+	return readFileHeader(newJuteReader(r))
+}
+
+// TxnLogReader iterates the records of a txnlog file.
+//
+// Each record is:
+//
+//	crc int64 adler32 of txn
+//	len int32
+//	txn [len]byte
+//	eor byte 'B'
+//
+// The log file is preallocated with zeros, a zero length record means
+// the end of log.
+type TxnLogReader struct {
+	r      *juteReader
+	Header FileHeader
+
+	// Truncated is set when the log ends with a partially flushed record,
+	// which zookeeper itself tolerates as the end of log.
+	Truncated bool
+
+	offset int64 // of current record
+}
+
+func NewTxnLogReader(rd io.Reader) (*TxnLogReader, error) {
+	r := newJuteReader(bufio.NewReader(rd))
+	hdr, err := readFileHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.IsSnapshot() {
+		return nil, ErrBadMagic
+	}
+
+	return &TxnLogReader{r: r, Header: hdr, offset: 16}, nil
+}
+
+// Next returns the next txn, io.EOF at the end of log.
+func (this *TxnLogReader) Next() (*Txn, error) {
+	crc := this.r.int64()
+	n := this.r.int32()
+	if this.r.err != nil {
+		if this.r.err == ErrTruncated {
+			this.Truncated = true
+			return nil, io.EOF
+		}
+		return nil, this.r.err
+	}
+	if n == 0 {
+		return nil, io.EOF
+	}
+	if n < 0 || n > maxBufferLen {
+		return nil, fmt.Errorf("offset %d: invalid txn length %d", this.offset, n)
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(this.r.r, payload); err != nil {
+		this.Truncated = true
+		return nil, io.EOF
+	}
+	if uint32(crc) != adler32.Checksum(payload) {
+		return nil, fmt.Errorf("offset %d: %v", this.offset, ErrBadChecksum)
+	}
+	if eor := this.r.byte(); this.r.err != nil || eor != endOfRecord {
+		return nil, fmt.Errorf("offset %d: %v", this.offset, ErrBadEOR)
+	}
+
+	txn, err := decodeTxn(payload)
+	if err != nil {
+		return nil, fmt.Errorf("offset %d: %v", this.offset, err)
+	}
+
+	this.offset += 8 + 4 + int64(n) + 1
+	return txn, nil
+}
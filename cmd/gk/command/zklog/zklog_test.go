@@ -0,0 +1,216 @@
+package zklog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/adler32"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+type juteWriter struct {
+	bytes.Buffer
+}
+
+func (this *juteWriter) int32(v int32) *juteWriter {
+	binary.Write(this, binary.BigEndian, v)
+	return this
+}
+
+func (this *juteWriter) int64(v int64) *juteWriter {
+	binary.Write(this, binary.BigEndian, v)
+	return this
+}
+
+func (this *juteWriter) bool(v bool) *juteWriter {
+	if v {
+		this.WriteByte(1)
+	} else {
+		this.WriteByte(0)
+	}
+	return this
+}
+
+func (this *juteWriter) buffer(b []byte) *juteWriter {
+	this.int32(int32(len(b)))
+	this.Write(b)
+	return this
+}
+
+func (this *juteWriter) string(s string) *juteWriter {
+	return this.buffer([]byte(s))
+}
+
+func (this *juteWriter) acls(acls ...ACL) *juteWriter {
+	this.int32(int32(len(acls)))
+	for _, acl := range acls {
+		this.int32(acl.Perms).string(acl.Scheme).string(acl.Id)
+	}
+	return this
+}
+
+var (
+	worldAll = ACL{Perms: 31, Scheme: "world", Id: "anyone"}
+	t0       = time.Date(2016, 7, 1, 10, 0, 0, 0, time.UTC)
+)
+
+func txnRecord(session int64, zxid int64, ms int, op int32, body []byte) []byte {
+	w := &juteWriter{}
+	w.int64(session).int32(1).int64(zxid).int64(t0.UnixNano()/1e6 + int64(ms)).int32(op)
+	w.Write(body)
+	txn := w.Bytes()
+
+	rec := &juteWriter{}
+	rec.int64(int64(adler32.Checksum(txn))).int32(int32(len(txn)))
+	rec.Write(txn)
+	rec.WriteByte(endOfRecord)
+	return rec.Bytes()
+}
+
+func buildTxnLog() []byte {
+	log := &juteWriter{}
+	log.int32(TxnLogMagic).int32(2).int64(0)
+
+	log.Write(txnRecord(0x155, 1, 0, opCreateSession, new(juteWriter).int32(6000).Bytes()))
+	log.Write(txnRecord(0x155, 2, 10, opCreate, new(juteWriter).string("/brokers/ids/1").
+		buffer([]byte(`{"host":"k1"}`)).acls(worldAll).bool(true).int32(1).Bytes()))
+	log.Write(txnRecord(0x156, 3, 20, opSetData, new(juteWriter).string("/controller").
+		buffer([]byte("1")).int32(3).Bytes()))
+
+	check := new(juteWriter).string("/brokers/ids/1").int32(0).Bytes()
+	del := new(juteWriter).string("/brokers/ids/1").Bytes()
+	multi := new(juteWriter).int32(2).
+		int32(opCheck).buffer(check).
+		int32(opDelete).buffer(del)
+	log.Write(txnRecord(0x156, 4, 30, opMulti, multi.Bytes()))
+	log.Write(txnRecord(0x155, 5, 40, opCloseSession, nil))
+
+	// preallocated padding
+	log.Write(make([]byte, 64))
+	return log.Bytes()
+}
+
+func readAll(t *testing.T, b []byte) (txns []*Txn, truncated bool, err error) {
+	r, err := NewTxnLogReader(bytes.NewReader(b))
+	assert.Equal(t, nil, err)
+
+	for {
+		txn, err := r.Next()
+		if err == io.EOF {
+			return txns, r.Truncated, nil
+		}
+		if err != nil {
+			return txns, r.Truncated, err
+		}
+		txns = append(txns, txn)
+	}
+}
+
+func TestTxnLogReader(t *testing.T) {
+	txns, truncated, err := readAll(t, buildTxnLog())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, truncated)
+	assert.Equal(t, 5, len(txns))
+
+	assert.Equal(t, "createSession", txns[0].Type)
+	assert.Equal(t, int32(6000), txns[0].Timeout)
+
+	create := txns[1]
+	assert.Equal(t, "create", create.Type)
+	assert.Equal(t, int64(0x155), create.Session)
+	assert.Equal(t, int64(2), create.Zxid)
+	assert.Equal(t, "/brokers/ids/1", create.Path)
+	assert.Equal(t, `{"host":"k1"}`, string(create.Data))
+	assert.Equal(t, true, create.Ephemeral)
+	assert.Equal(t, []ACL{worldAll}, create.Acl)
+	assert.Equal(t, t0.Add(10*time.Millisecond).Unix(), create.Time.Unix())
+	assert.Equal(t, "world:anyone:rwcda", create.Acl[0].String())
+
+	assert.Equal(t, int32(3), txns[2].Version)
+
+	multi := txns[3]
+	assert.Equal(t, "multi", multi.Type)
+	assert.Equal(t, 2, len(multi.Ops))
+	assert.Equal(t, "delete", multi.Ops[1].Type)
+	assert.Equal(t, []string{"/brokers/ids/1", "/brokers/ids/1"}, multi.Paths())
+	assert.Equal(t, true, strings.Contains(multi.String(), "\n    delete /brokers/ids/1"))
+
+	assert.Equal(t, "closeSession", txns[4].Type)
+}
+
+func TestTxnLogBadChecksum(t *testing.T) {
+	b := buildTxnLog()
+	b[16+8+4+30] ^= 0xff // corrupt the 1st txn body
+
+	txns, _, err := readAll(t, b)
+	assert.Equal(t, 0, len(txns))
+	assert.Equal(t, "offset 16: checksum mismatch", err.Error())
+}
+
+func TestTxnLogTruncatedTail(t *testing.T) {
+	b := buildTxnLog()
+	txns, truncated, err := readAll(t, b[:len(b)-64-5])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, truncated)
+	assert.Equal(t, 4, len(txns))
+}
+
+func TestFilter(t *testing.T) {
+	txns, _, _ := readAll(t, buildTxnLog())
+	match := func(f Filter) (types []string) {
+		for _, txn := range txns {
+			if f.Match(txn) {
+				types = append(types, txn.Type)
+			}
+		}
+		return
+	}
+
+	assert.Equal(t, []string{"create", "multi"}, match(Filter{PathPrefix: "/brokers/ids"}))
+	assert.Equal(t, []string{"createSession", "create", "closeSession"}, match(Filter{Session: 0x155}))
+	assert.Equal(t, []string{"setData", "multi"}, match(Filter{
+		From: t0.Add(15 * time.Millisecond),
+		To:   t0.Add(35 * time.Millisecond),
+	}))
+}
+
+func TestReadSnapshot(t *testing.T) {
+	w := &juteWriter{}
+	w.int32(SnapshotMagic).int32(2).int64(0)
+	w.int32(1).int64(0x155).int32(6000) // sessions
+	w.int32(1).int64(1).acls(worldAll)  // acl cache
+	node := func(path string, data string, owner int64) {
+		w.string(path).buffer([]byte(data)).int64(1)
+		w.int64(2).int64(3).int64(t0.UnixNano() / 1e6).int64(t0.UnixNano() / 1e6).
+			int32(0).int32(0).int32(0).int64(owner).int64(2)
+	}
+	node("", "", 0)
+	node("/brokers", "", 0)
+	node("/brokers/ids/1", "k1", 0x155)
+	w.string("/")
+	b := append([]byte(nil), w.Bytes()...)
+	w.int64(int64(adler32.Checksum(b)))
+	w.string("/")
+
+	var nodes []*Node
+	snap, err := ReadSnapshot(bytes.NewReader(w.Bytes()), func(n *Node) {
+		nodes = append(nodes, n)
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, snap.Nodes)
+	assert.Equal(t, int32(6000), snap.Sessions[0x155])
+	assert.Equal(t, "/", nodes[0].Path)
+	assert.Equal(t, "k1", string(nodes[2].Data))
+	assert.Equal(t, []ACL{worldAll}, nodes[2].Acl)
+	assert.Equal(t, true, (&Filter{Session: 0x155}).MatchNode(nodes[2]))
+	assert.Equal(t, false, (&Filter{PathPrefix: "/controller"}).MatchNode(nodes[2]))
+
+	corrupted := w.Bytes()
+	corrupted[30] ^= 0xff
+	_, err = ReadSnapshot(bytes.NewReader(corrupted), nil)
+	assert.Equal(t, ErrBadChecksum, err)
+}
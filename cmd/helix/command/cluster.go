@@ -3,11 +3,13 @@ package command
 import (
 	"flag"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/funkygao/gafka/ctx"
 	"github.com/funkygao/go-helix"
 	"github.com/funkygao/gocli"
+	"github.com/ryanuber/columnize"
 )
 
 type Cluster struct {
@@ -46,16 +48,56 @@ func (this *Cluster) Run(args []string) (exitCode int) {
 		this.Ui.Info(fmt.Sprintf("%s dropped", drop))
 
 	default:
-		clusters, err := this.admin.Clusters()
+		this.displayClusters(zone)
+	}
+
+	return
+}
+
+func (this *Cluster) displayClusters(zone string) {
+	clusters, err := this.admin.Clusters()
+	must(err)
+	sort.Strings(clusters)
+
+	hzk := newHelixZk(zone)
+	defer hzk.Close()
+
+	lines := []string{"Cluster|Controller|Resources|Instances|Live"}
+	configs := make(map[string]*znRecord)
+	for _, c := range clusters {
+		resources, err := this.admin.Resources(c)
+		must(err)
+		instances, err := this.admin.Instances(c)
 		must(err)
-		for _, c := range clusters {
-			this.Ui.Output(c)
 
-			// TODO get cluster config
+		lines = append(lines, fmt.Sprintf("%s|%s|%d|%d|%d", c, this.admin.ControllerLeader(c),
+			len(resources), len(instances), len(hzk.liveInstances(c))))
+
+		cf, err := hzk.clusterConfig(c)
+		must(err)
+		if cf != nil && len(cf.SimpleFields) > 0 {
+			configs[c] = cf
 		}
 	}
+	this.Ui.Output(columnize.SimpleFormat(lines))
 
-	return
+	for _, c := range clusters {
+		cf, present := configs[c]
+		if !present {
+			continue
+		}
+
+		this.Ui.Output("")
+		this.Ui.Info(fmt.Sprintf("%s config", c))
+		keys := make([]string, 0, len(cf.SimpleFields))
+		for k := range cf.SimpleFields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			this.Ui.Output(fmt.Sprintf("    %s=%s", k, cf.SimpleFields[k]))
+		}
+	}
 }
 
 func (*Cluster) Synopsis() string {
@@ -4,11 +4,13 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 
 	"github.com/funkygao/gafka/ctx"
 	"github.com/funkygao/go-helix"
 	"github.com/funkygao/gocli"
+	"github.com/ryanuber/columnize"
 )
 
 type Node struct {
@@ -57,16 +59,47 @@ func (this *Node) Run(args []string) (exitCode int) {
 		must(this.admin.DropNode(cluster, node))
 
 	default:
-		instances, err := this.admin.Instances(cluster)
+		this.displayInstances(zone, cluster)
+	}
+
+	return
+}
+
+func (this *Node) displayInstances(zone, cluster string) {
+	instances, err := this.admin.Instances(cluster)
+	must(err)
+	sort.Strings(instances)
+
+	hzk := newHelixZk(zone)
+	defer hzk.Close()
+
+	live := hzk.liveInstances(cluster)
+	partitions := hzk.partitionsOfInstances(cluster)
+	lines := []string{"Instance|Host|Port|Enabled|Live|Partitions"}
+	for _, instance := range instances {
+		cf, err := hzk.instanceConfig(cluster, instance)
 		must(err)
-		for _, instance := range instances {
-			this.Ui.Output(instance)
 
-			// TODO display each instance info
+		var host, port, enabled string
+		if cf != nil {
+			host = cf.SimpleFields["HELIX_HOST"]
+			port = cf.SimpleFields["HELIX_PORT"]
+			enabled = cf.SimpleFields["HELIX_ENABLED"]
+		}
+
+		_, isLive := live[instance]
+
+		states := make([]string, 0, len(partitions[instance]))
+		for state, n := range partitions[instance] {
+			states = append(states, fmt.Sprintf("%s:%d", state, n))
 		}
+		sort.Strings(states)
+
+		lines = append(lines, fmt.Sprintf("%s|%s|%s|%s|%v|%s", instance, host, port, enabled,
+			isLive, strings.Join(states, " ")))
 	}
 
-	return
+	this.Ui.Output(columnize.SimpleFormat(lines))
 }
 
 func (*Node) Synopsis() string {
@@ -3,12 +3,14 @@ package command
 import (
 	"flag"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/funkygao/gafka/ctx"
 	"github.com/funkygao/go-helix"
 	"github.com/funkygao/gocli"
+	"github.com/ryanuber/columnize"
 )
 
 type Resource struct {
@@ -58,11 +60,29 @@ func (this *Resource) Run(args []string) (exitCode int) {
 		must(this.admin.DropResource(this.cluster, drop))
 
 	case scale != "":
-		// TODO
+		tuple := strings.SplitN(scale, ":", 2)
+		if len(tuple) != 2 {
+			this.Ui.Error("-scale resource:partition")
+			return 2
+		}
+		partitions, err := strconv.Atoi(tuple[1])
+		if err != nil || partitions <= 0 {
+			this.Ui.Error(fmt.Sprintf("invalid partitions: %s", tuple[1]))
+			return 2
+		}
+		this.scale(zone, tuple[0], partitions)
+		this.Ui.Info(fmt.Sprintf("%s scaled to %d partitions", tuple[0], partitions))
 
 	default:
 		resources, err := this.admin.Resources(this.cluster)
 		must(err)
+		sort.Strings(resources)
+
+		var hzk *helixZk
+		if verbose {
+			hzk = newHelixZk(zone)
+			defer hzk.Close()
+		}
 
 		for _, resource := range resources {
 			is, err := this.admin.ResourceIdealState(this.cluster, resource)
@@ -71,15 +91,79 @@ func (this *Resource) Run(args []string) (exitCode int) {
 			this.Ui.Info(fmt.Sprintf("%s[%s]", resource, is.StateModelDefRef()))
 
 			if verbose {
-				this.Ui.Output(fmt.Sprintf("%+v", is))
+				this.displayExternalView(hzk, resource)
 			}
-
 		}
 	}
 
 	return
 }
 
+// displayExternalView shows the current state of each partition on instances.
+func (this *Resource) displayExternalView(hzk *helixZk, resource string) {
+	ev, err := hzk.externalView(this.cluster, resource)
+	must(err)
+	if ev == nil {
+		this.Ui.Output("    no external view")
+		return
+	}
+
+	partitions := make([]string, 0, len(ev.MapFields))
+	for p := range ev.MapFields {
+		partitions = append(partitions, p)
+	}
+	sort.Strings(partitions)
+
+	lines := []string{"Partition|Instance|State"}
+	for _, p := range partitions {
+		instances := make([]string, 0, len(ev.MapFields[p]))
+		for instance := range ev.MapFields[p] {
+			instances = append(instances, instance)
+		}
+		sort.Strings(instances)
+
+		for _, instance := range instances {
+			lines = append(lines, fmt.Sprintf("%s|%s|%s", p, instance, ev.MapFields[p][instance]))
+		}
+	}
+	this.Ui.Output(columnize.SimpleFormat(lines))
+}
+
+// scale changes the partition count in the ideal state and triggers
+// rebalance so that the controller assigns the new partitions.
+func (this *Resource) scale(zone, resource string, partitions int) {
+	hzk := newHelixZk(zone)
+	defer hzk.Close()
+
+	path := hzk.idealStatePath(this.cluster, resource)
+	is, err := hzk.record(path)
+	must(err)
+	if is == nil {
+		panic(fmt.Sprintf("resource %s not found", resource))
+	}
+
+	is.SimpleFields["NUM_PARTITIONS"] = strconv.Itoa(partitions)
+
+	// remove the shrinked partitions of SEMI_AUTO/CUSTOMIZED mode
+	for p := range is.MapFields {
+		if id, err := strconv.Atoi(p[strings.LastIndex(p, "_")+1:]); err == nil && id >= partitions {
+			delete(is.MapFields, p)
+		}
+	}
+	for p := range is.ListFields {
+		if id, err := strconv.Atoi(p[strings.LastIndex(p, "_")+1:]); err == nil && id >= partitions {
+			delete(is.ListFields, p)
+		}
+	}
+	must(hzk.setRecord(path, is))
+
+	replicas, err := strconv.Atoi(is.SimpleFields["REPLICAS"])
+	if err != nil {
+		replicas = 1
+	}
+	must(this.admin.Rebalance(this.cluster, resource, replicas))
+}
+
 func (*Resource) Synopsis() string {
 	return "Resources management of a cluster"
 }
@@ -97,7 +181,7 @@ Options:
     -c cluster
 
     -v
-      Verbose
+      Verbose, show the state of each partition on instances.
 
     -add resource:partition:stateModel
 
@@ -0,0 +1,131 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/funkygao/gafka/ctx"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// znRecord is how helix persists everything in zk.
+type znRecord struct {
+	Id           string                       `json:"id"`
+	SimpleFields map[string]string            `json:"simpleFields"`
+	ListFields   map[string][]string          `json:"listFields"`
+	MapFields    map[string]map[string]string `json:"mapFields"`
+}
+
+// helixZk reads the helix cluster layout directly from zk for the info
+// helix admin doesn't expose.
+type helixZk struct {
+	conn   *zk.Conn
+	chroot string
+}
+
+// zk_helix of a zone is e,g. localhost:2181/helix
+func newHelixZk(zone string) *helixZk {
+	zkSvr := ctx.Zone(zone).ZkHelix
+	var chroot string
+	if idx := strings.Index(zkSvr, "/"); idx != -1 {
+		zkSvr, chroot = zkSvr[:idx], zkSvr[idx:]
+	}
+
+	conn, _, err := zk.Connect(strings.Split(zkSvr, ","), time.Second*30)
+	must(err)
+	return &helixZk{conn: conn, chroot: chroot}
+}
+
+func (this *helixZk) Close() {
+	this.conn.Close()
+}
+
+func (this *helixZk) path(cluster string, elems ...string) string {
+	return fmt.Sprintf("%s/%s/%s", this.chroot, cluster, strings.Join(elems, "/"))
+}
+
+// record returns nil if the znode does not exist.
+func (this *helixZk) record(path string) (*znRecord, error) {
+	data, _, err := this.conn.Get(path)
+	if err == zk.ErrNoNode {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := &znRecord{}
+	return r, json.Unmarshal(data, r)
+}
+
+func (this *helixZk) setRecord(path string, r *znRecord) error {
+	_, stat, err := this.conn.Get(path)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = this.conn.Set(path, data, stat.Version)
+	return err
+}
+
+func (this *helixZk) children(path string) []string {
+	children, _, err := this.conn.Children(path)
+	if err != nil && err != zk.ErrNoNode {
+		must(err)
+	}
+	return children
+}
+
+func (this *helixZk) clusterConfig(cluster string) (*znRecord, error) {
+	return this.record(this.path(cluster, "CONFIGS", "CLUSTER", cluster))
+}
+
+func (this *helixZk) liveInstances(cluster string) map[string]struct{} {
+	r := make(map[string]struct{})
+	for _, instance := range this.children(this.path(cluster, "LIVEINSTANCES")) {
+		r[instance] = struct{}{}
+	}
+	return r
+}
+
+func (this *helixZk) instanceConfig(cluster, instance string) (*znRecord, error) {
+	return this.record(this.path(cluster, "CONFIGS", "PARTICIPANT", instance))
+}
+
+func (this *helixZk) externalView(cluster, resource string) (*znRecord, error) {
+	return this.record(this.path(cluster, "EXTERNALVIEW", resource))
+}
+
+func (this *helixZk) idealStatePath(cluster, resource string) string {
+	return this.path(cluster, "IDEALSTATES", resource)
+}
+
+// partitionsOfInstances returns {instance: {state: partitions count}}
+// according to the external view of all resources.
+func (this *helixZk) partitionsOfInstances(cluster string) map[string]map[string]int {
+	r := make(map[string]map[string]int)
+	for _, resource := range this.children(this.path(cluster, "EXTERNALVIEW")) {
+		ev, err := this.externalView(cluster, resource)
+		must(err)
+		if ev == nil {
+			continue
+		}
+
+		for _, states := range ev.MapFields {
+			for instance, state := range states {
+				if _, present := r[instance]; !present {
+					r[instance] = make(map[string]int)
+				}
+				r[instance][state]++
+			}
+		}
+	}
+	return r
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -17,6 +19,7 @@ type PubOption struct {
 	Async      bool
 	AckAll     bool
 	Tag        string
+	MsgId      string // producer message id for idempotent pub
 }
 
 // Pub publish a keyed message to specified versioned topic.
@@ -52,6 +55,9 @@ func (this *Client) Pub(key string, msg []byte, opt PubOption) (err error) {
 	if opt.Tag != "" {
 		req.Header.Set(gateway.HttpHeaderMsgTag, opt.Tag)
 	}
+	if opt.MsgId != "" {
+		req.Header.Set(gateway.HttpHeaderMsgId, opt.MsgId)
+	}
 
 	var response *http.Response
 	response, err = this.pubConn.Do(req)
@@ -84,3 +90,63 @@ func (this *Client) Pub(key string, msg []byte, opt PubOption) (err error) {
 
 	return nil
 }
+
+type PubBatchResult struct {
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Hinted    bool   `json:"hh"`
+	Error     string `json:"error"`
+}
+
+// PubBatch publish messages to specified versioned topic in one request, each
+// message has its own result in the same order.
+func (this *Client) PubBatch(msgs []gateway.PubMessage, opt PubOption) ([]PubBatchResult, error) {
+	var u url.URL
+	u.Scheme = this.cf.Pub.Scheme
+	u.Host = this.cf.Pub.Endpoint
+	u.Path = fmt.Sprintf("/v1/msgs/%s/%s/batch", opt.Topic, opt.Ver)
+	q := u.Query()
+	if opt.AckAll {
+		q.Set("ack", "all")
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(gateway.EncodePubBatch(msgs)))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(gateway.HttpHeaderAppid, this.cf.AppId)
+	req.Header.Set(gateway.HttpHeaderPubkey, this.cf.Secret)
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	response, err := this.pubConn.Do(req)
+	if response != nil {
+		// reuse the connection
+		defer response.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusMultiStatus {
+		return nil, errors.New(string(b))
+	}
+
+	if this.cf.Debug {
+		log.Printf("--> [%s] %s", response.Status, string(b))
+	}
+
+	var r struct {
+		Results []PubBatchResult `json:"results"`
+	}
+	if err = json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	return r.Results, nil
+}
@@ -1,12 +1,16 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/funkygao/gafka/cmd/kateway/gateway"
 	"github.com/funkygao/gafka/sla"
@@ -22,7 +26,12 @@ type SubOption struct {
 	Shadow     string
 	Wait       string
 	Tag        string // tag filter
+	Filter     string // filter expression over msg headers and json fields
 	AutoClose  bool
+
+	// VisibilityTimeout enables per message ack by AckMsgs, msgs not acked
+	// in time are redelivered.
+	VisibilityTimeout time.Duration
 }
 
 type SubHandler func(statusCode int, msg []byte) error
@@ -46,6 +55,12 @@ func (this *Client) Sub(opt SubOption, h SubHandler) error {
 	if opt.Wait != "" {
 		q.Set("wait", opt.Wait)
 	}
+	if opt.Filter != "" {
+		q.Set("filter", opt.Filter)
+	}
+	if opt.VisibilityTimeout > 0 {
+		q.Set("vt", strconv.Itoa(int(opt.VisibilityTimeout/time.Second)))
+	}
 	u.RawQuery = q.Encode()
 	req, err := http.NewRequest("GET", u.String(), nil)
 	if err != nil {
@@ -73,9 +88,10 @@ func (this *Client) Sub(opt SubOption, h SubHandler) error {
 
 		if this.cf.Debug {
 			log.Printf("--> [%s]", response.Status)
-			log.Printf("Partition:%s Offset:%s",
+			log.Printf("Partition:%s Offset:%s Delivery:%s",
 				response.Header.Get("X-Partition"),
-				response.Header.Get("X-Offset"))
+				response.Header.Get("X-Offset"),
+				response.Header.Get(gateway.HttpHeaderDeliveryCount))
 		}
 
 		// reuse the connection
@@ -125,6 +141,9 @@ func (this *Client) SubX(opt SubOption, h SubXHandler) error {
 	if opt.Wait != "" {
 		q.Set("wait", opt.Wait)
 	}
+	if opt.Filter != "" {
+		q.Set("filter", opt.Filter)
+	}
 	u.RawQuery = q.Encode()
 
 	req := gorequest.New()
@@ -177,3 +196,55 @@ func (this *Client) SubX(opt SubOption, h SubXHandler) error {
 	}
 
 }
+
+// AckMsg is a msg got from Sub with VisibilityTimeout.
+type AckMsg struct {
+	Partition int   `json:"partition"`
+	Offset    int64 `json:"offset"`
+}
+
+// AckMsgs acks msgs got from Sub with VisibilityTimeout, katewayId is the
+// X-Kateway-Id header of the sub response.
+func (this *Client) AckMsgs(opt SubOption, katewayId string, msgs []AckMsg) error {
+	var u url.URL
+	u.Scheme = this.cf.Sub.Scheme
+	u.Host = this.cf.Sub.Endpoint
+	u.Path = fmt.Sprintf("/v1/acks/%s/%s/%s/%s", opt.AppId, opt.Topic, opt.Ver, opt.Group)
+	if opt.Shadow != "" && sla.ValidateShadowName(opt.Shadow) {
+		q := u.Query()
+		q.Set("q", opt.Shadow)
+		u.RawQuery = q.Encode()
+	}
+
+	body, err := json.Marshal(msgs)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(gateway.HttpHeaderAppid, this.cf.AppId)
+	req.Header.Set(gateway.HttpHeaderSubkey, this.cf.Secret)
+	req.Header.Set(gateway.HttpHeaderKatewayId, katewayId)
+	response, err := this.subConn.Do(req)
+	if err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	// reuse the connection
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New(string(b))
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+// Package dedup provides the dedup window of producer message ids for
+// idempotent Pub.
+//
+// Kateway retries, load balancer redispatch and hinted handoff can all
+// produce duplicated messages. A producer that stamps each message with an id
+// gets the original partition/offset when the same id is published again
+// within the window.
+package dedup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+	log "github.com/funkygao/log4go"
+)
+
+// PendingTTL bounds how long an in flight pub holds its id, in case kateway
+// crashes before recording the result.
+const PendingTTL = time.Second * 30
+
+type State byte
+
+const (
+	// Pending means a pub of the id is in flight.
+	Pending State = 'p'
+
+	// Accepted means the msg is accepted by async pub or hinted handoff, its
+	// partition/offset is unknown yet.
+	Accepted State = 'a'
+
+	// Delivered means the msg is written to partition/offset.
+	Delivered State = 'd'
+)
+
+// Result is what the window remembers for a message id.
+type Result struct {
+	State     State
+	Partition int32
+	Offset    int64
+}
+
+// String encodes the result for backends that store strings.
+func (this Result) String() string {
+	if this.State != Delivered {
+		return string(this.State)
+	}
+
+	return fmt.Sprintf("%c:%d:%d", this.State, this.Partition, this.Offset)
+}
+
+// ParseResult decodes the output of Result.String.
+func ParseResult(s string) (r Result, err error) {
+	switch {
+	case s == string(Pending), s == string(Accepted):
+		r.State = State(s[0])
+		return
+
+	case strings.HasPrefix(s, string(Delivered)+":"):
+		parts := strings.SplitN(s, ":", 3)
+		if len(parts) != 3 {
+			break
+		}
+
+		var p int64
+		if p, err = strconv.ParseInt(parts[1], 10, 32); err != nil {
+			return
+		}
+		if r.Offset, err = strconv.ParseInt(parts[2], 10, 64); err != nil {
+			return
+		}
+		r.State, r.Partition = Delivered, int32(p)
+		return
+	}
+
+	return r, ErrInvalidResult
+}
+
+// Window is a bounded and ttl limited memory of recent message ids.
+type Window interface {
+	// Name returns the underlying implementation name.
+	Name() string
+
+	Start() error
+	Stop()
+
+	// Reserve atomically reserves the key as Pending if it is not in the
+	// window, otherwise returns what was remembered for it.
+	Reserve(key string) (r Result, reserved bool, err error)
+
+	// Lookup returns what was remembered for the key without reserving it.
+	Lookup(key string) (r Result, found bool, err error)
+
+	// Record remembers the result of the key and renews its ttl.
+	Record(key string, r Result) error
+
+	// Release forgets the key so that the failed pub can be retried.
+	Release(key string) error
+}
+
+var Default Window
+
+// Key is the window key of a message id, ids are scoped by kafka topic.
+func Key(topic, msgId string) string {
+	return topic + "/" + msgId
+}
+
+// msgIdOf returns the producer msg id in envelope headers of the msg.
+func msgIdOf(msg []byte) (string, bool) {
+	if Default == nil || !envelope.IsEnveloped(msg) {
+		return "", false
+	}
+
+	headers, _, err := envelope.Open(msg)
+	if err != nil {
+		return "", false
+	}
+
+	return headers.Get(envelope.MsgIdKey)
+}
+
+// RecordDelivered remembers the partition/offset of a msg that carries its id
+// in envelope headers, so that hinted handoff pumps preserve the id.
+func RecordDelivered(topic string, msg []byte, partition int32, offset int64) {
+	msgId, present := msgIdOf(msg)
+	if !present {
+		return
+	}
+
+	if err := Default.Record(Key(topic, msgId), Result{State: Delivered, Partition: partition, Offset: offset}); err != nil {
+		log.Error("dedup[%s] %s/%s: %v", Default.Name(), topic, msgId, err)
+	}
+}
+
+// IsDelivered checks if a msg that carries its id in envelope headers was
+// already delivered, e,g. the producer retried the pub after it was handed
+// off, so that hinted handoff pumps don't deliver it twice.
+// The msg is delivered again if the window fails to tell: at least once.
+func IsDelivered(topic string, msg []byte) bool {
+	msgId, present := msgIdOf(msg)
+	if !present {
+		return false
+	}
+
+	r, found, err := Default.Lookup(Key(topic, msgId))
+	if err != nil {
+		log.Error("dedup[%s] %s/%s: %v", Default.Name(), topic, msgId, err)
+		return false
+	}
+
+	return found && r.State == Delivered
+}
@@ -0,0 +1,24 @@
+package dedup
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestResultStringAndParse(t *testing.T) {
+	for _, r := range []Result{
+		{State: Pending},
+		{State: Accepted},
+		{State: Delivered, Partition: 3, Offset: 1234567890123},
+	} {
+		r1, err := ParseResult(r.String())
+		assert.Equal(t, nil, err)
+		assert.Equal(t, r, r1)
+	}
+
+	for _, s := range []string{"", "x", "d:1", "d:a:1", "d:1:b"} {
+		_, err := ParseResult(s)
+		assert.Equal(t, true, err != nil)
+	}
+}
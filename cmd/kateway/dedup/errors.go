@@ -0,0 +1,9 @@
+package dedup
+
+import (
+	"errors"
+)
+
+var (
+	ErrInvalidResult = errors.New("invalid dedup result")
+)
@@ -0,0 +1,117 @@
+// Package mem is a dedup window in memory of a single kateway node.
+package mem
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+)
+
+type entry struct {
+	key     string
+	r       dedup.Result
+	expires time.Time
+}
+
+// window is a LRU of message ids with ttl.
+type window struct {
+	ttl      time.Duration
+	capacity int
+
+	mu    sync.Mutex
+	lru   *list.List // front is the most recent
+	items map[string]*list.Element
+}
+
+func New(capacity int, ttl time.Duration) dedup.Window {
+	return &window{
+		ttl:      ttl,
+		capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element, capacity),
+	}
+}
+
+func (this *window) Name() string {
+	return "mem"
+}
+
+func (this *window) Start() error {
+	return nil
+}
+
+func (this *window) Stop() {}
+
+func (this *window) Reserve(key string) (r dedup.Result, reserved bool, err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if el, present := this.items[key]; present {
+		e := el.Value.(*entry)
+		if time.Now().Before(e.expires) {
+			return e.r, false, nil
+		}
+
+		this.remove(el)
+	}
+
+	r = dedup.Result{State: dedup.Pending}
+	this.put(key, r)
+	return r, true, nil
+}
+
+func (this *window) Lookup(key string) (r dedup.Result, found bool, err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if el, present := this.items[key]; present {
+		e := el.Value.(*entry)
+		if time.Now().Before(e.expires) {
+			return e.r, true, nil
+		}
+
+		this.remove(el)
+	}
+
+	return
+}
+
+func (this *window) Record(key string, r dedup.Result) error {
+	this.mu.Lock()
+	if el, present := this.items[key]; present {
+		this.remove(el)
+	}
+	this.put(key, r)
+	this.mu.Unlock()
+	return nil
+}
+
+func (this *window) Release(key string) error {
+	this.mu.Lock()
+	if el, present := this.items[key]; present {
+		this.remove(el)
+	}
+	this.mu.Unlock()
+	return nil
+}
+
+func (this *window) put(key string, r dedup.Result) {
+	ttl := this.ttl
+	if r.State == dedup.Pending && ttl > dedup.PendingTTL {
+		ttl = dedup.PendingTTL
+	}
+
+	this.items[key] = this.lru.PushFront(&entry{key: key, r: r, expires: time.Now().Add(ttl)})
+
+	// evict the least recent ones
+	for this.lru.Len() > this.capacity {
+		this.remove(this.lru.Back())
+	}
+}
+
+func (this *window) remove(el *list.Element) {
+	this.lru.Remove(el)
+	delete(this.items, el.Value.(*entry).key)
+}
@@ -0,0 +1,54 @@
+package mem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+)
+
+func TestReserveAndRecord(t *testing.T) {
+	w := New(2, time.Minute)
+	r, reserved, err := w.Reserve("a")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, reserved)
+	assert.Equal(t, dedup.Pending, r.State)
+
+	// in flight
+	r, reserved, _ = w.Reserve("a")
+	assert.Equal(t, false, reserved)
+	assert.Equal(t, dedup.Pending, r.State)
+
+	w.Record("a", dedup.Result{State: dedup.Delivered, Partition: 1, Offset: 10})
+	r, reserved, _ = w.Reserve("a")
+	assert.Equal(t, false, reserved)
+	assert.Equal(t, dedup.Result{State: dedup.Delivered, Partition: 1, Offset: 10}, r)
+
+	r, found, err := w.Lookup("a")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, found)
+	assert.Equal(t, dedup.Delivered, r.State)
+	_, found, _ = w.Lookup("b")
+	assert.Equal(t, false, found)
+
+	// failed pub can be retried
+	w.Release("a")
+	_, reserved, _ = w.Reserve("a")
+	assert.Equal(t, true, reserved)
+}
+
+func TestBoundedAndExpires(t *testing.T) {
+	w := New(2, time.Millisecond*10)
+	w.Reserve("a")
+	w.Reserve("b")
+	w.Reserve("c") // evicts a
+	_, reserved, _ := w.Reserve("a")
+	assert.Equal(t, true, reserved)
+	_, reserved, _ = w.Reserve("c")
+	assert.Equal(t, false, reserved)
+
+	time.Sleep(time.Millisecond * 20)
+	_, reserved, _ = w.Reserve("c")
+	assert.Equal(t, true, reserved)
+}
@@ -0,0 +1,139 @@
+// Package redis is a dedup window in redis shared by all kateway instances.
+package redis
+
+import (
+	"time"
+
+	"github.com/funkygao/Go-Redis"
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+	"github.com/funkygao/gafka/cmd/kateway/redispool"
+)
+
+const keyPrefix = "kw.dedup."
+
+type window struct {
+	ttl time.Duration
+
+	pool *redispool.Pool
+}
+
+func New(addr string, poolSize int, ttl time.Duration) dedup.Window {
+	return &window{
+		ttl:  ttl,
+		pool: redispool.New(addr, poolSize),
+	}
+}
+
+func (this *window) Name() string {
+	return "redis"
+}
+
+func (this *window) Start() error {
+	// fail fast if redis is not reachable
+	return this.pool.Ping()
+}
+
+func (this *window) Stop() {
+	this.pool.Close()
+}
+
+func (this *window) Reserve(key string) (r dedup.Result, reserved bool, err error) {
+	c, err := this.pool.Get()
+	if err != nil {
+		return
+	}
+
+	k := keyPrefix + key
+	pending := dedup.Result{State: dedup.Pending}
+	if reserved, err = c.Setnx(k, []byte(pending.String())); err != nil {
+		this.pool.Discard(c)
+		return
+	}
+
+	if reserved {
+		if err = this.expire(c, k, dedup.PendingTTL); err != nil {
+			// never leave a key without ttl behind
+			c.Del(k)
+			this.pool.Discard(c)
+			return r, false, err
+		}
+
+		this.pool.Put(c)
+		return pending, true, nil
+	}
+
+	b, err := c.Get(k)
+	if err != nil {
+		this.pool.Discard(c)
+		return
+	}
+	this.pool.Put(c)
+
+	if len(b) == 0 {
+		// expired between Setnx and Get, treat it as in flight
+		return pending, false, nil
+	}
+
+	r, err = dedup.ParseResult(string(b))
+	return
+}
+
+func (this *window) Lookup(key string) (r dedup.Result, found bool, err error) {
+	c, err := this.pool.Get()
+	if err != nil {
+		return
+	}
+
+	b, err := c.Get(keyPrefix + key)
+	if err != nil {
+		this.pool.Discard(c)
+		return
+	}
+	this.pool.Put(c)
+
+	if len(b) == 0 {
+		return
+	}
+
+	r, err = dedup.ParseResult(string(b))
+	return r, err == nil, err
+}
+
+func (this *window) Record(key string, r dedup.Result) error {
+	c, err := this.pool.Get()
+	if err != nil {
+		return err
+	}
+
+	k := keyPrefix + key
+	if err = c.Set(k, []byte(r.String())); err == nil {
+		err = this.expire(c, k, this.ttl)
+	}
+	if err != nil {
+		this.pool.Discard(c)
+		return err
+	}
+
+	this.pool.Put(c)
+	return nil
+}
+
+func (this *window) Release(key string) error {
+	c, err := this.pool.Get()
+	if err != nil {
+		return err
+	}
+
+	if _, err = c.Del(keyPrefix + key); err != nil {
+		this.pool.Discard(c)
+		return err
+	}
+
+	this.pool.Put(c)
+	return nil
+}
+
+func (this *window) expire(c redis.Client, key string, ttl time.Duration) error {
+	_, err := c.Expire(key, int64(ttl/time.Second))
+	return err
+}
@@ -0,0 +1,183 @@
+// Package envelope is the on-wire format of pubsub messages stored in kafka.
+//
+// An enveloped message carries versioned headers ahead of the payload so that
+// kateway can route and filter messages without understanding the payload:
+//
+//	┌─────┬─────┬───────┬────────┬──────────────────────────┐ ┌────────┐
+//	│Magic│Magic│Version│N uvarint│N * (Key Value) len-prefix│ │Payload │
+//	└─────┴─────┴───────┴────────┴──────────────────────────┘ └────────┘
+//
+// The leading zero byte never starts a valid protobuf message(field number 0
+// is reserved) nor a json/text message. But a binary payload can start with
+// the magic, e,g. an Avro record whose 1st field is zigzag 0 followed by 'K',
+// so pub envelopes such payloads even without headers, see IsAmbiguous.
+package envelope
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+const (
+	Magic0   = byte(0)
+	Magic1   = byte('K')
+	Version1 = byte(1)
+
+	// CurrentVersion is the version new messages are enveloped with.
+	CurrentVersion = Version1
+
+	fixedLen = 3 // Magic0 Magic1 Version
+
+	// legacy in-band tag format: TagMarkStart tag TagMarkEnd Message
+	legacyTagMarkStart = byte(1)
+	legacyTagMarkEnd   = byte(2)
+
+	TagSeperator = ";" // follow cookie rules a=b;c=d
+)
+
+// IsEnveloped checks whether the message starts with the envelope magic.
+// A plain binary payload might start with the magic too, it's enveloped only
+// if it was published after IsAmbiguous was guarded on pub.
+func IsEnveloped(msg []byte) bool {
+	return len(msg) >= fixedLen && msg[0] == Magic0 && msg[1] == Magic1
+}
+
+// IsAmbiguous checks whether a payload without headers would be misread by
+// Open as enveloped or legacy tagged, such payloads must be wrapped with no
+// headers before being stored.
+func IsAmbiguous(payload []byte) bool {
+	return IsEnveloped(payload) || (len(payload) > 0 && payload[0] == legacyTagMarkStart)
+}
+
+// Len returns the encoded length of the envelope prefix of the headers.
+func Len(headers Headers) int {
+	n := fixedLen + uvarintLen(uint64(len(headers)))
+	for _, h := range headers {
+		n += uvarintLen(uint64(len(h.Key))) + len(h.Key)
+		n += uvarintLen(uint64(len(h.Value))) + len(h.Value)
+	}
+	return n
+}
+
+// Encode writes the envelope prefix of headers into buf and returns the
+// number of bytes written. buf must be at least Len(headers) long, the
+// payload follows at buf[n:].
+func Encode(buf []byte, headers Headers) int {
+	buf[0] = Magic0
+	buf[1] = Magic1
+	buf[2] = CurrentVersion
+	n := fixedLen
+	n += binary.PutUvarint(buf[n:], uint64(len(headers)))
+	for _, h := range headers {
+		n += binary.PutUvarint(buf[n:], uint64(len(h.Key)))
+		n += copy(buf[n:], h.Key)
+		n += binary.PutUvarint(buf[n:], uint64(len(h.Value)))
+		n += copy(buf[n:], h.Value)
+	}
+	return n
+}
+
+// Wrap returns a new enveloped message of headers and payload.
+func Wrap(headers Headers, payload []byte) []byte {
+	buf := make([]byte, Len(headers)+len(payload))
+	n := Encode(buf, headers)
+	copy(buf[n:], payload)
+	return buf
+}
+
+// Open decodes the headers of a message and returns the index where the
+// payload starts.
+// Messages written before envelope was introduced are also recognized: plain
+// messages have no headers and legacy tagged messages have their tags as headers.
+func Open(msg []byte) (headers Headers, bodyIdx int, err error) {
+	switch {
+	case IsEnveloped(msg):
+		return decode(msg)
+
+	case len(msg) > 0 && msg[0] == legacyTagMarkStart:
+		return openLegacy(msg)
+
+	default:
+		return nil, 0, nil
+	}
+}
+
+func decode(msg []byte) (Headers, int, error) {
+	if msg[2] != Version1 {
+		return nil, 0, ErrUnknownVersion
+	}
+
+	i := fixedLen
+	count, n := binary.Uvarint(msg[i:])
+	if n <= 0 || count > uint64(len(msg)) {
+		return nil, 0, ErrCorruptEnvelope
+	}
+	i += n
+
+	headers := make(Headers, 0, count)
+	for j := uint64(0); j < count; j++ {
+		key, n := readString(msg[i:])
+		if n <= 0 {
+			return nil, 0, ErrCorruptEnvelope
+		}
+		i += n
+
+		val, n := readString(msg[i:])
+		if n <= 0 {
+			return nil, 0, ErrCorruptEnvelope
+		}
+		i += n
+
+		headers = append(headers, Header{Key: key, Value: val})
+	}
+
+	return headers, i, nil
+}
+
+func openLegacy(msg []byte) (Headers, int, error) {
+	for i := 1; i < len(msg); i++ {
+		if msg[i] == legacyTagMarkEnd {
+			return ParseTag(string(msg[1:i])), i + 1, nil
+		}
+	}
+
+	return nil, 0, ErrCorruptEnvelope
+}
+
+// readString reads a uvarint length prefixed string, n <= 0 if buf is malformed.
+func readString(buf []byte) (string, int) {
+	l, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < l {
+		return "", 0
+	}
+
+	return string(buf[n : n+int(l)]), n + int(l)
+}
+
+func uvarintLen(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
+
+// ParseTag converts the X-Tag http header into headers.
+// Each tag is either key=value or a bare key with empty value.
+func ParseTag(tag string) Headers {
+	var headers Headers
+	for _, t := range strings.Split(tag, TagSeperator) {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+
+		if eq := strings.IndexByte(t, '='); eq > 0 {
+			headers = append(headers, Header{Key: t[:eq], Value: t[eq+1:]})
+		} else {
+			headers = append(headers, Header{Key: t})
+		}
+	}
+	return headers
+}
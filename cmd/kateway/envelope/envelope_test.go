@@ -0,0 +1,96 @@
+package envelope
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestWrapAndOpen(t *testing.T) {
+	headers := Headers{{"region", "cn"}, {"vip", ""}, {"schema", "abc"}}
+	payload := []byte(`{"amount":120}`)
+	msg := Wrap(headers, payload)
+	assert.Equal(t, true, IsEnveloped(msg))
+	assert.Equal(t, Len(headers)+len(payload), len(msg))
+
+	h, i, err := Open(msg)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, string(payload), string(msg[i:]))
+	assert.Equal(t, 3, len(h))
+	v, present := h.Get("region")
+	assert.Equal(t, true, present)
+	assert.Equal(t, "cn", v)
+	_, present = h.Get("foo")
+	assert.Equal(t, false, present)
+	assert.Equal(t, "region=cn;vip;schema=abc", h.String())
+
+	// empty payload and headers
+	h, i, err = Open(Wrap(nil, nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(h))
+	assert.Equal(t, Len(nil), i)
+}
+
+func TestOpenPlainAndLegacy(t *testing.T) {
+	h, i, err := Open([]byte("hello"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, i)
+	assert.Equal(t, 0, len(h))
+
+	// a protobuf message can start with the legacy tag mark, but never with 0
+	legacy := append([]byte{legacyTagMarkStart}, []byte("a=b;c;")...)
+	legacy = append(legacy, legacyTagMarkEnd)
+	legacy = append(legacy, []byte("hello")...)
+	h, i, err = Open(legacy)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "hello", string(legacy[i:]))
+	assert.Equal(t, []string{"a=b", "c"}, h.Tags())
+
+	_, _, err = Open([]byte{legacyTagMarkStart, 'a'})
+	assert.Equal(t, ErrCorruptEnvelope, err)
+}
+
+func TestIsAmbiguous(t *testing.T) {
+	assert.Equal(t, false, IsAmbiguous(nil))
+	assert.Equal(t, false, IsAmbiguous([]byte(`{"amount":120}`)))
+	assert.Equal(t, true, IsAmbiguous([]byte{legacyTagMarkStart, 'a'}))
+
+	// avro record: long 0 as zigzag, string "KK"
+	avro := []byte{0x00, 0x04, 'K', 'K'}
+	assert.Equal(t, false, IsAmbiguous(avro))
+	avro = []byte{0x00, 'K', 0x01, 0x00, 0x02}
+	assert.Equal(t, true, IsAmbiguous(avro))
+
+	// wrapped with no headers, the payload is intact
+	h, i, err := Open(Wrap(nil, avro))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(h))
+	assert.Equal(t, avro, Wrap(nil, avro)[i:])
+}
+
+func TestOpenCorrupt(t *testing.T) {
+	msg := Wrap(Headers{{"region", "cn"}}, []byte("x"))
+	for n := fixedLen; n < Len(Headers{{"region", "cn"}}); n++ {
+		_, _, err := Open(msg[:n])
+		assert.Equal(t, ErrCorruptEnvelope, err)
+	}
+
+	msg[2] = 9
+	_, _, err := Open(msg)
+	assert.Equal(t, ErrUnknownVersion, err)
+}
+
+func TestParseTag(t *testing.T) {
+	h := ParseTag("a=b; c ;;d=e=f;")
+	assert.Equal(t, Headers{{"a", "b"}, {"c", ""}, {"d", "e=f"}}, h)
+	assert.Equal(t, 0, len(ParseTag("")))
+}
+
+func BenchmarkOpen(b *testing.B) {
+	b.ReportAllocs()
+	msg := Wrap(Headers{{"region", "cn"}, {"schema", "abc"}}, make([]byte, 900))
+	for i := 0; i < b.N; i++ {
+		Open(msg)
+	}
+	b.SetBytes(int64(len(msg)))
+}
@@ -0,0 +1,10 @@
+package envelope
+
+import (
+	"errors"
+)
+
+var (
+	ErrCorruptEnvelope = errors.New("corrupt message envelope")
+	ErrUnknownVersion  = errors.New("unknown message envelope version")
+)
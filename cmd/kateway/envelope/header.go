@@ -0,0 +1,50 @@
+package envelope
+
+import (
+	"strings"
+)
+
+const (
+	// MsgIdKey is the header of the producer message id for idempotent pub.
+	MsgIdKey = "msgid"
+
+	// OriginKey is the header of the zone where a message replicated from.
+	// Messages without it are published in the local zone.
+	OriginKey = "_origin"
+)
+
+// Header is a key value pair carried in the envelope, keys are case sensitive.
+type Header struct {
+	Key, Value string
+}
+
+// Headers keeps the order in which they were published.
+type Headers []Header
+
+// Get returns the value of the first header with the key.
+func (this Headers) Get(key string) (string, bool) {
+	for _, h := range this {
+		if h.Key == key {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
+
+// Tags renders the headers back into tags: key=value or bare key.
+func (this Headers) Tags() []string {
+	tags := make([]string, 0, len(this))
+	for _, h := range this {
+		if h.Value == "" {
+			tags = append(tags, h.Key)
+		} else {
+			tags = append(tags, h.Key+"="+h.Value)
+		}
+	}
+	return tags
+}
+
+// String renders the headers in the X-Tag http header format.
+func (this Headers) String() string {
+	return strings.Join(this.Tags(), TagSeperator)
+}
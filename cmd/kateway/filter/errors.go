@@ -0,0 +1,10 @@
+package filter
+
+import (
+	"errors"
+)
+
+var (
+	ErrSyntax  = errors.New("filter syntax error")
+	ErrTooLong = errors.New("filter too long")
+)
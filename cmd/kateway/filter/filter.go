@@ -0,0 +1,112 @@
+// Package filter evaluates subscription filter expressions over message
+// envelope headers and json payload fields.
+//
+// An expression is comparisons combined with && || ! and parentheses:
+//
+//	region=='cn' && amount>100
+//	!(type=='test') || user.vip==true
+//
+// An identifier resolves to the envelope header of the same name first and
+// then to the json payload field, dots navigate into nested json objects.
+// A comparison involving an absent field is always false.
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+)
+
+// MaxExprLen is the max length of a filter expression.
+const MaxExprLen = 1 << 10
+
+// Filter is a compiled filter expression, safe for concurrent use.
+type Filter struct {
+	expr string
+	root node
+}
+
+// Compile parses a filter expression.
+func Compile(expr string) (*Filter, error) {
+	if len(expr) > MaxExprLen {
+		return nil, ErrTooLong
+	}
+
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, syntaxError(t.pos, "unexpected %s", t.text)
+	}
+
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// Match evaluates the filter against a message.
+func (this *Filter) Match(headers envelope.Headers, payload []byte) bool {
+	m := &message{headers: headers, payload: payload}
+	return this.root.eval(m).truthy()
+}
+
+func (this *Filter) String() string {
+	return this.expr
+}
+
+// message lazily decodes the json payload only when some identifier is not
+// found in headers.
+type message struct {
+	headers envelope.Headers
+	payload []byte
+
+	decoded bool
+	doc     interface{}
+}
+
+func (this *message) lookup(name string) value {
+	if v, present := this.headers.Get(name); present {
+		return value{kind: kindString, s: v}
+	}
+
+	if !this.decoded {
+		this.decoded = true
+		if err := json.Unmarshal(this.payload, &this.doc); err != nil {
+			// not a json payload, all fields absent
+			this.doc = nil
+		}
+	}
+
+	cur := this.doc
+	for _, part := range strings.Split(name, ".") {
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return absent
+		}
+		if cur, ok = obj[part]; !ok {
+			return absent
+		}
+	}
+
+	return fromJson(cur)
+}
+
+type syntaxErr struct {
+	pos    int
+	reason string
+}
+
+func (this *syntaxErr) Error() string {
+	return fmt.Sprintf("%s at %d: %s", ErrSyntax.Error(), this.pos, this.reason)
+}
+
+func syntaxError(pos int, format string, args ...interface{}) error {
+	return &syntaxErr{pos: pos, reason: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,77 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+)
+
+func TestMatch(t *testing.T) {
+	headers := envelope.Headers{{Key: "region", Value: "cn"}, {Key: "retry", Value: "3"}}
+	payload := []byte(`{"amount":120,"user":{"name":"bob","vip":true},"memo":null,"items":[1]}`)
+	fixtures := []struct {
+		expr  string
+		match bool
+	}{
+		{`region=='cn' && amount>100`, true},
+		{`region=="us" || amount>100`, true},
+		{`region=='cn' && amount>200`, false},
+		{`retry>=3 && retry<4`, true}, // header value compared as number
+		{`retry=='3'`, true},
+		{`user.name=='bob' && user.vip==true`, true},
+		{`user.vip`, true},
+		{`!user.vip`, false},
+		{`!(region=='us')`, true},
+		{`memo==null`, true},
+		{`items`, true},
+		{`nosuch=='x'`, false},
+		{`nosuch!='x'`, false}, // absent field never matches
+		{`!nosuch`, true},
+		{`amount!='abc'`, true},
+		{`amount==120 && region!='us' && (user.name=='alice' || retry<5)`, true},
+		{`user.name>'alice'`, true},
+		{`-1<amount`, true},
+	}
+	for _, f := range fixtures {
+		flt, err := Compile(f.expr)
+		assert.Equal(t, nil, err)
+		if flt.Match(headers, payload) != f.match {
+			t.Fatalf("%s: expect %v", f.expr, f.match)
+		}
+	}
+
+	// non json payload only has headers
+	flt, _ := Compile(`region=='cn' || amount>1`)
+	assert.Equal(t, true, flt.Match(headers, []byte("hello")))
+	flt, _ = Compile(`amount>1`)
+	assert.Equal(t, false, flt.Match(nil, []byte("hello")))
+}
+
+func TestCompileError(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`region=`,
+		`region=='cn' &`,
+		`region=='cn`,
+		`(a==1`,
+		`a==1)`,
+		`a==`,
+		`a==1 b==2`,
+		`a # 1`,
+	} {
+		if _, err := Compile(expr); err == nil {
+			t.Fatalf("%s: expect syntax error", expr)
+		}
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	b.ReportAllocs()
+	headers := envelope.Headers{{Key: "region", Value: "cn"}}
+	payload := []byte(`{"amount":120,"user":{"name":"bob","vip":true}}`)
+	flt, _ := Compile(`region=='cn' && amount>100`)
+	for i := 0; i < b.N; i++ {
+		flt.Match(headers, payload)
+	}
+}
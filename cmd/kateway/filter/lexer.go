@@ -0,0 +1,155 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokTrue
+	tokFalse
+	tokNull
+	tokOp     // == != > >= < <=
+	tokAnd    // &&
+	tokOr     // ||
+	tokNot    // !
+	tokLParen // (
+	tokRParen // )
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: i})
+			i++
+
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: i})
+			i++
+
+		case c == '&' || c == '|':
+			if i+1 >= len(expr) || expr[i+1] != c {
+				return nil, syntaxError(i, "expect %c%c", c, c)
+			}
+			if c == '&' {
+				tokens = append(tokens, token{kind: tokAnd, text: "&&", pos: i})
+			} else {
+				tokens = append(tokens, token{kind: tokOr, text: "||", pos: i})
+			}
+			i += 2
+
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			if i+1 < len(expr) && expr[i+1] == '=' {
+				tokens = append(tokens, token{kind: tokOp, text: expr[i : i+2], pos: i})
+				i += 2
+			} else if c == '!' {
+				tokens = append(tokens, token{kind: tokNot, text: "!", pos: i})
+				i++
+			} else if c == '=' {
+				return nil, syntaxError(i, "expect ==")
+			} else {
+				tokens = append(tokens, token{kind: tokOp, text: expr[i : i+1], pos: i})
+				i++
+			}
+
+		case c == '\'' || c == '"':
+			s, n, err := scanString(expr[i:])
+			if err != nil {
+				return nil, syntaxError(i, "%v", err)
+			}
+			tokens = append(tokens, token{kind: tokString, text: s, pos: i})
+			i += n
+
+		case c == '-' || c == '.' || isDigit(c):
+			j := i + 1
+			for j < len(expr) && (isDigit(expr[j]) || expr[j] == '.' || expr[j] == 'e' || expr[j] == 'E') {
+				j++
+			}
+			f, err := strconv.ParseFloat(expr[i:j], 64)
+			if err != nil {
+				return nil, syntaxError(i, "bad number %s", expr[i:j])
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: expr[i:j], num: f, pos: i})
+			i = j
+
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(expr) && isIdentPart(expr[j]) {
+				j++
+			}
+			t := token{kind: tokIdent, text: expr[i:j], pos: i}
+			switch t.text {
+			case "true":
+				t.kind = tokTrue
+			case "false":
+				t.kind = tokFalse
+			case "null":
+				t.kind = tokNull
+			}
+			tokens = append(tokens, t)
+			i = j
+
+		default:
+			return nil, syntaxError(i, "unexpected %q", c)
+		}
+	}
+
+	tokens = append(tokens, token{kind: tokEOF, pos: len(expr)})
+	return tokens, nil
+}
+
+// scanString scans a quoted string with backslash escapes, returns the
+// unquoted string and number of bytes consumed.
+func scanString(s string) (string, int, error) {
+	quote := s[0]
+	buf := make([]byte, 0, len(s))
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case quote:
+			return string(buf), i + 1, nil
+
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			buf = append(buf, s[i])
+
+		default:
+			buf = append(buf, s[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '.' || c == '-'
+}
@@ -0,0 +1,176 @@
+package filter
+
+import (
+	"strconv"
+)
+
+type kind int
+
+const (
+	kindAbsent kind = iota
+	kindNull
+	kindBool
+	kindNumber
+	kindString
+	kindOther // json array or object, only truthy
+)
+
+type value struct {
+	kind kind
+	b    bool
+	n    float64
+	s    string
+}
+
+var absent = value{kind: kindAbsent}
+
+func fromJson(v interface{}) value {
+	switch v := v.(type) {
+	case nil:
+		return value{kind: kindNull}
+	case bool:
+		return value{kind: kindBool, b: v}
+	case float64:
+		return value{kind: kindNumber, n: v}
+	case string:
+		return value{kind: kindString, s: v}
+	default:
+		return value{kind: kindOther}
+	}
+}
+
+func (this value) truthy() bool {
+	switch this.kind {
+	case kindBool:
+		return this.b
+	case kindNumber:
+		return this.n != 0
+	case kindString:
+		return this.s != ""
+	case kindOther:
+		return true
+	default:
+		return false
+	}
+}
+
+// number converts the value to number, header values are strings so "100"
+// is comparable with 100.
+func (this value) number() (float64, bool) {
+	switch this.kind {
+	case kindNumber:
+		return this.n, true
+	case kindString:
+		f, err := strconv.ParseFloat(this.s, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+type node interface {
+	eval(m *message) value
+}
+
+type identNode struct {
+	name string
+}
+
+func (this *identNode) eval(m *message) value {
+	return m.lookup(this.name)
+}
+
+type literalNode struct {
+	v value
+}
+
+func (this *literalNode) eval(m *message) value {
+	return this.v
+}
+
+type notNode struct {
+	operand node
+}
+
+func (this *notNode) eval(m *message) value {
+	return value{kind: kindBool, b: !this.operand.eval(m).truthy()}
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (this *andNode) eval(m *message) value {
+	return value{kind: kindBool, b: this.left.eval(m).truthy() && this.right.eval(m).truthy()}
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (this *orNode) eval(m *message) value {
+	return value{kind: kindBool, b: this.left.eval(m).truthy() || this.right.eval(m).truthy()}
+}
+
+type cmpNode struct {
+	op          string
+	left, right node
+}
+
+func (this *cmpNode) eval(m *message) value {
+	l, r := this.left.eval(m), this.right.eval(m)
+	return value{kind: kindBool, b: compare(this.op, l, r)}
+}
+
+func compare(op string, l, r value) bool {
+	if l.kind == kindAbsent || r.kind == kindAbsent {
+		return false
+	}
+
+	// a number on either side makes it a numeric comparison
+	if l.kind == kindNumber || r.kind == kindNumber {
+		ln, lok := l.number()
+		rn, rok := r.number()
+		if lok && rok {
+			return cmpOrdered(op, ln < rn, ln == rn)
+		}
+		return op == "!="
+	}
+
+	if l.kind == kindString && r.kind == kindString {
+		return cmpOrdered(op, l.s < r.s, l.s == r.s)
+	}
+
+	if l.kind != r.kind || l.kind == kindOther {
+		return op == "!="
+	}
+
+	// null or bool
+	switch op {
+	case "==":
+		return l.b == r.b
+	case "!=":
+		return l.b != r.b
+	default:
+		return false
+	}
+}
+
+func cmpOrdered(op string, less, equal bool) bool {
+	switch op {
+	case "==":
+		return equal
+	case "!=":
+		return !equal
+	case "<":
+		return less
+	case "<=":
+		return less || equal
+	case ">":
+		return !less && !equal
+	case ">=":
+		return !less
+	default:
+		return false
+	}
+}
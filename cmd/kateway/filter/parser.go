@@ -0,0 +1,127 @@
+package filter
+
+// parser is a recursive descent parser of the grammar:
+//
+//	or      := and ('||' and)*
+//	and     := unary ('&&' unary)*
+//	unary   := '!' unary | primary
+//	primary := '(' or ')' | operand [op operand]
+//	operand := ident | string | number | true | false | null
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (this *parser) peek() token {
+	return this.tokens[this.i]
+}
+
+func (this *parser) next() token {
+	t := this.tokens[this.i]
+	if t.kind != tokEOF {
+		this.i++
+	}
+	return t
+}
+
+func (this *parser) parseOr() (node, error) {
+	left, err := this.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for this.peek().kind == tokOr {
+		this.next()
+		right, err := this.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (this *parser) parseAnd() (node, error) {
+	left, err := this.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for this.peek().kind == tokAnd {
+		this.next()
+		right, err := this.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (this *parser) parseUnary() (node, error) {
+	if this.peek().kind == tokNot {
+		this.next()
+		operand, err := this.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+
+	return this.parsePrimary()
+}
+
+func (this *parser) parsePrimary() (node, error) {
+	if this.peek().kind == tokLParen {
+		this.next()
+		n, err := this.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := this.next(); t.kind != tokRParen {
+			return nil, syntaxError(t.pos, "expect )")
+		}
+		return n, nil
+	}
+
+	left, err := this.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if this.peek().kind != tokOp {
+		return left, nil
+	}
+
+	op := this.next()
+	right, err := this.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return &cmpNode{op: op.text, left: left, right: right}, nil
+}
+
+func (this *parser) parseOperand() (node, error) {
+	t := this.next()
+	switch t.kind {
+	case tokIdent:
+		return &identNode{name: t.text}, nil
+
+	case tokString:
+		return &literalNode{v: value{kind: kindString, s: t.text}}, nil
+
+	case tokNumber:
+		return &literalNode{v: value{kind: kindNumber, n: t.num}}, nil
+
+	case tokTrue, tokFalse:
+		return &literalNode{v: value{kind: kindBool, b: t.kind == tokTrue}}, nil
+
+	case tokNull:
+		return &literalNode{v: value{kind: kindNull}}, nil
+
+	case tokEOF:
+		return nil, syntaxError(t.pos, "unexpected end")
+
+	default:
+		return nil, syntaxError(t.pos, "unexpected %s", t.text)
+	}
+}
@@ -0,0 +1,235 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	hhdisk "github.com/funkygao/gafka/cmd/kateway/hh/disk"
+	log "github.com/funkygao/log4go"
+)
+
+// A bury moves a message from the master topic to a shadow topic in 2 steps:
+// pub to the shadow and then skip it in the master. Each step is recorded in
+// the bury journal so that a half done bury can be finished on restart.
+//
+//     intent ----> published ----> done
+//       |              |
+//       | crash        | crash
+//       v              v
+//     rollback       skip in master
+const (
+	buryIntent    = "intent"
+	buryPublished = "published"
+	buryDone      = "done"
+)
+
+const (
+	buryJournalMaxSize     = 64 << 20
+	buryJournalCompactSize = 1 << 20 // compact when no pending bury and journal grows beyond
+)
+
+type buryRecord struct {
+	State string `json:"s"`
+
+	Cluster   string `json:"c,omitempty"`
+	Topic     string `json:"t,omitempty"` // master raw topic
+	Group     string `json:"g,omitempty"`
+	Partition int32  `json:"p,omitempty"`
+	Offset    int64  `json:"o,omitempty"`
+
+	Shadow          string `json:"st,omitempty"` // shadow raw topic
+	ShadowPartition int32  `json:"sp,omitempty"`
+	ShadowOffset    int64  `json:"so,omitempty"`
+}
+
+func (this *buryRecord) id() string {
+	return fmt.Sprintf("%s/%s/%s/%d/%d", this.Cluster, this.Topic, this.Group, this.Partition, this.Offset)
+}
+
+func (this buryRecord) String() string {
+	return fmt.Sprintf("%s {C:%s T:%s/%d O:%d G:%s -> %s/%d O:%d}", this.State,
+		this.Cluster, this.Topic, this.Partition, this.Offset, this.Group,
+		this.Shadow, this.ShadowPartition, this.ShadowOffset)
+}
+
+type buryJournal struct {
+	mu      sync.Mutex
+	journal *hhdisk.Journal
+	pending map[string]*buryRecord // key is id
+}
+
+func openBuryJournal(path string) (*buryJournal, error) {
+	j, err := hhdisk.OpenJournal(path, buryJournalMaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &buryJournal{
+		journal: j,
+		pending: make(map[string]*buryRecord),
+	}, nil
+}
+
+// recover finishes the half done buries left by last run: the ones pub'ed to
+// shadow are skipped in master by complete, the others are rolled back and
+// will be delivered and buried again.
+// Buries that fail to complete are kept in journal till next recover.
+func (this *buryJournal) recover(complete func(*buryRecord) error) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.journal.Replay(func(key, value []byte) error {
+		var rec buryRecord
+		if err := json.Unmarshal(value, &rec); err != nil {
+			return err
+		}
+
+		id := string(key)
+		switch rec.State {
+		case buryIntent:
+			this.pending[id] = &rec
+
+		case buryPublished:
+			if r, present := this.pending[id]; present {
+				r.State = buryPublished
+				r.ShadowPartition, r.ShadowOffset = rec.ShadowPartition, rec.ShadowOffset
+			} else if rec.Topic != "" {
+				// compacted
+				this.pending[id] = &rec
+			}
+
+		case buryDone:
+			delete(this.pending, id)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for id, rec := range this.pending {
+		if rec.State != buryPublished {
+			// the shadow might have got the msg, it will be duplicated there
+			log.Warn("bury journal rollback %s", rec)
+			delete(this.pending, id)
+			continue
+		}
+
+		if err := complete(rec); err != nil {
+			log.Error("bury journal complete %s: %v", rec, err)
+			continue
+		}
+
+		log.Info("bury journal completed %s", rec)
+		delete(this.pending, id)
+	}
+
+	return this.compact()
+}
+
+// begin records the intent of a bury before pub to shadow.
+// If the same bury is already in shadow but failed to skip in master, it
+// resumes at step 2: rec gets the shadow position and resumed is true.
+func (this *buryJournal) begin(rec *buryRecord) (resumed bool, err error) {
+	id := rec.id()
+
+	this.mu.Lock()
+	if r, present := this.pending[id]; present && r.State == buryPublished {
+		rec.State = buryPublished
+		rec.ShadowPartition, rec.ShadowOffset = r.ShadowPartition, r.ShadowOffset
+		this.mu.Unlock()
+		return true, nil
+	}
+	this.mu.Unlock()
+
+	rec.State = buryIntent
+	return false, this.append(id, rec)
+}
+
+// published records that the msg is in shadow and to be skipped in master.
+func (this *buryJournal) published(rec *buryRecord, partition int32, offset int64) error {
+	return this.append(rec.id(), &buryRecord{
+		State:           buryPublished,
+		ShadowPartition: partition,
+		ShadowOffset:    offset,
+	})
+}
+
+// done records the end of a bury, successful or rolled back.
+func (this *buryJournal) done(rec *buryRecord) error {
+	return this.append(rec.id(), &buryRecord{State: buryDone})
+}
+
+// rollback ends a bury that failed before skipped in master. It is dropped
+// from pending even if the journal fails so that the client can retry it,
+// the journal left behind is rolled back by recover.
+func (this *buryJournal) rollback(rec *buryRecord) error {
+	err := this.done(rec)
+	if err != nil {
+		this.mu.Lock()
+		delete(this.pending, rec.id())
+		this.mu.Unlock()
+	}
+	return err
+}
+
+func (this *buryJournal) append(id string, rec *buryRecord) error {
+	value, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if _, present := this.pending[id]; present && rec.State == buryIntent {
+		return ErrBuryInFlight
+	}
+
+	if err = this.journal.Append([]byte(id), value); err != nil {
+		return err
+	}
+
+	switch rec.State {
+	case buryIntent:
+		this.pending[id] = rec
+
+	case buryPublished:
+		if r, present := this.pending[id]; present {
+			r.State = buryPublished
+			r.ShadowPartition, r.ShadowOffset = rec.ShadowPartition, rec.ShadowOffset
+		}
+
+	case buryDone:
+		delete(this.pending, id)
+		if len(this.pending) == 0 && this.journal.DiskUsage() > buryJournalCompactSize {
+			if err = this.compact(); err != nil {
+				log.Error("bury journal compact: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// compact rewrites the journal with pending buries only, caller holds the lock.
+func (this *buryJournal) compact() error {
+	return this.journal.Rewrite(func(add func(key, value []byte) error) error {
+		for id, rec := range this.pending {
+			value, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+
+			if err = add([]byte(id), value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (this *buryJournal) Close() error {
+	return this.journal.Close()
+}
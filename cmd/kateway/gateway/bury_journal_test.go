@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestBuryJournalRecover(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bury")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	j, err := openBuryJournal(path)
+	assert.Equal(t, nil, err)
+
+	done := &buryRecord{Cluster: "c1", Topic: "t1", Group: "g1", Partition: 0, Offset: 1, Shadow: "dead"}
+	_, err = j.begin(done)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, j.published(done, 2, 10))
+	assert.Equal(t, nil, j.done(done))
+
+	intent := &buryRecord{Cluster: "c1", Topic: "t1", Group: "g1", Partition: 0, Offset: 2, Shadow: "dead"}
+	_, err = j.begin(intent)
+	assert.Equal(t, nil, err)
+	_, err = j.begin(intent)
+	assert.Equal(t, ErrBuryInFlight, err)
+
+	published := &buryRecord{Cluster: "c1", Topic: "t1", Group: "g1", Partition: 1, Offset: 3, Shadow: "dead"}
+	_, err = j.begin(published)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, j.published(published, 2, 11))
+
+	failed := &buryRecord{Cluster: "c1", Topic: "t1", Group: "g1", Partition: 1, Offset: 4, Shadow: "dead"}
+	_, err = j.begin(failed)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, j.published(failed, 2, 12))
+	j.Close()
+
+	// crash and restart
+	j, err = openBuryJournal(path)
+	assert.Equal(t, nil, err)
+	var completed []buryRecord
+	assert.Equal(t, nil, j.recover(func(rec *buryRecord) error {
+		if rec.Offset == 4 {
+			return errors.New("zk down")
+		}
+		completed = append(completed, *rec)
+		return nil
+	}))
+	assert.Equal(t, 1, len(completed))
+	assert.Equal(t, int64(3), completed[0].Offset)
+	assert.Equal(t, int64(11), completed[0].ShadowOffset)
+	assert.Equal(t, 1, len(j.pending))
+	j.Close()
+
+	// the failed one is retried on next restart
+	j, _ = openBuryJournal(path)
+	completed = completed[:0]
+	assert.Equal(t, nil, j.recover(func(rec *buryRecord) error {
+		completed = append(completed, *rec)
+		return nil
+	}))
+	assert.Equal(t, 1, len(completed))
+	assert.Equal(t, int64(4), completed[0].Offset)
+	assert.Equal(t, int64(12), completed[0].ShadowOffset)
+	assert.Equal(t, 0, len(j.pending))
+	assert.Equal(t, int64(0), j.journal.DiskUsage())
+	j.Close()
+}
+
+func TestBuryJournalResumePublished(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bury")
+	defer os.RemoveAll(dir)
+
+	j, err := openBuryJournal(filepath.Join(dir, "journal"))
+	assert.Equal(t, nil, err)
+	defer j.Close()
+
+	rec := &buryRecord{Cluster: "c1", Topic: "t1", Group: "g1", Partition: 0, Offset: 1, Shadow: "dead"}
+	resumed, err := j.begin(rec)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, resumed)
+	assert.Equal(t, nil, j.published(rec, 2, 10))
+
+	// skip in master failed, the client retries
+	retry := &buryRecord{Cluster: "c1", Topic: "t1", Group: "g1", Partition: 0, Offset: 1, Shadow: "dead"}
+	resumed, err = j.begin(retry)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, resumed)
+	assert.Equal(t, int32(2), retry.ShadowPartition)
+	assert.Equal(t, int64(10), retry.ShadowOffset)
+
+	assert.Equal(t, nil, j.done(retry))
+	assert.Equal(t, 0, len(j.pending))
+}
+
+func TestBuryJournalRollbackOnJournalError(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bury")
+	defer os.RemoveAll(dir)
+
+	j, err := openBuryJournal(filepath.Join(dir, "journal"))
+	assert.Equal(t, nil, err)
+
+	rec := &buryRecord{Cluster: "c1", Topic: "t1", Group: "g1", Partition: 0, Offset: 1, Shadow: "dead"}
+	_, err = j.begin(rec)
+	assert.Equal(t, nil, err)
+
+	// journal broken after pub to shadow
+	j.Close()
+	assert.NotEqual(t, nil, j.published(rec, 2, 10))
+	assert.NotEqual(t, nil, j.rollback(rec))
+	assert.Equal(t, 0, len(j.pending)) // retry not blocked by ErrBuryInFlight
+}
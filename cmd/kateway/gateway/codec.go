@@ -1,10 +1,18 @@
 package gateway
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"io"
 )
 
+const ContentTypeNDJSON = "application/x-ndjson"
+
+var ErrIllegalPubBatch = errors.New("illegal pub batch")
+
 func writeI16(writer io.Writer, buf []byte, v int16) error {
 	b := buf[:2]
 	binary.BigEndian.PutUint16(b, uint16(v))
@@ -58,3 +66,123 @@ func DecodeMessageSet(messageSet []byte) []Message {
 	}
 
 }
+
+// PubMessage is a message of a batch pub.
+type PubMessage struct {
+	Key   []byte
+	Tag   string
+	Value []byte
+}
+
+// EncodePubBatch encodes messages into the length prefixed batch pub body.
+// PubBatch => [KeyLen(int16) Key TagLen(int16) Tag MessageSize(int32) Message] BigEndian
+func EncodePubBatch(msgs []PubMessage) []byte {
+	w := bytes.NewBuffer(make([]byte, 0, len(msgs)*64))
+	buf := make([]byte, 8)
+	for _, m := range msgs {
+		writeI16(w, buf, int16(len(m.Key)))
+		w.Write(m.Key)
+		writeI16(w, buf, int16(len(m.Tag)))
+		w.WriteString(m.Tag)
+		writeI32(w, buf, int32(len(m.Value)))
+		w.Write(m.Value)
+	}
+	return w.Bytes()
+}
+
+// DecodePubBatch decodes the length prefixed batch pub body, the decoded
+// messages refer to b.
+func DecodePubBatch(b []byte) ([]PubMessage, error) {
+	var (
+		r   []PubMessage
+		idx int
+	)
+
+	readBytes := func(lenSize int) ([]byte, bool) {
+		if len(b)-idx < lenSize {
+			return nil, false
+		}
+
+		var l int
+		if lenSize == 2 {
+			l = int(binary.BigEndian.Uint16(b[idx:]))
+		} else {
+			l = int(binary.BigEndian.Uint32(b[idx:]))
+		}
+		idx += lenSize
+
+		if l < 0 || len(b)-idx < l {
+			return nil, false
+		}
+		v := b[idx : idx+l]
+		idx += l
+		return v, true
+	}
+
+	for idx < len(b) {
+		var (
+			m   PubMessage
+			tag []byte
+			ok  bool
+		)
+		m.Key, ok = readBytes(2)
+		if ok {
+			tag, ok = readBytes(2)
+		}
+		if ok {
+			m.Value, ok = readBytes(4)
+		}
+		if !ok {
+			return nil, ErrIllegalPubBatch
+		}
+
+		m.Tag = string(tag)
+		r = append(r, m)
+	}
+
+	return r, nil
+}
+
+// DecodePubBatchNDJSON decodes newline delimited json batch pub body:
+//
+//	{"key":"k1","tag":"a=b","value":"hello"}
+//	{"key":"k2","value":{"amount":100}}
+//
+// A string value is published as is, other json values in raw json.
+func DecodePubBatchNDJSON(b []byte) ([]PubMessage, error) {
+	var r []PubMessage
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(make([]byte, 64<<10), len(b)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var v struct {
+			Key   string          `json:"key"`
+			Tag   string          `json:"tag"`
+			Value json.RawMessage `json:"value"`
+		}
+		if err := json.Unmarshal(line, &v); err != nil {
+			return nil, err
+		}
+
+		m := PubMessage{Tag: v.Tag, Value: []byte(v.Value)}
+		if v.Key != "" {
+			m.Key = []byte(v.Key)
+		}
+		if len(v.Value) > 0 && v.Value[0] == '"' {
+			var s string
+			if err := json.Unmarshal(v.Value, &s); err != nil {
+				return nil, err
+			}
+			m.Value = []byte(s)
+		}
+
+		r = append(r, m)
+	}
+
+	return r, scanner.Err()
+}
@@ -54,3 +54,45 @@ func TestDecodeMessageSetMultiMessage(t *testing.T) {
 	assert.Equal(t, offset2, msgSet[1].Offset)
 	assert.Equal(t, msg2, msgSet[1].Value)
 }
+
+func TestEncodeDecodePubBatch(t *testing.T) {
+	msgs := []PubMessage{
+		{Key: []byte("k1"), Tag: "a=b", Value: []byte("hello world")},
+		{Value: []byte("good morning")},
+	}
+	b := EncodePubBatch(msgs)
+	r, err := DecodePubBatch(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(r))
+	assert.Equal(t, "k1", string(r[0].Key))
+	assert.Equal(t, "a=b", r[0].Tag)
+	assert.Equal(t, "hello world", string(r[0].Value))
+	assert.Equal(t, 0, len(r[1].Key))
+	assert.Equal(t, "", r[1].Tag)
+	assert.Equal(t, "good morning", string(r[1].Value))
+
+	// truncated
+	for i := 1; i < len(b); i++ {
+		if _, err = DecodePubBatch(b[:i]); err == nil && i != len(EncodePubBatch(msgs[:1])) {
+			t.Fatalf("truncated at %d: expect error", i)
+		}
+	}
+}
+
+func TestDecodePubBatchNDJSON(t *testing.T) {
+	body := `{"key":"k1","tag":"a=b","value":"hello"}
+
+{"value":{"amount":100}}
+`
+	r, err := DecodePubBatchNDJSON([]byte(body))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(r))
+	assert.Equal(t, "k1", string(r[0].Key))
+	assert.Equal(t, "a=b", r[0].Tag)
+	assert.Equal(t, "hello", string(r[0].Value))
+	assert.Equal(t, 0, len(r[1].Key))
+	assert.Equal(t, `{"amount":100}`, string(r[1].Value))
+
+	_, err = DecodePubBatchNDJSON([]byte(`{"value":`))
+	assert.Equal(t, true, err != nil)
+}
@@ -7,7 +7,12 @@ const (
 	HttpHeaderMsgBury         = "X-Bury"
 	HttpHeaderMsgKey          = "X-Key"
 	HttpHeaderMsgTag          = "X-Tag"
+	HttpHeaderMsgId           = "X-Msg-Id"
+	HttpHeaderMsgDuplicated   = "X-Msg-Duplicated"
 	HttpHeaderJobId           = "X-Job-Id"
+	HttpHeaderSchemaId        = "X-Schema-Id"
+	HttpHeaderDeliveryCount   = "X-Delivery-Count"
+	HttpHeaderKatewayId       = "X-Kateway-Id"
 	HttpHeaderAcceptEncoding  = "Accept-Encoding"
 	HttpHeaderContentEncoding = "Content-Encoding"
 	HttpEncodingGzip          = "gzip"
@@ -18,6 +23,7 @@ const (
 	UrlParamGroup   = "group"
 
 	MaxPartitionKeyLen = 256
+	MaxMsgIdLen        = 128
 )
 
 var (
@@ -5,10 +5,18 @@ import (
 )
 
 var (
-	ErrClientGone           = errors.New("remote client gone")
-	ErrTooBigMessage        = errors.New("too big message")
-	ErrTooSmallMessage      = errors.New("too small message")
-	ErrIllegalTaggedMessage = errors.New("illegal tagged message")
-	ErrClientKilled         = errors.New("client killed")
-	ErrBadResponseWriter    = errors.New("ResponseWriter Close not supported")
+	ErrClientGone        = errors.New("remote client gone")
+	ErrTooBigMessage     = errors.New("too big message")
+	ErrTooSmallMessage   = errors.New("too small message")
+	ErrTooBigKey         = errors.New("too big key")
+	ErrTooBigTag         = errors.New("too big tag")
+	ErrIllegalTag        = errors.New("illegal tag")
+	ErrTooBigBatch       = errors.New("too many messages in batch")
+	ErrClientKilled      = errors.New("client killed")
+	ErrMsgInFlight       = errors.New("message with the same id in flight")
+	ErrBatchMsgId        = errors.New("X-Msg-Id not supported in batch pub")
+	ErrBuryInFlight      = errors.New("bury of the same message in flight")
+	ErrRetentionQuota    = errors.New("retention.bytes exceeds quota")
+	ErrServerShutdown    = errors.New("server is shutting down")
+	ErrBadResponseWriter = errors.New("ResponseWriter Close not supported")
 )
@@ -8,12 +8,17 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	_ "expvar" // register /debug/vars HTTP handler
 
 	"github.com/funkygao/fae/config"
 	"github.com/funkygao/gafka"
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+	dedupmem "github.com/funkygao/gafka/cmd/kateway/dedup/mem"
+	dedupredis "github.com/funkygao/gafka/cmd/kateway/dedup/redis"
 	"github.com/funkygao/gafka/cmd/kateway/hh"
 	hhdisk "github.com/funkygao/gafka/cmd/kateway/hh/disk"
 	hhdummy "github.com/funkygao/gafka/cmd/kateway/hh/dummy"
@@ -26,8 +31,14 @@ import (
 	manopen "github.com/funkygao/gafka/cmd/kateway/manager/open"
 	"github.com/funkygao/gafka/cmd/kateway/meta"
 	"github.com/funkygao/gafka/cmd/kateway/meta/zkmeta"
+	"github.com/funkygao/gafka/cmd/kateway/quota"
+	quotamem "github.com/funkygao/gafka/cmd/kateway/quota/mem"
+	quotaredis "github.com/funkygao/gafka/cmd/kateway/quota/redis"
+	"github.com/funkygao/gafka/cmd/kateway/schema"
+	"github.com/funkygao/gafka/cmd/kateway/schema/zkschema"
 	"github.com/funkygao/gafka/cmd/kateway/store"
 	storedummy "github.com/funkygao/gafka/cmd/kateway/store/dummy"
+	storehelix "github.com/funkygao/gafka/cmd/kateway/store/helix"
 	storekfk "github.com/funkygao/gafka/cmd/kateway/store/kafka"
 	"github.com/funkygao/gafka/ctx"
 	"github.com/funkygao/gafka/registry"
@@ -57,6 +68,13 @@ type Gateway struct {
 	certFile string
 	keyFile  string
 
+	draining       int32      // atomic, announced shutdown to ehaproxy
+	registeredMu   sync.Mutex // guards registeredInfo
+	registeredInfo []byte     // the data last written to registry
+
+	quotas     *quotaKeeper
+	replicator *replicator // nil if replication disabled
+
 	pubServer *pubServer
 	subServer *subServer
 	manServer *manServer
@@ -71,6 +89,10 @@ func New(id string) *Gateway {
 		certFile:   Options.CertFile,
 		keyFile:    Options.KeyFile,
 	}
+	this.quotas = newQuotaKeeper(this)
+	if Options.ReplicaZone != "" {
+		this.replicator = newReplicator(this)
+	}
 
 	this.zkzone = gzk.NewZkZone(gzk.DefaultConfig(Options.Zone, ctx.ZoneZkAddrs(Options.Zone)))
 	if err := this.zkzone.Ping(); err != nil {
@@ -83,12 +105,23 @@ func New(id string) *Gateway {
 	metaConf := zkmeta.DefaultConfig()
 	metaConf.Refresh = Options.MetaRefresh
 	meta.Default = zkmeta.New(metaConf, this.zkzone)
+	schema.Default = zkschema.New(this.zkzone, func(appid, topic, ver string) (string, error) {
+		// topics registered in manager before the schema registry
+		return manager.Default.TopicSchema(appid, topic, ver)
+	}, Options.MetaRefresh)
 	this.accessLogger = NewAccessLogger("access_log", 100)
 	this.svrMetrics = NewServerMetrics(Options.ReporterInterval, this)
 	rc, err := influxdb.NewConfig(Options.InfluxServer, Options.InfluxDbName, "", "", Options.ReporterInterval)
 	if err != nil {
 		log.Error("telemetry: %v", err)
 	} else {
+		rc.UseRetentionPolicy(Options.InfluxRetentionPolicy)
+		if Options.InfluxGzip {
+			rc.EnableGzip()
+		}
+		if Options.InfluxSpoolDir != "" {
+			rc.EnableSpool(Options.InfluxSpoolDir, 0)
+		}
 		telemetry.Default = influxdb.New(metrics.DefaultRegistry, rc)
 	}
 
@@ -131,7 +164,8 @@ func New(id string) *Gateway {
 			Options.MaxClients, this)
 
 		switch Options.Store {
-		case "kafka":
+		case "kafka", "helix":
+			// helix only manages sub partitions
 			store.DefaultPubStore = storekfk.NewPubStore(Options.PubPoolCapcity, Options.PubPoolIdleTimeout,
 				Options.UseCompress, Options.Debug, Options.DryRun)
 
@@ -190,6 +224,34 @@ func New(id string) *Gateway {
 			panic("unkown hinted handoff type")
 		}
 
+		switch Options.DedupType {
+		case "":
+			// idempotent pub disabled
+
+		case "mem":
+			dedup.Default = dedupmem.New(Options.DedupCapacity, Options.DedupTTL)
+
+		case "redis":
+			dedup.Default = dedupredis.New(Options.DedupRedisAddr, Options.RedisPoolCapacity, Options.DedupTTL)
+
+		default:
+			panic("unknown dedup type")
+		}
+
+		switch Options.QuotaType {
+		case "":
+			// quotas disabled
+
+		case "mem":
+			quota.Default = quotamem.New()
+
+		case "redis":
+			quota.Default = quotaredis.New(Options.QuotaRedisAddr, Options.RedisPoolCapacity)
+
+		default:
+			panic("unknown quota type")
+		}
+
 		if Options.FlushHintedOffOnly {
 			meta.Default.Start()
 			log.Trace("meta store[%s] started", meta.Default.Name())
@@ -211,6 +273,10 @@ func New(id string) *Gateway {
 		case "kafka":
 			store.DefaultSubStore = storekfk.NewSubStore(this.subServer.closedConnCh, Options.Debug)
 
+		case "helix":
+			store.DefaultSubStore = storehelix.NewSubStore(this.subServer.closedConnCh,
+				ctx.Zone(Options.Zone).ZkHelix, Options.HelixCluster, Options.SubHttpAddr)
+
 		case "dummy":
 			store.DefaultSubStore = storedummy.NewSubStore(this.subServer.closedConnCh, Options.Debug)
 
@@ -246,6 +312,15 @@ func (this *Gateway) InstanceInfo() []byte {
 		SManAddr:  Options.ManHttpsAddr,
 		DebugAddr: Options.DebugHttpAddr,
 	}
+	if this.pubServer != nil {
+		info.PubConns = int(atomic.LoadInt32(&this.pubServer.activeConnN))
+	}
+	if this.subServer != nil {
+		info.SubConns = int(atomic.LoadInt32(&this.subServer.activeConnN))
+	}
+	if atomic.LoadInt32(&this.draining) == 1 {
+		info.State = gzk.KatewayStateDraining
+	}
 	d, _ := json.Marshal(info)
 	return d
 }
@@ -272,6 +347,11 @@ func (this *Gateway) Start() (err error) {
 	}
 	log.Trace("manager store[%s] started", manager.Default.Name())
 
+	if err = schema.Default.Start(); err != nil {
+		return
+	}
+	log.Trace("schema registry[%s] started", schema.Default.Name())
+
 	if telemetry.Default != nil {
 		go func() {
 			log.Trace("telemetry[%s] started", telemetry.Default.Name())
@@ -306,6 +386,23 @@ func (this *Gateway) Start() (err error) {
 		}
 		log.Trace("hh[%s] started", hh.Default.Name())
 
+		if dedup.Default != nil {
+			if err = dedup.Default.Start(); err != nil {
+				return
+			}
+			log.Trace("dedup[%s] started", dedup.Default.Name())
+		}
+
+		if quota.Default != nil {
+			if err = quota.Default.Start(); err != nil {
+				return
+			}
+			log.Trace("quota[%s] started", quota.Default.Name())
+
+			this.wg.Add(1)
+			go this.quotas.usageFlusher(time.Minute)
+		}
+
 		if err = job.Default.Start(); err != nil {
 			panic(err)
 		}
@@ -322,9 +419,18 @@ func (this *Gateway) Start() (err error) {
 		this.subServer.Start()
 	}
 
+	if this.replicator != nil {
+		for _, cluster := range meta.Default.ClusterNames() {
+			this.wg.Add(1)
+			go this.replicator.replicate(cluster)
+		}
+		log.Trace("replicator to zone[%s] started", Options.ReplicaZone)
+	}
+
 	// the last thing is to register: notify others: come on baby!
 	if registry.Default != nil {
-		registry.Default.Register(this.id, this.InstanceInfo())
+		this.register()
+		go this.reportLoad()
 
 		log.Info("gateway[%s:%s] ready, registered in %s :-)", ctx.Hostname(), this.id,
 			registry.Default.Name())
@@ -338,9 +444,11 @@ func (this *Gateway) Start() (err error) {
 func (this *Gateway) ServeForever() {
 	select {
 	case <-this.quiting:
-		// the 1st thing is to deregister
+		// the 1st thing is to drain and deregister
 		if registry.Default != nil {
-			if err := registry.Default.Deregister(this.id, this.InstanceInfo()); err != nil {
+			this.announceDraining()
+
+			if err := registry.Default.Deregister(this.id, this.lastRegisteredInfo()); err != nil {
 				log.Error("de-register: %v", err)
 			} else {
 				log.Info("de-registered from %s", registry.Default.Name())
@@ -365,6 +473,11 @@ func (this *Gateway) ServeForever() {
 			hh.Default.Stop()
 		}
 
+		if dedup.Default != nil {
+			dedup.Default.Stop()
+			log.Trace("dedup[%s] stopped", dedup.Default.Name())
+		}
+
 		if Options.EnableAccessLog {
 			log.Trace("stopping access logger")
 			this.accessLogger.Stop()
@@ -389,6 +502,17 @@ func (this *Gateway) ServeForever() {
 		this.wg.Wait()
 		log.Info("<----- all services shutdown ----->")
 
+		if quota.Default != nil {
+			this.quotas.flushUsage()
+			quota.Default.Stop()
+			log.Trace("quota[%s] stopped", quota.Default.Name())
+		}
+
+		if this.replicator != nil {
+			this.replicator.close()
+			log.Trace("replicator stopped")
+		}
+
 		this.svrMetrics.Flush()
 		log.Trace("svr metrics flushed")
 
@@ -400,6 +524,9 @@ func (this *Gateway) ServeForever() {
 		meta.Default.Stop()
 		log.Trace("meta store[%s] stopped", meta.Default.Name())
 
+		schema.Default.Stop()
+		log.Trace("schema registry[%s] stopped", schema.Default.Name())
+
 		manager.Default.Stop()
 		log.Trace("manager store[%s] stopped", manager.Default.Name())
 
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/filter"
 	"github.com/funkygao/gafka/cmd/kateway/hh"
 	"github.com/funkygao/gafka/cmd/kateway/job"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
@@ -24,35 +25,6 @@ import (
 )
 
 //go:generate goannotation $GOFILE
-// @rest GET /v1/schema/:appid/:topic/:ver
-func (this *manServer) schemaHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	hisAppid := params.ByName(UrlParamAppid)
-	myAppid := r.Header.Get(HttpHeaderAppid)
-	topic := params.ByName(UrlParamTopic)
-	ver := params.ByName(UrlParamVersion)
-	realIp := getHttpRemoteIp(r)
-
-	log.Info("schema[%s] %s(%s) {app:%s topic:%s ver:%s UA:%s}",
-		myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"))
-
-	// TODO authorization
-
-	_, found := manager.Default.LookupCluster(hisAppid)
-	if !found {
-		writeBadRequest(w, "invalid appid")
-		return
-	}
-
-	// TODO lookup from manager and send reponse
-	schema, err := manager.Default.TopicSchema(hisAppid, topic, ver)
-	if err != nil {
-		writeBadRequest(w, err.Error())
-		return
-	}
-
-	w.Write([]byte(strings.TrimSpace(schema)))
-}
-
 // @rest GET /v1/status
 func (this *manServer) statusHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	log.Info("status %s(%s)", r.RemoteAddr, getHttpRemoteIp(r))
@@ -68,6 +40,9 @@ func (this *manServer) statusHandler(w http.ResponseWriter, r *http.Request, par
 	output["hh_appends"] = strconv.FormatInt(hh.Default.AppendN(), 10)
 	output["hh_delivers"] = strconv.FormatInt(hh.Default.DeliverN(), 10)
 	output["goroutines"] = strconv.Itoa(runtime.NumGoroutine())
+	if this.gw.replicator != nil {
+		output["replication"] = this.gw.replicator.stats()
+	}
 
 	var mem runtime.MemStats
 	runtime.ReadMemStats(&mem)
@@ -191,6 +166,9 @@ func (this *manServer) setOptionHandler(w http.ResponseWriter, r *http.Request,
 	case "standbysub":
 		Options.PermitStandbySub = boolVal
 
+	case "schemacheck":
+		Options.ValidateSchema = boolVal
+
 	case "unregroup":
 		Options.PermitUnregisteredGroup = boolVal
 		manager.Default.AllowSubWithUnregisteredGroup(boolVal)
@@ -335,6 +313,16 @@ func (this *manServer) createWebhookHandler(w http.ResponseWriter, r *http.Reque
 	}
 	r.Body.Close()
 
+	if hook.Filter != "" {
+		if _, err := filter.Compile(hook.Filter); err != nil {
+			log.Error("+webhook[%s/%s] %s(%s): {%s.%s.%s UA:%s} %s %v",
+				myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), hook.Filter, err)
+
+			writeBadRequest(w, err.Error())
+			return
+		}
+	}
+
 	// validate the url
 	for _, ep := range hook.Endpoints {
 		_, err := url.ParseRequestURI(ep)
@@ -542,6 +530,13 @@ func (this *manServer) createTopicHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if err := capRetention(hisAppid, topic, ts, ts.Partitions); err != nil {
+		log.Warn("app[%s] %s(%s) create topic:%s %s: %v", hisAppid, r.RemoteAddr, realIp, topic, query.Encode(), err)
+
+		writeBadRequest(w, err.Error())
+		return
+	}
+
 	log.Info("app[%s] %s(%s) create topic: {appid:%s cluster:%s topic:%s ver:%s query:%s}",
 		appid, r.RemoteAddr, realIp, hisAppid, cluster, topic, ver, query.Encode())
 
@@ -661,10 +656,21 @@ func (this *manServer) alterTopicHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	rawTopic := manager.Default.KafkaTopic(hisAppid, topic, ver)
+	partitions := ts.Partitions
+	if query.Get(sla.SlaKeyPartitions) == "" {
+		partitions = len(zkcluster.Partitions(rawTopic))
+	}
+	if err := capRetention(hisAppid, topic, ts, partitions); err != nil {
+		log.Warn("app[%s] alter topic:%s %s: %v", hisAppid, topic, query.Encode(), err)
+
+		writeBadRequest(w, err.Error())
+		return
+	}
+
 	log.Info("app[%s] from %s(%s) alter topic: {appid:%s cluster:%s topic:%s ver:%s query:%s}",
 		appid, r.RemoteAddr, realIp, hisAppid, cluster, topic, ver, query.Encode())
 
-	rawTopic := manager.Default.KafkaTopic(hisAppid, topic, ver)
 	alterConfig := ts.DumpForAlterTopic()
 	if len(alterConfig) == 0 {
 		log.Warn("app[%s] from %s(%s) alter topic: {appid:%s cluster:%s topic:%s ver:%s query:%s} nothing updated",
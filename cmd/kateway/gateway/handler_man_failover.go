@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/meta"
+	"github.com/funkygao/gafka/ctx"
+	gzk "github.com/funkygao/gafka/zk"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+// failoverPartition is the offset translation of a partition, local offset
+// is -1 if it can't be translated.
+type failoverPartition struct {
+	Partition   int32 `json:"partition"`
+	PeerOffset  int64 `json:"peer_offset"`
+	LocalOffset int64 `json:"local_offset"`
+}
+
+//go:generate goannotation $GOFILE
+// @rest PUT /v1/failover/:appid/:topic/:ver/:group?dryrun=true
+// translates the committed offsets of a group of a global topic in the peer
+// zone to the local zone, so that the group resumes here where it left off.
+// response: {"peer":"zone2","partitions":[{"partition":0,"peer_offset":10,"local_offset":12}]}
+func (this *manServer) failoverHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var (
+		topic    = params.ByName(UrlParamTopic)
+		ver      = params.ByName(UrlParamVersion)
+		hisAppid = params.ByName(UrlParamAppid)
+		group    = params.ByName(UrlParamGroup)
+		myAppid  = r.Header.Get(HttpHeaderAppid)
+		dryRun   = r.URL.Query().Get("dryrun") == "true"
+		realIp   = getHttpRemoteIp(r)
+	)
+
+	if !this.throttleSubStatus.Pour(realIp, 1) {
+		writeQuotaExceeded(w)
+		return
+	}
+
+	if err := manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
+		hisAppid, topic, group); err != nil {
+		log.Error("failover[%s] %s(%s) {app:%s topic:%s ver:%s group:%s} %v",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, group, err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	if Options.ReplicaZone == "" {
+		writeBadRequest(w, "replication disabled")
+		return
+	}
+
+	if !manager.Default.IsGlobalTopic(hisAppid, topic) {
+		writeBadRequest(w, "not a global topic")
+		return
+	}
+
+	cluster, found := manager.Default.LookupCluster(hisAppid)
+	if !found {
+		log.Error("failover[%s] %s(%s) {app:%s topic:%s ver:%s group:%s} cluster not found",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, group)
+
+		writeBadRequest(w, "invalid appid")
+		return
+	}
+
+	log.Info("failover[%s] %s(%s) {app:%s topic:%s ver:%s group:%s dryrun:%v}",
+		myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, group, dryRun)
+
+	peerZone := gzk.NewZkZone(gzk.DefaultConfig(Options.ReplicaZone, ctx.ZoneZkAddrs(Options.ReplicaZone)))
+	defer peerZone.Close()
+
+	peerStore := peerZone.NewCluster(cluster).NewOffsetStore()
+	defer peerStore.Close()
+	localStore := meta.Default.ZkCluster(cluster).NewOffsetStore()
+	defer localStore.Close()
+
+	var (
+		realGroup = myAppid + "." + group
+		rawTopic  = manager.Default.KafkaTopic(hisAppid, topic, ver)
+		fromPeer  = replicaRouteName(Options.ReplicaZone, cluster, Options.Zone)
+		toPeer    = replicaRouteName(Options.Zone, cluster, Options.ReplicaZone)
+	)
+
+	partitions := make([]failoverPartition, 0)
+	for _, partitionId := range meta.Default.TopicPartitions(cluster, rawTopic) {
+		peerOffset, err := peerStore.FetchOffset(realGroup, rawTopic, partitionId)
+		if err == nil && peerOffset < 0 {
+			// never consumed in peer zone
+			continue
+		}
+
+		var fromCp, toCp *gzk.MirrorCheckpoint
+		if err == nil {
+			fromCp, err = this.gw.zkzone.LoadMirrorCheckpoint(fromPeer, rawTopic, partitionId)
+		}
+		if err == nil {
+			toCp, err = peerZone.LoadMirrorCheckpoint(toPeer, rawTopic, partitionId)
+		}
+		if err != nil {
+			log.Error("failover[%s] %s(%s) {app:%s topic:%s ver:%s group:%s partition:%d} %v",
+				myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, group, partitionId, err)
+
+			writeServerError(w, err.Error())
+			return
+		}
+
+		p := failoverPartition{
+			Partition:   partitionId,
+			PeerOffset:  peerOffset,
+			LocalOffset: failoverOffset(fromCp, toCp, peerOffset),
+		}
+		partitions = append(partitions, p)
+		if dryRun || p.LocalOffset < 0 {
+			continue
+		}
+
+		if err = localStore.ResetOffset(realGroup, rawTopic, partitionId, p.LocalOffset); err != nil {
+			log.Error("failover[%s] %s(%s) {app:%s topic:%s ver:%s group:%s partition:%d} %v",
+				myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, group, partitionId, err)
+
+			writeServerError(w, err.Error())
+			return
+		}
+	}
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"peer":       Options.ReplicaZone,
+		"partitions": partitions,
+	})
+	w.Write(b)
+}
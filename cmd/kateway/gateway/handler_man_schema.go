@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/schema"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+const maxSchemaSize = 64 << 10
+
+//go:generate goannotation $GOFILE
+// @rest GET /v1/schemas/:appid/:topic/:ver
+// returns the active schema of a topic with its id in X-Schema-Id header.
+func (this *manServer) schemaHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	hisAppid := params.ByName(UrlParamAppid)
+	myAppid := r.Header.Get(HttpHeaderAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+	realIp := getHttpRemoteIp(r)
+
+	log.Info("schema[%s] %s(%s) {app:%s topic:%s ver:%s UA:%s}",
+		myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"))
+
+	// TODO authorization
+
+	_, found := manager.Default.LookupCluster(hisAppid)
+	if !found {
+		writeBadRequest(w, "invalid appid")
+		return
+	}
+
+	sch, err := schema.Default.Active(hisAppid, topic, ver)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	w.Header().Set(HttpHeaderSchemaId, sch.Id)
+	w.Write([]byte(strings.TrimSpace(sch.Definition)))
+}
+
+// @rest GET /v1/schemas/:appid/:topic/:ver/versions
+func (this *manServer) schemaVersionsHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	hisAppid := params.ByName(UrlParamAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+
+	log.Info("schema versions[%s] %s(%s) {app:%s topic:%s ver:%s}", r.Header.Get(HttpHeaderAppid),
+		r.RemoteAddr, getHttpRemoteIp(r), hisAppid, topic, ver)
+
+	versions, compatibility, err := schema.Default.Versions(hisAppid, topic, ver)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	type schemaVersion struct {
+		Id     string          `json:"id"`
+		Schema json.RawMessage `json:"schema"`
+	}
+	var out = struct {
+		Compatibility schema.Compatibility `json:"compatibility"`
+		Versions      []schemaVersion      `json:"versions"`
+	}{
+		Compatibility: compatibility,
+	}
+	for _, v := range versions {
+		out.Versions = append(out.Versions, schemaVersion{Id: v.Id, Schema: json.RawMessage(v.Definition)})
+	}
+
+	b, _ := json.Marshal(out)
+	w.Write(b)
+}
+
+// @rest GET /v1/schema/:id
+// subscribers fetch the writer schema by the X-Schema-Id of messages.
+func (this *manServer) schemaByIdHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	sch, err := schema.Default.ById(params.ByName("id"))
+	if err != nil {
+		writeNotFound(w)
+		return
+	}
+
+	// schema of an id never changes
+	w.Header().Set("Cache-Control", "max-age=86400")
+	w.Header().Set(HttpHeaderSchemaId, sch.Id)
+	w.Write([]byte(strings.TrimSpace(sch.Definition)))
+}
+
+// @rest POST /v1/schemas/:appid/:topic/:ver
+// registers the body as a new schema version of the topic.
+// the new schema must be compatible with the active one, otherwise 409.
+func (this *manServer) registerSchemaHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	hisAppid := params.ByName(UrlParamAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+	appid := r.Header.Get(HttpHeaderAppid)
+	pubkey := r.Header.Get(HttpHeaderPubkey)
+	realIp := getHttpRemoteIp(r)
+
+	if !this.authSchemaOwner(appid, pubkey, hisAppid, topic) {
+		log.Warn("suspicous register schema %s(%s) {appid:%s pubkey:%s app:%s topic:%s ver:%s}",
+			r.RemoteAddr, realIp, appid, pubkey, hisAppid, topic, ver)
+
+		writeAuthFailure(w, manager.ErrAuthenticationFail)
+		return
+	}
+
+	definition, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSchemaSize))
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	t0 := time.Now()
+	id, err := schema.Default.Register(hisAppid, topic, ver, string(definition))
+	if err != nil {
+		log.Error("register schema[%s] %s(%s) {app:%s topic:%s ver:%s} %v",
+			appid, r.RemoteAddr, realIp, hisAppid, topic, ver, err)
+
+		if _, incompatible := err.(*schema.IncompatibleError); incompatible {
+			_writeErrorResponse(w, err.Error(), http.StatusConflict)
+		} else {
+			writeBadRequest(w, err.Error())
+		}
+		return
+	}
+
+	log.Info("register schema[%s] %s(%s) {app:%s topic:%s ver:%s} %s %s",
+		appid, r.RemoteAddr, realIp, hisAppid, topic, ver, id, time.Since(t0))
+
+	w.Header().Set(HttpHeaderSchemaId, id)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(`{"id":"` + id + `"}`))
+}
+
+// @rest PUT /v1/schemas/:appid/:topic/:ver?compat=backward|forward|full|none
+func (this *manServer) schemaCompatibilityHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	hisAppid := params.ByName(UrlParamAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+	appid := r.Header.Get(HttpHeaderAppid)
+	pubkey := r.Header.Get(HttpHeaderPubkey)
+	realIp := getHttpRemoteIp(r)
+
+	if !this.authSchemaOwner(appid, pubkey, hisAppid, topic) {
+		log.Warn("suspicous schema compatibility %s(%s) {appid:%s pubkey:%s app:%s topic:%s ver:%s}",
+			r.RemoteAddr, realIp, appid, pubkey, hisAppid, topic, ver)
+
+		writeAuthFailure(w, manager.ErrAuthenticationFail)
+		return
+	}
+
+	compatibility, err := schema.ParseCompatibility(r.URL.Query().Get("compat"))
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	if err = schema.Default.SetCompatibility(hisAppid, topic, ver, compatibility); err != nil {
+		log.Error("schema compatibility[%s] %s(%s) {app:%s topic:%s ver:%s} %v",
+			appid, r.RemoteAddr, realIp, hisAppid, topic, ver, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	log.Info("schema compatibility[%s] %s(%s) {app:%s topic:%s ver:%s} %s",
+		appid, r.RemoteAddr, realIp, hisAppid, topic, ver, compatibility)
+
+	w.Write(ResponseOk)
+}
+
+// authSchemaOwner allows the topic owner and admin to manage its schemas.
+func (this *manServer) authSchemaOwner(appid, pubkey, hisAppid, topic string) bool {
+	if manager.Default.AuthAdmin(appid, pubkey) {
+		return true
+	}
+
+	return appid == hisAppid && manager.Default.OwnTopic(appid, pubkey, topic) == nil
+}
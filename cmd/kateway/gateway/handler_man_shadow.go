@@ -0,0 +1,583 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+	"github.com/funkygao/gafka/cmd/kateway/filter"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/meta"
+	"github.com/funkygao/gafka/cmd/kateway/store"
+	"github.com/funkygao/gafka/sla"
+	gzk "github.com/funkygao/gafka/zk"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+const (
+	maxShadowPeek       = 100
+	maxShadowReplay     = 10000
+	maxShadowReplayRate = 1000
+	maxShadowReplayTime = time.Second * 30 // man server has 1m write timeout
+	shadowFetchWait     = time.Second * 2  // give up a partition if no msg arrives within
+)
+
+// shadowQueue is the retry or dead queue of a consumer group.
+type shadowQueue struct {
+	cluster     string
+	masterTopic string // raw topic the shadow belongs to
+	topic       string // raw shadow topic
+	group       string // real group name
+	shadow      string // retry|dead
+}
+
+type shadowPartition struct {
+	Partition int32 `json:"partition"`
+	Oldest    int64 `json:"oldest"`
+	Newest    int64 `json:"newest"`    // offset of the next msg to be pub'ed
+	Committed int64 `json:"committed"` // -1 if the group never consumed the shadow
+	Lag       int64 `json:"lag"`
+}
+
+// start returns where the group resumes consuming the partition.
+func (this shadowPartition) start() int64 {
+	if this.Committed < this.Oldest-1 {
+		return this.Oldest
+	}
+	return this.Committed + 1
+}
+
+type shadowMsg struct {
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Key       string `json:"key,omitempty"`
+	Tag       string `json:"tag,omitempty"`
+	Body      string `json:"body"`
+}
+
+type shadowReplayResult struct {
+	Partition int32 `json:"partition"`
+	From      int64 `json:"from"`
+	Next      int64 `json:"next"`  // where next replay starts
+	Purge     int64 `json:"purge"` // purge upto it drops only the replayed msgs
+	Replayed  int   `json:"replayed"`
+	Skipped   int   `json:"skipped"` // filter unmatched
+}
+
+// skip records a filter unmatched msg, which must survive the purge.
+func (this *shadowReplayResult) skip(offset int64) {
+	if this.Skipped == 0 {
+		this.Purge = offset
+	}
+	this.Skipped++
+}
+
+// done settles the purge offset once Next is known.
+func (this *shadowReplayResult) done() {
+	if this.Skipped == 0 {
+		this.Purge = this.Next
+	}
+}
+
+// shadowQueueOf authenticates the request and resolves the shadow queue of
+// q param. It writes the error response and returns nil on failure.
+func (this *manServer) shadowQueueOf(op string, w http.ResponseWriter, r *http.Request,
+	params httprouter.Params) *shadowQueue {
+	var (
+		group    = params.ByName(UrlParamGroup)
+		ver      = params.ByName(UrlParamVersion)
+		topic    = params.ByName(UrlParamTopic)
+		hisAppid = params.ByName(UrlParamAppid)
+		myAppid  = r.Header.Get(HttpHeaderAppid)
+		shadow   = r.URL.Query().Get("q")
+		realIp   = getHttpRemoteIp(r)
+	)
+
+	if !manager.Default.ValidateGroupName(r.Header, group) {
+		log.Warn("shadow %s[%s/%s] %s(%s) %s.%s.%s illegal group name",
+			op, myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver)
+
+		writeBadRequest(w, "illegal group")
+		return nil
+	}
+
+	if err := manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
+		hisAppid, topic, group); err != nil {
+		log.Error("shadow %s[%s/%s] %s(%s) %s.%s.%s %v",
+			op, myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, err)
+
+		writeAuthFailure(w, err)
+		return nil
+	}
+
+	if !sla.ValidateShadowName(shadow) {
+		log.Error("shadow %s[%s/%s] %s(%s) %s.%s.%s q:%s invalid shadow name",
+			op, myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, shadow)
+
+		writeBadRequest(w, "invalid shadow name")
+		return nil
+	}
+
+	if !manager.Default.IsShadowedTopic(hisAppid, topic, ver, myAppid, group) {
+		log.Error("shadow %s[%s/%s] %s(%s) %s.%s.%s q:%s not a shadowed topic",
+			op, myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, shadow)
+
+		writeBadRequest(w, "register shadow first")
+		return nil
+	}
+
+	cluster, found := manager.Default.LookupCluster(hisAppid)
+	if !found {
+		log.Error("shadow %s[%s/%s] %s(%s) %s.%s.%s cluster not found",
+			op, myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver)
+
+		writeBadRequest(w, "invalid appid")
+		return nil
+	}
+
+	log.Info("shadow %s[%s/%s] %s(%s) %s.%s.%s q:%s %s",
+		op, myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, shadow, r.URL.RawQuery)
+
+	return &shadowQueue{
+		cluster:     cluster,
+		masterTopic: manager.Default.KafkaTopic(hisAppid, topic, ver),
+		topic:       manager.Default.ShadowTopic(shadow, myAppid, hisAppid, topic, ver, group),
+		group:       myAppid + "." + group,
+		shadow:      shadow,
+	}
+}
+
+// partitions returns the offsets of the shadow partitions, all of them if
+// partitionId is -1.
+func (this *shadowQueue) partitions(kfk sarama.Client, offsetStore gzk.OffsetStore,
+	partitionId int32) ([]shadowPartition, error) {
+	partitionIds, err := kfk.Partitions(this.topic)
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]shadowPartition, 0, len(partitionIds))
+	for _, id := range partitionIds {
+		if partitionId != -1 && id != partitionId {
+			continue
+		}
+
+		p := shadowPartition{Partition: id}
+		if p.Oldest, err = kfk.GetOffset(this.topic, id, sarama.OffsetOldest); err != nil {
+			return nil, err
+		}
+		if p.Newest, err = kfk.GetOffset(this.topic, id, sarama.OffsetNewest); err != nil {
+			return nil, err
+		}
+		if p.Committed, err = offsetStore.FetchOffset(this.group, this.topic, id); err != nil {
+			return nil, err
+		}
+
+		p.Lag = p.Newest - p.start()
+		r = append(r, p)
+	}
+
+	if partitionId != -1 && len(r) == 0 {
+		return nil, sarama.ErrUnknownTopicOrPartition
+	}
+
+	return r, nil
+}
+
+// consume feeds fn with msgs of a partition in [offset, end) till fn returns
+// false, and returns the offset of the next msg not consumed.
+func (this *shadowQueue) consume(consumer sarama.Consumer, partitionId int32, offset, end int64,
+	fn func(*sarama.ConsumerMessage) bool) (int64, error) {
+	if offset >= end {
+		return offset, nil
+	}
+
+	pc, err := consumer.ConsumePartition(this.topic, partitionId, offset)
+	if err != nil {
+		return offset, err
+	}
+	defer pc.Close()
+
+	for {
+		select {
+		case msg := <-pc.Messages():
+			offset = msg.Offset + 1
+			if !fn(msg) || offset >= end {
+				return offset, nil
+			}
+
+		case err := <-pc.Errors():
+			return offset, err
+
+		case <-time.After(shadowFetchWait):
+			return offset, nil
+		}
+	}
+}
+
+// parseShadowCursor parses query params partition and offset, -1 if absent.
+func parseShadowCursor(query url.Values) (partitionId int32, offset int64, err error) {
+	partitionId, offset = -1, -1
+
+	if v := query.Get("partition"); v != "" {
+		var p int
+		if p, err = strconv.Atoi(v); err != nil || p < 0 {
+			return -1, -1, errors.New("invalid partition")
+		}
+		partitionId = int32(p)
+	}
+
+	if v := query.Get("offset"); v != "" {
+		if partitionId == -1 {
+			return -1, -1, errors.New("offset without partition")
+		}
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil || offset < 0 {
+			return -1, -1, errors.New("invalid offset")
+		}
+	}
+
+	return
+}
+
+//go:generate goannotation $GOFILE
+// @rest GET /v1/shadow/:appid/:topic/:ver/:group?q=<dead|retry>
+// inspect the offsets and lag of each shadow partition
+func (this *manServer) shadowStatusHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	if !this.throttleSubStatus.Pour(getHttpRemoteIp(r), 1) {
+		writeQuotaExceeded(w)
+		return
+	}
+
+	sq := this.shadowQueueOf("stat", w, r, params)
+	if sq == nil {
+		return
+	}
+
+	zkcluster := meta.Default.ZkCluster(sq.cluster)
+	kfk, err := sarama.NewClient(zkcluster.BrokerList(), sarama.NewConfig())
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
+	}
+	defer kfk.Close()
+
+	offsetStore := zkcluster.NewOffsetStore()
+	defer offsetStore.Close()
+
+	partitions, err := sq.partitions(kfk, offsetStore, -1)
+	if err != nil {
+		log.Error("shadow stat %s %s: %v", sq.topic, sq.group, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"topic":      sq.topic,
+		"online":     zkcluster.OnlineConsumersCount(sq.topic, sq.group),
+		"partitions": partitions,
+	})
+	w.Write(b)
+}
+
+// @rest GET /v1/shadow/:appid/:topic/:ver/:group/msgs?q=<dead|retry>&partition=0&offset=xx&n=10
+// browse a shadow partition from offset, which defaults to where the group resumes.
+// the X-Offset of the response is the offset for the next page.
+func (this *manServer) shadowPeekHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	sq := this.shadowQueueOf("peek", w, r, params)
+	if sq == nil {
+		return
+	}
+
+	query := r.URL.Query()
+	n, err := getHttpQueryInt(&query, "n", 10)
+	if err != nil || n <= 0 {
+		writeBadRequest(w, "invalid n param")
+		return
+	}
+	if n > maxShadowPeek {
+		n = maxShadowPeek
+	}
+	partitionId, offset, err := parseShadowCursor(query)
+	if err == nil && partitionId == -1 {
+		err = errors.New("partition required")
+	}
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	zkcluster := meta.Default.ZkCluster(sq.cluster)
+	kfk, err := sarama.NewClient(zkcluster.BrokerList(), sarama.NewConfig())
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
+	}
+	defer kfk.Close()
+
+	offsetStore := zkcluster.NewOffsetStore()
+	defer offsetStore.Close()
+
+	partitions, err := sq.partitions(kfk, offsetStore, partitionId)
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
+	}
+	p := partitions[0]
+	if offset == -1 {
+		offset = p.start()
+	} else if offset < p.Oldest || offset > p.Newest {
+		writeBadRequest(w, sarama.ErrOffsetOutOfRange.Error())
+		return
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(kfk)
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
+	}
+	defer consumer.Close()
+
+	msgs := make([]shadowMsg, 0, n)
+	next, err := sq.consume(consumer, partitionId, offset, p.Newest, func(msg *sarama.ConsumerMessage) bool {
+		m := shadowMsg{
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+			Key:       string(msg.Key),
+		}
+		if headers, bodyIdx, err := envelope.Open(msg.Value); err != nil {
+			m.Body = string(msg.Value)
+		} else {
+			m.Tag = headers.String()
+			m.Body = string(msg.Value[bodyIdx:])
+		}
+		msgs = append(msgs, m)
+		return len(msgs) < n
+	})
+	if err != nil {
+		log.Error("shadow peek %s/%d %s: %v", sq.topic, partitionId, sq.group, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	b, _ := json.Marshal(msgs)
+	w.Header().Set(HttpHeaderPartition, strconv.Itoa(int(partitionId)))
+	w.Header().Set(HttpHeaderOffset, strconv.FormatInt(next, 10))
+	w.Write(b)
+}
+
+// @rest POST /v1/shadow/:appid/:topic/:ver/:group/replay?q=<dead|retry>&n=1000&rate=100&filter=<expr>&partition=0&offset=xx
+// pub shadow msgs back to the master topic, from where the group resumes up to
+// the newest at request time. the shadow offsets are not touched, purge with
+// the purge offsets of the result to drop the replayed msgs: with filter they
+// stop at the first skipped msg, while next offsets move past the skipped.
+func (this *manServer) shadowReplayHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	if !this.throttleSubStatus.Pour(getHttpRemoteIp(r), 1) {
+		writeQuotaExceeded(w)
+		return
+	}
+
+	if this.gw.pubServer == nil {
+		writeBadRequest(w, "pub not enabled on this kateway")
+		return
+	}
+
+	sq := this.shadowQueueOf("replay", w, r, params)
+	if sq == nil {
+		return
+	}
+
+	query := r.URL.Query()
+	n, err := getHttpQueryInt(&query, "n", 1000)
+	if err != nil || n <= 0 || n > maxShadowReplay {
+		writeBadRequest(w, "invalid n param")
+		return
+	}
+	rate, err := getHttpQueryInt(&query, "rate", 100)
+	if err != nil || rate <= 0 || rate > maxShadowReplayRate {
+		writeBadRequest(w, "invalid rate param")
+		return
+	}
+	if time.Duration(n/rate)*time.Second > maxShadowReplayTime {
+		writeBadRequest(w, "too slow rate for n")
+		return
+	}
+	var msgFilter *filter.Filter
+	if expr := query.Get("filter"); expr != "" {
+		if msgFilter, err = filter.Compile(expr); err != nil {
+			writeBadRequest(w, "illegal filter")
+			return
+		}
+	}
+	partitionId, offset, err := parseShadowCursor(query)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	zkcluster := meta.Default.ZkCluster(sq.cluster)
+	kfk, err := sarama.NewClient(zkcluster.BrokerList(), sarama.NewConfig())
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
+	}
+	defer kfk.Close()
+
+	offsetStore := zkcluster.NewOffsetStore()
+	defer offsetStore.Close()
+
+	partitions, err := sq.partitions(kfk, offsetStore, partitionId)
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(kfk)
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
+	}
+	defer consumer.Close()
+
+	throttle := time.NewTicker(time.Second / time.Duration(rate))
+	defer throttle.Stop()
+
+	var (
+		results  = make([]shadowReplayResult, 0, len(partitions))
+		replayed int
+		pubErr   error
+	)
+	for _, p := range partitions {
+		result := shadowReplayResult{Partition: p.Partition, From: p.start()}
+		if offset != -1 {
+			result.From = offset
+		}
+		if result.From < p.Oldest || result.From > p.Newest {
+			writeBadRequest(w, sarama.ErrOffsetOutOfRange.Error())
+			return
+		}
+
+		result.Next, err = sq.consume(consumer, p.Partition, result.From, p.Newest, func(msg *sarama.ConsumerMessage) bool {
+			if msgFilter != nil {
+				headers, bodyIdx, err := envelope.Open(msg.Value)
+				if err != nil || !msgFilter.Match(headers, msg.Value[bodyIdx:]) {
+					result.skip(msg.Offset)
+					return true
+				}
+			}
+
+			<-throttle.C
+			if _, _, pubErr = store.DefaultPubStore.SyncPub(sq.cluster, sq.masterTopic, msg.Key, msg.Value); pubErr != nil {
+				return false
+			}
+
+			result.Replayed++
+			replayed++
+			return replayed < n
+		})
+		if pubErr != nil {
+			// the failed msg is not replayed
+			result.Next--
+			err = pubErr
+		}
+		result.done()
+		results = append(results, result)
+
+		if err != nil || replayed >= n {
+			break
+		}
+	}
+
+	log.Info("shadow replay %s -> %s %s: %d replayed, err:%v %+v", sq.topic, sq.masterTopic, sq.group, replayed, err, results)
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"replayed": replayed,
+		"results":  results,
+	})
+	if err != nil {
+		// tell client where to resume
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	w.Write(b)
+}
+
+// @rest PUT /v1/shadow/:appid/:topic/:ver/:group/purge?q=<dead|retry>&partition=0&offset=xx
+// drop the shadow msgs by moving the group offset to the newest or upto offset,
+// offset is exclusive so the next offset of replay result can be used.
+func (this *manServer) shadowPurgeHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	if !this.throttleSubStatus.Pour(getHttpRemoteIp(r), 1) {
+		writeQuotaExceeded(w)
+		return
+	}
+
+	sq := this.shadowQueueOf("purge", w, r, params)
+	if sq == nil {
+		return
+	}
+
+	partitionId, offset, err := parseShadowCursor(r.URL.Query())
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	zkcluster := meta.Default.ZkCluster(sq.cluster)
+	if online := zkcluster.OnlineConsumersCount(sq.topic, sq.group); online > 0 {
+		// the online consumers will overwrite the offsets
+		writeBadRequest(w, "purge a online shadow not allowed")
+		return
+	}
+
+	kfk, err := sarama.NewClient(zkcluster.BrokerList(), sarama.NewConfig())
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
+	}
+	defer kfk.Close()
+
+	offsetStore := zkcluster.NewOffsetStore()
+	defer offsetStore.Close()
+
+	partitions, err := sq.partitions(kfk, offsetStore, partitionId)
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
+	}
+
+	purged := make(map[int32]int64, len(partitions)) // partition: msgs purged
+	for _, p := range partitions {
+		upto := p.Newest
+		if offset != -1 {
+			if offset < p.start() || offset > p.Newest {
+				writeBadRequest(w, sarama.ErrOffsetOutOfRange.Error())
+				return
+			}
+			upto = offset
+		}
+		if upto == p.start() {
+			continue
+		}
+
+		// offset store keeps the last consumed offset
+		if err = offsetStore.ResetOffset(sq.group, sq.topic, p.Partition, upto-1); err != nil {
+			log.Error("shadow purge %s/%d %s: %v", sq.topic, p.Partition, sq.group, err)
+
+			writeServerError(w, err.Error())
+			return
+		}
+
+		purged[p.Partition] = upto - p.start()
+	}
+
+	log.Info("shadow purge %s %s: %+v", sq.topic, sq.group, purged)
+
+	b, _ := json.Marshal(purged)
+	w.Write(b)
+}
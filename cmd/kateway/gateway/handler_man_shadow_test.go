@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestParseShadowCursor(t *testing.T) {
+	p, o, err := parseShadowCursor(url.Values{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int32(-1), p)
+	assert.Equal(t, int64(-1), o)
+
+	p, o, err = parseShadowCursor(url.Values{"partition": {"2"}, "offset": {"100"}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int32(2), p)
+	assert.Equal(t, int64(100), o)
+
+	_, _, err = parseShadowCursor(url.Values{"offset": {"100"}})
+	assert.Equal(t, "offset without partition", err.Error())
+	_, _, err = parseShadowCursor(url.Values{"partition": {"-1"}})
+	assert.Equal(t, "invalid partition", err.Error())
+	_, _, err = parseShadowCursor(url.Values{"partition": {"1"}, "offset": {"x"}})
+	assert.Equal(t, "invalid offset", err.Error())
+}
+
+func TestShadowPartitionStart(t *testing.T) {
+	// never consumed
+	assert.Equal(t, int64(0), shadowPartition{Oldest: 0, Newest: 10, Committed: -1}.start())
+	assert.Equal(t, int64(5), shadowPartition{Oldest: 5, Newest: 10, Committed: -1}.start())
+
+	// committed offset purged by retention
+	assert.Equal(t, int64(5), shadowPartition{Oldest: 5, Newest: 10, Committed: 2}.start())
+
+	assert.Equal(t, int64(5), shadowPartition{Oldest: 5, Newest: 10, Committed: 4}.start())
+	assert.Equal(t, int64(8), shadowPartition{Oldest: 5, Newest: 10, Committed: 7}.start())
+}
+
+func TestShadowReplayResultPurge(t *testing.T) {
+	r := shadowReplayResult{From: 5}
+	r.Next = 9
+	r.done()
+	assert.Equal(t, int64(9), r.Purge)
+
+	// skipped msgs are never purged
+	r = shadowReplayResult{From: 5}
+	r.skip(6)
+	r.skip(8)
+	r.Next = 9
+	r.done()
+	assert.Equal(t, 2, r.Skipped)
+	assert.Equal(t, int64(6), r.Purge)
+}
@@ -246,6 +246,7 @@ func (this *manServer) resetSubOffsetHandler(w http.ResponseWriter, r *http.Requ
 		topic     string
 		ver       string
 		partition string
+		partN     int
 		myAppid   string
 		hisAppid  string
 		offset    string
@@ -269,6 +270,9 @@ func (this *manServer) resetSubOffsetHandler(w http.ResponseWriter, r *http.Requ
 	myAppid = r.Header.Get(HttpHeaderAppid)
 
 	offsetN, err = strconv.ParseInt(offset, 10, 64)
+	if err == nil {
+		partN, err = strconv.Atoi(partition)
+	}
 	if err != nil {
 		log.Error("sub reset offset[%s] %s(%s) {app:%s topic:%s ver:%s partition:%s group:%s offset:%s} %v",
 			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, partition, group, offset, err)
@@ -306,10 +310,12 @@ func (this *manServer) resetSubOffsetHandler(w http.ResponseWriter, r *http.Requ
 		myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, partition, group, offset)
 
 	// TODO stop all consumers of this group
-	zkcluster := meta.Default.ZkCluster(cluster)
+	store := meta.Default.ZkCluster(cluster).NewOffsetStore()
+	defer store.Close()
+
 	realGroup := myAppid + "." + group
 	rawTopic := manager.Default.KafkaTopic(hisAppid, topic, ver)
-	err = zkcluster.ResetConsumerGroupOffset(rawTopic, realGroup, partition, offsetN)
+	err = store.ResetOffset(realGroup, rawTopic, int32(partN), offsetN)
 	if err != nil {
 		log.Error("sub reset offset[%s] %s(%s) {app:%s topic:%s ver:%s partition:%s group:%s offset:%s} %v",
 			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, partition, group, offset, err)
@@ -322,7 +328,7 @@ func (this *manServer) resetSubOffsetHandler(w http.ResponseWriter, r *http.Requ
 }
 
 // @rest DELETE /v1/groups/:appid/:topic/:ver/:group
-// TODO delete shadow consumers too
+// the shadow consumers of the group are deleted too
 func (this *manServer) delSubGroupHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	var (
 		topic    string
@@ -366,10 +372,30 @@ func (this *manServer) delSubGroupHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// shadow consumers share the group znode with different topics
+	topics := []string{manager.Default.KafkaTopic(hisAppid, topic, ver)}
+	if manager.Default.IsShadowedTopic(hisAppid, topic, ver, myAppid, group) {
+		topics = append(topics,
+			manager.Default.ShadowTopic(sla.SlaKeyRetryTopic, myAppid, hisAppid, topic, ver, group),
+			manager.Default.ShadowTopic(sla.SlaKeyDeadLetterTopic, myAppid, hisAppid, topic, ver, group))
+	}
+
 	zkcluster := meta.Default.ZkCluster(cluster)
 	if group != "" {
 		group = myAppid + "." + group
 	}
+
+	// DeleteRecursive is not atomic, check before deleting to avoid a half deleted group
+	for _, t := range topics {
+		if zkcluster.OnlineConsumersCount(t, group) > 0 {
+			log.Warn("unsub[%s] %s(%s) {app:%s, topic:%s, ver:%s, group:%s} %s still online",
+				myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, group, t)
+
+			writeBadRequest(w, "delete a online group not allowed")
+			return
+		}
+	}
+
 	log.Info("unsub[%s] %s(%s) {app:%s, topic:%s, ver:%s, group:%s} zk:%s",
 		myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, group, zkcluster.ConsumerGroupRoot(group))
 
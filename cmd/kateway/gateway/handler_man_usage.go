@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/quota"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+//go:generate goannotation $GOFILE
+// @rest GET /v1/usage/:appid?hour=2016010215
+// hour is in UTC, defaults to the current hour
+// response: {"appid":"app1","hour":"2016010215","usage":{"pub_msgs":10,"pub_bytes":1024,"sub_msgs":10,"sub_bytes":1024}}
+func (this *manServer) usageHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var (
+		appid    = r.Header.Get(HttpHeaderAppid)
+		pubkey   = r.Header.Get(HttpHeaderPubkey)
+		hisAppid = params.ByName(UrlParamAppid)
+		realIp   = getHttpRemoteIp(r)
+	)
+
+	if !this.throttleSubStatus.Pour(realIp, 1) {
+		writeQuotaExceeded(w)
+		return
+	}
+
+	// admin or the app itself
+	if !manager.Default.AuthAdmin(appid, pubkey) {
+		if appid != hisAppid {
+			writeAuthFailure(w, manager.ErrAuthenticationFail)
+			return
+		}
+
+		if err := manager.Default.Auth(appid, pubkey); err != nil {
+			writeAuthFailure(w, err)
+			return
+		}
+	}
+
+	if quota.Default == nil {
+		writeBadRequest(w, "quota disabled")
+		return
+	}
+
+	hour := r.URL.Query().Get("hour")
+	if hour == "" {
+		hour = quota.UsageHour(time.Now())
+	} else if _, err := time.Parse(quota.UsageHourLayout, hour); err != nil {
+		writeBadRequest(w, "invalid hour")
+		return
+	}
+
+	usage, err := quota.Default.Usage(hisAppid, hour)
+	if err != nil {
+		log.Error("usage[%s] %s(%s) {appid:%s hour:%s} %v", appid, r.RemoteAddr, realIp, hisAppid, hour, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"appid": hisAppid,
+		"hour":  hour,
+		"usage": usage,
+	})
+	w.Write(b)
+}
@@ -8,8 +8,12 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
 	"github.com/funkygao/gafka/cmd/kateway/hh"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/quota"
+	"github.com/funkygao/gafka/cmd/kateway/schema"
 	"github.com/funkygao/gafka/cmd/kateway/store"
 	"github.com/funkygao/gafka/mpool"
 	"github.com/funkygao/httprouter"
@@ -75,6 +79,15 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 		return
 	}
 
+	if wait := this.gw.quotas.checkPub(appid, topic, 1, int64(msgLen)); wait > 0 {
+		log.Warn("pub[%s] %s(%s) {topic:%s ver:%s UA:%s} quota exceeded, retry after %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), wait)
+
+		this.pubMetrics.ClientError.Inc(1)
+		writeQuotaExceededAfter(w, wait)
+		return
+	}
+
 	query := r.URL.Query() // reuse the query will save 100ns
 
 	partitionKey = query.Get("key")
@@ -90,23 +103,60 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 
 	var msg *mpool.Message
 	tag = r.Header.Get(HttpHeaderMsgTag)
-	if tag != "" {
-		if len(tag) > Options.MaxMsgTagLen {
-			this.respond4XX(appid, w, "too big tag", http.StatusBadRequest)
-			return
+	if len(tag) > Options.MaxMsgTagLen {
+		this.respond4XX(appid, w, "too big tag", http.StatusBadRequest)
+		return
+	}
+	if err := validateTag(tag); err != nil {
+		log.Warn("pub[%s] %s(%s) {topic:%s ver:%s UA:%s} %s: %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), err, tag)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	msgId := r.Header.Get(HttpHeaderMsgId)
+	if msgId != "" && !validateMsgId(msgId) {
+		log.Warn("pub[%s] %s(%s) {topic:%s ver:%s UA:%s} illegal msg id: %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), msgId)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, "illegal msg id", http.StatusBadRequest)
+		return
+	}
+
+	var activeSchema *schema.Schema
+	if Options.ValidateSchema {
+		// topics without schema are not validated
+		if activeSchema, _ = schema.Default.Active(appid, topic, ver); activeSchema != nil {
+			// stamp the writer schema id so that subscribers can fetch the schema
+			tag = addSchemaTag(tag, activeSchema.Id)
 		}
+	}
 
-		msgSz := tagLen(tag) + msgLen
-		msg = mpool.NewMessage(msgSz)
-		msg.Body = msg.Body[0:msgSz]
-	} else {
-		msg = mpool.NewMessage(msgLen)
-		msg.Body = msg.Body[0:msgLen]
+	if msgId != "" {
+		// the id travels with the msg so that hinted handoff preserves it
+		tag = addMsgIdTag(tag, msgId)
 	}
 
+	// tags are carried as envelope headers ahead of the payload
+	var (
+		headers    envelope.Headers
+		payloadIdx int
+	)
+	if tag != "" {
+		headers = envelope.ParseTag(tag)
+		payloadIdx = envelope.Len(headers)
+	}
+
+	msgSz := payloadIdx + msgLen
+	msg = mpool.NewMessage(msgSz)
+	msg.Body = msg.Body[0:msgSz]
+
 	// get the raw POST message, if body more than content-length ignore the extra payload
 	lbr := io.LimitReader(r.Body, Options.MaxPubSize+1)
-	if _, err := io.ReadAtLeast(lbr, msg.Body, msgLen); err != nil {
+	if _, err := io.ReadAtLeast(lbr, msg.Body[payloadIdx:], msgLen); err != nil {
 		msg.Free()
 
 		log.Error("pub[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
@@ -117,8 +167,30 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 		return
 	}
 
-	if tag != "" {
-		AddTagToMessage(msg, tag)
+	if activeSchema != nil {
+		if err := validateMessage(activeSchema, r.Header.Get("Content-Type"), msg.Body[payloadIdx:]); err != nil {
+			msg.Free()
+
+			log.Warn("pub[%s] %s(%s) {topic:%s ver:%s UA:%s} schema %s: %v",
+				appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), activeSchema.Id, err)
+
+			this.pubMetrics.ClientError.Inc(1)
+			this.respond4XX(appid, w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set(HttpHeaderSchemaId, activeSchema.Id)
+	}
+
+	if payloadIdx > 0 {
+		envelope.Encode(msg.Body, headers)
+	} else if envelope.IsAmbiguous(msg.Body) {
+		// binary payload that looks enveloped, subscribers would strip it
+		wrapped := mpool.NewMessage(envelope.Len(nil) + msgLen)
+		wrapped.Body = wrapped.Body[:envelope.Len(nil)+msgLen]
+		copy(wrapped.Body[envelope.Encode(wrapped.Body, nil):], msg.Body)
+		msg.Free()
+		msg = wrapped
 	}
 
 	if !Options.DisableMetrics {
@@ -140,9 +212,33 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 		partition int32
 		offset    int64
 		err       error
+		accepted  bool // partition/offset unknown: hinted handoff or async pub
+		dedupKey  string
 		rawTopic  = manager.Default.KafkaTopic(appid, topic, ver)
 	)
 
+	if msgId != "" && dedup.Default != nil {
+		dedupKey = dedup.Key(rawTopic, msgId)
+		res, reserved, e := dedup.Default.Reserve(dedupKey)
+		switch {
+		case e != nil:
+			// availability over exactly once
+			log.Warn("pub[%s] %s(%s) {%s.%s.%s} dedup[%s] %s: %v", appid, r.RemoteAddr, realIp,
+				appid, topic, ver, dedup.Default.Name(), msgId, e)
+			dedupKey = ""
+
+		case !reserved:
+			msg.Free()
+
+			log.Debug("pub[%s] %s(%s) {%s.%s.%s} duplicated %s %+v", appid, r.RemoteAddr, realIp,
+				appid, topic, ver, msgId, res)
+
+			this.pubMetrics.PubDupQps.Mark(1)
+			this.respondDuplicated(w, res)
+			return
+		}
+	}
+
 	pubMethod := store.DefaultPubStore.SyncPub
 	async = query.Get("async") == "1"
 	if async {
@@ -161,10 +257,13 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 		// hh not applied
 		partition, offset, err = pubMethod(cluster, rawTopic, msgKey, msg.Body)
 	} else if Options.AllwaysHintedHandoff {
+		accepted = true
 		err = hh.Default.Append(cluster, rawTopic, msgKey, msg.Body)
 	} else if !hhDisabled && Options.EnableHintedHandoff && !hh.Default.Empty(cluster, rawTopic) {
+		accepted = true
 		err = hh.Default.Append(cluster, rawTopic, msgKey, msg.Body)
 	} else if async {
+		accepted = true
 		if !hhDisabled && Options.EnableHintedHandoff {
 			// async uses hinted handoff mechanism to save memory overhead
 			err = hh.Default.Append(cluster, rawTopic, msgKey, msg.Body)
@@ -181,10 +280,15 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 		if err != nil && store.DefaultPubStore.IsSystemError(err) && !hhDisabled && Options.EnableHintedHandoff {
 			log.Warn("pub[%s] %s(%s) {%s.%s.%s UA:%s} resort hh for: %v", appid, r.RemoteAddr, realIp,
 				appid, topic, ver, r.Header.Get("User-Agent"), err)
+			accepted = true
 			err = hh.Default.Append(cluster, rawTopic, msgKey, msg.Body)
 		}
 	}
 
+	if dedupKey != "" {
+		this.recordDedup(dedupKey, err, accepted, partition, offset)
+	}
+
 	// in case of request panic, mem pool leakage
 	msg.Free()
 
@@ -221,9 +325,52 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 		this.pubMetrics.ClientError.Inc(1)
 	}
 
+	this.gw.quotas.addUsage(appid, quota.Usage{PubMsgs: 1, PubBytes: int64(msgLen)})
+
 	if !Options.DisableMetrics {
 		this.pubMetrics.PubOk(appid, topic, ver)
 		this.pubMetrics.PubLatency.Update(time.Since(t1).Nanoseconds() / 1e6) // in ms
 	}
 
 }
+
+func (this *pubServer) respondDuplicated(w http.ResponseWriter, r dedup.Result) {
+	switch r.State {
+	case dedup.Pending:
+		// the original pub is still in flight, client should retry later
+		_writeErrorResponse(w, ErrMsgInFlight.Error(), http.StatusConflict)
+
+	case dedup.Accepted:
+		w.Header().Set(HttpHeaderMsgDuplicated, "1")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(ResponseOk)
+
+	default:
+		w.Header().Set(HttpHeaderMsgDuplicated, "1")
+		w.Header().Set(HttpHeaderPartition, strconv.FormatInt(int64(r.Partition), 10))
+		w.Header().Set(HttpHeaderOffset, strconv.FormatInt(r.Offset, 10))
+		w.WriteHeader(http.StatusCreated)
+		w.Write(ResponseOk)
+	}
+}
+
+// recordDedup remembers the pub result of a reserved msg id.
+func (this *pubServer) recordDedup(key string, err error, accepted bool, partition int32, offset int64) {
+	var e error
+	switch {
+	case err != nil:
+		// let the client retry
+		e = dedup.Default.Release(key)
+
+	case accepted:
+		// hh pump will record the partition/offset when delivered
+		e = dedup.Default.Record(key, dedup.Result{State: dedup.Accepted})
+
+	default:
+		e = dedup.Default.Record(key, dedup.Result{State: dedup.Delivered, Partition: partition, Offset: offset})
+	}
+
+	if e != nil {
+		log.Error("dedup[%s] %s: %v", dedup.Default.Name(), key, e)
+	}
+}
@@ -0,0 +1,318 @@
+// +build !fasthttp
+
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+	"github.com/funkygao/gafka/cmd/kateway/hh"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/quota"
+	"github.com/funkygao/gafka/cmd/kateway/schema"
+	"github.com/funkygao/gafka/cmd/kateway/store"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+type pubBatchResult struct {
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Hinted    bool   `json:"hh,omitempty"` // accepted by hinted handoff, partition/offset unknown yet
+	Error     string `json:"error,omitempty"`
+}
+
+//go:generate goannotation $GOFILE
+// @rest POST /v1/msgs/:topic/:ver/batch?ack=all&hh=n
+// idempotent pub is per message, X-Msg-Id is rejected
+func (this *pubServer) pubBatchHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var (
+		appid      string
+		topic      string
+		ver        string
+		hhDisabled bool // hh enabled by default
+		t1         = time.Now()
+	)
+
+	realIp := getHttpRemoteIp(r)
+	appid = r.Header.Get(HttpHeaderAppid)
+	topic = params.ByName(UrlParamTopic)
+	ver = params.ByName(UrlParamVersion)
+
+	if err := manager.Default.OwnTopic(appid, r.Header.Get(HttpHeaderPubkey), topic); err != nil {
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), err)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if r.Header.Get(HttpHeaderMsgId) != "" {
+		// one id can't dedup many msgs, silently ignoring it duplicates the batch on retry
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), ErrBatchMsgId)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, ErrBatchMsgId.Error(), http.StatusBadRequest)
+		return
+	}
+
+	maxBatchBytes := Options.MaxPubSize * int64(Options.MaxPubBatchSize)
+	if r.ContentLength > maxBatchBytes {
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} too big content length: %d",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), r.ContentLength)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, ErrTooBigMessage.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBatchBytes+1))
+	if err == nil && int64(len(body)) > maxBatchBytes {
+		err = ErrTooBigMessage
+	}
+	if err != nil {
+		log.Error("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), err)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Content-Type tells the container format, and for length prefixed batch
+	// also the content type of each message
+	contentType := r.Header.Get("Content-Type")
+	ndjson := strings.HasPrefix(contentType, ContentTypeNDJSON)
+	var msgs []PubMessage
+	if ndjson {
+		msgs, err = DecodePubBatchNDJSON(body)
+	} else {
+		msgs, err = DecodePubBatch(body)
+	}
+	if err == nil && len(msgs) == 0 {
+		err = ErrIllegalPubBatch
+	}
+	if err == nil && len(msgs) > Options.MaxPubBatchSize {
+		err = ErrTooBigBatch
+	}
+	if err != nil {
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), err)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// metrics and rate limit count messages instead of requests
+	if !Options.DisableMetrics {
+		this.pubMetrics.PubTryQps.Mark(int64(len(msgs)))
+	}
+
+	if Options.Ratelimit && !this.throttlePub.Pour(realIp, len(msgs)) {
+		log.Warn("pub batch[%s] %s(%s) rate limit reached: %d/s", appid, r.RemoteAddr, realIp, Options.PubQpsLimit)
+
+		this.pubMetrics.ClientError.Inc(1)
+		writeQuotaExceeded(w)
+		return
+	}
+
+	var batchBytes int64
+	for _, m := range msgs {
+		batchBytes += int64(len(m.Value))
+	}
+	if wait := this.gw.quotas.checkPub(appid, topic, int64(len(msgs)), batchBytes); wait > 0 {
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} quota exceeded, retry after %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), wait)
+
+		this.pubMetrics.ClientError.Inc(1)
+		writeQuotaExceededAfter(w, wait)
+		return
+	}
+
+	cluster, found := manager.Default.LookupCluster(appid)
+	if !found {
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} cluster not found",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"))
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, "invalid appid", http.StatusBadRequest)
+		return
+	}
+
+	var activeSchema *schema.Schema
+	if Options.ValidateSchema {
+		activeSchema, _ = schema.Default.Active(appid, topic, ver)
+	}
+
+	// validate each message, the invalid ones fail alone
+	var (
+		results = make([]pubBatchResult, len(msgs))
+		idx     = make([]int, 0, len(msgs)) // index of the valid msgs in the batch
+		keys    = make([][]byte, 0, len(msgs))
+		values  = make([][]byte, 0, len(msgs))
+	)
+	for i, m := range msgs {
+		results[i].Partition, results[i].Offset = -1, -1
+
+		if err = validatePubMessage(m, activeSchema, contentType, ndjson); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		tag := m.Tag
+		if activeSchema != nil {
+			tag = addSchemaTag(tag, activeSchema.Id)
+		}
+
+		value := m.Value
+		if tag != "" || envelope.IsAmbiguous(m.Value) {
+			value = envelope.Wrap(envelope.ParseTag(tag), m.Value)
+		}
+
+		if !Options.DisableMetrics {
+			this.pubMetrics.PubMsgSize.Update(int64(len(value)))
+		}
+
+		idx = append(idx, i)
+		keys = append(keys, m.Key)
+		values = append(values, value)
+	}
+
+	if activeSchema != nil {
+		w.Header().Set(HttpHeaderSchemaId, activeSchema.Id)
+	}
+
+	query := r.URL.Query()
+	ackAll := query.Get("ack") == "all"
+	hhDisabled = query.Get("hh") == "n" // yes | no
+	hhEnabled := !ackAll && !hhDisabled && Options.EnableHintedHandoff
+
+	rawTopic := manager.Default.KafkaTopic(appid, topic, ver)
+	appendHH := func(j int) {
+		if err := hh.Default.Append(cluster, rawTopic, keys[j], values[j]); err != nil {
+			results[idx[j]].Error = err.Error()
+		} else {
+			results[idx[j]].Hinted = true
+		}
+	}
+
+	if len(values) > 0 {
+		if !ackAll && (Options.AllwaysHintedHandoff || (hhEnabled && !hh.Default.Empty(cluster, rawTopic))) {
+			// keep the order with messages already in hh
+			for j := range values {
+				appendHH(j)
+			}
+		} else {
+			pubMethod := store.DefaultPubStore.SyncPubBatch
+			if ackAll {
+				pubMethod = store.DefaultPubStore.SyncAllPubBatch
+			}
+
+			pubResults, err := pubMethod(cluster, rawTopic, keys, values)
+			for j := range values {
+				e := err
+				if e == nil {
+					e = pubResults[j].Err
+				}
+
+				switch {
+				case e == nil:
+					results[idx[j]].Partition = pubResults[j].Partition
+					results[idx[j]].Offset = pubResults[j].Offset
+
+				case hhEnabled && store.DefaultPubStore.IsSystemError(e):
+					// hinted handoff only the failed subset
+					appendHH(j)
+
+				default:
+					results[idx[j]].Error = e.Error()
+				}
+			}
+
+			if err != nil {
+				log.Error("pub batch[%s] %s(%s) {topic:%s ver:%s} %s", appid, r.RemoteAddr, realIp, topic, ver, err)
+			}
+		}
+	}
+
+	var okN, failN, okBytes int64
+	for i, res := range results {
+		if res.Error == "" {
+			okN++
+			okBytes += int64(len(msgs[i].Value))
+		} else {
+			failN++
+		}
+	}
+
+	if Options.AuditPub {
+		this.auditor.Trace("pub batch[%s] %s(%s) {%s.%s.%s UA:%s} {N:%d ok:%d}",
+			appid, r.RemoteAddr, realIp, appid, topic, ver, r.Header.Get("User-Agent"), len(msgs), okN)
+	}
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"ok":      okN,
+		"fail":    failN,
+		"results": results,
+	})
+	if failN > 0 {
+		w.WriteHeader(http.StatusMultiStatus)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	if _, err = w.Write(b); err != nil {
+		log.Error("%s: %v", r.RemoteAddr, err)
+		this.pubMetrics.ClientError.Inc(1)
+	}
+
+	this.gw.quotas.addUsage(appid, quota.Usage{PubMsgs: okN, PubBytes: okBytes})
+
+	if !Options.DisableMetrics {
+		this.pubMetrics.PubQps.Mark(okN)
+		if okN > 0 {
+			this.pubMetrics.PubOkN(appid, topic, ver, okN)
+		}
+		if failN > 0 {
+			this.pubMetrics.PubFailN(appid, topic, ver, failN)
+		}
+		this.pubMetrics.PubLatency.Update(time.Since(t1).Nanoseconds() / 1e6) // in ms
+	}
+}
+
+func validatePubMessage(m PubMessage, activeSchema *schema.Schema, contentType string, ndjson bool) error {
+	switch {
+	case int64(len(m.Value)) > Options.MaxPubSize:
+		return ErrTooBigMessage
+
+	case len(m.Value) < Options.MinPubSize:
+		return ErrTooSmallMessage
+
+	case len(m.Key) > MaxPartitionKeyLen:
+		return ErrTooBigKey
+
+	case len(m.Tag) > Options.MaxMsgTagLen:
+		return ErrTooBigTag
+	}
+
+	if err := validateTag(m.Tag); err != nil {
+		return err
+	}
+
+	if activeSchema == nil {
+		return nil
+	}
+
+	if ndjson {
+		// ndjson values are always json
+		contentType = ""
+	}
+	return validateMessage(activeSchema, contentType, m.Value)
+}
@@ -7,15 +7,20 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+	"github.com/funkygao/gafka/cmd/kateway/filter"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/quota"
 	"github.com/funkygao/gafka/cmd/kateway/store"
+	"github.com/funkygao/gafka/cmd/kateway/visibility"
 	"github.com/funkygao/gafka/sla"
 	"github.com/funkygao/httprouter"
 	log "github.com/funkygao/log4go"
 )
 
 //go:generate goannotation $GOFILE
-// @rest GET /v1/msgs/:appid/:topic/:ver?group=xx&batch=10&reset=<newest|oldest>&ack=1&q=<dead|retry>
+// @rest GET /v1/msgs/:appid/:topic/:ver?group=xx&batch=10&reset=<newest|oldest>&ack=1&q=<dead|retry>&filter=<expr>&vt=<seconds>
+// vt is the visibility timeout: each msg is acked by PUT /v1/acks and redelivered if not acked in time
 func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	var (
 		topic      string
@@ -33,6 +38,9 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 		offsetN    int64 = -1
 		limit      int   // max messages to include in the message set
 		delayedAck bool  // last acked partition/offset piggybacked on this request
+		vt         int   // visibility timeout in seconds, 0 means cursor based ack
+		vis        *visibilitySub
+		msgFilter  *filter.Filter
 		err        error
 	)
 
@@ -78,6 +86,23 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 		limit = Options.MaxSubBatchSize
 	}
 
+	vt, err = getHttpQueryInt(&query, "vt", 0)
+	if err != nil || vt < 0 || time.Duration(vt)*time.Second > Options.MaxVisibilityTimeout {
+		log.Error("sub -(%s): illegal vt: %s", realIp, query.Get("vt"))
+		this.subMetrics.ClientError.Mark(1)
+		writeBadRequest(w, "illegal vt")
+		return
+	}
+
+	if expr := query.Get("filter"); expr != "" {
+		if msgFilter, err = filter.Compile(expr); err != nil {
+			log.Error("sub -(%s): illegal filter %s: %v", realIp, expr, err)
+			this.subMetrics.ClientError.Mark(1)
+			writeBadRequest(w, err.Error())
+			return
+		}
+	}
+
 	ver = params.ByName(UrlParamVersion)
 	topic = params.ByName(UrlParamTopic)
 	hisAppid = params.ByName(UrlParamAppid)
@@ -95,6 +120,14 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 
 	// fetch the client ack partition and offset
 	delayedAck = query.Get("ack") == "1"
+	if delayedAck && vt > 0 {
+		log.Error("sub[%s/%s] -(%s): {%s.%s.%s UA:%s} ack=1 with vt",
+			myAppid, group, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"))
+
+		this.subMetrics.ClientError.Mark(1)
+		writeBadRequest(w, "ack=1 and vt are exclusive")
+		return
+	}
 	if delayedAck {
 		// consumers use explicit acknowledges in order to signal a message as processed successfully
 		// if consumers fail to ACK, the message hangs and server will refuse to move ahead
@@ -180,6 +213,26 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 		return
 	}
 
+	if wait := this.gw.quotas.checkSub(myAppid, hisAppid, topic); wait > 0 {
+		log.Warn("sub[%s/%s] %s(%s) {%s.%s.%s UA:%s} quota exceeded, retry after %s",
+			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), wait)
+
+		this.subMetrics.ClientError.Mark(1)
+		writeQuotaExceededAfter(w, wait)
+		return
+	}
+
+	releaseQuota, ok := this.gw.quotas.acquireSub(myAppid, hisAppid, topic, this.gw.id+"/"+r.RemoteAddr)
+	if !ok {
+		log.Warn("sub[%s/%s] %s(%s) {%s.%s.%s UA:%s} too many subscribers",
+			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"))
+
+		this.subMetrics.ClientError.Mark(1)
+		writeQuotaExceededAfter(w, Options.SubTimeout)
+		return
+	}
+	defer releaseQuota()
+
 	fetcher, err := store.DefaultSubStore.Fetch(cluster, rawTopic,
 		realGroup, r.RemoteAddr, realIp, reset, Options.PermitStandbySub)
 	if err != nil {
@@ -218,9 +271,25 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 		}
 	}
 
+	if vt > 0 {
+		vis = &visibilitySub{
+			key:      visibility.Key(cluster, rawTopic, realGroup),
+			cluster:  cluster,
+			rawTopic: rawTopic,
+			group:    realGroup,
+			timeout:  time.Duration(vt) * time.Second,
+		}
+		if shadow != sla.SlaKeyDeadLetterTopic && manager.Default.IsShadowedTopic(hisAppid, topic, ver, myAppid, group) {
+			vis.deadTopic = manager.Default.ShadowTopic(sla.SlaKeyDeadLetterTopic, myAppid, hisAppid, topic, ver, group)
+		}
+
+		// acks must go to the kateway holding the inflight msgs
+		w.Header().Set(HttpHeaderKatewayId, this.gw.id)
+	}
+
 	var gz *gzip.Writer
 	w, gz = gzipWriter(w, r)
-	err = this.pumpMessages(w, r, realIp, fetcher, limit, myAppid, hisAppid, topic, ver, group, delayedAck)
+	err = this.pumpMessages(w, r, realIp, fetcher, limit, myAppid, hisAppid, topic, ver, group, delayedAck, msgFilter, vis)
 	if err != nil {
 		// e,g. broken pipe, io timeout, client gone
 		// e,g. kafka: error while consuming app1.foobar.v1/0: EOF (kafka was shutdown)
@@ -266,7 +335,8 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 }
 
 func (this *subServer) pumpMessages(w http.ResponseWriter, r *http.Request, realIp string,
-	fetcher store.Fetcher, limit int, myAppid, hisAppid, topic, ver, group string, delayedAck bool) error {
+	fetcher store.Fetcher, limit int, myAppid, hisAppid, topic, ver, group string, delayedAck bool,
+	msgFilter *filter.Filter, vis *visibilitySub) error {
 	cn, ok := w.(http.CloseNotifier)
 	if !ok {
 		return ErrBadResponseWriter
@@ -276,12 +346,21 @@ func (this *subServer) pumpMessages(w http.ResponseWriter, r *http.Request, real
 		metaBuf       []byte = nil
 		n                    = 0
 		idleTimeout          = Options.SubTimeout
-		chunkedEver          = false
-		tagConditions        = make(map[string]struct{})
-		clientGoneCh         = cn.CloseNotify()
-		startedAt            = time.Now()
+		idleCh        <-chan time.Time
+		visTick       <-chan time.Time
+		chunkedEver   = false
+		tagConditions = make(map[string]struct{})
+		takenOff      = make(map[int32]struct{}) // partitions with msg delivered in this request
+		clientGoneCh  = cn.CloseNotify()
+		startedAt     = time.Now()
+		delivered     quota.Usage
 	)
 
+	// the cost of sub is known only after delivery
+	defer func() {
+		this.gw.quotas.chargeSub(myAppid, hisAppid, topic, delivered)
+	}()
+
 	// parse http tag header as filter condition
 	if tagFilter := r.Header.Get(HttpHeaderMsgTag); tagFilter != "" {
 		for _, t := range parseMessageTag(tagFilter) {
@@ -291,8 +370,156 @@ func (this *subServer) pumpMessages(w http.ResponseWriter, r *http.Request, real
 		}
 	}
 
+	// skip moves the offset ahead over msgs not for the client
+	skip := func(msg *sarama.ConsumerMessage) {
+		if vis != nil {
+			if committable := this.visTracker.Skip(vis.key, msg); committable != -1 {
+				this.commitAcked(vis.cluster, vis.rawTopic, vis.group, msg.Partition, committable)
+			}
+			return
+		}
+
+		fetcher.CommitUpto(msg)
+	}
+
+	// takeOff writes the msg to client, returns true if the response is done
+	takeOff := func(msg *sarama.ConsumerMessage, deliveries int) (bool, error) {
+		partition := strconv.FormatInt(int64(msg.Partition), 10)
+
+		if limit == 1 {
+			w.Header().Set("Content-Type", "text/plain; charset=utf8") // override middleware header
+			w.Header().Set(HttpHeaderMsgKey, string(msg.Key))
+			w.Header().Set(HttpHeaderPartition, partition)
+			w.Header().Set(HttpHeaderOffset, strconv.FormatInt(msg.Offset, 10))
+			if vis != nil {
+				w.Header().Set(HttpHeaderDeliveryCount, strconv.Itoa(deliveries))
+			}
+		}
+
+		headers, bodyIdx, err := envelope.Open(msg.Value)
+		if err != nil {
+			// always move offset cursor ahead, otherwise will be blocked forever
+			skip(msg)
+
+			return true, err
+		}
+
+		tags := headers.Tags()
+		if limit == 1 {
+			if schemaId := schemaIdOfTags(tags); schemaId != "" {
+				w.Header().Set(HttpHeaderSchemaId, schemaId)
+			}
+			if len(headers) > 0 {
+				w.Header().Set(HttpHeaderMsgTag, headers.String())
+			}
+		}
+
+		// assert tag conditions and filter are satisfied. if empty, feed all messages
+		if !tagsSatisfied(tagConditions, tags) ||
+			(msgFilter != nil && !msgFilter.Match(headers, msg.Value[bodyIdx:])) {
+			// skipped msgs must move the offset ahead, otherwise a rebalance or
+			// restart will feed them again and again.
+			// With delayed ack, the client acks upto the msg it got, so skipped
+			// msgs can only be committed before any msg of the partition is
+			// taken off in this request. Those after it are skipped again after
+			// rebalance, no msg lost.
+			if _, present := takenOff[msg.Partition]; !delayedAck || !present {
+				log.Debug("sub auto commit offset with filter unmatched %s(%s) {G:%s, T:%s/%d, O:%d} %+v/%+v",
+					r.RemoteAddr, realIp, group, msg.Topic, msg.Partition, msg.Offset, tagConditions, tags)
+
+				skip(msg)
+			}
+
+			if limit == 1 {
+				w.Header().Del(HttpHeaderSchemaId)
+				w.Header().Del(HttpHeaderMsgTag)
+			}
+
+			return false, nil
+		}
+
+		takenOff[msg.Partition] = struct{}{}
+
+		if limit == 1 {
+			// non-batch mode, just the message itself without meta
+			if _, err = w.Write(msg.Value[bodyIdx:]); err != nil {
+				// when remote close silently, the write still ok
+				return true, err
+			}
+		} else {
+			// batch mode, write MessageSet
+			// MessageSet => [Partition(int32) Offset(int64) MessageSize(int32) Message] BigEndian
+			if metaBuf == nil {
+				// initialize the reuseable buffer
+				metaBuf = make([]byte, 8)
+
+				// override the middleware added header
+				w.Header().Set("Content-Type", "application/octet-stream")
+			}
+
+			if err = writeI32(w, metaBuf, msg.Partition); err != nil {
+				return true, err
+			}
+			if err = writeI64(w, metaBuf, msg.Offset); err != nil {
+				return true, err
+			}
+			if err = writeI32(w, metaBuf, int32(len(msg.Value[bodyIdx:]))); err != nil {
+				return true, err
+			}
+			if _, err = w.Write(msg.Value[bodyIdx:]); err != nil {
+				return true, err
+			}
+		}
+
+		if !delayedAck && vis == nil {
+			log.Debug("sub[%s/%s] %s(%s) auto commit offset {%s/%d O:%d}",
+				myAppid, group, r.RemoteAddr, realIp, msg.Topic, msg.Partition, msg.Offset)
+
+			// ignore the offset commit err on purpose:
+			// during rebalance, offset commit often encounter errors because fetcher
+			// underlying partition offset tracker has changed
+			// e,g.
+			// topic has partition: 0, 1
+			// 1. got msg(p=0) from fetcher
+			// 2. rebalanced, then start consuming p=1
+			// 3. commit the msg offset, still msg(p=0) => error
+			// BUT, it has no fatal effects.
+			// The worst case is between 1-3, kateway shutdown, sub client
+			// will get 1 duplicated msg.
+			fetcher.CommitUpto(msg)
+		} else {
+			log.Debug("sub[%s/%s] %s(%s) take off {%s/%d O:%d D:%d}",
+				myAppid, group, r.RemoteAddr, realIp, msg.Topic, msg.Partition, msg.Offset, deliveries)
+		}
+
+		this.subMetrics.ConsumeOk(myAppid, topic, ver)
+		this.subMetrics.ConsumedOk(hisAppid, topic, ver)
+
+		delivered.SubMsgs++
+		delivered.SubBytes += int64(len(msg.Value[bodyIdx:]))
+
+		n++
+		if n >= limit {
+			return true, nil
+		}
+
+		// http chunked: len in hex
+		// curl CURLOPT_HTTP_TRANSFER_DECODING will auto unchunk
+		w.(http.Flusher).Flush()
+
+		chunkedEver = true
+
+		if n == 1 {
+			log.Debug("sub idle timeout %s->1s %s(%s) {G:%s, T:%s/%d, O:%d B:%d}",
+				idleTimeout, r.RemoteAddr, realIp, group, msg.Topic, msg.Partition, msg.Offset, limit)
+			idleTimeout = time.Second
+		}
+
+		return false, nil
+	}
+
 	for {
-		if len(tagConditions) > 0 && time.Since(startedAt) > idleTimeout {
+		if (len(tagConditions) > 0 || msgFilter != nil) && time.Since(startedAt) > idleTimeout {
 			// e,g. tag filter got 1000 msgs, but no tag hit after timeout, we'll return 204
 			if chunkedEver {
 				return nil
@@ -303,6 +530,41 @@ func (this *subServer) pumpMessages(w http.ResponseWriter, r *http.Request, real
 			return nil
 		}
 
+		msgCh := fetcher.Messages()
+		if vis != nil {
+			// redelivery goes before new msgs
+			if m, ok := this.visTracker.Redeliver(vis.key, vis.timeout); ok {
+				if Options.MaxDeliveries > 0 && m.Deliveries > Options.MaxDeliveries && vis.deadTopic != "" {
+					if err := this.buryDead(vis, m); err != nil {
+						log.Error("sub[%s/%s] %s(%s) bury {%s/%d O:%d D:%d} %v",
+							myAppid, group, r.RemoteAddr, realIp, m.Topic, m.Partition, m.Offset, m.Deliveries, err)
+					} else {
+						log.Warn("sub[%s/%s] %s(%s) buried {%s/%d O:%d D:%d} -> %s",
+							myAppid, group, r.RemoteAddr, realIp, m.Topic, m.Partition, m.Offset, m.Deliveries, vis.deadTopic)
+					}
+					continue
+				}
+
+				if done, err := takeOff(m.ConsumerMessage(), m.Deliveries); done || err != nil {
+					return err
+				}
+				idleCh = nil
+				continue
+			}
+
+			if this.visTracker.Full(vis.key) {
+				// wait for acks or redeliveries
+				msgCh = nil
+			}
+
+			// check redeliveries periodically
+			visTick = this.timer.After(time.Second)
+		}
+
+		if idleCh == nil {
+			idleCh = this.timer.After(idleTimeout)
+		}
+
 		select {
 		case <-clientGoneCh:
 			// FIXME access log will not be able to record this behavior
@@ -326,7 +588,9 @@ func (this *subServer) pumpMessages(w http.ResponseWriter, r *http.Request, real
 			// e,g. kafka: error while consuming foobar/2: read tcp 10.1.1.1:60088->10.1.1.2:11005: i/o timeout
 			return err
 
-		case <-this.timer.After(idleTimeout):
+		case <-visTick:
+
+		case <-idleCh:
 			if chunkedEver {
 				// response already sent in chunk
 				log.Debug("chunked sub idle timeout %s {A:%s/G:%s->A:%s T:%s V:%s}",
@@ -338,134 +602,45 @@ func (this *subServer) pumpMessages(w http.ResponseWriter, r *http.Request, real
 			w.Write([]byte{}) // without this, client cant get response
 			return nil
 
-		case msg, ok := <-fetcher.Messages():
+		case msg, ok := <-msgCh:
 			if !ok {
 				return ErrClientKilled
 			}
 
+			idleCh = nil
+
 			if Options.AuditSub {
 				this.auditor.Trace("sub[%s/%s] %s(%s) {T:%s/%d O:%d}",
 					myAppid, group, r.RemoteAddr, realIp, msg.Topic, msg.Partition, msg.Offset)
 			}
 
-			partition := strconv.FormatInt(int64(msg.Partition), 10)
-
-			if limit == 1 {
-				w.Header().Set("Content-Type", "text/plain; charset=utf8") // override middleware header
-				w.Header().Set(HttpHeaderMsgKey, string(msg.Key))
-				w.Header().Set(HttpHeaderPartition, partition)
-				w.Header().Set(HttpHeaderOffset, strconv.FormatInt(msg.Offset, 10))
-			}
-
-			var (
-				tags    []string
-				bodyIdx int
-				err     error
-			)
-			if IsTaggedMessage(msg.Value) {
-				tags, bodyIdx, err = ExtractMessageTag(msg.Value)
-				if err != nil {
-					// always move offset cursor ahead, otherwise will be blocked forever
-					fetcher.CommitUpto(msg)
-
-					return err
-				}
-			}
-
-			// assert tag conditions are satisfied. if empty, feed all messages
-			if len(tagConditions) > 0 {
-				tagSatisfied := false
-				for _, t := range tags {
-					if _, present := tagConditions[t]; present {
-						tagSatisfied = true
-						break
-					}
-				}
-
-				if !tagSatisfied {
-					if !delayedAck {
-						log.Debug("sub auto commit offset with tag unmatched %s(%s) {G:%s, T:%s/%d, O:%d} %+v/%+v",
-							r.RemoteAddr, realIp, group, msg.Topic, msg.Partition, msg.Offset, tagConditions, tags)
-
-						fetcher.CommitUpto(msg)
-					}
-
+			deliveries := 1
+			if vis != nil {
+				m, ok := this.visTracker.Deliver(vis.key, msg, vis.timeout)
+				if !ok {
+					// acked already, fetched again after rebalance
 					continue
 				}
+				deliveries = m.Deliveries
 			}
 
-			if limit == 1 {
-				// non-batch mode, just the message itself without meta
-				if _, err = w.Write(msg.Value[bodyIdx:]); err != nil {
-					// when remote close silently, the write still ok
-					return err
-				}
-			} else {
-				// batch mode, write MessageSet
-				// MessageSet => [Partition(int32) Offset(int64) MessageSize(int32) Message] BigEndian
-				if metaBuf == nil {
-					// initialize the reuseable buffer
-					metaBuf = make([]byte, 8)
-
-					// override the middleware added header
-					w.Header().Set("Content-Type", "application/octet-stream")
-				}
-
-				if err = writeI32(w, metaBuf, msg.Partition); err != nil {
-					return err
-				}
-				if err = writeI64(w, metaBuf, msg.Offset); err != nil {
-					return err
-				}
-				if err = writeI32(w, metaBuf, int32(len(msg.Value[bodyIdx:]))); err != nil {
-					return err
-				}
-				if _, err = w.Write(msg.Value[bodyIdx:]); err != nil {
-					return err
-				}
+			if done, err := takeOff(msg, deliveries); done || err != nil {
+				return err
 			}
+		}
+	}
+}
 
-			if !delayedAck {
-				log.Debug("sub[%s/%s] %s(%s) auto commit offset {%s/%d O:%d}",
-					myAppid, group, r.RemoteAddr, realIp, msg.Topic, msg.Partition, msg.Offset)
-
-				// ignore the offset commit err on purpose:
-				// during rebalance, offset commit often encounter errors because fetcher
-				// underlying partition offset tracker has changed
-				// e,g.
-				// topic has partition: 0, 1
-				// 1. got msg(p=0) from fetcher
-				// 2. rebalanced, then start consuming p=1
-				// 3. commit the msg offset, still msg(p=0) => error
-				// BUT, it has no fatal effects.
-				// The worst case is between 1-3, kateway shutdown, sub client
-				// will get 1 duplicated msg.
-				fetcher.CommitUpto(msg)
-			} else {
-				log.Debug("sub[%s/%s] %s(%s) take off {%s/%d O:%d}",
-					myAppid, group, r.RemoteAddr, realIp, msg.Topic, msg.Partition, msg.Offset)
-			}
-
-			this.subMetrics.ConsumeOk(myAppid, topic, ver)
-			this.subMetrics.ConsumedOk(hisAppid, topic, ver)
-
-			n++
-			if n >= limit {
-				return nil
-			}
-
-			// http chunked: len in hex
-			// curl CURLOPT_HTTP_TRANSFER_DECODING will auto unchunk
-			w.(http.Flusher).Flush()
-
-			chunkedEver = true
-
-			if n == 1 {
-				log.Debug("sub idle timeout %s->1s %s(%s) {G:%s, T:%s/%d, O:%d B:%d}",
-					idleTimeout, r.RemoteAddr, realIp, group, msg.Topic, msg.Partition, msg.Offset, limit)
-				idleTimeout = time.Second
-			}
+// tagsSatisfied checks if any of the msg tags is in the tag conditions.
+func tagsSatisfied(tagConditions map[string]struct{}, tags []string) bool {
+	if len(tagConditions) == 0 {
+		return true
+	}
 
+	for _, t := range tags {
+		if _, present := tagConditions[t]; present {
+			return true
 		}
 	}
+	return false
 }
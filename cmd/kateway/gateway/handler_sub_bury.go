@@ -7,6 +7,7 @@ import (
 
 	"github.com/Shopify/sarama"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/meta"
 	"github.com/funkygao/gafka/cmd/kateway/store"
 	"github.com/funkygao/gafka/sla"
 	"github.com/funkygao/httprouter"
@@ -16,6 +17,7 @@ import (
 //go:generate goannotation $GOFILE
 // @rest PUT /v1/msgs/:appid/:topic/:ver?group=xx&q=<dead|retry>
 // q=retry&X-Bury=dead means bury from retry queue to dead queue
+// X-Partition and X-Offset of the response is where the msg is in the shadow queue
 func (this *subServer) buryHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	var (
 		topic      string
@@ -149,27 +151,90 @@ func (this *subServer) buryHandler(w http.ResponseWriter, r *http.Request, param
 		return
 	}
 
-	// step1: pub
-	shadowTopic := manager.Default.ShadowTopic(bury, myAppid, hisAppid, topic, ver, group)
-	_, _, err = store.DefaultPubStore.SyncPub(cluster, shadowTopic, nil, msg)
+	rec := &buryRecord{
+		Cluster:   cluster,
+		Topic:     rawTopic,
+		Group:     myAppid + "." + group,
+		Partition: int32(partitionN),
+		Offset:    offsetN,
+		Shadow:    manager.Default.ShadowTopic(bury, myAppid, hisAppid, topic, ver, group),
+	}
+	resumed, err := this.buryJournal.begin(rec)
 	if err != nil {
-		log.Error("bury[%s/%s] %s(%s) %s %v", myAppid, group, r.RemoteAddr, realIp, shadowTopic, err)
+		log.Error("bury[%s/%s] %s(%s) %s %v", myAppid, group, r.RemoteAddr, realIp, rec, err)
 
-		writeServerError(w, err.Error())
+		if err == ErrBuryInFlight {
+			_writeErrorResponse(w, err.Error(), http.StatusConflict)
+		} else {
+			writeServerError(w, err.Error())
+		}
 		return
 	}
 
-	// step2: skip this message in the master topic TODO atomic with step1
+	// step1: pub, skipped if a previous try of this bury got here
+	shadowPartition, shadowOffset := rec.ShadowPartition, rec.ShadowOffset
+	if resumed {
+		log.Warn("bury[%s/%s] %s(%s) %s resumed", myAppid, group, r.RemoteAddr, realIp, rec)
+	} else {
+		shadowPartition, shadowOffset, err = store.DefaultPubStore.SyncPub(cluster, rec.Shadow, nil, msg)
+		if err != nil {
+			log.Error("bury[%s/%s] %s(%s) %s %v", myAppid, group, r.RemoteAddr, realIp, rec.Shadow, err)
+
+			this.buryJournal.rollback(rec)
+
+			writeServerError(w, err.Error())
+			return
+		}
+
+		if err = this.buryJournal.published(rec, shadowPartition, shadowOffset); err != nil {
+			// a retry or recover will bury it again and the msg will be duplicated in shadow
+			log.Error("bury[%s/%s] %s(%s) %s %v", myAppid, group, r.RemoteAddr, realIp, rec, err)
+
+			this.buryJournal.rollback(rec)
+
+			writeServerError(w, err.Error())
+			return
+		}
+	}
+
+	// step2: skip this message in the master topic
 	if err = fetcher.CommitUpto(&sarama.ConsumerMessage{
 		Topic:     rawTopic,
 		Partition: int32(partitionN),
 		Offset:    offsetN,
 	}); err != nil {
+		// a retry of this bury or recover on next start will skip it, till
+		// then it might be delivered again
 		log.Error("bury[%s/%s] %s(%s) %s %v", myAppid, group, r.RemoteAddr, realIp, rawTopic, err)
 
 		writeServerError(w, err.Error())
 		return
 	}
 
+	if err = this.buryJournal.done(rec); err != nil {
+		// recover will skip it again, harmless
+		log.Error("bury[%s/%s] %s(%s) %s %v", myAppid, group, r.RemoteAddr, realIp, rec, err)
+	}
+
+	w.Header().Set(HttpHeaderPartition, strconv.FormatInt(int64(shadowPartition), 10))
+	w.Header().Set(HttpHeaderOffset, strconv.FormatInt(shadowOffset, 10))
 	w.Write(ResponseOk)
 }
+
+// completeBury skips a msg in master topic that is already buried into shadow.
+// It never rewinds the offset of the group.
+func (this *subServer) completeBury(rec *buryRecord) error {
+	offsetStore := meta.Default.ZkCluster(rec.Cluster).NewOffsetStore()
+	defer offsetStore.Close()
+
+	offset, err := offsetStore.FetchOffset(rec.Group, rec.Topic, rec.Partition)
+	if err != nil {
+		return err
+	}
+	if offset >= rec.Offset {
+		// consumed beyond the buried msg
+		return nil
+	}
+
+	return offsetStore.CommitOffset(rec.Group, rec.Topic, rec.Partition, rec.Offset)
+}
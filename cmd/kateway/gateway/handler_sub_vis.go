@@ -0,0 +1,196 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/store"
+	"github.com/funkygao/gafka/cmd/kateway/visibility"
+	"github.com/funkygao/gafka/sla"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+// visibilitySub is a sub in visibility timeout mode: each msg is acked on
+// its own and redelivered if not acked before timeout.
+type visibilitySub struct {
+	key       string // of the tracker
+	cluster   string
+	rawTopic  string
+	group     string // real group
+	timeout   time.Duration
+	deadTopic string // empty if dead shadow queue not registered
+}
+
+type visAckResult struct {
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Error     string `json:"error,omitempty"`
+}
+
+//go:generate goannotation $GOFILE
+// @rest PUT /v1/acks/:appid/:topic/:ver/:group?q=<dead|retry> with json body
+// body: [{"partition":0,"offset":10}], header X-Kateway-Id is what the sub response returned
+func (this *subServer) visAckHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var (
+		topic    string
+		ver      string
+		myAppid  string
+		hisAppid string
+		group    string
+		rawTopic string
+		err      error
+	)
+
+	group = params.ByName(UrlParamGroup)
+	ver = params.ByName(UrlParamVersion)
+	topic = params.ByName(UrlParamTopic)
+	hisAppid = params.ByName(UrlParamAppid)
+	myAppid = r.Header.Get(HttpHeaderAppid)
+	realIp := getHttpRemoteIp(r)
+
+	if err = manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
+		hisAppid, topic, group); err != nil {
+		log.Error("vis ack[%s/%s] %s(%s) {%s.%s.%s UA:%s} %v",
+			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	cluster, found := manager.Default.LookupCluster(hisAppid)
+	if !found {
+		writeBadRequest(w, "invalid appid")
+		return
+	}
+
+	if r.ContentLength > Options.MaxPubSize {
+		writeBadRequest(w, ErrTooBigMessage.Error())
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, Options.MaxPubSize+1))
+	if err == nil && int64(len(body)) > Options.MaxPubSize {
+		err = ErrTooBigMessage
+	}
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	if kwId := r.Header.Get(HttpHeaderKatewayId); kwId != "" && kwId != this.gw.id {
+		// the inflight msgs are tracked by another kateway
+		kw := this.gw.zkzone.KatewayInfoById(kwId)
+		if kw == nil {
+			log.Warn("vis ack[%s/%s] %s(%s) {%s.%s.%s} kateway[%s] gone",
+				myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, kwId)
+
+			writeBadRequest(w, "kateway gone, msgs will be redelivered")
+			return
+		}
+
+		status, resp, err := this.gw.forwardSub(kw, r, body)
+		if err != nil {
+			log.Error("vis ack[%s/%s] %s(%s) {%s.%s.%s} -> kateway[%s]: %v",
+				myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, kwId, err)
+
+			writeServerError(w, err.Error())
+			return
+		}
+
+		w.WriteHeader(status)
+		w.Write(resp)
+		return
+	}
+
+	var acks ackOffsets
+	if err = json.Unmarshal(body, &acks); err != nil || len(acks) == 0 {
+		writeBadRequest(w, "invalid ack json body")
+		return
+	}
+
+	if shadow := r.URL.Query().Get("q"); shadow != "" {
+		if !sla.ValidateShadowName(shadow) {
+			writeBadRequest(w, "invalid shadow name")
+			return
+		}
+
+		rawTopic = manager.Default.ShadowTopic(shadow, myAppid, hisAppid, topic, ver, group)
+	} else {
+		rawTopic = manager.Default.KafkaTopic(hisAppid, topic, ver)
+	}
+
+	log.Debug("vis ack[%s/%s] %s(%s) {%s UA:%s} %+v",
+		myAppid, group, r.RemoteAddr, realIp, rawTopic, r.Header.Get("User-Agent"), acks)
+
+	realGroup := myAppid + "." + group
+	key := visibility.Key(cluster, rawTopic, realGroup)
+	results := make([]visAckResult, len(acks))
+	var failN int
+	for i, ack := range acks {
+		results[i].Partition, results[i].Offset = int32(ack.Partition), ack.Offset
+
+		committable, err := this.visTracker.Ack(key, int32(ack.Partition), ack.Offset)
+		if err == nil && committable != -1 {
+			err = this.commitAcked(cluster, rawTopic, realGroup, int32(ack.Partition), committable)
+		}
+		if err != nil {
+			results[i].Error = err.Error()
+			failN++
+		}
+	}
+
+	if failN == 0 {
+		w.Write(ResponseOk)
+		return
+	}
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"ok":      len(acks) - failN,
+		"fail":    failN,
+		"results": results,
+	})
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write(b)
+}
+
+// commitAcked hands the committable offset over to the ack committer.
+func (this *subServer) commitAcked(cluster, topic, group string, partition int32, offset int64) error {
+	if atomic.AddInt32(&this.ackShutdown, 1) == 0 {
+		// kateway is shutting down, ackCh is already closed
+		atomic.AddInt32(&this.ackShutdown, -1)
+		return ErrServerShutdown
+	}
+
+	this.ackCh <- ackOffsets{{
+		Partition: int(partition),
+		Offset:    offset,
+		cluster:   cluster,
+		topic:     topic,
+		group:     group,
+	}}
+	atomic.AddInt32(&this.ackShutdown, -1)
+	return nil
+}
+
+// buryDead moves a msg delivered too many times into the dead shadow queue.
+func (this *subServer) buryDead(vis *visibilitySub, m visibility.Message) error {
+	if _, _, err := store.DefaultPubStore.SyncPub(vis.cluster, vis.deadTopic, m.Key, m.Value); err != nil {
+		return err
+	}
+
+	committable, err := this.visTracker.Ack(vis.key, m.Partition, m.Offset)
+	if err != nil {
+		// acked by client in between, it is duplicated in dead queue
+		return nil
+	}
+	if committable != -1 {
+		return this.commitAcked(vis.cluster, vis.rawTopic, vis.group, m.Partition, committable)
+	}
+
+	return nil
+}
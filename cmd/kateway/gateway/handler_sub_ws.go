@@ -4,15 +4,21 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+	"github.com/funkygao/gafka/cmd/kateway/filter"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/quota"
 	"github.com/funkygao/gafka/cmd/kateway/store"
 	"github.com/funkygao/httprouter"
 	log "github.com/funkygao/log4go"
 	"github.com/gorilla/websocket"
 )
 
+// wsQuotaChargeBatch is how many msgs a ws sub delivers between quota charges.
+const wsQuotaChargeBatch = 100
+
 //go:generate goannotation $GOFILE
-// @rest GET /v1/ws/msgs/:appid/:topic/:ver?group=xx
+// @rest GET /v1/ws/msgs/:appid/:topic/:ver?group=xx&filter=<expr>
 func (this *subServer) subWsHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -50,6 +56,14 @@ func (this *subServer) subWsHandler(w http.ResponseWriter, r *http.Request, para
 		return
 	}
 
+	var msgFilter *filter.Filter
+	if expr := query.Get("filter"); expr != "" {
+		if msgFilter, err = filter.Compile(expr); err != nil {
+			writeWsError(ws, err.Error())
+			return
+		}
+	}
+
 	ver = params.ByName(UrlParamVersion)
 	topic = params.ByName(UrlParamTopic)
 	hisAppid = params.ByName(UrlParamAppid)
@@ -75,6 +89,17 @@ func (this *subServer) subWsHandler(w http.ResponseWriter, r *http.Request, para
 		return
 	}
 
+	holder := this.gw.id + "/" + r.RemoteAddr
+	releaseQuota, ok := this.gw.quotas.acquireSub(myAppid, hisAppid, topic, holder)
+	if !ok {
+		log.Warn("sub[%s] %s {hisapp:%s, topic:%s, ver:%s, group:%s} too many subscribers",
+			myAppid, r.RemoteAddr, hisAppid, topic, ver, group)
+
+		writeWsError(ws, "quota exceeded")
+		return
+	}
+	defer releaseQuota()
+
 	fetcher, err := store.DefaultSubStore.Fetch(cluster, rawTopic,
 		myAppid+"."+group, r.RemoteAddr, realIp, resetOffset, Options.PermitStandbySub)
 	if err != nil {
@@ -95,7 +120,7 @@ func (this *subServer) subWsHandler(w http.ResponseWriter, r *http.Request, para
 	//
 
 	clientGone := make(chan struct{})
-	go this.wsWritePump(clientGone, ws, fetcher)
+	go this.wsWritePump(clientGone, ws, fetcher, msgFilter, myAppid, hisAppid, topic, holder)
 	this.wsReadPump(clientGone, ws)
 
 	return
@@ -128,17 +153,55 @@ func (this *subServer) wsReadPump(clientGone chan struct{}, ws *websocket.Conn)
 	}
 }
 
-func (this *subServer) wsWritePump(clientGone chan struct{}, ws *websocket.Conn, fetcher store.Fetcher) {
-	defer fetcher.Close()
+func (this *subServer) wsWritePump(clientGone chan struct{}, ws *websocket.Conn, fetcher store.Fetcher,
+	msgFilter *filter.Filter, myAppid, hisAppid, topic, holder string) {
+	var delivered quota.Usage
+	defer func() {
+		this.gw.quotas.chargeSub(myAppid, hisAppid, topic, delivered)
+		fetcher.Close()
+	}()
+
+	// throttle charges the delivered msgs and waits if the sub quota is drained
+	throttle := func() bool {
+		this.gw.quotas.chargeSub(myAppid, hisAppid, topic, delivered)
+		delivered = quota.Usage{}
+
+		wait := this.gw.quotas.checkSub(myAppid, hisAppid, topic)
+		if wait == 0 {
+			return true
+		}
+
+		select {
+		case <-this.timer.After(wait):
+			return true
+		case <-this.gw.shutdownCh:
+			return false
+		case <-clientGone:
+			return false
+		}
+	}
 
 	var err error
 	for {
 		select {
 		case msg := <-fetcher.Messages():
+			headers, bodyIdx, err := envelope.Open(msg.Value)
+			if err != nil {
+				log.Error("%s: {T:%s/%d O:%d} %v", ws.RemoteAddr(), msg.Topic, msg.Partition, msg.Offset, err)
+			}
+
+			if err != nil || (msgFilter != nil && !msgFilter.Match(headers, msg.Value[bodyIdx:])) {
+				// skipped msg still moves the offset ahead
+				if err = fetcher.CommitUpto(msg); err != nil {
+					log.Error(err)
+				}
+				continue
+			}
+
 			ws.SetWriteDeadline(time.Now().Add(time.Second * 10))
 			// FIXME because of buffer, client recv 10, but kateway written 100, then
 			// client quit...
-			if err = ws.WriteMessage(websocket.BinaryMessage, msg.Value); err != nil {
+			if err = ws.WriteMessage(websocket.BinaryMessage, msg.Value[bodyIdx:]); err != nil {
 				log.Error("%s: %v", ws.RemoteAddr(), err)
 				return
 			}
@@ -147,6 +210,12 @@ func (this *subServer) wsWritePump(clientGone chan struct{}, ws *websocket.Conn,
 				log.Error(err) // TODO add more ctx
 			}
 
+			delivered.SubMsgs++
+			delivered.SubBytes += int64(len(msg.Value[bodyIdx:]))
+			if delivered.SubMsgs >= wsQuotaChargeBatch && !throttle() {
+				return
+			}
+
 		case err = <-fetcher.Errors():
 			// TODO
 			log.Error(err)
@@ -158,6 +227,12 @@ func (this *subServer) wsWritePump(clientGone chan struct{}, ws *websocket.Conn,
 				return
 			}
 
+			// keep the subscriber slot alive
+			this.gw.quotas.acquireSub(myAppid, hisAppid, topic, holder)
+			if !throttle() {
+				return
+			}
+
 		case <-this.gw.shutdownCh:
 			return
 
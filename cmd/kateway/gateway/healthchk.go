@@ -37,7 +37,7 @@ func (this *Gateway) healthCheck() {
 
 			if evt.State == zklib.StateHasSession {
 				log.Warn("re-registering kateway[%s] in %s...", this.id, registry.Default.Name())
-				registry.Default.Register(this.id, this.InstanceInfo())
+				this.register()
 				log.Info("re-register kateway[%s] in %s done", this.id, registry.Default.Name())
 			}
 		}
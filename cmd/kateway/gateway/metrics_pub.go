@@ -27,6 +27,7 @@ type pubMetrics struct {
 	ClientError metrics.Counter
 	PubQps      metrics.Meter
 	PubTryQps   metrics.Meter
+	PubDupQps   metrics.Meter
 	JobQps      metrics.Meter
 	JobTryQps   metrics.Meter
 	PubLatency  metrics.Histogram
@@ -43,6 +44,7 @@ func NewPubMetrics(gw *Gateway) *pubMetrics {
 		ClientError: metrics.NewRegisteredCounter("pub.clienterr", metrics.DefaultRegistry),
 		PubQps:      metrics.NewRegisteredMeter("pub.qps", metrics.DefaultRegistry),
 		PubTryQps:   metrics.NewRegisteredMeter("pub.try.qps", metrics.DefaultRegistry),
+		PubDupQps:   metrics.NewRegisteredMeter("pub.dup.qps", metrics.DefaultRegistry),
 		JobQps:      metrics.NewRegisteredMeter("job.qps", metrics.DefaultRegistry),
 		JobTryQps:   metrics.NewRegisteredMeter("job.try.qps", metrics.DefaultRegistry),
 		PubMsgSize:  metrics.NewRegisteredHistogram("pub.msgsize", metrics.DefaultRegistry, metrics.NewExpDecaySample(1028, 0.015)),
@@ -105,15 +107,25 @@ func (this *pubMetrics) Flush() {
 }
 
 func (this *pubMetrics) PubFail(appid, topic, ver string) {
+	this.PubFailN(appid, topic, ver, 1)
+}
+
+func (this *pubMetrics) PubOk(appid, topic, ver string) {
+	this.PubOkN(appid, topic, ver, 1)
+}
+
+// PubFailN records n failed messages of a batch pub.
+func (this *pubMetrics) PubFailN(appid, topic, ver string, n int64) {
 	if this.expPubFail != nil {
-		this.expPubFail.Add(1)
+		this.expPubFail.Add(n)
 	}
-	telemetry.UpdateCounter(appid, topic, ver, "pub.fail", 1, &this.pubFailMu, this.PubFailMap)
+	telemetry.UpdateCounter(appid, topic, ver, "pub.fail", n, &this.pubFailMu, this.PubFailMap)
 }
 
-func (this *pubMetrics) PubOk(appid, topic, ver string) {
+// PubOkN records n successful messages of a batch pub.
+func (this *pubMetrics) PubOkN(appid, topic, ver string, n int64) {
 	if this.expPubOk != nil {
-		this.expPubOk.Add(1)
+		this.expPubOk.Add(n)
 	}
-	telemetry.UpdateCounter(appid, topic, ver, "pub.ok", 1, &this.pubOkMu, this.PubOkMap)
+	telemetry.UpdateCounter(appid, topic, ver, "pub.ok", n, &this.pubOkMu, this.PubOkMap)
 }
@@ -23,6 +23,7 @@ var (
 		ManHttpsAddr               string
 		DebugHttpAddr              string
 		Store                      string
+		HelixCluster               string
 		JobStore                   string
 		ManagerStore               string
 		PidFile                    string
@@ -34,9 +35,19 @@ var (
 		DummyCluster               string
 		InfluxServer               string
 		InfluxDbName               string
+		InfluxRetentionPolicy      string
+		InfluxSpoolDir             string
 		KillFile                   string
 		HintedHandoffType          string
+		DedupType                  string
+		DedupRedisAddr             string
+		DedupCapacity              int
+		DedupTTL                   time.Duration
+		QuotaType                  string
+		QuotaRedisAddr             string
+		ReplicaZone                string
 		HintedHandoffDir           string
+		BuryJournal                string
 		AllwaysHintedHandoff       bool
 		ShowVersion                bool
 		Ratelimit                  bool
@@ -51,12 +62,14 @@ var (
 		AuditPub                   bool
 		AuditSub                   bool
 		EnableGzip                 bool
+		InfluxGzip                 bool
 		DryRun                     bool
 		CpuAffinity                bool
 		EnableAccessLog            bool
 		EnableHttpPanicRecover     bool
 		GolangTrace                bool
 		PermitUnregisteredGroup    bool
+		ValidateSchema             bool
 		UseCompress                bool
 		Debug                      bool
 		EnableRegistry             bool
@@ -67,17 +80,25 @@ var (
 		MaxMsgTagLen               int
 		MinPubSize                 int
 		PubQpsLimit                int64
+		ReplicaBandwidth           int64
 		MaxSubBatchSize            int
+		MaxPubBatchSize            int
+		MaxSubInflight             int
+		MaxDeliveries              int
 		MaxClients                 int
 		MaxRequestPerConn          int // to make load balancer distribute request even for persistent conn
 		PubPoolCapcity             int
+		RedisPoolCapacity          int
 		AssignJobShardId           int // how to assign shard id for new app
 		PubPoolIdleTimeout         time.Duration
 		SubTimeout                 time.Duration
+		MaxVisibilityTimeout       time.Duration
 		OffsetCommitInterval       time.Duration
 		BadClientPunishDuration    time.Duration
 		InternalServerErrorBackoff time.Duration
 		ReporterInterval           time.Duration
+		LoadReportInterval         time.Duration
+		DrainWait                  time.Duration
 		MetaRefresh                time.Duration
 		ManagerRefresh             time.Duration
 		HttpReadTimeout            time.Duration
@@ -115,9 +136,19 @@ func ParseFlags() {
 	flag.StringVar(&Options.PidFile, "pid", "", "pid file")
 	flag.StringVar(&Options.KeyFile, "keyfile", "", "key file path")
 	flag.StringVar(&Options.DebugHttpAddr, "debughttp", "", "debug http bind addr")
-	flag.StringVar(&Options.Store, "store", "kafka", "message underlying store")
+	flag.StringVar(&Options.Store, "store", "kafka", "message underlying store: kafka|helix|dummy")
+	flag.StringVar(&Options.HelixCluster, "helixcluster", "kateway", "helix cluster of sub partition management if store is helix")
 	flag.StringVar(&Options.HintedHandoffType, "hhtype", "disk", "underlying hinted handoff")
+	flag.StringVar(&Options.DedupType, "dedup", "", "dedup window of X-Msg-Id <mem|redis>, empty to disable")
+	flag.StringVar(&Options.DedupRedisAddr, "dedupredis", "localhost:6379", "redis addr of the dedup window shared by kateway cluster")
+	flag.IntVar(&Options.DedupCapacity, "dedupcap", 1<<20, "max message ids the mem dedup window remembers")
+	flag.DurationVar(&Options.DedupTTL, "dedupttl", time.Minute*10, "how long a message id is remembered in dedup window")
+	flag.StringVar(&Options.QuotaType, "quota", "", "appid and topic quotas backend <mem|redis>, empty to disable")
+	flag.StringVar(&Options.QuotaRedisAddr, "quotaredis", "localhost:6379", "redis addr of the quota buckets shared by kateway cluster")
+	flag.StringVar(&Options.ReplicaZone, "replicazone", "", "peer zone where global topics are replicated to, empty to disable")
+	flag.Int64Var(&Options.ReplicaBandwidth, "replicabw", 0, "replication bandwidth limit in Mbps per cluster, 0 means unlimited")
 	flag.StringVar(&Options.HintedHandoffDir, "hhdirs", "hhdata", "hinted handoff dirs seperated by comma")
+	flag.StringVar(&Options.BuryJournal, "buryjournal", "hhdata.bury/journal", "write ahead log of sub bury")
 	flag.BoolVar(&Options.FlushHintedOffOnly, "hhflush", false, "flush hinted handoff and exit")
 	flag.StringVar(&Options.JobStore, "jstore", "mysql", "job underlying store")
 	flag.StringVar(&Options.DummyCluster, "dummycluster", "me", "dummy store's cluster name")
@@ -126,6 +157,9 @@ func ParseFlags() {
 	flag.StringVar(&Options.KillFile, "kill", "", "kill running kateway by pid file")
 	flag.StringVar(&Options.InfluxServer, "influxdbaddr", "", "influxdb server address for the metrics reporter")
 	flag.StringVar(&Options.InfluxDbName, "influxdbname", "pubsub", "influxdb db name")
+	flag.StringVar(&Options.InfluxRetentionPolicy, "influxdbrp", "", "influxdb retention policy, empty for db default")
+	flag.StringVar(&Options.InfluxSpoolDir, "influxspool", "", "dir to spool unsent metrics while influxdb is down")
+	flag.BoolVar(&Options.InfluxGzip, "influxgzip", false, "gzip metrics written to influxdb")
 	flag.BoolVar(&Options.ShowVersion, "version", false, "show version and exit")
 	flag.BoolVar(&Options.Debug, "debug", false, "enable debug mode")
 	flag.BoolVar(&Options.RunSwaggerServer, "swagger", false, "run swagger server")
@@ -140,6 +174,7 @@ func ParseFlags() {
 	flag.BoolVar(&Options.HintedHandoffBufio, "hhbuf", false, "enable hinted handoff bufio")
 	flag.BoolVar(&Options.EnableHintedHandoff, "hh", true, "enable hinted handoff for full pub availability")
 	flag.BoolVar(&Options.PermitUnregisteredGroup, "unregrp", false, "permit sub group usage without being registered")
+	flag.BoolVar(&Options.ValidateSchema, "schemacheck", false, "validate pub messages against the avro schema of topic")
 	flag.BoolVar(&Options.PermitStandbySub, "standbysub", false, "permits sub threads exceed partitions")
 	flag.BoolVar(&Options.EnableGzip, "gzip", false, "enable http response gzip")
 	flag.BoolVar(&Options.CpuAffinity, "cpuaffinity", false, "enable cpu affinity")
@@ -157,15 +192,22 @@ func ParseFlags() {
 	flag.IntVar(&Options.MaxMsgTagLen, "tagsz", 1024, "max message tag length permitted")
 	// kafka Fetch maxFetchSize=1MB, so if our msg agv size is 250B, batch size can be 4000
 	flag.IntVar(&Options.MaxSubBatchSize, "maxbatch", 4000, "max sub batch size")
+	flag.IntVar(&Options.MaxPubBatchSize, "maxpubbatch", 1000, "max messages of a batch pub")
+	flag.IntVar(&Options.MaxSubInflight, "maxinflight", 1000, "max unacked messages of a group in visibility timeout sub")
+	flag.IntVar(&Options.MaxDeliveries, "maxdelivery", 5, "bury message into dead queue after max deliveries, 0 means never")
 	flag.IntVar(&Options.LogRotateSize, "logsize", 10<<30, "max unrotated log file size")
 	flag.Int64Var(&Options.PubQpsLimit, "publimit", 60*10000, "pub qps limit per minute per ip")
 	flag.IntVar(&Options.PubPoolCapcity, "pubpool", 100, "pub connection pool capacity")
+	flag.IntVar(&Options.RedisPoolCapacity, "redispool", 100, "idle redis clients pooled for dedup and quota each")
 	flag.IntVar(&Options.MaxClients, "maxclient", 100000, "max concurrent connections")
 	flag.DurationVar(&Options.OffsetCommitInterval, "offsetcommit", time.Minute, "consumer offset commit interval")
 	flag.DurationVar(&Options.HttpReadTimeout, "httprtimeout", time.Minute*5, "http server read timeout")
 	flag.DurationVar(&Options.HttpWriteTimeout, "httpwtimeout", time.Minute, "http server write timeout")
 	flag.DurationVar(&Options.SubTimeout, "subtimeout", time.Second*30, "sub timeout before send http 204")
+	flag.DurationVar(&Options.MaxVisibilityTimeout, "maxvt", time.Hour*12, "max visibility timeout of sub")
 	flag.DurationVar(&Options.ReporterInterval, "report", time.Second*30, "reporter flush interval")
+	flag.DurationVar(&Options.LoadReportInterval, "loadreport", time.Second*10, "interval of reporting live load to registry")
+	flag.DurationVar(&Options.DrainWait, "drainwait", time.Second*15, "wait after announcing draining before deregistering")
 	flag.DurationVar(&Options.BadClientPunishDuration, "punish", time.Second*3, "punish bad client by sleep")
 	flag.DurationVar(&Options.MetaRefresh, "metarefresh", time.Minute*5, "meta data refresh interval")
 	flag.DurationVar(&Options.ManagerRefresh, "manrefresh", time.Minute*5, "manager integration refresh interval")
@@ -194,4 +236,9 @@ func ValidateFlags() {
 		fmt.Fprintf(os.Stderr, "-zone required\n")
 		os.Exit(1)
 	}
+
+	if Options.ReplicaZone == Options.Zone {
+		fmt.Fprintf(os.Stderr, "-replicazone must be another zone\n")
+		os.Exit(1)
+	}
 }
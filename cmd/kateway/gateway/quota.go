@@ -0,0 +1,256 @@
+package gateway
+
+import (
+	"sync"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/quota"
+	"github.com/funkygao/gafka/sla"
+	log "github.com/funkygao/log4go"
+)
+
+// quotaCheck is a token bucket to take from.
+type quotaCheck struct {
+	key  string
+	rate int64
+	n    int64
+}
+
+// quotaKeeper enforces the quotas of manager on quota.Default and accumulates
+// the usage of each appid locally which is flushed periodically.
+//
+// It fails open: if the quota backend is down, the traffic is not limited.
+type quotaKeeper struct {
+	gw *Gateway
+
+	mu     sync.Mutex
+	usages map[string]*quota.Usage // appid:usage not flushed yet
+}
+
+func newQuotaKeeper(gw *Gateway) *quotaKeeper {
+	return &quotaKeeper{
+		gw:     gw,
+		usages: make(map[string]*quota.Usage),
+	}
+}
+
+func appQuotaKey(appid, kind string) string {
+	return "a." + appid + "." + kind
+}
+
+func topicQuotaKey(appid, topic, kind string) string {
+	return "t." + appid + "." + topic + "." + kind
+}
+
+// take takes from the buckets in order, and returns the wait of the first
+// drained one.
+// Tokens already taken from the buckets before it are not given back, which
+// only makes the limit a bit stricter under pressure.
+func (this *quotaKeeper) take(checks []quotaCheck) time.Duration {
+	for _, c := range checks {
+		if c.rate <= 0 {
+			// unlimited
+			continue
+		}
+
+		wait, err := quota.Default.Take(c.key, c.rate, c.n)
+		if err != nil {
+			log.Error("quota[%s] take %s: %v", quota.Default.Name(), c.key, err)
+			return 0
+		}
+		if wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
+
+// checkPub takes msgs and bytes from the pub quotas of the appid and its topic.
+// It returns how long the client should wait before retry, 0 if allowed.
+func (this *quotaKeeper) checkPub(appid, topic string, msgs, bytes int64) time.Duration {
+	if quota.Default == nil {
+		return 0
+	}
+
+	aq := manager.Default.AppQuota(appid)
+	tq := manager.Default.TopicQuota(appid, topic)
+	return this.take([]quotaCheck{
+		{appQuotaKey(appid, "pm"), aq.PubMsgs, msgs},
+		{appQuotaKey(appid, "pb"), aq.PubBytes, bytes},
+		{topicQuotaKey(appid, topic, "pm"), tq.PubMsgs, msgs},
+		{topicQuotaKey(appid, topic, "pb"), tq.PubBytes, bytes},
+	})
+}
+
+// checkSub checks if the sub quotas of the subscriber and the topic are drained.
+// The cost of a sub is known only after delivery, see chargeSub.
+func (this *quotaKeeper) checkSub(myAppid, hisAppid, topic string) time.Duration {
+	if quota.Default == nil {
+		return 0
+	}
+
+	aq := manager.Default.AppQuota(myAppid)
+	tq := manager.Default.TopicQuota(hisAppid, topic)
+	return this.take([]quotaCheck{
+		{appQuotaKey(myAppid, "sm"), aq.SubMsgs, 0},
+		{appQuotaKey(myAppid, "sb"), aq.SubBytes, 0},
+		{topicQuotaKey(hisAppid, topic, "sm"), tq.SubMsgs, 0},
+		{topicQuotaKey(hisAppid, topic, "sb"), tq.SubBytes, 0},
+	})
+}
+
+// chargeSub charges the delivered msgs to the sub quotas and the usage of the
+// subscriber.
+func (this *quotaKeeper) chargeSub(myAppid, hisAppid, topic string, usage quota.Usage) {
+	if quota.Default == nil || usage.IsZero() {
+		return
+	}
+
+	this.addUsage(myAppid, usage)
+
+	aq := manager.Default.AppQuota(myAppid)
+	tq := manager.Default.TopicQuota(hisAppid, topic)
+	for _, c := range []quotaCheck{
+		{appQuotaKey(myAppid, "sm"), aq.SubMsgs, usage.SubMsgs},
+		{appQuotaKey(myAppid, "sb"), aq.SubBytes, usage.SubBytes},
+		{topicQuotaKey(hisAppid, topic, "sm"), tq.SubMsgs, usage.SubMsgs},
+		{topicQuotaKey(hisAppid, topic, "sb"), tq.SubBytes, usage.SubBytes},
+	} {
+		if c.rate <= 0 {
+			continue
+		}
+
+		if err := quota.Default.Charge(c.key, c.rate, c.n); err != nil {
+			log.Error("quota[%s] charge %s: %v", quota.Default.Name(), c.key, err)
+			return
+		}
+	}
+}
+
+// acquireSub occupies a concurrent subscriber slot of the subscriber and the
+// topic for the holder. The returned release func must be called when the
+// sub is done.
+func (this *quotaKeeper) acquireSub(myAppid, hisAppid, topic, holder string) (release func(), ok bool) {
+	release = func() {}
+	if quota.Default == nil {
+		return release, true
+	}
+
+	// a crashed kateway never leaks the slots
+	ttl := Options.SubTimeout * 2
+	var acquired []string
+	release = func() {
+		for _, key := range acquired {
+			if err := quota.Default.Release(key, holder); err != nil {
+				log.Error("quota[%s] release %s %s: %v", quota.Default.Name(), key, holder, err)
+			}
+		}
+	}
+
+	for _, c := range []quotaCheck{
+		{appQuotaKey(myAppid, "sub"), int64(manager.Default.AppQuota(myAppid).Subscribers), 1},
+		{topicQuotaKey(hisAppid, topic, "sub"), int64(manager.Default.TopicQuota(hisAppid, topic).Subscribers), 1},
+	} {
+		if c.rate <= 0 {
+			continue
+		}
+
+		ok, err := quota.Default.Acquire(c.key, holder, int(c.rate), ttl)
+		if err != nil {
+			log.Error("quota[%s] acquire %s %s: %v", quota.Default.Name(), c.key, holder, err)
+			continue
+		}
+		if !ok {
+			release()
+			return func() {}, false
+		}
+
+		acquired = append(acquired, c.key)
+	}
+
+	return release, true
+}
+
+// capRetention applies the retention bytes quota of the topic, which covers
+// all its partitions, to the per partition retention.bytes of ts.
+// Unlimited retention.bytes is capped to the quota.
+func capRetention(appid, topic string, ts *sla.TopicSla, partitions int) error {
+	limit := manager.Default.TopicQuota(appid, topic).RetentionBytes
+	if limit <= 0 {
+		return nil
+	}
+
+	if partitions < 1 {
+		partitions = 1
+	}
+	perPartition := limit / int64(partitions)
+	switch {
+	case perPartition < 1:
+		return ErrRetentionQuota
+
+	case ts.RetentionBytes <= 0:
+		ts.RetentionBytes = int(perPartition)
+
+	case int64(ts.RetentionBytes) > perPartition:
+		return ErrRetentionQuota
+	}
+
+	return nil
+}
+
+// addUsage accumulates the usage of an appid in memory till next flush.
+func (this *quotaKeeper) addUsage(appid string, usage quota.Usage) {
+	if quota.Default == nil || appid == "" {
+		return
+	}
+
+	this.mu.Lock()
+	u, present := this.usages[appid]
+	if !present {
+		u = &quota.Usage{}
+		this.usages[appid] = u
+	}
+	u.Add(usage)
+	this.mu.Unlock()
+}
+
+// flushUsage reports the accumulated usages to the quota backend.
+func (this *quotaKeeper) flushUsage() {
+	if quota.Default == nil {
+		return
+	}
+
+	this.mu.Lock()
+	usages := this.usages
+	this.usages = make(map[string]*quota.Usage, len(usages))
+	this.mu.Unlock()
+
+	hour := quota.UsageHour(time.Now())
+	for appid, u := range usages {
+		if err := quota.Default.AddUsage(appid, hour, *u); err != nil {
+			// lost usage is not charged, never double charge
+			log.Error("quota[%s] usage %s %+v: %v", quota.Default.Name(), appid, *u, err)
+		}
+	}
+}
+
+func (this *quotaKeeper) usageFlusher(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer func() {
+		ticker.Stop()
+		this.gw.wg.Done()
+	}()
+
+	for {
+		select {
+		case <-this.gw.shutdownCh:
+			// the final flush is done after all requests finished
+			return
+
+		case <-ticker.C:
+			this.flushUsage()
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/sla"
+)
+
+type quotaManager struct {
+	manager.Manager
+
+	quota manager.Quota
+}
+
+func (this quotaManager) TopicQuota(appid, topic string) manager.Quota {
+	return this.quota
+}
+
+func TestWriteQuotaExceededAfter(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeQuotaExceededAfter(w, time.Millisecond*1500)
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	w = httptest.NewRecorder()
+	writeQuotaExceededAfter(w, 0)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
+
+func TestCapRetention(t *testing.T) {
+	saved := manager.Default
+	defer func() {
+		manager.Default = saved
+	}()
+
+	// unlimited
+	manager.Default = quotaManager{}
+	ts := sla.DefaultSla()
+	assert.Equal(t, nil, capRetention("app1", "foobar", ts, 4))
+	assert.Equal(t, -1, ts.RetentionBytes)
+
+	manager.Default = quotaManager{quota: manager.Quota{RetentionBytes: 100}}
+	ts = sla.DefaultSla()
+	assert.Equal(t, nil, capRetention("app1", "foobar", ts, 4))
+	assert.Equal(t, 25, ts.RetentionBytes)
+
+	ts.RetentionBytes = 20
+	assert.Equal(t, nil, capRetention("app1", "foobar", ts, 4))
+	assert.Equal(t, 20, ts.RetentionBytes)
+
+	ts.RetentionBytes = 30
+	assert.Equal(t, ErrRetentionQuota, capRetention("app1", "foobar", ts, 4))
+
+	assert.Equal(t, ErrRetentionQuota, capRetention("app1", "foobar", sla.DefaultSla(), 101))
+}
@@ -0,0 +1,69 @@
+package gateway
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/funkygao/gafka/registry"
+	log "github.com/funkygao/log4go"
+)
+
+// register registers this kateway instance with its latest instance info.
+func (this *Gateway) register() {
+	this.registeredMu.Lock()
+	defer this.registeredMu.Unlock()
+
+	this.registeredInfo = this.InstanceInfo()
+	registry.Default.Register(this.id, this.registeredInfo)
+}
+
+// updateRegistration refreshes the registered instance info so that ehaproxy
+// can weight this kateway by its live load.
+func (this *Gateway) updateRegistration() error {
+	this.registeredMu.Lock()
+	defer this.registeredMu.Unlock()
+
+	info := this.InstanceInfo()
+	if err := registry.Default.Update(this.id, info); err != nil {
+		return err
+	}
+
+	this.registeredInfo = info
+	return nil
+}
+
+func (this *Gateway) lastRegisteredInfo() []byte {
+	this.registeredMu.Lock()
+	defer this.registeredMu.Unlock()
+	return this.registeredInfo
+}
+
+func (this *Gateway) reportLoad() {
+	ticker := time.NewTicker(Options.LoadReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.quiting:
+			return
+
+		case <-ticker.C:
+			if err := this.updateRegistration(); err != nil {
+				log.Error("report load: %v", err)
+			}
+		}
+	}
+}
+
+// announceDraining tells ehaproxy to stop dispatching new requests to this
+// kateway and waits for it to take effect.
+func (this *Gateway) announceDraining() {
+	atomic.StoreInt32(&this.draining, 1)
+	if err := this.updateRegistration(); err != nil {
+		log.Error("announce draining: %v", err)
+		return
+	}
+
+	log.Info("draining announced, waiting %s before deregister", Options.DrainWait)
+	time.Sleep(Options.DrainWait)
+}
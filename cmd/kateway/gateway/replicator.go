@@ -0,0 +1,217 @@
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/gk/command/mirror"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/meta"
+	gzk "github.com/funkygao/gafka/zk"
+	log "github.com/funkygao/log4go"
+)
+
+// replicaClaimInterval is how often a kateway tries to claim the replication
+// of a cluster and checks the changes of global topics.
+const replicaClaimInterval = time.Second * 30
+
+// replicator copies the messages published in the local zone of global topics
+// to the same partition of the same topic in the peer zone, while the peer
+// zone does the same reversely: global topics are active-active across zones.
+//
+// A message is stamped with its origin zone when replicated, and messages
+// with origin are never replicated again, so each message is written exactly
+// once in each zone without loops.
+//
+// Only one kateway of the zone replicates a cluster, claimed in zk.
+type replicator struct {
+	gw     *Gateway
+	daemon *mirror.Daemon
+}
+
+func newReplicator(gw *Gateway) *replicator {
+	return &replicator{
+		gw:     gw,
+		daemon: mirror.NewDaemon(&mirror.DaemonConfig{}),
+	}
+}
+
+// replicaRouteName is the name of the mirror route of a cluster from a zone
+// to another, the clusters of both zones have the same name.
+func replicaRouteName(fromZone, cluster, toZone string) string {
+	return fmt.Sprintf("kateway.%s.%s.%s", fromZone, cluster, toZone)
+}
+
+func replicaRoute(cluster string) *mirror.Route {
+	return &mirror.Route{
+		Name:         replicaRouteName(Options.Zone, cluster, Options.ReplicaZone),
+		Source:       mirror.Endpoint{Zone: Options.Zone, Cluster: cluster},
+		Target:       mirror.Endpoint{Zone: Options.ReplicaZone, Cluster: cluster},
+		Replicas:     2,
+		Bandwidth:    Options.ReplicaBandwidth,
+		SharedTarget: true,
+		Accept:       isGlobalKafkaTopic,
+		Transform:    stampOrigin,
+	}
+}
+
+// isGlobalKafkaTopic checks if a raw kafka topic is a global topic.
+// Shadow topics are never replicated.
+func isGlobalKafkaTopic(rawTopic string) bool {
+	// appid.topic.ver[.cookie]
+	p := strings.SplitN(rawTopic, ".", 4)
+	if len(p) < 3 {
+		return false
+	}
+
+	appid, topic, ver := p[0], p[1], p[2]
+	return manager.Default.KafkaTopic(appid, topic, ver) == rawTopic &&
+		manager.Default.IsGlobalTopic(appid, topic)
+}
+
+// stampOrigin adds the local zone as origin header of a local message, and
+// skips the messages replicated from the peer zone.
+func stampOrigin(msg *sarama.ConsumerMessage) ([]byte, bool) {
+	headers, bodyIdx, err := envelope.Open(msg.Value)
+	if err != nil {
+		// can't tell its origin, replicating it might loop
+		log.Error("replicator %s/%d %d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+		return nil, false
+	}
+
+	if _, replicated := headers.Get(envelope.OriginKey); replicated {
+		return nil, false
+	}
+
+	headers = append(headers, envelope.Header{Key: envelope.OriginKey, Value: Options.Zone})
+	return envelope.Wrap(headers, msg.Value[bodyIdx:]), true
+}
+
+// globalTopics returns the sorted raw global topics of a cluster joined.
+func globalTopics(cluster string) (string, error) {
+	topics, err := meta.Default.ZkCluster(cluster).Topics()
+	if err != nil {
+		return "", err
+	}
+
+	r := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		if isGlobalKafkaTopic(topic) {
+			r = append(r, topic)
+		}
+	}
+	sort.Strings(r)
+	return strings.Join(r, ","), nil
+}
+
+// replicate runs the route of a cluster while this kateway owns it, and
+// restarts the route when global topics of the cluster change.
+func (this *replicator) replicate(cluster string) {
+	var (
+		route        = replicaRoute(cluster)
+		orchestrator = this.gw.zkzone.NewOrchestrator()
+		ticker       = time.NewTicker(replicaClaimInterval)
+		stop         chan struct{}
+		stopped      chan struct{}
+		topics       string
+	)
+
+	halt := func() {
+		if stop != nil {
+			close(stop)
+			<-stopped
+			stop = nil
+			log.Trace("replicator[%s] stopped", route.Name)
+		}
+	}
+
+	defer func() {
+		ticker.Stop()
+		halt()
+		orchestrator.ReleaseResource(this.gw.id, gzk.PubsubReplicaOwners, route.Name)
+		this.gw.wg.Done()
+	}()
+
+	for {
+		err := orchestrator.ClaimResource(this.gw.id, gzk.PubsubReplicaOwners, route.Name)
+		if err == nil {
+			var t string
+			if t, err = globalTopics(cluster); err == nil && (stop == nil || t != topics) {
+				halt()
+
+				topics = t
+				stop, stopped = make(chan struct{}), make(chan struct{})
+				go func(stop, stopped chan struct{}) {
+					this.daemon.RunRoute(route, stop)
+					close(stopped)
+				}(stop, stopped)
+				log.Info("replicator[%s] started: %s", route.Name, topics)
+			}
+		}
+
+		switch err {
+		case nil:
+		case gzk.ErrClaimedByOthers:
+			halt()
+		default:
+			// the claim might be lost, never run 2 replicators of a cluster
+			log.Error("replicator[%s] %v", route.Name, err)
+			halt()
+		}
+
+		select {
+		case <-this.gw.shutdownCh:
+			return
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// stats reports the replication progress from the local zone to the peer
+// zone, only the kateway owning a cluster reports it.
+func (this *replicator) stats() map[string]interface{} {
+	routes := this.daemon.Stats()
+	var lag int64
+	for _, r := range routes {
+		lag += r.Lag
+	}
+
+	return map[string]interface{}{
+		"zone":   Options.Zone,
+		"peer":   Options.ReplicaZone,
+		"lag":    lag,
+		"routes": routes,
+	}
+}
+
+func (this *replicator) close() {
+	this.daemon.Close()
+}
+
+// failoverOffset translates the offset a group consumed in the peer zone to
+// the offset to resume in the local zone.
+//
+// fromPeer is the checkpoint of peer->local route stored in the local zone
+// and toPeer the checkpoint of local->peer route stored in the peer zone.
+// Both constraints are taken: the peer messages upto the offset are consumed,
+// and so are the local messages which were consumed as replicas in the peer
+// zone.
+// Returns -1 if the offset can't be translated.
+func failoverOffset(fromPeer, toPeer *gzk.MirrorCheckpoint, peerOffset int64) int64 {
+	if fromPeer == nil || toPeer == nil {
+		// the partition is not replicated both ways yet
+		return -1
+	}
+
+	peer := mirror.TranslateSharedOffset(fromPeer, peerOffset)
+	local := mirror.ReverseSharedOffset(toPeer, peerOffset)
+	if peer < local {
+		return peer
+	}
+	return local
+}
@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	gzk "github.com/funkygao/gafka/zk"
+)
+
+type globalTopicManager struct {
+	manager.Manager
+}
+
+func (this globalTopicManager) KafkaTopic(appid string, topic string, ver string) string {
+	return appid + "." + topic + "." + ver
+}
+
+func (this globalTopicManager) IsGlobalTopic(appid, topic string) bool {
+	return appid == "app1" && topic == "orders"
+}
+
+func TestIsGlobalKafkaTopic(t *testing.T) {
+	saved := manager.Default
+	defer func() {
+		manager.Default = saved
+	}()
+
+	manager.Default = globalTopicManager{}
+	assert.Equal(t, true, isGlobalKafkaTopic("app1.orders.v1"))
+	assert.Equal(t, false, isGlobalKafkaTopic("app1.users.v1"))
+	assert.Equal(t, false, isGlobalKafkaTopic("app1.orders"))
+	// shadow topic
+	assert.Equal(t, false, isGlobalKafkaTopic("app1.orders.v1.app2.group1.retry"))
+}
+
+func TestStampOrigin(t *testing.T) {
+	saved := Options.Zone
+	defer func() {
+		Options.Zone = saved
+	}()
+	Options.Zone = "z1"
+
+	value, ok := stampOrigin(&sarama.ConsumerMessage{Value: []byte("hello")})
+	assert.Equal(t, true, ok)
+	headers, bodyIdx, err := envelope.Open(value)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "hello", string(value[bodyIdx:]))
+	origin, _ := headers.Get(envelope.OriginKey)
+	assert.Equal(t, "z1", origin)
+
+	value, ok = stampOrigin(&sarama.ConsumerMessage{Value: envelope.Wrap(envelope.Headers{{Key: "a", Value: "b"}}, []byte("hello"))})
+	assert.Equal(t, true, ok)
+	headers, _, _ = envelope.Open(value)
+	assert.Equal(t, "a=b;_origin=z1", headers.String())
+
+	// replicated from peer zone, never replicate back
+	_, ok = stampOrigin(&sarama.ConsumerMessage{Value: value})
+	assert.Equal(t, false, ok)
+}
+
+func TestFailoverOffset(t *testing.T) {
+	// peer->local: peer offset 10 is local 20, 30 is 45
+	fromPeer := &gzk.MirrorCheckpoint{History: [][2]int64{{10, 20}, {30, 45}}}
+	// local->peer: local offset 15 is peer 25
+	toPeer := &gzk.MirrorCheckpoint{History: [][2]int64{{15, 25}}}
+
+	assert.Equal(t, int64(-1), failoverOffset(nil, toPeer, 30))
+	assert.Equal(t, int64(-1), failoverOffset(fromPeer, nil, 30))
+	// local messages not consumed in peer yet
+	assert.Equal(t, int64(-1), failoverOffset(fromPeer, toPeer, 20))
+	assert.Equal(t, int64(15), failoverOffset(fromPeer, toPeer, 30))
+
+	toPeer.History = append(toPeer.History, [2]int64{50, 60})
+	assert.Equal(t, int64(45), failoverOffset(fromPeer, toPeer, 100))
+}
@@ -3,6 +3,7 @@ package gateway
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -47,10 +48,22 @@ func writeWsError(ws *websocket.Conn, err string) {
 }
 
 func writeQuotaExceeded(w http.ResponseWriter) {
+	writeQuotaExceededAfter(w, time.Second)
+}
+
+// writeQuotaExceededAfter tells the client to retry after wait.
+func writeQuotaExceededAfter(w http.ResponseWriter, wait time.Duration) {
 	punishClient()
 	punishClient() // twice on purpose
 
+	// Retry-After is in seconds, round up
+	retryAfter := int64((wait + time.Second - 1) / time.Second)
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
 	w.Header().Set("Connection", "close")
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
 	_writeErrorResponse(w, "quota exceeded", http.StatusTooManyRequests)
 }
 
@@ -39,6 +39,14 @@ func (this *Gateway) buildRouting() {
 			this.manServer.deleteWebhookHandler)
 		this.manServer.Router().GET("/v1/schemas/:appid/:topic/:ver",
 			m(this.manServer.schemaHandler))
+		this.manServer.Router().GET("/v1/schemas/:appid/:topic/:ver/versions",
+			m(this.manServer.schemaVersionsHandler))
+		this.manServer.Router().POST("/v1/schemas/:appid/:topic/:ver",
+			m(this.manServer.registerSchemaHandler))
+		this.manServer.Router().PUT("/v1/schemas/:appid/:topic/:ver",
+			m(this.manServer.schemaCompatibilityHandler))
+		this.manServer.Router().GET("/v1/schema/:id",
+			m(this.manServer.schemaByIdHandler))
 		this.manServer.Router().DELETE("/v1/manager/cache",
 			m(this.manServer.refreshManagerHandler))
 
@@ -53,16 +61,28 @@ func (this *Gateway) buildRouting() {
 			m(this.manServer.peekHandler))
 		this.manServer.Router().POST("/v1/shadow/:appid/:topic/:ver/:group",
 			m(this.manServer.addTopicShadowHandler))
+		this.manServer.Router().GET("/v1/shadow/:appid/:topic/:ver/:group",
+			m(this.manServer.shadowStatusHandler))
+		this.manServer.Router().GET("/v1/shadow/:appid/:topic/:ver/:group/msgs",
+			m(this.manServer.shadowPeekHandler))
+		this.manServer.Router().POST("/v1/shadow/:appid/:topic/:ver/:group/replay",
+			m(this.manServer.shadowReplayHandler))
+		this.manServer.Router().PUT("/v1/shadow/:appid/:topic/:ver/:group/purge",
+			m(this.manServer.shadowPurgeHandler))
 		this.manServer.Router().GET("/v1/subd/:topic/:ver",
 			m(this.manServer.subdStatusHandler))
 		this.manServer.Router().GET("/v1/status/:appid/:topic/:ver",
 			m(this.manServer.subStatusHandler))
 		this.manServer.Router().GET("/v1/sub/status",
 			m(this.manServer.appSubStatusHandler))
+		this.manServer.Router().GET("/v1/usage/:appid",
+			m(this.manServer.usageHandler))
 		this.manServer.Router().DELETE("/v1/groups/:appid/:topic/:ver/:group",
 			m(this.manServer.delSubGroupHandler))
 		this.manServer.Router().PUT("/v1/offset/:appid/:topic/:ver/:group/:partition",
 			m(this.manServer.resetSubOffsetHandler))
+		this.manServer.Router().PUT("/v1/failover/:appid/:topic/:ver/:group",
+			m(this.manServer.failoverHandler))
 	}
 
 	if this.pubServer != nil {
@@ -74,6 +94,7 @@ func (this *Gateway) buildRouting() {
 
 		this.pubServer.Router().POST("/v1/raw/msgs/:cluster/:topic", m(this.pubServer.pubRawHandler))
 		this.pubServer.Router().POST("/v1/msgs/:topic/:ver", m(this.pubServer.pubHandler))
+		this.pubServer.Router().POST("/v1/msgs/:topic/:ver/batch", m(this.pubServer.pubBatchHandler))
 		this.pubServer.Router().POST("/v1/ws/msgs/:topic/:ver", m(this.pubServer.pubWsHandler))
 		this.pubServer.Router().POST("/v1/jobs/:topic/:ver", m(this.pubServer.addJobHandler))
 		this.pubServer.Router().DELETE("/v1/jobs/:topic/:ver", m(this.pubServer.deleteJobHandler))
@@ -99,6 +120,7 @@ func (this *Gateway) buildRouting() {
 		this.subServer.Router().PUT("/v1/msgs/:appid/:topic/:ver", m(this.subServer.buryHandler))
 		this.subServer.Router().GET("/v1/ws/msgs/:appid/:topic/:ver", m(this.subServer.subWsHandler))
 		this.subServer.Router().PUT("/v1/offsets/:appid/:topic/:ver/:group", m(this.subServer.ackHandler))
+		this.subServer.Router().PUT("/v1/acks/:appid/:topic/:ver/:group", m(this.subServer.visAckHandler))
 		this.subServer.Router().PUT("/v1/raw/offsets/:cluster/:topic/:group", m(this.subServer.ackRawHandler))
 
 		// TODO deprecated
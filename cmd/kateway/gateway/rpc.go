@@ -1,6 +1,7 @@
 package gateway
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -54,3 +55,42 @@ func (this *Gateway) callKateway(kw *zk.KatewayMeta, method string, uri string)
 
 	return
 }
+
+// forwardSub replays a sub server request on another kateway and returns its response.
+func (this *Gateway) forwardSub(kw *zk.KatewayMeta, r *http.Request, body []byte) (status int, respBody []byte, err error) {
+	url := fmt.Sprintf("http://%s%s", kw.SubAddr, r.URL.RequestURI())
+
+	var req *http.Request
+	req, err = http.NewRequest(r.Method, url, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	for _, h := range []string{HttpHeaderAppid, HttpHeaderSubkey, HttpHeaderKatewayId, "Content-Type", "User-Agent"} {
+		req.Header.Set(h, r.Header.Get(h))
+	}
+	req.Header.Set(HttpHeaderXForwardedFor, getHttpRemoteIp(r))
+
+	timeout := time.Second * 10
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: nil,
+			Dial: (&net.Dialer{
+				Timeout: timeout,
+			}).Dial,
+			DisableKeepAlives:     true,
+			ResponseHeaderTimeout: timeout,
+		},
+	}
+
+	var response *http.Response
+	response, err = client.Do(req)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	respBody, err = ioutil.ReadAll(response.Body)
+	status = response.StatusCode
+	return
+}
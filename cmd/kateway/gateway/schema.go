@@ -0,0 +1,19 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/funkygao/gafka/cmd/kateway/schema"
+)
+
+// ContentTypeAvro is the Content-Type of avro binary encoded pub messages,
+// other messages are validated as json.
+const ContentTypeAvro = "avro/binary"
+
+func validateMessage(s *schema.Schema, contentType string, body []byte) error {
+	if strings.HasPrefix(contentType, ContentTypeAvro) {
+		return s.ValidateBinary(body)
+	}
+
+	return s.ValidateJSON(body)
+}
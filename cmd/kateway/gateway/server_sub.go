@@ -4,12 +4,14 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/funkygao/gafka/cmd/kateway/meta"
+	"github.com/funkygao/gafka/cmd/kateway/visibility"
+	gzk "github.com/funkygao/gafka/zk"
 	"github.com/funkygao/golib/ratelimiter"
 	"github.com/funkygao/golib/sync2"
 	"github.com/funkygao/golib/timewheel"
@@ -37,6 +39,11 @@ type subServer struct {
 	ackShutdown  int32                                          // sync shutdown with ack handlers goroutines
 	ackCh        chan ackOffsets                                // client ack'ed offsets
 	ackedOffsets map[string]map[string]map[string]map[int]int64 // [cluster][topic][group][partition]: offset
+	offsetStores map[string]gzk.OffsetStore                     // key is cluster, owned by ackCommitter
+
+	visTracker *visibility.Tracker // inflight msgs of visibility timeout sub
+
+	buryJournal *buryJournal
 
 	subMetrics *subMetrics
 
@@ -58,6 +65,8 @@ func newSubServer(httpAddr, httpsAddr string, maxClients int, gw *Gateway) *subS
 		ackShutdown:      0,
 		ackCh:            make(chan ackOffsets, 100),
 		ackedOffsets:     make(map[string]map[string]map[string]map[int]int64),
+		offsetStores:     make(map[string]gzk.OffsetStore),
+		visTracker:       visibility.New(Options.MaxSubInflight),
 	}
 	this.subMetrics = NewSubMetrics(this.gw)
 	this.waitExitFunc = this.waitExit
@@ -88,10 +97,20 @@ func newSubServer(httpAddr, httpsAddr string, maxClients int, gw *Gateway) *subS
 	filer.SetRotateDaily(true)
 	this.auditor.AddFilter("file", logLevel, filer)
 
+	var err error
+	if this.buryJournal, err = openBuryJournal(Options.BuryJournal); err != nil {
+		panic(err)
+	}
+
 	return this
 }
 
 func (this *subServer) Start() {
+	if err := this.buryJournal.recover(this.completeBury); err != nil {
+		// the half done buries will be delivered and buried again
+		log.Error("bury journal recover: %v", err)
+	}
+
 	this.gw.wg.Add(1)
 	go this.ackCommitter()
 
@@ -211,6 +230,7 @@ func (this *subServer) waitExit(exit <-chan struct{}) {
 
 	this.subMetrics.Flush()
 	this.timer.Stop()
+	this.buryJournal.Close()
 
 	this.gw.wg.Done()
 	close(this.closed)
@@ -220,6 +240,9 @@ func (this *subServer) ackCommitter() {
 	ticker := time.NewTicker(time.Second * 30)
 	defer func() {
 		ticker.Stop()
+		for _, store := range this.offsetStores {
+			store.Close()
+		}
 		log.Debug("ack committer done")
 		this.gw.wg.Done()
 	}()
@@ -281,7 +304,11 @@ func (this *subServer) ackCommitter() {
 
 func (this *subServer) commitOffsets() {
 	for cluster, clusterTopic := range this.ackedOffsets {
-		zkcluster := meta.Default.ZkCluster(cluster)
+		store, present := this.offsetStores[cluster]
+		if !present {
+			store = meta.Default.ZkCluster(cluster).NewOffsetStore()
+			this.offsetStores[cluster] = store
+		}
 
 		for topic, groupPartition := range clusterTopic {
 			for group, partitionOffset := range groupPartition {
@@ -293,10 +320,10 @@ func (this *subServer) commitOffsets() {
 
 					log.Debug("cluster[%s] group[%s] commit offset {T:%s/%d O:%d}", cluster, group, topic, partition, offset)
 
-					if err := zkcluster.ResetConsumerGroupOffset(topic, group, strconv.Itoa(partition), offset); err != nil {
+					if err := store.CommitOffset(group, topic, int32(partition), offset); err != nil {
 						log.Error("cluster[%s] group[%s] commit offset {T:%s/%d O:%d} %v", cluster, group, topic, partition, offset, err)
 
-						if err == zk.ErrNoNode {
+						if err == zk.ErrNoNode || err == sarama.ErrUnknownTopicOrPartition {
 							// invalid offset commit request, will not retry
 							this.ackedOffsets[cluster][topic][group][partition] = -1
 						}
@@ -1,57 +1,84 @@
 package gateway
 
 import (
-	"bytes"
 	"strings"
 
-	"github.com/funkygao/gafka/mpool"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
 )
 
 const (
-	TagMarkStart = byte(1) // FIXME conflicts with ProtocolBuffer
-	TagMarkEnd   = byte(2)
-	TagSeperator = ";" // follow cookie rules a=b;c=d
+	TagSeperator = envelope.TagSeperator
+
+	schemaTagPrefix = "schema="
 )
 
-func IsTaggedMessage(msg []byte) bool {
-	return msg[0] == TagMarkStart
+// parseMessageTag splits the X-Tag http header, the tags of a message are
+// carried in its envelope headers.
+func parseMessageTag(tag string) []string {
+	return strings.Split(strings.TrimSuffix(tag, TagSeperator), TagSeperator)
 }
 
-// ┌────────────────────────────┐ ┌────────┐
-// │TagMarkStart Tag TagMarkEnd │ │Message │
-// └────────────────────────────┘ └────────┘
-func AddTagToMessage(m *mpool.Message, tag string) {
-	shift := tagLen(tag)
-	for i := len(m.Body) - 1; i >= shift; i-- {
-		m.Body[i] = m.Body[i-shift]
-	}
+// addSchemaTag stamps the writer schema id of a message into its tag.
+func addSchemaTag(tag string, schemaId string) string {
+	return appendTag(tag, schemaTagPrefix+schemaId)
+}
 
-	i := 0
-	m.Body[i] = TagMarkStart
-	i++
-	for _, b := range tag {
-		m.Body[i] = byte(b)
-		i++
+// addMsgIdTag stamps the producer message id into its tag.
+func addMsgIdTag(tag string, msgId string) string {
+	return appendTag(tag, envelope.MsgIdKey+"="+msgId)
+}
+
+func appendTag(tag string, t string) string {
+	if tag == "" {
+		return t
 	}
-	m.Body[i] = TagMarkEnd
+
+	return strings.TrimSuffix(tag, TagSeperator) + TagSeperator + t
 }
 
-func ExtractMessageTag(msg []byte) ([]string, int, error) {
-	tagEnd := bytes.IndexByte(msg, TagMarkEnd)
-	if tagEnd == -1 {
-		// not a tagged message
-		return nil, 0, ErrIllegalTaggedMessage
+// validateMsgId checks the producer message id, it must be safe in a tag.
+func validateMsgId(msgId string) bool {
+	if len(msgId) > MaxMsgIdLen {
+		return false
 	}
 
-	tag := string(msg[1:tagEnd]) // discard the tag mark start
-	tags := parseMessageTag(tag)
-	return tags, tagEnd + 1, nil
+	for i := 0; i < len(msgId); i++ {
+		switch c := msgId[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
 }
 
-func tagLen(tag string) int {
-	return 2 + len(tag) // TagMarkStart tag TagMarkEnd
+// validateTag checks the tag of a message before it becomes envelope headers:
+// each tag is key=value or a bare key, and the msg id must be legal.
+func validateTag(tag string) error {
+	for _, t := range parseMessageTag(tag) {
+		if t = strings.TrimSpace(t); t == "" {
+			continue
+		}
+
+		if strings.HasPrefix(t, "=") {
+			return ErrIllegalTag
+		}
+	}
+
+	if msgId, present := envelope.ParseTag(tag).Get(envelope.MsgIdKey); present && !validateMsgId(msgId) {
+		return ErrIllegalTag
+	}
+
+	return nil
 }
 
-func parseMessageTag(tag string) []string {
-	return strings.Split(strings.TrimSuffix(tag, TagSeperator), TagSeperator)
+// schemaIdOfTags returns empty string if the message has no schema.
+func schemaIdOfTags(tags []string) string {
+	for _, t := range tags {
+		if strings.HasPrefix(t, schemaTagPrefix) {
+			return t[len(schemaTagPrefix):]
+		}
+	}
+	return ""
 }
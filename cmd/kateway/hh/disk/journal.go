@@ -0,0 +1,202 @@
+package disk
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Journal is a write ahead log in the segment format for kateway components
+// other than hinted handoff that need to survive a crash, e,g. sub bury.
+//
+// Unlike queue, every Append is synced to disk before return and there is no
+// cursor: the whole journal is replayed on open and compacted by the caller.
+type Journal struct {
+	mu sync.Mutex
+
+	path    string
+	maxSize int64
+	seg     *segment
+}
+
+// OpenJournal opens or creates the journal file.
+func OpenJournal(path string, maxSize int64) (*Journal, error) {
+	if err := mkdirIfNotExist(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	seg, err := newSegment(0, path, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Journal{path: path, maxSize: maxSize, seg: seg}, nil
+}
+
+// Append durably appends a record to the journal.
+func (j *Journal) Append(key, value []byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	b := &block{magic: currentMagic, key: key, value: value}
+
+	j.seg.mu.Lock()
+	defer j.seg.mu.Unlock()
+
+	if j.seg.wfile == nil {
+		return ErrSegmentNotOpen
+	}
+	if j.seg.size+b.size() > j.maxSize {
+		return ErrSegmentFull
+	}
+	if err := b.writeTo(j.seg.wfile); err != nil {
+		return err
+	}
+	if err := j.seg.wfile.Sync(); err != nil {
+		return err
+	}
+
+	j.seg.size += b.size()
+	return nil
+}
+
+// Replay calls fn with each record in the order appended.
+//
+// A torn record at the tail, left by a crash in the middle of Append, is
+// truncated so that later appends are readable.
+func (j *Journal) Replay(fn func(key, value []byte) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.seg.Seek(0); err != nil {
+		return err
+	}
+
+	var pos int64
+	for {
+		b := new(block)
+		err := j.seg.ReadOne(b)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if pos == j.seg.DiskUsage() {
+				return err
+			}
+
+			// torn tail
+			return j.truncate(pos)
+		}
+
+		pos += b.size()
+		if err = fn(b.key, b.value); err != nil {
+			return err
+		}
+	}
+}
+
+// Reset discards all the records.
+func (j *Journal) Reset() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.truncate(0)
+}
+
+// Rewrite replaces all the records with the ones fn adds.
+//
+// The records are written to a temp file which is renamed over the journal,
+// so a crash in the middle leaves either the old or the new records intact.
+func (j *Journal) Rewrite(fn func(add func(key, value []byte) error) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	tmp := j.path + ".tmp"
+	os.Remove(tmp) // left by a crash
+	seg, err := newSegment(0, tmp, j.maxSize)
+	if err != nil {
+		return err
+	}
+
+	err = fn(func(key, value []byte) error {
+		b := &block{magic: currentMagic, key: key, value: value}
+		if seg.size+b.size() > j.maxSize {
+			return ErrSegmentFull
+		}
+		if err := b.writeTo(seg.wfile); err != nil {
+			return err
+		}
+
+		seg.size += b.size()
+		return nil
+	})
+	if err == nil {
+		err = seg.wfile.Sync()
+	}
+	if e := seg.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err = j.seg.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, j.path); err == nil {
+		err = syncDir(filepath.Dir(j.path))
+	}
+
+	// reopen even if rename failed, the old records are still there
+	seg, e := newSegment(0, j.path, j.maxSize)
+	if e != nil {
+		return e
+	}
+
+	j.seg = seg
+	return err
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func (j *Journal) truncate(size int64) error {
+	if err := j.seg.Close(); err != nil {
+		return err
+	}
+	if err := os.Truncate(j.path, size); err != nil {
+		return err
+	}
+
+	seg, err := newSegment(0, j.path, j.maxSize)
+	if err != nil {
+		return err
+	}
+
+	j.seg = seg
+	return nil
+}
+
+// DiskUsage returns the size of the journal file in bytes.
+func (j *Journal) DiskUsage() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.seg.DiskUsage()
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.seg.Close()
+}
@@ -0,0 +1,116 @@
+package disk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func replayAll(t *testing.T, j *Journal) (keys, values []string) {
+	err := j.Replay(func(key, value []byte) error {
+		keys = append(keys, string(key))
+		values = append(values, string(value))
+		return nil
+	})
+	assert.Equal(t, nil, err)
+	return
+}
+
+func TestJournalAppendReplayReset(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "journal")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sub", "journal")
+
+	j, err := OpenJournal(path, 1<<20)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, j.Append([]byte("k1"), []byte("v1")))
+	assert.Equal(t, nil, j.Append([]byte("k2"), []byte("v2")))
+	assert.Equal(t, nil, j.Close())
+
+	j, err = OpenJournal(path, 1<<20)
+	assert.Equal(t, nil, err)
+	keys, values := replayAll(t, j)
+	assert.Equal(t, []string{"k1", "k2"}, keys)
+	assert.Equal(t, []string{"v1", "v2"}, values)
+
+	// append after replay
+	assert.Equal(t, nil, j.Append([]byte("k3"), []byte("v3")))
+	keys, _ = replayAll(t, j)
+	assert.Equal(t, 3, len(keys))
+
+	assert.Equal(t, nil, j.Reset())
+	assert.Equal(t, int64(0), j.DiskUsage())
+	keys, _ = replayAll(t, j)
+	assert.Equal(t, 0, len(keys))
+	j.Close()
+}
+
+func TestJournalRewrite(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "journal")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	j, _ := OpenJournal(path, 1<<20)
+	j.Append([]byte("k1"), []byte("v1"))
+	j.Append([]byte("k2"), []byte("v2"))
+
+	assert.Equal(t, nil, j.Rewrite(func(add func(key, value []byte) error) error {
+		return add([]byte("k2"), []byte("v2"))
+	}))
+	keys, _ := replayAll(t, j)
+	assert.Equal(t, []string{"k2"}, keys)
+	j.Append([]byte("k3"), []byte("v3"))
+	j.Close()
+
+	j, _ = OpenJournal(path, 1<<20)
+	keys, _ = replayAll(t, j)
+	assert.Equal(t, []string{"k2", "k3"}, keys)
+
+	// a failed rewrite keeps the old records
+	assert.Equal(t, ErrSegmentFull, j.Rewrite(func(add func(key, value []byte) error) error {
+		return add([]byte("k4"), make([]byte, 2<<20))
+	}))
+	keys, _ = replayAll(t, j)
+	assert.Equal(t, []string{"k2", "k3"}, keys)
+	_, err := os.Stat(path + ".tmp")
+	assert.Equal(t, true, os.IsNotExist(err))
+	j.Close()
+}
+
+func TestJournalTornTail(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "journal")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	j, _ := OpenJournal(path, 1<<20)
+	j.Append([]byte("k1"), []byte("v1"))
+	good := j.DiskUsage()
+	j.Append([]byte("k2"), []byte("v2"))
+	j.Close()
+
+	// crash in the middle of the 2nd append
+	os.Truncate(path, good+5)
+
+	j, _ = OpenJournal(path, 1<<20)
+	keys, _ := replayAll(t, j)
+	assert.Equal(t, []string{"k1"}, keys)
+	assert.Equal(t, good, j.DiskUsage())
+
+	j.Append([]byte("k3"), []byte("v3"))
+	keys, _ = replayAll(t, j)
+	assert.Equal(t, []string{"k1", "k3"}, keys)
+	j.Close()
+}
+
+func TestJournalFull(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "journal")
+	defer os.RemoveAll(dir)
+
+	j, _ := OpenJournal(filepath.Join(dir, "journal"), 20)
+	assert.Equal(t, nil, j.Append([]byte("k1"), []byte("v1")))
+	assert.Equal(t, ErrSegmentFull, j.Append([]byte("k2"), []byte("v2")))
+	j.Close()
+}
@@ -3,6 +3,7 @@ package disk
 import (
 	"time"
 
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
 	"github.com/funkygao/gafka/cmd/kateway/store"
 	log "github.com/funkygao/log4go"
 )
@@ -37,6 +38,16 @@ func (q *queue) pump() {
 		err = q.Next(&b)
 		switch err {
 		case nil:
+			if dedup.IsDelivered(q.clusterTopic.topic, b.value) {
+				// the producer retried the pub and it was delivered directly
+				log.Debug("queue[%s] {k:%s} delivered, skipped", q.ident(), string(b.key))
+
+				q.cursor.commitPosition()
+				q.inflights.Add(-1)
+				q.deliverN.Add(1)
+				continue
+			}
+
 			for retries = 0; retries < defaultMaxRetries; retries++ {
 				// TODO we might use AsyncPub
 				partition, offset, err = store.DefaultPubStore.SyncPub(q.clusterTopic.cluster, q.clusterTopic.topic, b.key, b.value)
@@ -45,6 +56,9 @@ func (q *queue) pump() {
 						Auditor.Trace("queue[%s] {P:%d O:%d}", q.ident(), partition, offset)
 					}
 
+					// preserve the producer msg id for idempotent pub
+					dedup.RecordDelivered(q.clusterTopic.topic, b.value, partition, offset)
+
 					q.cursor.commitPosition()
 					okN++
 					q.inflights.Add(-1)
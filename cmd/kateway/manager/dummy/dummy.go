@@ -70,6 +70,18 @@ func (this *dummyStore) DeadPartitions() map[string]map[int32]struct{} {
 	return nil
 }
 
+func (this *dummyStore) AppQuota(appid string) manager.Quota {
+	return manager.Quota{}
+}
+
+func (this *dummyStore) TopicQuota(appid, topic string) manager.Quota {
+	return manager.Quota{}
+}
+
+func (this *dummyStore) IsGlobalTopic(appid, topic string) bool {
+	return false
+}
+
 func (this *dummyStore) ForceRefresh() {
 
 }
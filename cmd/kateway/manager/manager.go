@@ -54,6 +54,15 @@ type Manager interface {
 
 	DeadPartitions() map[string]map[int32]struct{}
 
+	// AppQuota returns the quota of an appid.
+	AppQuota(appid string) Quota
+
+	// TopicQuota returns the quota of a topic of an appid.
+	TopicQuota(appid, topic string) Quota
+
+	// IsGlobalTopic checks if a topic is replicated across zones.
+	IsGlobalTopic(appid, topic string) bool
+
 	Dump() map[string]interface{}
 }
 
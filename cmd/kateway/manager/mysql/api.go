@@ -81,9 +81,25 @@ func (this *mysqlStore) Dump() map[string]interface{} {
 	r["app_topic"] = this.appTopicsMap
 	r["groups"] = this.appConsumerGroupMap
 	r["shadows"] = this.shadowQueueMap
+	r["app_quota"] = this.appQuotaMap
+	r["topic_quota"] = this.topicQuotaMap
+	r["global_topics"] = this.globalTopicMap
 	return r
 }
 
+func (this *mysqlStore) AppQuota(appid string) manager.Quota {
+	return this.appQuotaMap[appid]
+}
+
+func (this *mysqlStore) TopicQuota(appid, topic string) manager.Quota {
+	return this.topicQuotaMap[appid][topic]
+}
+
+func (this *mysqlStore) IsGlobalTopic(appid, topic string) bool {
+	_, present := this.globalTopicMap[appid][topic]
+	return present
+}
+
 func (this *mysqlStore) DeadPartitions() map[string]map[int32]struct{} {
 	return this.deadPartitionMap
 }
@@ -141,3 +141,21 @@ CREATE TABLE `topic_schema` (
   `Status` tinyint(2) NOT NULL COMMENT '状态：1正常|-2废弃',
   PRIMARY KEY (`AppId`, `TopicName`, `Ver`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `app_quota` (
+  `AppId` bigint(20) NOT NULL,
+  `TopicName` varchar(255) NOT NULL DEFAULT '' COMMENT '空为应用配额',
+  `PubMsgs` bigint(20) NOT NULL DEFAULT '0' COMMENT '每秒消息数，0不限',
+  `PubBytes` bigint(20) NOT NULL DEFAULT '0' COMMENT '每秒字节数，0不限',
+  `SubMsgs` bigint(20) NOT NULL DEFAULT '0',
+  `SubBytes` bigint(20) NOT NULL DEFAULT '0',
+  `Subscribers` int(11) NOT NULL DEFAULT '0' COMMENT '并发订阅者数',
+  `RetentionBytes` bigint(20) NOT NULL DEFAULT '0' COMMENT '主题所有分区的保留字节数',
+  PRIMARY KEY (`AppId`, `TopicName`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `global_topic` (
+  `AppId` bigint(20) NOT NULL,
+  `TopicName` varchar(255) NOT NULL COMMENT '跨机房双活复制的主题',
+  PRIMARY KEY (`AppId`, `TopicName`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -30,3 +30,15 @@ type topicSchemaRecord struct {
 	AppId, TopicName, Ver string
 	Schema                string
 }
+
+type globalTopicRecord struct {
+	AppId, TopicName string
+}
+
+type quotaRecord struct {
+	AppId, TopicName  string
+	PubMsgs, PubBytes int64
+	SubMsgs, SubBytes int64
+	Subscribers       int
+	RetentionBytes    int64
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/funkygao/gafka/cmd/kateway/manager"
 	"github.com/funkygao/gafka/ctx"
 	"github.com/funkygao/gafka/zk"
 	log "github.com/funkygao/log4go"
@@ -30,6 +31,9 @@ type mysqlStore struct {
 	shadowQueueMap      map[string]string                       // hisappid.topic.ver.myappid:group
 	deadPartitionMap    map[string]map[int32]struct{}           // topic:partitionId
 	topicSchemaMap      map[string]map[string]map[string]string // appid:topic:ver:schema
+	appQuotaMap         map[string]manager.Quota                // appid:quota
+	topicQuotaMap       map[string]map[string]manager.Quota     // appid:topic:quota
+	globalTopicMap      map[string]map[string]struct{}          // appid:topics replicated across zones
 	dev2appMap          map[string]string                       // devId:appId
 }
 
@@ -130,6 +134,15 @@ func (this *mysqlStore) refreshFromMysql() error {
 		return err
 	}
 
+	if err = this.fetchQuotaRecords(db); err != nil {
+		// e,g. app_quota not created yet, which should not block the rest
+		log.Warn("mysql manager store quotas disabled: %v", err)
+	}
+
+	if err = this.fetchGlobalTopics(db); err != nil {
+		return err
+	}
+
 	if err = this.fetchDevApp(db); err != nil {
 		return err
 	}
@@ -216,6 +229,77 @@ func (this *mysqlStore) fetchDeadPartitions(db *sql.DB) error {
 	return nil
 }
 
+func (this *mysqlStore) fetchQuotaRecords(db *sql.DB) error {
+	rows, err := db.Query("SELECT AppId,TopicName,PubMsgs,PubBytes,SubMsgs,SubBytes,Subscribers,RetentionBytes FROM app_quota")
+	if err != nil {
+		this.appQuotaMap = make(map[string]manager.Quota)
+		this.topicQuotaMap = make(map[string]map[string]manager.Quota)
+		return err
+	}
+	defer rows.Close()
+
+	appQuotaMap := make(map[string]manager.Quota)
+	topicQuotaMap := make(map[string]map[string]manager.Quota)
+	var q quotaRecord
+	for rows.Next() {
+		err = rows.Scan(&q.AppId, &q.TopicName, &q.PubMsgs, &q.PubBytes, &q.SubMsgs, &q.SubBytes,
+			&q.Subscribers, &q.RetentionBytes)
+		if err != nil {
+			log.Error("mysql manager store: %v", err)
+			continue
+		}
+
+		quota := manager.Quota{
+			PubMsgs:        q.PubMsgs,
+			PubBytes:       q.PubBytes,
+			SubMsgs:        q.SubMsgs,
+			SubBytes:       q.SubBytes,
+			Subscribers:    q.Subscribers,
+			RetentionBytes: q.RetentionBytes,
+		}
+		if q.TopicName == "" {
+			// empty topic name is the quota of the app
+			appQuotaMap[q.AppId] = quota
+			continue
+		}
+
+		if _, present := topicQuotaMap[q.AppId]; !present {
+			topicQuotaMap[q.AppId] = make(map[string]manager.Quota)
+		}
+		topicQuotaMap[q.AppId][q.TopicName] = quota
+	}
+
+	this.appQuotaMap = appQuotaMap
+	this.topicQuotaMap = topicQuotaMap
+	return nil
+}
+
+func (this *mysqlStore) fetchGlobalTopics(db *sql.DB) error {
+	rows, err := db.Query("SELECT AppId,TopicName FROM global_topic")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	globalTopicMap := make(map[string]map[string]struct{})
+	var t globalTopicRecord
+	for rows.Next() {
+		err = rows.Scan(&t.AppId, &t.TopicName)
+		if err != nil {
+			log.Error("mysql manager store: %v", err)
+			continue
+		}
+
+		if _, present := globalTopicMap[t.AppId]; !present {
+			globalTopicMap[t.AppId] = make(map[string]struct{})
+		}
+		globalTopicMap[t.AppId][t.TopicName] = struct{}{}
+	}
+
+	this.globalTopicMap = globalTopicMap
+	return nil
+}
+
 func (this *mysqlStore) fetchShadowQueueRecords(db *sql.DB) error {
 	rows, err := db.Query("SELECT HisAppId,TopicName,Version,MyAppid,GroupName FROM group_shadow WHERE Status=1")
 	if err != nil {
@@ -0,0 +1,19 @@
+package manager
+
+// Quota limits the resources of an appid or a topic, 0 means unlimited.
+//
+// The appid quota applies to the traffic of the app: what it pubs and subs.
+// The topic quota applies to the traffic of the topic: pub to it and sub
+// from it by all the subscribers.
+type Quota struct {
+	PubMsgs        int64 // msgs per second
+	PubBytes       int64 // bytes per second
+	SubMsgs        int64 // msgs per second
+	SubBytes       int64 // bytes per second
+	Subscribers    int   // concurrent subscribers
+	RetentionBytes int64 // of all partitions of a topic
+}
+
+func (this Quota) IsUnlimited() bool {
+	return this == Quota{}
+}
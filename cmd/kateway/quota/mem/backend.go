@@ -0,0 +1,169 @@
+// Package mem is a quota backend in memory of a single kateway node.
+//
+// The quotas are enforced per node, only for a single kateway deployment
+// or testing.
+package mem
+
+import (
+	"sync"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/quota"
+)
+
+// usageHours is how long the usage is kept.
+const usageHours = 24 * 7
+
+type bucket struct {
+	second int64 // unix second of the current window
+	used   int64 // might exceed rate with the debt of charges
+}
+
+type backend struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	slots   map[string]map[string]time.Time    // key:holder:expires
+	usages  map[string]map[string]*quota.Usage // appid:hour:usage
+}
+
+func New() quota.Backend {
+	return &backend{
+		buckets: make(map[string]*bucket),
+		slots:   make(map[string]map[string]time.Time),
+		usages:  make(map[string]map[string]*quota.Usage),
+	}
+}
+
+func (this *backend) Name() string {
+	return "mem"
+}
+
+func (this *backend) Start() error {
+	return nil
+}
+
+func (this *backend) Stop() {}
+
+func (this *backend) bucketOf(key string, rate int64, now time.Time) *bucket {
+	b, present := this.buckets[key]
+	if !present {
+		b = &bucket{}
+		this.buckets[key] = b
+	}
+
+	if sec := now.Unix(); b.second != sec {
+		// refill by rate each window passed, the debt is paid first
+		if elapsed := sec - b.second; elapsed > 0 && elapsed <= quota.MaxDebtWindows {
+			b.used -= rate * elapsed
+		} else {
+			b.used = 0
+		}
+		if b.used < 0 {
+			b.used = 0
+		}
+		b.second = sec
+	}
+
+	return b
+}
+
+func (this *backend) Take(key string, rate, n int64) (time.Duration, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	now := time.Now()
+	b := this.bucketOf(key, rate, now)
+	if b.used >= rate || (b.used > 0 && b.used+n > rate) {
+		// a batch larger than rate is allowed only on a full bucket
+		return time.Unix(b.second+1, 0).Sub(now), nil
+	}
+
+	b.used += n
+	return 0, nil
+}
+
+func (this *backend) Charge(key string, rate, n int64) error {
+	this.mu.Lock()
+	b := this.bucketOf(key, rate, time.Now())
+	b.used += n
+	if max := rate * (quota.MaxDebtWindows + 1); b.used > max {
+		b.used = max
+	}
+	this.mu.Unlock()
+	return nil
+}
+
+func (this *backend) Acquire(key, holder string, max int, ttl time.Duration) (bool, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	holders, present := this.slots[key]
+	if !present {
+		holders = make(map[string]time.Time)
+		this.slots[key] = holders
+	}
+
+	now := time.Now()
+	for h, expires := range holders {
+		if now.After(expires) {
+			delete(holders, h)
+		}
+	}
+
+	if _, present = holders[holder]; !present && len(holders) >= max {
+		return false, nil
+	}
+
+	holders[holder] = now.Add(ttl)
+	return true, nil
+}
+
+func (this *backend) Release(key, holder string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if holders, present := this.slots[key]; present {
+		delete(holders, holder)
+		if len(holders) == 0 {
+			delete(this.slots, key)
+		}
+	}
+	return nil
+}
+
+func (this *backend) AddUsage(appid, hour string, usage quota.Usage) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	hours, present := this.usages[appid]
+	if !present {
+		hours = make(map[string]*quota.Usage)
+		this.usages[appid] = hours
+	}
+
+	u, present := hours[hour]
+	if !present {
+		u = &quota.Usage{}
+		hours[hour] = u
+
+		oldest := quota.UsageHour(time.Now().Add(-time.Hour * usageHours))
+		for h := range hours {
+			if h < oldest {
+				delete(hours, h)
+			}
+		}
+	}
+
+	u.Add(usage)
+	return nil
+}
+
+func (this *backend) Usage(appid, hour string) (quota.Usage, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if u, present := this.usages[appid][hour]; present {
+		return *u, nil
+	}
+	return quota.Usage{}, nil
+}
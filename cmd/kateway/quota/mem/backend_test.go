@@ -0,0 +1,106 @@
+package mem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/quota"
+)
+
+func TestTake(t *testing.T) {
+	b := New()
+	// avoid crossing the second boundary during the test
+	time.Sleep(time.Unix(time.Now().Unix()+1, 0).Sub(time.Now()))
+
+	wait, err := b.Take("k", 3, 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Duration(0), wait)
+	wait, _ = b.Take("k", 3, 1)
+	assert.Equal(t, time.Duration(0), wait)
+
+	// drained
+	wait, _ = b.Take("k", 3, 0)
+	assert.Equal(t, true, wait > 0 && wait <= time.Second)
+	wait, _ = b.Take("k", 3, 1)
+	assert.Equal(t, true, wait > 0)
+
+	// other keys not affected
+	wait, _ = b.Take("k1", 3, 0)
+	assert.Equal(t, time.Duration(0), wait)
+
+	// a batch larger than rate on a full bucket
+	wait, _ = b.Take("k2", 3, 10)
+	assert.Equal(t, time.Duration(0), wait)
+	wait, _ = b.Take("k2", 3, 1)
+	assert.Equal(t, true, wait > 0)
+
+	// charged beyond rate
+	b.Charge("k3", 3, 5)
+	wait, _ = b.Take("k3", 3, 0)
+	assert.Equal(t, true, wait > 0)
+
+	time.Sleep(wait)
+	wait, _ = b.Take("k", 3, 3)
+	assert.Equal(t, time.Duration(0), wait)
+}
+
+func TestChargeDebt(t *testing.T) {
+	b := New()
+	time.Sleep(time.Unix(time.Now().Unix()+1, 0).Sub(time.Now()))
+
+	// 2 windows in debt
+	b.Charge("k", 3, 9)
+	for i := 0; i < 3; i++ {
+		wait, _ := b.Take("k", 3, 0)
+		assert.Equal(t, true, wait > 0)
+		if i < 2 {
+			time.Sleep(wait)
+		}
+	}
+
+	time.Sleep(time.Unix(time.Now().Unix()+1, 0).Sub(time.Now()))
+	wait, _ := b.Take("k", 3, 3)
+	assert.Equal(t, time.Duration(0), wait)
+
+	// debt beyond MaxDebtWindows is forgiven
+	b.Charge("k1", 1, 1<<20)
+	assert.Equal(t, int64(quota.MaxDebtWindows+1), b.(*backend).buckets["k1"].used)
+}
+
+func TestAcquire(t *testing.T) {
+	b := New()
+	ok, _ := b.Acquire("k", "h1", 2, time.Hour)
+	assert.Equal(t, true, ok)
+	ok, _ = b.Acquire("k", "h2", 2, time.Millisecond)
+	assert.Equal(t, true, ok)
+	ok, _ = b.Acquire("k", "h3", 2, time.Hour)
+	assert.Equal(t, false, ok)
+
+	// reentrant
+	ok, _ = b.Acquire("k", "h1", 2, time.Hour)
+	assert.Equal(t, true, ok)
+
+	// h2 expired
+	time.Sleep(time.Millisecond * 5)
+	ok, _ = b.Acquire("k", "h3", 2, time.Hour)
+	assert.Equal(t, true, ok)
+
+	b.Release("k", "h1")
+	ok, _ = b.Acquire("k", "h4", 2, time.Hour)
+	assert.Equal(t, true, ok)
+}
+
+func TestUsage(t *testing.T) {
+	b := New()
+	hour := quota.UsageHour(time.Now())
+	b.AddUsage("app1", hour, quota.Usage{PubMsgs: 1, PubBytes: 10})
+	b.AddUsage("app1", hour, quota.Usage{PubMsgs: 2, PubBytes: 20, SubMsgs: 1, SubBytes: 5})
+
+	u, err := b.Usage("app1", hour)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, quota.Usage{PubMsgs: 3, PubBytes: 30, SubMsgs: 1, SubBytes: 5}, u)
+
+	u, _ = b.Usage("app2", hour)
+	assert.Equal(t, true, u.IsZero())
+}
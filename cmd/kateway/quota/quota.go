@@ -0,0 +1,78 @@
+// Package quota provides the backend that enforces the quotas of each appid
+// consistently across all kateway instances and accounts their usage for
+// billing.
+//
+// Throughput quotas are token buckets refilled once per second, concurrency
+// quotas are slots with ttl so that a crashed kateway never leaks them.
+package quota
+
+import (
+	"time"
+)
+
+// Usage is the resource an appid consumed in a period.
+type Usage struct {
+	PubMsgs  int64 `json:"pub_msgs"`
+	PubBytes int64 `json:"pub_bytes"`
+	SubMsgs  int64 `json:"sub_msgs"`
+	SubBytes int64 `json:"sub_bytes"`
+}
+
+func (this *Usage) Add(that Usage) {
+	this.PubMsgs += that.PubMsgs
+	this.PubBytes += that.PubBytes
+	this.SubMsgs += that.SubMsgs
+	this.SubBytes += that.SubBytes
+}
+
+func (this Usage) IsZero() bool {
+	return this == Usage{}
+}
+
+// MaxDebtWindows is how many 1s windows a charge beyond rate can drain in
+// advance, the debt beyond is forgiven.
+const MaxDebtWindows = 60
+
+// UsageHourLayout is the time layout of the hourly usage period.
+const UsageHourLayout = "2006010215"
+
+// UsageHour returns the usage period that t falls into.
+func UsageHour(t time.Time) string {
+	return t.UTC().Format(UsageHourLayout)
+}
+
+type Backend interface {
+
+	// Name returns the underlying implementation name.
+	Name() string
+
+	Start() error
+	Stop()
+
+	// Take takes n tokens from the bucket of key which is refilled to rate
+	// tokens every second. If the bucket has not enough tokens, nothing is
+	// taken and it returns how long to wait till the refill.
+	// n 0 checks if the bucket is already drained without taking.
+	Take(key string, rate, n int64) (wait time.Duration, err error)
+
+	// Charge takes n tokens from the bucket of key even if it is drained, for
+	// the cost known only after the work is done.
+	// What exceeds rate is a debt paid by the following windows, at most
+	// MaxDebtWindows of them.
+	Charge(key string, rate, n int64) error
+
+	// Acquire occupies one of the max slots of key for holder at most ttl.
+	Acquire(key, holder string, max int, ttl time.Duration) (ok bool, err error)
+
+	// Release gives the slot of holder back.
+	Release(key, holder string) error
+
+	// AddUsage accumulates the usage of an appid in an hour.
+	AddUsage(appid, hour string, usage Usage) error
+
+	// Usage returns the usage of an appid in an hour.
+	Usage(appid, hour string) (Usage, error)
+}
+
+// Default is nil if quota is disabled.
+var Default Backend
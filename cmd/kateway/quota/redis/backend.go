@@ -0,0 +1,245 @@
+// Package redis is a quota backend in redis shared by all kateway instances.
+package redis
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/quota"
+	"github.com/funkygao/gafka/cmd/kateway/redispool"
+)
+
+const (
+	keyPrefix = "kw.quota."
+
+	usageTTL = time.Hour * 24 * 7
+)
+
+type backend struct {
+	pool *redispool.Pool
+}
+
+func New(addr string, poolSize int) quota.Backend {
+	return &backend{
+		pool: redispool.New(addr, poolSize),
+	}
+}
+
+func (this *backend) Name() string {
+	return "redis"
+}
+
+func (this *backend) Start() error {
+	// fail fast if redis is not reachable
+	return this.pool.Ping()
+}
+
+func (this *backend) Stop() {
+	this.pool.Close()
+}
+
+// bucketKey is the counter of the current 1s window, it expires right after
+// the window.
+func (this *backend) bucketKey(key string, now time.Time) string {
+	return keyPrefix + "b." + key + "." + strconv.FormatInt(now.Unix(), 10)
+}
+
+func (this *backend) Take(key string, rate, n int64) (time.Duration, error) {
+	c, err := this.pool.Get()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	k := this.bucketKey(key, now)
+	used, err := c.Incrby(k, n)
+	if err != nil {
+		this.pool.Discard(c)
+		return 0, err
+	}
+	if used == n {
+		// 1st take of the window
+		if _, err = c.Expire(k, 2); err != nil {
+			this.pool.Discard(c)
+			return 0, err
+		}
+	}
+
+	drained := used > rate && used != n // a batch larger than rate is allowed only on a full bucket
+	if n == 0 {
+		drained = used >= rate
+	}
+	if drained {
+		if n > 0 {
+			if _, err = c.Decrby(k, n); err != nil {
+				this.pool.Discard(c)
+				return 0, err
+			}
+		}
+
+		this.pool.Put(c)
+		return time.Unix(now.Unix()+1, 0).Sub(now), nil
+	}
+
+	this.pool.Put(c)
+	return 0, nil
+}
+
+// Charge carries what exceeds the current window forward by filling the
+// following windows up to rate each.
+func (this *backend) Charge(key string, rate, n int64) error {
+	c, err := this.pool.Get()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	k := this.bucketKey(key, now)
+	used, err := c.Incrby(k, n)
+	if err == nil {
+		_, err = c.Expire(k, 2)
+	}
+
+	debt := used - rate
+	if debt > n {
+		// the rest was carried by former charges
+		debt = n
+	}
+	for i := int64(1); err == nil && debt > 0 && i <= quota.MaxDebtWindows; i++ {
+		fill := debt
+		if fill > rate {
+			fill = rate
+		}
+
+		k = this.bucketKey(key, now.Add(time.Duration(i)*time.Second))
+		if _, err = c.Incrby(k, fill); err == nil {
+			_, err = c.Expire(k, i+2)
+		}
+		debt -= fill
+	}
+	if err != nil {
+		this.pool.Discard(c)
+		return err
+	}
+
+	this.pool.Put(c)
+	return nil
+}
+
+// Acquire keeps the holders in a sorted set scored by expiration.
+func (this *backend) Acquire(key, holder string, max int, ttl time.Duration) (bool, error) {
+	c, err := this.pool.Get()
+	if err != nil {
+		return false, err
+	}
+
+	k := keyPrefix + "s." + key
+	now := time.Now()
+	expired, err := c.Zrangebyscore(k, 0, float64(now.Unix()))
+	if err != nil {
+		this.pool.Discard(c)
+		return false, err
+	}
+	for _, h := range expired {
+		if _, err = c.Zrem(k, h); err != nil {
+			this.pool.Discard(c)
+			return false, err
+		}
+	}
+
+	if _, err = c.Zadd(k, float64(now.Add(ttl).Unix()), []byte(holder)); err != nil {
+		this.pool.Discard(c)
+		return false, err
+	}
+	n, err := c.Zcard(k)
+	if err == nil {
+		_, err = c.Expire(k, int64(ttl/time.Second)+1)
+	}
+	if err != nil {
+		this.pool.Discard(c)
+		return false, err
+	}
+
+	if n > int64(max) {
+		// concurrent acquirers might all fail, never exceeds
+		_, err = c.Zrem(k, []byte(holder))
+		if err != nil {
+			this.pool.Discard(c)
+			return false, err
+		}
+
+		this.pool.Put(c)
+		return false, nil
+	}
+
+	this.pool.Put(c)
+	return true, nil
+}
+
+func (this *backend) Release(key, holder string) error {
+	c, err := this.pool.Get()
+	if err != nil {
+		return err
+	}
+
+	if _, err = c.Zrem(keyPrefix+"s."+key, []byte(holder)); err != nil {
+		this.pool.Discard(c)
+		return err
+	}
+
+	this.pool.Put(c)
+	return nil
+}
+
+func (this *backend) usageKeys(appid, hour string) [4]string {
+	prefix := keyPrefix + "u." + appid + "." + hour + "."
+	return [4]string{prefix + "pm", prefix + "pb", prefix + "sm", prefix + "sb"}
+}
+
+func (this *backend) AddUsage(appid, hour string, usage quota.Usage) error {
+	c, err := this.pool.Get()
+	if err != nil {
+		return err
+	}
+
+	keys := this.usageKeys(appid, hour)
+	for i, n := range [4]int64{usage.PubMsgs, usage.PubBytes, usage.SubMsgs, usage.SubBytes} {
+		if n == 0 {
+			continue
+		}
+
+		if _, err = c.Incrby(keys[i], n); err == nil {
+			_, err = c.Expire(keys[i], int64(usageTTL/time.Second))
+		}
+		if err != nil {
+			this.pool.Discard(c)
+			return err
+		}
+	}
+
+	this.pool.Put(c)
+	return nil
+}
+
+func (this *backend) Usage(appid, hour string) (u quota.Usage, err error) {
+	c, err := this.pool.Get()
+	if err != nil {
+		return
+	}
+
+	keys := this.usageKeys(appid, hour)
+	for i, v := range [4]*int64{&u.PubMsgs, &u.PubBytes, &u.SubMsgs, &u.SubBytes} {
+		b, err := c.Get(keys[i])
+		if err != nil {
+			this.pool.Discard(c)
+			return u, err
+		}
+
+		if len(b) > 0 {
+			*v, _ = strconv.ParseInt(string(b), 10, 64)
+		}
+	}
+
+	this.pool.Put(c)
+	return
+}
@@ -0,0 +1,91 @@
+// Package redispool is a redis client pool shared by the kateway components
+// backed by redis.
+package redispool
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/funkygao/Go-Redis"
+	log "github.com/funkygao/log4go"
+)
+
+// Pool pools the sync redis clients which are not goroutine safe.
+type Pool struct {
+	addr    string
+	clients chan redis.Client
+}
+
+func New(addr string, capacity int) *Pool {
+	return &Pool{
+		addr:    addr,
+		clients: make(chan redis.Client, capacity),
+	}
+}
+
+func (this *Pool) Addr() string {
+	return this.addr
+}
+
+// Get returns a pooled client or dials a new one if the pool is empty.
+func (this *Pool) Get() (redis.Client, error) {
+	select {
+	case c := <-this.clients:
+		return c, nil
+	default:
+	}
+
+	host, port, err := net.SplitHostPort(this.addr)
+	if err != nil {
+		return nil, err
+	}
+	portN, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+
+	c, e := redis.NewSynchClientWithSpec(redis.DefaultSpec().Host(host).Port(portN))
+	if e != nil {
+		log.Error("redis %s: %v", this.addr, e)
+		return nil, e
+	}
+	return c, nil
+}
+
+// Put gives the healthy client back.
+func (this *Pool) Put(c redis.Client) {
+	select {
+	case this.clients <- c:
+	default:
+		// pool is full
+		c.Quit()
+	}
+}
+
+// Discard closes the client that might be broken.
+func (this *Pool) Discard(c redis.Client) {
+	c.Quit()
+}
+
+// Ping fails fast if redis is not reachable.
+func (this *Pool) Ping() error {
+	c, err := this.Get()
+	if err != nil {
+		return err
+	}
+
+	this.Put(c)
+	return nil
+}
+
+// Close closes all the idle clients.
+func (this *Pool) Close() {
+	for {
+		select {
+		case c := <-this.clients:
+			c.Quit()
+		default:
+			return
+		}
+	}
+}
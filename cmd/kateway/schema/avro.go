@@ -0,0 +1,345 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	typeNull    = "null"
+	typeBoolean = "boolean"
+	typeInt     = "int"
+	typeLong    = "long"
+	typeFloat   = "float"
+	typeDouble  = "double"
+	typeBytes   = "bytes"
+	typeString  = "string"
+	typeRecord  = "record"
+	typeEnum    = "enum"
+	typeArray   = "array"
+	typeMap     = "map"
+	typeUnion   = "union"
+	typeFixed   = "fixed"
+)
+
+var primitives = map[string]struct{}{
+	typeNull: {}, typeBoolean: {}, typeInt: {}, typeLong: {},
+	typeFloat: {}, typeDouble: {}, typeBytes: {}, typeString: {},
+}
+
+// Schema is a parsed avro schema.
+type Schema struct {
+	// Id is the hex of the CRC-64-AVRO fingerprint of the parsing canonical form.
+	Id         string
+	Definition string
+
+	root *avroType
+}
+
+type avroType struct {
+	kind     string
+	name     string // full name of record, enum and fixed
+	fields   []*avroField
+	symbols  []string
+	items    *avroType // array
+	values   *avroType // map
+	branches []*avroType
+	size     int
+}
+
+type avroField struct {
+	name       string
+	typ        *avroType
+	hasDefault bool
+}
+
+// Parse parses an avro schema definition json.
+func Parse(definition string) (*Schema, error) {
+	var v interface{}
+	d := json.NewDecoder(strings.NewReader(definition))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidSchema, err)
+	}
+
+	p := &parser{names: make(map[string]*avroType)}
+	root, err := p.parse(v, "")
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidSchema, err)
+	}
+
+	s := &Schema{Definition: definition, root: root}
+	s.Id = fmt.Sprintf("%016x", fingerprint64([]byte(s.Canonical())))
+	return s, nil
+}
+
+type parser struct {
+	names map[string]*avroType
+}
+
+func fullname(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func namespaceOf(fullname string) string {
+	if idx := strings.LastIndex(fullname, "."); idx != -1 {
+		return fullname[:idx]
+	}
+	return ""
+}
+
+func (this *parser) parse(v interface{}, namespace string) (*avroType, error) {
+	switch s := v.(type) {
+	case string:
+		if _, present := primitives[s]; present {
+			return &avroType{kind: s}, nil
+		}
+
+		// reference to a named type
+		if t, present := this.names[fullname(s, namespace)]; present {
+			return t, nil
+		}
+		if t, present := this.names[s]; present {
+			return t, nil
+		}
+		return nil, fmt.Errorf("unknown type: %s", s)
+
+	case []interface{}:
+		t := &avroType{kind: typeUnion}
+		seen := make(map[string]struct{})
+		for _, b := range s {
+			bt, err := this.parse(b, namespace)
+			if err != nil {
+				return nil, err
+			}
+			if bt.kind == typeUnion {
+				return nil, fmt.Errorf("nested union")
+			}
+
+			key := bt.kind
+			if bt.name != "" {
+				key = bt.name
+			}
+			if _, present := seen[key]; present {
+				return nil, fmt.Errorf("duplicated union branch: %s", key)
+			}
+			seen[key] = struct{}{}
+
+			t.branches = append(t.branches, bt)
+		}
+		return t, nil
+
+	case map[string]interface{}:
+		return this.parseComplex(s, namespace)
+
+	default:
+		return nil, fmt.Errorf("invalid type: %v", v)
+	}
+}
+
+func (this *parser) parseComplex(m map[string]interface{}, namespace string) (*avroType, error) {
+	kind, _ := m["type"].(string)
+	switch kind {
+	case typeRecord, "error", typeEnum, typeFixed:
+		name, _ := m["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("%s without name", kind)
+		}
+		if ns, ok := m["namespace"].(string); ok && ns != "" {
+			namespace = ns
+		}
+		name = fullname(name, namespace)
+		if _, present := this.names[name]; present {
+			return nil, fmt.Errorf("duplicated name: %s", name)
+		}
+
+		t := &avroType{kind: kind, name: name}
+		if kind == "error" {
+			t.kind = typeRecord
+		}
+		// register before parsing fields so that recursive types work
+		this.names[name] = t
+		return t, this.parseNamed(t, m, namespaceOf(name))
+
+	case typeArray:
+		items, err := this.parse(m["items"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &avroType{kind: typeArray, items: items}, nil
+
+	case typeMap:
+		values, err := this.parse(m["values"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &avroType{kind: typeMap, values: values}, nil
+
+	default:
+		// e,g. {"type": "string"} or {"type": {"type": "array", ...}}
+		if m["type"] == nil {
+			return nil, fmt.Errorf("missing type")
+		}
+		return this.parse(m["type"], namespace)
+	}
+}
+
+func (this *parser) parseNamed(t *avroType, m map[string]interface{}, namespace string) error {
+	switch t.kind {
+	case typeRecord:
+		fields, ok := m["fields"].([]interface{})
+		if !ok {
+			return fmt.Errorf("record %s without fields", t.name)
+		}
+
+		seen := make(map[string]struct{})
+		for _, f := range fields {
+			fm, ok := f.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("record %s: invalid field", t.name)
+			}
+
+			name, _ := fm["name"].(string)
+			if name == "" {
+				return fmt.Errorf("record %s: field without name", t.name)
+			}
+			if _, present := seen[name]; present {
+				return fmt.Errorf("record %s: duplicated field %s", t.name, name)
+			}
+			seen[name] = struct{}{}
+
+			ft, err := this.parse(fm["type"], namespace)
+			if err != nil {
+				return fmt.Errorf("record %s field %s: %v", t.name, name, err)
+			}
+
+			_, hasDefault := fm["default"]
+			t.fields = append(t.fields, &avroField{name: name, typ: ft, hasDefault: hasDefault})
+		}
+
+	case typeEnum:
+		symbols, ok := m["symbols"].([]interface{})
+		if !ok || len(symbols) == 0 {
+			return fmt.Errorf("enum %s without symbols", t.name)
+		}
+		for _, s := range symbols {
+			symbol, ok := s.(string)
+			if !ok {
+				return fmt.Errorf("enum %s: invalid symbol", t.name)
+			}
+			t.symbols = append(t.symbols, symbol)
+		}
+
+	case typeFixed:
+		size, ok := m["size"].(json.Number)
+		if !ok {
+			return fmt.Errorf("fixed %s without size", t.name)
+		}
+		n, err := size.Int64()
+		if err != nil || n < 0 {
+			return fmt.Errorf("fixed %s: invalid size", t.name)
+		}
+		t.size = int(n)
+	}
+
+	return nil
+}
+
+// Canonical returns the parsing canonical form of the schema, which strips
+// doc, aliases and defaults so that equivalent schemas get the same id.
+func (this *Schema) Canonical() string {
+	var buf bytes.Buffer
+	canonical(&buf, this.root, make(map[string]struct{}))
+	return buf.String()
+}
+
+func canonical(buf *bytes.Buffer, t *avroType, seen map[string]struct{}) {
+	switch t.kind {
+	case typeUnion:
+		buf.WriteByte('[')
+		for i, b := range t.branches {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			canonical(buf, b, seen)
+		}
+		buf.WriteByte(']')
+
+	case typeArray:
+		buf.WriteString(`{"type":"array","items":`)
+		canonical(buf, t.items, seen)
+		buf.WriteByte('}')
+
+	case typeMap:
+		buf.WriteString(`{"type":"map","values":`)
+		canonical(buf, t.values, seen)
+		buf.WriteByte('}')
+
+	case typeRecord, typeEnum, typeFixed:
+		if _, present := seen[t.name]; present {
+			buf.WriteString(strconv.Quote(t.name))
+			return
+		}
+		seen[t.name] = struct{}{}
+
+		fmt.Fprintf(buf, `{"name":%s,"type":"%s"`, strconv.Quote(t.name), t.kind)
+		switch t.kind {
+		case typeRecord:
+			buf.WriteString(`,"fields":[`)
+			for i, f := range t.fields {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				fmt.Fprintf(buf, `{"name":%s,"type":`, strconv.Quote(f.name))
+				canonical(buf, f.typ, seen)
+				buf.WriteByte('}')
+			}
+			buf.WriteByte(']')
+
+		case typeEnum:
+			buf.WriteString(`,"symbols":[`)
+			for i, s := range t.symbols {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				buf.WriteString(strconv.Quote(s))
+			}
+			buf.WriteByte(']')
+
+		case typeFixed:
+			fmt.Fprintf(buf, `,"size":%d`, t.size)
+		}
+		buf.WriteByte('}')
+
+	default:
+		buf.WriteString(strconv.Quote(t.kind))
+	}
+}
+
+const fingerprintEmpty = 0xc15d213aa4d7a795
+
+var fingerprintTable = func() (table [256]uint64) {
+	for i := range table {
+		fp := uint64(i)
+		for j := 0; j < 8; j++ {
+			fp = (fp >> 1) ^ (fingerprintEmpty & -(fp & 1))
+		}
+		table[i] = fp
+	}
+	return
+}()
+
+// fingerprint64 is the CRC-64-AVRO Rabin fingerprint of avro spec.
+func fingerprint64(b []byte) uint64 {
+	fp := uint64(fingerprintEmpty)
+	for _, c := range b {
+		fp = (fp >> 8) ^ fingerprintTable[byte(fp)^c]
+	}
+	return fp
+}
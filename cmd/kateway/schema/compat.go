@@ -0,0 +1,132 @@
+package schema
+
+import (
+	"fmt"
+)
+
+// canRead checks if data written with writer schema can be read with reader
+// schema according to the schema resolution rules of avro spec.
+func canRead(reader, writer *avroType) error {
+	return (&resolver{results: make(map[[2]*avroType]error)}).canRead(reader, writer)
+}
+
+type resolver struct {
+	// results of resolved pairs, a pair in progress is assumed ok to break
+	// the recursion of recursive types
+	results map[[2]*avroType]error
+}
+
+func unqualified(name string) string {
+	for i := len(name) - 1; i >= 0; i-- {
+		if name[i] == '.' {
+			return name[i+1:]
+		}
+	}
+	return name
+}
+
+// promotable returns true if writer primitive can be promoted to reader.
+func promotable(reader, writer string) bool {
+	switch writer {
+	case typeInt:
+		return reader == typeLong || reader == typeFloat || reader == typeDouble
+	case typeLong:
+		return reader == typeFloat || reader == typeDouble
+	case typeFloat:
+		return reader == typeDouble
+	case typeString:
+		return reader == typeBytes
+	case typeBytes:
+		return reader == typeString
+	}
+	return false
+}
+
+func (this *resolver) canRead(reader, writer *avroType) error {
+	key := [2]*avroType{reader, writer}
+	if err, present := this.results[key]; present {
+		return err
+	}
+
+	this.results[key] = nil
+	err := this.resolve(reader, writer)
+	this.results[key] = err
+	return err
+}
+
+func (this *resolver) resolve(reader, writer *avroType) error {
+	if writer.kind == typeUnion {
+		for _, w := range writer.branches {
+			if err := this.canRead(reader, w); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if reader.kind == typeUnion {
+		for _, r := range reader.branches {
+			if this.canRead(r, writer) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s not in reader union", typeName(writer))
+	}
+
+	if reader.kind != writer.kind {
+		if promotable(reader.kind, writer.kind) {
+			return nil
+		}
+		return fmt.Errorf("%s can't read %s", typeName(reader), typeName(writer))
+	}
+
+	switch reader.kind {
+	case typeRecord, typeEnum, typeFixed:
+		if unqualified(reader.name) != unqualified(writer.name) {
+			return fmt.Errorf("name mismatch: %s vs %s", reader.name, writer.name)
+		}
+	}
+
+	switch reader.kind {
+	case typeRecord:
+		for _, rf := range reader.fields {
+			wf := writer.field(rf.name)
+			if wf == nil {
+				if !rf.hasDefault {
+					return fmt.Errorf("%s.%s: missing in writer without default", reader.name, rf.name)
+				}
+				continue
+			}
+			if err := this.canRead(rf.typ, wf.typ); err != nil {
+				return fmt.Errorf("%s.%s: %v", reader.name, rf.name, err)
+			}
+		}
+
+	case typeEnum:
+		for _, ws := range writer.symbols {
+			found := false
+			for _, rs := range reader.symbols {
+				if rs == ws {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("%s: symbol %s missing in reader", reader.name, ws)
+			}
+		}
+
+	case typeFixed:
+		if reader.size != writer.size {
+			return fmt.Errorf("%s: size mismatch %d vs %d", reader.name, reader.size, writer.size)
+		}
+
+	case typeArray:
+		return this.canRead(reader.items, writer.items)
+
+	case typeMap:
+		return this.canRead(reader.values, writer.values)
+	}
+
+	return nil
+}
@@ -0,0 +1,21 @@
+package schema
+
+import (
+	"errors"
+)
+
+var (
+	ErrSchemaNotFound = errors.New("schema not found")
+	ErrIncompatible   = errors.New("schema incompatible")
+	ErrInvalidSchema  = errors.New("invalid avro schema")
+	ErrInvalidDatum   = errors.New("message does not match schema")
+)
+
+// IncompatibleError tells why a schema can't evolve from the active one.
+type IncompatibleError struct {
+	Reason string
+}
+
+func (this *IncompatibleError) Error() string {
+	return ErrIncompatible.Error() + ": " + this.Reason
+}
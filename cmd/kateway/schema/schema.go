@@ -0,0 +1,84 @@
+// Package schema is the avro schema registry of pubsub topics.
+//
+// Each topic version has a list of schema versions, the latest is the active
+// one that pub validates messages against. A new schema version must be
+// compatible with the active one according to the compatibility of the topic.
+// A schema is identified by the fingerprint of its canonical form, so that
+// subscribers can fetch the writer schema by the id stamped in messages.
+package schema
+
+import (
+	"fmt"
+)
+
+type Compatibility string
+
+const (
+	// Backward means the new schema can read data written by the active one.
+	Backward Compatibility = "backward"
+
+	// Forward means the active schema can read data written by the new one.
+	Forward Compatibility = "forward"
+
+	// Full is both backward and forward.
+	Full Compatibility = "full"
+
+	None Compatibility = "none"
+
+	DefaultCompatibility = Backward
+)
+
+func ParseCompatibility(s string) (Compatibility, error) {
+	switch c := Compatibility(s); c {
+	case Backward, Forward, Full, None:
+		return c, nil
+
+	default:
+		return "", fmt.Errorf("invalid compatibility: %s", s)
+	}
+}
+
+// Check checks if the new schema can evolve from the active one.
+func (this Compatibility) Check(active, newer *Schema) error {
+	if active == nil {
+		return nil
+	}
+
+	if this == Backward || this == Full {
+		if err := canRead(newer.root, active.root); err != nil {
+			return &IncompatibleError{Reason: fmt.Sprintf("new schema can't read old data, %v", err)}
+		}
+	}
+	if this == Forward || this == Full {
+		if err := canRead(active.root, newer.root); err != nil {
+			return &IncompatibleError{Reason: fmt.Sprintf("old schema can't read new data, %v", err)}
+		}
+	}
+	return nil
+}
+
+// Registry manages the schemas of topics.
+type Registry interface {
+	Name() string
+
+	Start() error
+	Stop()
+
+	// Register adds a schema version to the topic if it is compatible with
+	// the active schema, and returns the schema id.
+	// Registering the active schema again is a no-op.
+	Register(appid, topic, ver, definition string) (id string, err error)
+
+	SetCompatibility(appid, topic, ver string, c Compatibility) error
+
+	// Active returns the latest schema version of a topic.
+	Active(appid, topic, ver string) (*Schema, error)
+
+	// Versions returns all the schema versions of a topic, oldest first.
+	Versions(appid, topic, ver string) ([]*Schema, Compatibility, error)
+
+	// ById returns a schema by its id.
+	ById(id string) (*Schema, error)
+}
+
+var Default Registry
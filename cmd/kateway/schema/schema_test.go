@@ -0,0 +1,138 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+const userV1 = `{
+  "type": "record", "name": "User", "namespace": "com.foo", "doc": "a user",
+  "fields": [
+    {"name": "id", "type": "long"},
+    {"name": "name", "type": "string"},
+    {"name": "email", "type": ["null", "string"], "default": null},
+    {"name": "level", "type": {"type": "enum", "name": "Level", "symbols": ["LOW", "HIGH"]}},
+    {"name": "tags", "type": {"type": "array", "items": "string"}},
+    {"name": "next", "type": ["null", "User"]}
+  ]
+}`
+
+func TestFingerprint(t *testing.T) {
+	// test vectors of avro spec
+	assert.Equal(t, uint64(7195948357588979594), fingerprint64([]byte(`"null"`)))
+	assert.Equal(t, uint64(8247732601305521295), fingerprint64([]byte(`"int"`)))
+}
+
+func TestParseAndCanonical(t *testing.T) {
+	s, err := Parse(userV1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `{"name":"com.foo.User","type":"record","fields":[{"name":"id","type":"long"},{"name":"name","type":"string"},{"name":"email","type":["null","string"]},{"name":"level","type":{"name":"com.foo.Level","type":"enum","symbols":["LOW","HIGH"]}},{"name":"tags","type":{"type":"array","items":"string"}},{"name":"next","type":["null","com.foo.User"]}]}`,
+		s.Canonical())
+	assert.Equal(t, 16, len(s.Id))
+
+	// doc and whitespace don't change the id
+	s2, _ := Parse(`{"type":"record","name":"com.foo.User","fields":[{"name":"id","type":"long"},{"name":"name","type":{"type":"string"}},{"name":"email","type":["null","string"]},{"name":"level","type":{"type":"enum","name":"Level","symbols":["LOW","HIGH"]}},{"name":"tags","type":{"type":"array","items":"string"}},{"name":"next","type":["null","User"]}]}`)
+	assert.Equal(t, s.Id, s2.Id)
+
+	for _, bad := range []string{`{`, `"foo"`, `{"type":"record","name":"A"}`, `["int","int"]`,
+		`{"type":"enum","name":"E","symbols":[]}`, `{"type":"fixed","name":"F"}`} {
+		_, err = Parse(bad)
+		assert.NotEqual(t, nil, err)
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	s, _ := Parse(userV1)
+	assert.Equal(t, nil, s.ValidateJSON([]byte(`{"id":1,"name":"a","level":"LOW","tags":[],"next":null}`)))
+	assert.Equal(t, nil, s.ValidateJSON([]byte(`{"id":1,"name":"a","email":{"string":"a@b"},"level":"HIGH","tags":["x"],"next":{"id":2,"name":"b","level":"LOW","tags":[],"next":null}}`)))
+	assert.Equal(t, nil, s.ValidateJSON([]byte(`{"id":1,"name":"a","email":"a@b","level":"HIGH","tags":["x"],"next":null}`)))
+
+	for _, bad := range []string{
+		`{"id":"1","name":"a","level":"LOW","tags":[],"next":null}`,
+		`{"id":1.5,"name":"a","level":"LOW","tags":[],"next":null}`,
+		`{"name":"a","level":"LOW","tags":[],"next":null}`,
+		`{"id":1,"name":"a","level":"MID","tags":[],"next":null}`,
+		`{"id":1,"name":"a","level":"LOW","tags":[1],"next":null}`,
+		`{"id":1,"name":"a","level":"LOW","tags":[],"next":null,"foo":1}`,
+		`{"id":1,"name":"a","level":"LOW","tags":[],"next":null} {}`,
+		`not json`,
+	} {
+		assert.NotEqual(t, nil, s.ValidateJSON([]byte(bad)))
+	}
+}
+
+type avroWriter struct {
+	bytes.Buffer
+}
+
+func (this *avroWriter) long(v int64) *avroWriter {
+	var buf [binary.MaxVarintLen64]byte
+	this.Write(buf[:binary.PutVarint(buf[:], v)])
+	return this
+}
+
+func (this *avroWriter) string(s string) *avroWriter {
+	this.long(int64(len(s)))
+	this.WriteString(s)
+	return this
+}
+
+func TestValidateBinary(t *testing.T) {
+	s, _ := Parse(userV1)
+
+	var w avroWriter
+	w.long(1).string("a")     // id, name
+	w.long(1).string("a@b")   // email: union branch 1
+	w.long(1)                 // level HIGH
+	w.long(-2).long(4)        // tags block of 2 items with size
+	w.string("x").string("y") //
+	w.long(0)                 // end of tags
+	w.long(0)                 // next: null
+	assert.Equal(t, nil, s.ValidateBinary(w.Bytes()))
+
+	b := w.Bytes()
+	assert.NotEqual(t, nil, s.ValidateBinary(b[:len(b)-1]))
+	assert.NotEqual(t, nil, s.ValidateBinary(append(b, 0)))
+
+	w.Reset()
+	w.long(1).string("a").long(0).long(5) // enum out of range
+	assert.NotEqual(t, nil, s.ValidateBinary(w.Bytes()))
+}
+
+func TestCompatibility(t *testing.T) {
+	v1, _ := Parse(`{"type":"record","name":"R","fields":[{"name":"a","type":"int"}]}`)
+	// add field with default: backward and forward
+	v2, _ := Parse(`{"type":"record","name":"R","fields":[{"name":"a","type":"long"},{"name":"b","type":"string","default":""}]}`)
+	// add field without default: forward only
+	v3, _ := Parse(`{"type":"record","name":"R","fields":[{"name":"a","type":"int"},{"name":"b","type":"string"}]}`)
+	// remove field without default: backward only
+	v4, _ := Parse(`{"type":"record","name":"R","fields":[]}`)
+	// type change
+	v5, _ := Parse(`{"type":"record","name":"R","fields":[{"name":"a","type":"string"}]}`)
+
+	assert.Equal(t, nil, Backward.Check(v1, v2))
+	assert.NotEqual(t, nil, Forward.Check(v1, v2)) // int can't read long
+	assert.NotEqual(t, nil, Backward.Check(v1, v3))
+	assert.Equal(t, nil, Forward.Check(v1, v3))
+	assert.Equal(t, nil, Backward.Check(v1, v4))
+	assert.NotEqual(t, nil, Forward.Check(v1, v4))
+	assert.NotEqual(t, nil, Full.Check(v1, v5))
+	assert.Equal(t, nil, None.Check(v1, v5))
+	assert.Equal(t, nil, Full.Check(nil, v5))
+
+	u1, _ := Parse(userV1)
+	assert.Equal(t, nil, Full.Check(u1, u1))
+
+	e1, _ := Parse(`{"type":"enum","name":"E","symbols":["A","B"]}`)
+	e2, _ := Parse(`{"type":"enum","name":"E","symbols":["A","B","C"]}`)
+	assert.Equal(t, nil, Backward.Check(e1, e2))
+	assert.NotEqual(t, nil, Forward.Check(e1, e2))
+
+	_, err := ParseCompatibility("full")
+	assert.Equal(t, nil, err)
+	_, err = ParseCompatibility("foo")
+	assert.NotEqual(t, nil, err)
+}
@@ -0,0 +1,325 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// ValidateJSON checks a json message against the schema.
+// Union values can be either the plain value or avro json encoding {"branch": value}.
+func (this *Schema) ValidateJSON(msg []byte) error {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(msg))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return fmt.Errorf("%v: %v", ErrInvalidDatum, err)
+	}
+	if d.More() {
+		return fmt.Errorf("%v: trailing data", ErrInvalidDatum)
+	}
+
+	if err := validateJSON(this.root, v, "$"); err != nil {
+		return fmt.Errorf("%v: %v", ErrInvalidDatum, err)
+	}
+	return nil
+}
+
+func validateJSON(t *avroType, v interface{}, path string) error {
+	mismatch := func() error {
+		return fmt.Errorf("%s: expect %s", path, typeName(t))
+	}
+
+	switch t.kind {
+	case typeNull:
+		if v != nil {
+			return mismatch()
+		}
+
+	case typeBoolean:
+		if _, ok := v.(bool); !ok {
+			return mismatch()
+		}
+
+	case typeInt, typeLong:
+		n, ok := v.(json.Number)
+		if !ok {
+			return mismatch()
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return mismatch()
+		}
+		if t.kind == typeInt && (i < math.MinInt32 || i > math.MaxInt32) {
+			return fmt.Errorf("%s: int overflow", path)
+		}
+
+	case typeFloat, typeDouble:
+		if _, ok := v.(json.Number); !ok {
+			return mismatch()
+		}
+
+	case typeBytes, typeString:
+		if _, ok := v.(string); !ok {
+			return mismatch()
+		}
+
+	case typeFixed:
+		s, ok := v.(string)
+		if !ok || utf8.RuneCountInString(s) != t.size {
+			return mismatch()
+		}
+
+	case typeEnum:
+		s, ok := v.(string)
+		if !ok {
+			return mismatch()
+		}
+		for _, symbol := range t.symbols {
+			if symbol == s {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: %s not in enum %s", path, s, t.name)
+
+	case typeArray:
+		items, ok := v.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for i, item := range items {
+			if err := validateJSON(t.items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+
+	case typeMap:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for k, val := range m {
+			if err := validateJSON(t.values, val, path+"."+k); err != nil {
+				return err
+			}
+		}
+
+	case typeRecord:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		matched := 0
+		for _, f := range t.fields {
+			val, present := m[f.name]
+			if !present {
+				if f.hasDefault {
+					continue
+				}
+				return fmt.Errorf("%s.%s: missing field", path, f.name)
+			}
+
+			matched++
+			if err := validateJSON(f.typ, val, path+"."+f.name); err != nil {
+				return err
+			}
+		}
+		if len(m) > matched {
+			for k := range m {
+				if t.field(k) == nil {
+					return fmt.Errorf("%s.%s: unknown field", path, k)
+				}
+			}
+		}
+
+	case typeUnion:
+		for _, b := range t.branches {
+			if validateJSON(b, v, path) == nil {
+				return nil
+			}
+		}
+
+		// avro json encoding of union
+		if m, ok := v.(map[string]interface{}); ok && len(m) == 1 {
+			for k, val := range m {
+				for _, b := range t.branches {
+					if typeName(b) == k {
+						return validateJSON(b, val, path)
+					}
+				}
+			}
+		}
+		return mismatch()
+	}
+
+	return nil
+}
+
+func (this *avroType) field(name string) *avroField {
+	for _, f := range this.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func typeName(t *avroType) string {
+	if t.name != "" {
+		return t.name
+	}
+	return t.kind
+}
+
+// ValidateBinary checks an avro binary encoded message against the schema.
+func (this *Schema) ValidateBinary(msg []byte) error {
+	d := &binaryDecoder{b: msg}
+	if err := d.skip(this.root); err != nil {
+		return fmt.Errorf("%v: %v", ErrInvalidDatum, err)
+	}
+	if d.pos != len(d.b) {
+		return fmt.Errorf("%v: %d trailing bytes", ErrInvalidDatum, len(d.b)-d.pos)
+	}
+	return nil
+}
+
+type binaryDecoder struct {
+	b   []byte
+	pos int
+}
+
+var errShortBuffer = fmt.Errorf("unexpected end of data")
+
+func (this *binaryDecoder) long() (int64, error) {
+	v, n := binary.Varint(this.b[this.pos:])
+	if n <= 0 {
+		return 0, errShortBuffer
+	}
+	this.pos += n
+	return v, nil
+}
+
+func (this *binaryDecoder) advance(n int64) error {
+	if n < 0 || int64(len(this.b)-this.pos) < n {
+		return errShortBuffer
+	}
+	this.pos += int(n)
+	return nil
+}
+
+// skip decodes a datum of the type without materializing it.
+func (this *binaryDecoder) skip(t *avroType) error {
+	switch t.kind {
+	case typeNull:
+		return nil
+
+	case typeBoolean:
+		if this.pos >= len(this.b) {
+			return errShortBuffer
+		}
+		if this.b[this.pos] > 1 {
+			return fmt.Errorf("invalid boolean")
+		}
+		this.pos++
+		return nil
+
+	case typeInt:
+		v, err := this.long()
+		if err == nil && (v < math.MinInt32 || v > math.MaxInt32) {
+			return fmt.Errorf("int overflow")
+		}
+		return err
+
+	case typeLong:
+		_, err := this.long()
+		return err
+
+	case typeFloat:
+		return this.advance(4)
+
+	case typeDouble:
+		return this.advance(8)
+
+	case typeBytes, typeString:
+		n, err := this.long()
+		if err != nil {
+			return err
+		}
+		start := this.pos
+		if err = this.advance(n); err != nil {
+			return err
+		}
+		if t.kind == typeString && !utf8.Valid(this.b[start:this.pos]) {
+			return fmt.Errorf("invalid utf8 string")
+		}
+		return nil
+
+	case typeFixed:
+		return this.advance(int64(t.size))
+
+	case typeEnum:
+		i, err := this.long()
+		if err != nil {
+			return err
+		}
+		if i < 0 || i >= int64(len(t.symbols)) {
+			return fmt.Errorf("enum %s index %d out of range", t.name, i)
+		}
+		return nil
+
+	case typeUnion:
+		i, err := this.long()
+		if err != nil {
+			return err
+		}
+		if i < 0 || i >= int64(len(t.branches)) {
+			return fmt.Errorf("union index %d out of range", i)
+		}
+		return this.skip(t.branches[i])
+
+	case typeRecord:
+		for _, f := range t.fields {
+			if err := this.skip(f.typ); err != nil {
+				return fmt.Errorf("%s.%s: %v", t.name, f.name, err)
+			}
+		}
+		return nil
+
+	case typeArray, typeMap:
+		// blocks of items, a negative count is followed by the block size
+		for {
+			n, err := this.long()
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return nil
+			}
+			if n < 0 {
+				n = -n
+				if _, err = this.long(); err != nil {
+					return err
+				}
+			}
+
+			for i := int64(0); i < n; i++ {
+				if t.kind == typeMap {
+					if err = this.skip(&avroType{kind: typeString}); err != nil {
+						return err
+					}
+					err = this.skip(t.values)
+				} else {
+					err = this.skip(t.items)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return fmt.Errorf("unknown type: %s", t.kind)
+}
@@ -0,0 +1,291 @@
+// Package zkschema implements a schema registry persisted in zk.
+package zkschema
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/schema"
+	"github.com/funkygao/gafka/zk"
+	log "github.com/funkygao/log4go"
+	zklib "github.com/samuel/go-zookeeper/zk"
+)
+
+// Fallback returns the schema definition of a topic that is not registered
+// in zk yet, e,g. the schemas managed by pubsub manager.
+type Fallback func(appid, topic, ver string) (string, error)
+
+type subject struct {
+	compatibility schema.Compatibility
+	versions      []*schema.Schema
+}
+
+type zkRegistry struct {
+	zkzone          *zk.ZkZone
+	fallback        Fallback
+	refreshInterval time.Duration
+
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+
+	mu        sync.RWMutex
+	subjects  map[string]*subject       // key is appid.topic.ver
+	ids       map[string]*schema.Schema // key is schema id
+	fallbacks map[string]*schema.Schema // parsed fallback schemas, nil if not found
+}
+
+func New(zkzone *zk.ZkZone, fallback Fallback, refreshInterval time.Duration) schema.Registry {
+	return &zkRegistry{
+		zkzone:          zkzone,
+		fallback:        fallback,
+		refreshInterval: refreshInterval,
+		shutdownCh:      make(chan struct{}),
+		subjects:        make(map[string]*subject),
+		ids:             make(map[string]*schema.Schema),
+		fallbacks:       make(map[string]*schema.Schema),
+	}
+}
+
+func (this *zkRegistry) Name() string {
+	return "zk"
+}
+
+func (this *zkRegistry) Start() error {
+	this.refresh()
+
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+
+		ticker := time.NewTicker(this.refreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-this.shutdownCh:
+				return
+
+			case <-ticker.C:
+				this.refresh()
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (this *zkRegistry) Stop() {
+	close(this.shutdownCh)
+	this.wg.Wait()
+}
+
+func subjectOf(appid, topic, ver string) string {
+	return fmt.Sprintf("%s.%s.%s", appid, topic, ver)
+}
+
+// refresh reloads all subjects from zk, and the fallback schemas will be
+// reloaded on demand.
+func (this *zkRegistry) refresh() {
+	subjects := make(map[string]*subject)
+	ids := make(map[string]*schema.Schema)
+	for name, ts := range this.zkzone.AllTopicSchemas() {
+		s, err := parseSubject(ts)
+		if err != nil {
+			log.Error("schema[%s]: %v", name, err)
+			continue
+		}
+
+		subjects[name] = s
+		for _, v := range s.versions {
+			ids[v.Id] = v
+		}
+	}
+
+	this.mu.Lock()
+	this.subjects = subjects
+	this.ids = ids
+	this.fallbacks = make(map[string]*schema.Schema)
+	this.mu.Unlock()
+}
+
+func parseSubject(ts *zk.TopicSchemas) (*subject, error) {
+	s := &subject{compatibility: schema.DefaultCompatibility}
+	if ts.Compatibility != "" {
+		c, err := schema.ParseCompatibility(ts.Compatibility)
+		if err != nil {
+			return nil, err
+		}
+		s.compatibility = c
+	}
+
+	for _, v := range ts.Versions {
+		sch, err := schema.Parse(v.Schema)
+		if err != nil {
+			return nil, err
+		}
+		s.versions = append(s.versions, sch)
+	}
+	return s, nil
+}
+
+func (this *zkRegistry) Register(appid, topic, ver, definition string) (string, error) {
+	newer, err := schema.Parse(definition)
+	if err != nil {
+		return "", err
+	}
+
+	name := subjectOf(appid, topic, ver)
+	for {
+		ts, version, err := this.zkzone.TopicSchemas(name)
+		if err != nil {
+			return "", err
+		}
+
+		s := &subject{compatibility: schema.DefaultCompatibility}
+		if ts == nil {
+			ts = &zk.TopicSchemas{}
+
+			// the first registration evolves from the schema in manager if any
+			if fs := this.fallbackSchema(appid, topic, ver); fs != nil {
+				s.versions = []*schema.Schema{fs}
+			}
+		} else if s, err = parseSubject(ts); err != nil {
+			return "", err
+		}
+
+		var active *schema.Schema
+		if len(s.versions) > 0 {
+			active = s.versions[len(s.versions)-1]
+		}
+		if active != nil && active.Id == newer.Id && len(ts.Versions) > 0 {
+			return newer.Id, nil
+		}
+
+		if err = s.compatibility.Check(active, newer); err != nil {
+			return "", err
+		}
+
+		ts.Versions = append(ts.Versions, zk.TopicSchemaVersion{
+			Id:     newer.Id,
+			Schema: definition,
+			Ctime:  time.Now(),
+		})
+		err = this.zkzone.SaveTopicSchemas(name, *ts, version)
+		switch err {
+		case nil:
+			log.Info("schema[%s] registered %s", name, newer.Id)
+			this.refresh()
+			return newer.Id, nil
+
+		case zklib.ErrBadVersion, zklib.ErrNodeExists:
+			// concurrent registration, retry with the latest
+			continue
+
+		default:
+			return "", err
+		}
+	}
+}
+
+func (this *zkRegistry) SetCompatibility(appid, topic, ver string, c schema.Compatibility) error {
+	name := subjectOf(appid, topic, ver)
+	for {
+		ts, version, err := this.zkzone.TopicSchemas(name)
+		if err != nil {
+			return err
+		}
+		if ts == nil {
+			ts = &zk.TopicSchemas{}
+		}
+
+		ts.Compatibility = string(c)
+		err = this.zkzone.SaveTopicSchemas(name, *ts, version)
+		switch err {
+		case nil:
+			log.Info("schema[%s] compatibility: %s", name, c)
+			this.refresh()
+			return nil
+
+		case zklib.ErrBadVersion, zklib.ErrNodeExists:
+			continue
+
+		default:
+			return err
+		}
+	}
+}
+
+func (this *zkRegistry) Active(appid, topic, ver string) (*schema.Schema, error) {
+	this.mu.RLock()
+	s, present := this.subjects[subjectOf(appid, topic, ver)]
+	this.mu.RUnlock()
+	if present && len(s.versions) > 0 {
+		return s.versions[len(s.versions)-1], nil
+	}
+
+	if fs := this.fallbackSchema(appid, topic, ver); fs != nil {
+		return fs, nil
+	}
+	return nil, schema.ErrSchemaNotFound
+}
+
+func (this *zkRegistry) Versions(appid, topic, ver string) ([]*schema.Schema, schema.Compatibility, error) {
+	this.mu.RLock()
+	s, present := this.subjects[subjectOf(appid, topic, ver)]
+	this.mu.RUnlock()
+	if present && len(s.versions) > 0 {
+		return s.versions, s.compatibility, nil
+	}
+
+	c := schema.DefaultCompatibility
+	if present {
+		c = s.compatibility
+	}
+	if fs := this.fallbackSchema(appid, topic, ver); fs != nil {
+		return []*schema.Schema{fs}, c, nil
+	}
+	return nil, c, schema.ErrSchemaNotFound
+}
+
+func (this *zkRegistry) ById(id string) (*schema.Schema, error) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	if s, present := this.ids[id]; present {
+		return s, nil
+	}
+	for _, s := range this.fallbacks {
+		if s != nil && s.Id == id {
+			return s, nil
+		}
+	}
+	return nil, schema.ErrSchemaNotFound
+}
+
+// fallbackSchema returns nil if the topic has no schema in fallback.
+func (this *zkRegistry) fallbackSchema(appid, topic, ver string) *schema.Schema {
+	if this.fallback == nil {
+		return nil
+	}
+
+	name := subjectOf(appid, topic, ver)
+	this.mu.RLock()
+	s, present := this.fallbacks[name]
+	this.mu.RUnlock()
+	if present {
+		return s
+	}
+
+	definition, err := this.fallback(appid, topic, ver)
+	if err == nil {
+		if s, err = schema.Parse(definition); err != nil {
+			log.Warn("schema[%s] fallback: %v", name, err)
+		}
+	}
+
+	this.mu.Lock()
+	this.fallbacks[name] = s
+	this.mu.Unlock()
+	return s
+}
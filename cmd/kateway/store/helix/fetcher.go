@@ -0,0 +1,30 @@
+package helix
+
+import (
+	"github.com/Shopify/sarama"
+)
+
+// fetcher is a sub client view of the group consumer, clients of the same
+// group on this kateway compete for the messages.
+type fetcher struct {
+	*groupConsumer
+	remoteAddr string
+	store      *subStore
+}
+
+func (this *fetcher) Messages() <-chan *sarama.ConsumerMessage {
+	return this.msgs
+}
+
+func (this *fetcher) Errors() <-chan *sarama.ConsumerError {
+	return this.errs
+}
+
+func (this *fetcher) CommitUpto(msg *sarama.ConsumerMessage) error {
+	this.markOffset(msg)
+	return nil
+}
+
+func (this *fetcher) Close() error {
+	return this.store.killClient(this.remoteAddr)
+}
@@ -0,0 +1,217 @@
+package helix
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/meta"
+	"github.com/funkygao/gafka/zk"
+	log "github.com/funkygao/log4go"
+)
+
+type partitionConsumer struct {
+	sarama.PartitionConsumer
+	quit chan struct{}
+	done chan struct{}
+}
+
+// groupConsumer consumes the partitions of a topic assigned to this kateway
+// by helix controller on behalf of a consumer group, the messages are shared
+// by all sub clients of the group connected to this kateway.
+type groupConsumer struct {
+	cluster, topic, group string
+	initialOffset         int64 // when the group has no committed offset
+
+	msgs chan *sarama.ConsumerMessage
+	errs chan *sarama.ConsumerError
+
+	mu          sync.Mutex
+	consumer    sarama.Consumer
+	offsetStore zk.OffsetStore
+	partitions  map[int32]*partitionConsumer
+	offsets     *offsetTracker
+}
+
+func newGroupConsumer(cluster, topic, group string, initialOffset int64) *groupConsumer {
+	return &groupConsumer{
+		cluster:       cluster,
+		topic:         topic,
+		group:         group,
+		initialOffset: initialOffset,
+		msgs:          make(chan *sarama.ConsumerMessage),
+		errs:          make(chan *sarama.ConsumerError, 10),
+		partitions:    make(map[int32]*partitionConsumer),
+		offsets:       newOffsetTracker(),
+	}
+}
+
+func (this *groupConsumer) String() string {
+	return resourceName(this.cluster, this.topic, this.group)
+}
+
+// online starts consuming the partition from the committed offset.
+func (this *groupConsumer) online(partitionId int32) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if _, present := this.partitions[partitionId]; present {
+		return nil
+	}
+
+	if this.consumer == nil {
+		c, err := sarama.NewConsumer(meta.Default.BrokerList(this.cluster), sarama.NewConfig())
+		if err != nil {
+			return err
+		}
+		this.consumer = c
+	}
+	if this.offsetStore == nil {
+		this.offsetStore = meta.Default.ZkCluster(this.cluster).NewOffsetStore()
+	}
+
+	offset, err := this.offsetStore.FetchOffset(this.group, this.topic, partitionId)
+	if err != nil {
+		return err
+	}
+	if offset == -1 {
+		offset = this.initialOffset
+	} else {
+		// offset store records the last consumed offset
+		offset++
+	}
+
+	pc, err := this.consumer.ConsumePartition(this.topic, partitionId, offset)
+	if err == sarama.ErrOffsetOutOfRange {
+		log.Warn("%s/%d offset %d out of range, reset to %d", this, partitionId, offset, this.initialOffset)
+		pc, err = this.consumer.ConsumePartition(this.topic, partitionId, this.initialOffset)
+	}
+	if err != nil {
+		return err
+	}
+
+	p := &partitionConsumer{
+		PartitionConsumer: pc,
+		quit:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+	this.partitions[partitionId] = p
+	go this.pump(p)
+
+	log.Trace("%s/%d online from offset %d", this, partitionId, offset)
+	return nil
+}
+
+func (this *groupConsumer) pump(p *partitionConsumer) {
+	defer close(p.done)
+
+	for {
+		select {
+		case <-p.quit:
+			return
+
+		case msg := <-p.Messages():
+			select {
+			case this.msgs <- msg:
+			case <-p.quit:
+				// the message will be consumed by the next owner
+				return
+			}
+
+		case err := <-p.Errors():
+			select {
+			case this.errs <- err:
+			default:
+				log.Error("%s: %v", this, err)
+			}
+		}
+	}
+}
+
+// offline stops consuming the partition and commits its offset before the
+// controller hands it off to another kateway.
+func (this *groupConsumer) offline(partitionId int32) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	p, present := this.partitions[partitionId]
+	if !present {
+		return nil
+	}
+
+	close(p.quit)
+	<-p.done
+	p.Close()
+	delete(this.partitions, partitionId)
+
+	var err error
+	if offset, present := this.offsets.dirty()[partitionId]; present {
+		err = this.commitPartition(partitionId, offset)
+	}
+	this.offsets.forget(partitionId)
+
+	log.Trace("%s/%d offline", this, partitionId)
+	return err
+}
+
+func (this *groupConsumer) markOffset(msg *sarama.ConsumerMessage) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if _, present := this.partitions[msg.Partition]; !present {
+		// handed off, the new owner will redeliver it
+		return
+	}
+
+	this.offsets.mark(msg.Partition, msg.Offset)
+}
+
+func (this *groupConsumer) commit() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for partitionId, offset := range this.offsets.dirty() {
+		if err := this.commitPartition(partitionId, offset); err != nil {
+			log.Error("%s/%d commit: %v", this, partitionId, err)
+		}
+	}
+}
+
+// commitPartition is called with lock held.
+func (this *groupConsumer) commitPartition(partitionId int32, offset int64) error {
+	if err := this.offsetStore.CommitOffset(this.group, this.topic, partitionId, offset); err != nil {
+		return err
+	}
+
+	this.offsets.markCommitted(partitionId, offset)
+	return nil
+}
+
+func (this *groupConsumer) onlinePartitions() []int32 {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	r := make([]int32, 0, len(this.partitions))
+	for partitionId := range this.partitions {
+		r = append(r, partitionId)
+	}
+	return r
+}
+
+func (this *groupConsumer) close() {
+	for _, partitionId := range this.onlinePartitions() {
+		if err := this.offline(partitionId); err != nil {
+			log.Error("%s/%d: %v", this, partitionId, err)
+		}
+	}
+
+	this.mu.Lock()
+	if this.consumer != nil {
+		this.consumer.Close()
+		this.consumer = nil
+	}
+	if this.offsetStore != nil {
+		this.offsetStore.Close()
+		this.offsetStore = nil
+	}
+	this.mu.Unlock()
+}
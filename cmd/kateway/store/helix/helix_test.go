@@ -0,0 +1,55 @@
+package helix
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestResourceName(t *testing.T) {
+	r := resourceName("trade", "app1.orders.v1", "group_a")
+	assert.Equal(t, "trade:app1.orders.v1:group_a", r)
+
+	cluster, topic, group, err := parseResourceName(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "trade", cluster)
+	assert.Equal(t, "app1.orders.v1", topic)
+	assert.Equal(t, "group_a", group)
+
+	_, _, _, err = parseResourceName("trade:orders")
+	assert.Equal(t, errInvalidResource, err)
+}
+
+func TestParsePartitionName(t *testing.T) {
+	id, err := parsePartitionName("trade:app1.orders.v1:group_a_12")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int32(12), id)
+
+	_, err = parsePartitionName("trade")
+	assert.Equal(t, errInvalidResource, err)
+}
+
+func TestRemoveTag(t *testing.T) {
+	assert.Equal(t, []string{"a", "c"}, removeTag([]string{"a", "b", "c"}, "b"))
+	assert.Equal(t, []string{"a"}, removeTag([]string{"a"}, "b"))
+	assert.Equal(t, 0, len(removeTag(nil, "b")))
+}
+
+func TestOffsetTracker(t *testing.T) {
+	o := newOffsetTracker()
+	assert.Equal(t, 0, len(o.dirty()))
+
+	o.mark(0, 5)
+	o.mark(0, 3) // out of order commit never goes backwards
+	o.mark(1, 0)
+	assert.Equal(t, map[int32]int64{0: 5, 1: 0}, o.dirty())
+
+	o.markCommitted(0, 5)
+	assert.Equal(t, map[int32]int64{1: 0}, o.dirty())
+
+	o.mark(0, 6)
+	assert.Equal(t, map[int32]int64{0: 6, 1: 0}, o.dirty())
+
+	o.forget(0)
+	assert.Equal(t, map[int32]int64{1: 0}, o.dirty())
+}
@@ -0,0 +1,46 @@
+package helix
+
+// offsetTracker tracks the offsets sub clients consumed up to and the
+// offsets committed to the offset store of a consumer group.
+type offsetTracker struct {
+	consumed  map[int32]int64
+	committed map[int32]int64
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		consumed:  make(map[int32]int64),
+		committed: make(map[int32]int64),
+	}
+}
+
+func (this *offsetTracker) mark(partitionId int32, offset int64) {
+	if offset > this.consumed[partitionId] || !this.has(partitionId) {
+		this.consumed[partitionId] = offset
+	}
+}
+
+func (this *offsetTracker) has(partitionId int32) bool {
+	_, present := this.consumed[partitionId]
+	return present
+}
+
+// dirty returns the consumed offsets not committed yet.
+func (this *offsetTracker) dirty() map[int32]int64 {
+	r := make(map[int32]int64)
+	for partitionId, offset := range this.consumed {
+		if committed, present := this.committed[partitionId]; !present || committed != offset {
+			r[partitionId] = offset
+		}
+	}
+	return r
+}
+
+func (this *offsetTracker) markCommitted(partitionId int32, offset int64) {
+	this.committed[partitionId] = offset
+}
+
+func (this *offsetTracker) forget(partitionId int32) {
+	delete(this.consumed, partitionId)
+	delete(this.committed, partitionId)
+}
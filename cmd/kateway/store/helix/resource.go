@@ -0,0 +1,41 @@
+package helix
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errInvalidResource = errors.New("invalid helix resource")
+
+// A helix resource is a consumer group of a topic, whose partitions are the
+// kafka partitions. Kafka topic and cluster names can't contain ':'.
+func resourceName(cluster, topic, group string) string {
+	return fmt.Sprintf("%s:%s:%s", cluster, topic, group)
+}
+
+func parseResourceName(resource string) (cluster, topic, group string, err error) {
+	tuples := strings.SplitN(resource, ":", 3)
+	if len(tuples) != 3 {
+		err = errInvalidResource
+		return
+	}
+
+	return tuples[0], tuples[1], tuples[2], nil
+}
+
+// helix partition name is resource_N.
+func parsePartitionName(partition string) (int32, error) {
+	idx := strings.LastIndex(partition, "_")
+	if idx == -1 {
+		return -1, errInvalidResource
+	}
+
+	id, err := strconv.Atoi(partition[idx+1:])
+	if err != nil {
+		return -1, errInvalidResource
+	}
+
+	return int32(id), nil
+}
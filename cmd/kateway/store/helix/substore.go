@@ -0,0 +1,369 @@
+// Package helix implements a kateway sub store whose partition assignment is
+// managed by helix controller instead of kafka consumer group rebalancing.
+//
+// Each consumer group of a topic is modeled as a helix resource with the
+// OnlineOffline state model, each kafka partition a helix partition, and each
+// kateway a participant. The controller assigns partitions deterministically
+// and hands off a partition only after the previous owner committed offset
+// and transitioned it offline, so there is no rebalancing storm.
+//
+// A kateway participates in a group only while clients of the group connect
+// to it: it tags itself with the resource on the first client and untags on
+// the last one, and the controller assigns partitions only to tagged instances.
+package helix
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/meta"
+	"github.com/funkygao/gafka/cmd/kateway/store"
+	gohelix "github.com/funkygao/go-helix"
+	"github.com/funkygao/go-helix/model"
+	helixzk "github.com/funkygao/go-helix/store/zk"
+	log "github.com/funkygao/log4go"
+)
+
+const commitInterval = time.Minute
+
+var errGroupNotLocal = errors.New("no client of the group on this kateway")
+
+type subStore struct {
+	shutdownCh   chan struct{}
+	closedConnCh <-chan string // remote addr
+	wg           sync.WaitGroup
+
+	zkSvr        string
+	helixCluster string
+	host, port   string
+
+	manager gohelix.HelixManager
+	admin   gohelix.HelixAdmin
+	tags    *instanceTags
+
+	joinMu sync.Mutex // serializes joining and leaving groups, held across helix admin calls
+
+	mu      sync.Mutex
+	groups  map[string]*groupConsumer // key is helix resource
+	clients map[string]*fetcher       // key is client remote addr
+}
+
+// NewSubStore creates a helix participant identified by the sub addr of the kateway.
+func NewSubStore(closedConnCh <-chan string, zkSvr, helixCluster, subAddr string) *subStore {
+	host, port, err := net.SplitHostPort(subAddr)
+	if err != nil {
+		panic(err)
+	}
+
+	return &subStore{
+		shutdownCh:   make(chan struct{}),
+		closedConnCh: closedConnCh,
+		zkSvr:        zkSvr,
+		helixCluster: helixCluster,
+		host:         host,
+		port:         port,
+		groups:       make(map[string]*groupConsumer),
+		clients:      make(map[string]*fetcher),
+	}
+}
+
+func (this *subStore) Name() string {
+	return "helix"
+}
+
+func (this *subStore) Start() (err error) {
+	this.admin = helixzk.NewZkHelixAdmin(this.zkSvr)
+	if err = this.admin.Connect(); err != nil {
+		return
+	}
+
+	this.tags, err = newInstanceTags(this.zkSvr, this.helixCluster, this.host+"_"+this.port)
+	if err != nil {
+		return
+	}
+	// the groups joined before crash have no clients now
+	if err = this.tags.reset(); err != nil {
+		return
+	}
+
+	this.manager, err = helixzk.NewZkHelixManager(this.helixCluster, this.host, this.port,
+		this.zkSvr, gohelix.InstanceTypeParticipant)
+	if err != nil {
+		return
+	}
+
+	sm := gohelix.NewStateModel()
+	sm.AddTransitions([]gohelix.Transition{
+		{"OFFLINE", "ONLINE", this.onOnline},
+		{"ONLINE", "OFFLINE", this.onOffline},
+		{"OFFLINE", "DROPPED", this.onDropped},
+	})
+	if err = this.manager.StateMachineEngine().RegisterStateModel(gohelix.StateModelOnlineOffline, sm); err != nil {
+		return
+	}
+
+	if err = this.manager.Connect(); err != nil {
+		return
+	}
+	log.Trace("helix participant %s_%s joined %s", this.host, this.port, this.helixCluster)
+
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+
+		ticker := time.NewTicker(commitInterval)
+		defer ticker.Stop()
+
+		var remoteAddr string
+		for {
+			select {
+			case <-this.shutdownCh:
+				log.Trace("sub store[%s] stopped", this.Name())
+				return
+
+			case <-ticker.C:
+				for _, g := range this.allGroups() {
+					g.commit()
+				}
+
+			case remoteAddr = <-this.closedConnCh:
+				this.killClient(remoteAddr)
+			}
+		}
+	}()
+
+	return
+}
+
+func (this *subStore) Stop() {
+	close(this.shutdownCh)
+	this.wg.Wait()
+
+	// commit offsets before leaving the cluster so that the next owners of
+	// the partitions resume from them
+	for _, g := range this.allGroups() {
+		g.close()
+	}
+
+	this.manager.Disconnect()
+	this.admin.Disconnect()
+	this.tags.Close()
+	log.Trace("all consumer offsets committed")
+}
+
+func (this *subStore) Fetch(cluster, topic, group, remoteAddr, realIp,
+	resetOffset string, permitStandby bool) (store.Fetcher, error) {
+	this.mu.Lock()
+	f, present := this.clients[remoteAddr]
+	this.mu.Unlock()
+	if present {
+		return f, nil
+	}
+
+	this.joinMu.Lock()
+	defer this.joinMu.Unlock()
+
+	resource := resourceName(cluster, topic, group)
+	this.mu.Lock()
+	g, present := this.groups[resource]
+	this.mu.Unlock()
+	if !present {
+		partitions := meta.Default.TopicPartitions(cluster, topic)
+		if len(partitions) == 0 {
+			return nil, store.ErrInvalidTopic
+		}
+
+		if err := this.ensureResource(resource, len(partitions)); err != nil {
+			return nil, err
+		}
+
+		g = newGroupConsumer(cluster, topic, group, initialOffset(resetOffset))
+		this.mu.Lock()
+		this.groups[resource] = g
+		this.mu.Unlock()
+	}
+
+	this.mu.Lock()
+	first := !this.hasClients(g)
+	this.mu.Unlock()
+	if first {
+		// the 1st client of the group on this kateway
+		if err := this.tags.join(resource); err != nil {
+			return nil, err
+		}
+		log.Trace("helix %s joined", resource)
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if f, present = this.clients[remoteAddr]; present {
+		return f, nil
+	}
+
+	f = &fetcher{
+		groupConsumer: g,
+		remoteAddr:    remoteAddr,
+		store:         this,
+	}
+	this.clients[remoteAddr] = f
+	return f, nil
+}
+
+// ensureResource creates the helix resource on demand and let the controller
+// assign its partitions among live kateway instances.
+func (this *subStore) ensureResource(resource string, partitions int) error {
+	resources, err := this.admin.Resources(this.helixCluster)
+	if err != nil {
+		return err
+	}
+	for _, r := range resources {
+		if r == resource {
+			// might be added before resources were tagged
+			return this.tags.tagResource(resource)
+		}
+	}
+
+	option := gohelix.DefaultAddResourceOption(partitions, gohelix.StateModelOnlineOffline)
+	if err = this.admin.AddResource(this.helixCluster, resource, option); err != nil {
+		return err
+	}
+
+	if err = this.tags.tagResource(resource); err != nil {
+		return err
+	}
+
+	log.Info("helix resource %s added with %d partitions", resource, partitions)
+	return this.admin.Rebalance(this.helixCluster, resource, 1)
+}
+
+// hasClients is called with lock held.
+func (this *subStore) hasClients(g *groupConsumer) bool {
+	for _, f := range this.clients {
+		if f.groupConsumer == g {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *subStore) IsSystemError(err error) bool {
+	switch err {
+	case store.ErrTooManyConsumers, store.ErrInvalidTopic:
+		return false
+
+	default:
+		return true
+	}
+}
+
+func (this *subStore) killClient(remoteAddr string) error {
+	this.joinMu.Lock()
+	defer this.joinMu.Unlock()
+
+	this.mu.Lock()
+	f, present := this.clients[remoteAddr]
+	if !present {
+		this.mu.Unlock()
+		return nil
+	}
+	delete(this.clients, remoteAddr)
+	last := !this.hasClients(f.groupConsumer)
+	this.mu.Unlock()
+
+	if !last {
+		return nil
+	}
+
+	// the last client of the group is gone, the group keeps its partitions
+	// online till the controller moves them to the kateways with clients
+	resource := f.groupConsumer.String()
+	if err := this.tags.leave(resource); err != nil {
+		log.Error("helix %s leave: %v", resource, err)
+		return err
+	}
+
+	log.Trace("helix %s left", resource)
+	return nil
+}
+
+func (this *subStore) allGroups() []*groupConsumer {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	r := make([]*groupConsumer, 0, len(this.groups))
+	for _, g := range this.groups {
+		r = append(r, g)
+	}
+	return r
+}
+
+// groupOf returns the group consumer of a resource, which is created by the
+// first client of the group with its reset offset.
+func (this *subStore) groupOf(resource string) (*groupConsumer, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if g, present := this.groups[resource]; present {
+		return g, nil
+	}
+
+	return nil, errGroupNotLocal
+}
+
+func (this *subStore) transitionOf(message *model.Message) (*groupConsumer, int32, error) {
+	partitionId, err := parsePartitionName(message.PartitionName())
+	if err != nil {
+		return nil, -1, err
+	}
+
+	g, err := this.groupOf(message.Resource())
+	return g, partitionId, err
+}
+
+func (this *subStore) onOnline(message *model.Message, context *gohelix.Context) {
+	g, partitionId, err := this.transitionOf(message)
+	if err == nil {
+		err = g.online(partitionId)
+	}
+	if err == errGroupNotLocal {
+		// untagged before the transition, the controller will move it away
+		log.Warn("%s %s OFFLINE->ONLINE: %v", message.Resource(), message.PartitionName(), err)
+		return
+	}
+	if err != nil {
+		// fail the transition: go-helix recovers the handler panic and puts
+		// the partition in ERROR state, the controller assigns it elsewhere
+		log.Error("%s %s OFFLINE->ONLINE: %v", message.Resource(), message.PartitionName(), err)
+		panic(err)
+	}
+}
+
+func (this *subStore) onOffline(message *model.Message, context *gohelix.Context) {
+	g, partitionId, err := this.transitionOf(message)
+	if err == nil {
+		err = g.offline(partitionId)
+	}
+	if err == errGroupNotLocal {
+		// never consumed here
+		return
+	}
+	if err != nil {
+		log.Error("%s %s ONLINE->OFFLINE: %v", message.Resource(), message.PartitionName(), err)
+	}
+}
+
+func (this *subStore) onDropped(message *model.Message, context *gohelix.Context) {
+	log.Trace("%s %s dropped", message.Resource(), message.PartitionName())
+}
+
+func initialOffset(resetOffset string) int64 {
+	if resetOffset == "newest" {
+		return sarama.OffsetNewest
+	}
+
+	return sarama.OffsetOldest
+}
@@ -0,0 +1,141 @@
+package helix
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	// helix controller assigns the partitions of a resource only to the
+	// instances tagged with its group tag.
+	idealStateGroupTag = "INSTANCE_GROUP_TAG"
+	instanceTagList    = "TAG_LIST"
+
+	maxZnRecordUpdates = 5 // optimistic update retries
+)
+
+// znRecord is how helix persists everything in zk.
+type znRecord struct {
+	Id           string                       `json:"id"`
+	SimpleFields map[string]string            `json:"simpleFields"`
+	ListFields   map[string][]string          `json:"listFields"`
+	MapFields    map[string]map[string]string `json:"mapFields"`
+}
+
+// instanceTags edits the helix records helix admin doesn't expose: the group
+// tag of resources and the tags of this participant, so that a group's
+// partitions are assigned only to the kateways its clients connect to.
+type instanceTags struct {
+	conn     *zk.Conn
+	chroot   string
+	cluster  string
+	instance string
+}
+
+// zkSvr is e,g. localhost:2181/helix
+func newInstanceTags(zkSvr, cluster, instance string) (*instanceTags, error) {
+	var chroot string
+	if idx := strings.Index(zkSvr, "/"); idx != -1 {
+		zkSvr, chroot = zkSvr[:idx], zkSvr[idx:]
+	}
+
+	conn, _, err := zk.Connect(strings.Split(zkSvr, ","), time.Second*30)
+	if err != nil {
+		return nil, err
+	}
+
+	return &instanceTags{conn: conn, chroot: chroot, cluster: cluster, instance: instance}, nil
+}
+
+func (this *instanceTags) Close() {
+	this.conn.Close()
+}
+
+func (this *instanceTags) path(elems ...string) string {
+	return fmt.Sprintf("%s/%s/%s", this.chroot, this.cluster, strings.Join(elems, "/"))
+}
+
+// update applies fn to the record with optimistic lock.
+func (this *instanceTags) update(path string, fn func(r *znRecord)) error {
+	for i := 0; i < maxZnRecordUpdates; i++ {
+		data, stat, err := this.conn.Get(path)
+		if err != nil {
+			return err
+		}
+
+		r := &znRecord{}
+		if err = json.Unmarshal(data, r); err != nil {
+			return err
+		}
+		if r.SimpleFields == nil {
+			r.SimpleFields = make(map[string]string)
+		}
+		if r.ListFields == nil {
+			r.ListFields = make(map[string][]string)
+		}
+		fn(r)
+
+		if data, err = json.Marshal(r); err != nil {
+			return err
+		}
+		if _, err = this.conn.Set(path, data, stat.Version); err != zk.ErrBadVersion {
+			return err
+		}
+	}
+
+	return zk.ErrBadVersion
+}
+
+// tagResource restricts the resource to the instances tagged with its name.
+func (this *instanceTags) tagResource(resource string) error {
+	return this.update(this.path("IDEALSTATES", resource), func(r *znRecord) {
+		r.SimpleFields[idealStateGroupTag] = resource
+	})
+}
+
+// join tags this instance with the resource so that the controller assigns
+// its partitions here.
+func (this *instanceTags) join(resource string) error {
+	return this.update(this.path("CONFIGS", "PARTICIPANT", this.instance), func(r *znRecord) {
+		for _, tag := range r.ListFields[instanceTagList] {
+			if tag == resource {
+				return
+			}
+		}
+		r.ListFields[instanceTagList] = append(r.ListFields[instanceTagList], resource)
+	})
+}
+
+// leave untags this instance with the resource so that the controller moves
+// its partitions to other instances.
+func (this *instanceTags) leave(resource string) error {
+	return this.update(this.path("CONFIGS", "PARTICIPANT", this.instance), func(r *znRecord) {
+		r.ListFields[instanceTagList] = removeTag(r.ListFields[instanceTagList], resource)
+	})
+}
+
+// reset drops all tags of this instance, e,g. left by a crash.
+func (this *instanceTags) reset() error {
+	err := this.update(this.path("CONFIGS", "PARTICIPANT", this.instance), func(r *znRecord) {
+		delete(r.ListFields, instanceTagList)
+	})
+	if err == zk.ErrNoNode {
+		// 1st time to join the cluster
+		return nil
+	}
+	return err
+}
+
+func removeTag(tags []string, tag string) []string {
+	r := tags[:0]
+	for _, t := range tags {
+		if t != tag {
+			r = append(r, t)
+		}
+	}
+	return r
+}
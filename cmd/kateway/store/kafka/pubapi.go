@@ -120,6 +120,115 @@ func (this *pubStore) SyncPub(cluster, topic string, key, msg []byte) (partition
 	return this.doSyncPub(false, cluster, topic, key, msg)
 }
 
+func (this *pubStore) SyncAllPubBatch(cluster, topic string, keys, msgs [][]byte) ([]store.PubResult, error) {
+	return this.doSyncPubBatch(true, cluster, topic, keys, msgs)
+}
+
+func (this *pubStore) SyncPubBatch(cluster, topic string, keys, msgs [][]byte) ([]store.PubResult, error) {
+	return this.doSyncPubBatch(false, cluster, topic, keys, msgs)
+}
+
+// doSyncPubBatch sends all msgs with one SendMessages, sarama groups them into a
+// message set per partition and a produce request per broker.
+func (this *pubStore) doSyncPubBatch(allAck bool, cluster, topic string,
+	keys, msgs [][]byte) ([]store.PubResult, error) {
+	this.pubPoolsLock.RLock()
+	pool, present := this.pubPools[cluster]
+	this.pubPoolsLock.RUnlock()
+	if !present {
+		return nil, store.ErrInvalidCluster
+	}
+
+	if pool.breaker.Open() {
+		return nil, store.ErrCircuitOpen
+	}
+
+	producerMsgs := make([]*sarama.ProducerMessage, len(msgs))
+	for i, msg := range msgs {
+		var keyEncoder sarama.Encoder = nil // will use random partitioner
+		if len(keys[i]) > 0 {
+			keyEncoder = sarama.ByteEncoder(keys[i]) // will use hash partition
+		}
+
+		producerMsgs[i] = &sarama.ProducerMessage{
+			Topic: topic,
+			Key:   keyEncoder,
+			Value: sarama.ByteEncoder(msg),
+		}
+	}
+
+	getProducer := pool.GetSyncProducer
+	if allAck {
+		getProducer = pool.GetSyncAllProducer
+	}
+
+	producer, err := getProducer()
+	if this.dryRun {
+		// ignore kafka I/O
+		producer.Recycle()
+		return make([]store.PubResult, len(msgs)), nil
+	}
+	if err != nil {
+		pool.breaker.Fail()
+
+		if producer != nil {
+			// should never happen
+			producer.CloseAndRecycle()
+		}
+
+		return nil, err
+	}
+
+	results := make([]store.PubResult, len(msgs))
+	failures := make(map[*sarama.ProducerMessage]error)
+	err = producer.SendMessages(producerMsgs)
+	if errs, ok := err.(sarama.ProducerErrors); ok {
+		for _, e := range errs {
+			failures[e.Msg] = e.Err
+		}
+	} else if err != nil {
+		// should never happen: sync producer always returns ProducerErrors
+		for _, m := range producerMsgs {
+			failures[m] = err
+		}
+	}
+
+	connBroken := false
+	for i, m := range producerMsgs {
+		e, failed := failures[m]
+		if !failed {
+			results[i].Partition = m.Partition
+			results[i].Offset = m.Offset
+			continue
+		}
+
+		switch e {
+		case sarama.ErrUnknownTopicOrPartition, sarama.ErrInvalidTopic:
+			// this conn is still valid
+			results[i].Err = store.ErrInvalidTopic
+
+		default:
+			// e,g. sarama.ErrLeaderNotAvailable, sarama.ErrOutOfBrokers, i/o timeout
+			results[i].Err = e
+			connBroken = true
+		}
+	}
+
+	if len(failures) > 0 {
+		log.Error("cluster[%s] topic:%s %d/%d failed %v", cluster, topic, len(failures), len(msgs), err)
+	}
+
+	if connBroken {
+		pool.breaker.Fail()
+		producer.CloseAndRecycle()
+	} else {
+		pool.breaker.Succeed()
+		producer.Recycle()
+	}
+
+	return results, nil
+}
+
 // FIXME not fully fault tolerant like SyncPub.
 func (this *pubStore) AsyncPub(cluster string, topic string, key []byte,
 	msg []byte) (partition int32, offset int64, err error) {
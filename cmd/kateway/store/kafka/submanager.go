@@ -12,14 +12,22 @@ import (
 	log "github.com/funkygao/log4go"
 )
 
+// offsetSyncInterval is how often the zk offsets of a group are forwarded to
+// its kafka offsets at most. kafka-cg commits every minute, so a new member
+// might redeliver the messages of last minute anyway.
+const offsetSyncInterval = time.Minute
+
 type subManager struct {
 	clientMap     map[string]*consumergroup.ConsumerGroup // key is client remote addr, a client can only sub 1 topic
 	clientMapLock sync.RWMutex                            // TODO the lock is too big
+
+	offsetSyncer *offsetSyncer
 }
 
 func newSubManager() *subManager {
 	return &subManager{
-		clientMap: make(map[string]*consumergroup.ConsumerGroup, 500),
+		clientMap:    make(map[string]*consumergroup.ConsumerGroup, 500),
+		offsetSyncer: newOffsetSyncer(),
 	}
 }
 
@@ -52,6 +60,12 @@ func (this *subManager) PickConsumerGroup(cluster, topic, group, remoteAddr, rea
 		}
 	}
 
+	if e := this.offsetSyncer.sync(cluster, topic, group); e != nil {
+		// kafka-cg resumes from zk offsets, the msgs acked since they were
+		// committed to kafka might be redelivered
+		log.Warn("cg[%s] sync offsets of %s/%s: %v, resume from zk offsets", group, cluster, topic, e)
+	}
+
 	this.clientMapLock.Lock()
 	defer this.clientMapLock.Unlock()
 
@@ -103,6 +117,103 @@ func (this *subManager) PickConsumerGroup(cluster, topic, group, remoteAddr, rea
 	return
 }
 
+// offsetSyncer forwards zk offsets to kafka offsets for groups whose offset
+// storage is kafka: kafka-cg resumes from zk while acked offsets are committed
+// to kafka.
+type offsetSyncer struct {
+	mu     sync.Mutex
+	stores map[string]zk.OffsetStore // key is cluster
+	synced map[string]time.Time      // key is cluster/topic/group
+	locks  map[string]*sync.Mutex    // key is cluster/topic/group
+}
+
+func newOffsetSyncer() *offsetSyncer {
+	return &offsetSyncer{
+		stores: make(map[string]zk.OffsetStore),
+		synced: make(map[string]time.Time),
+		locks:  make(map[string]*sync.Mutex),
+	}
+}
+
+// sync is done once per offsetSyncInterval for a group of a topic, the members
+// joining within share it. A failed sync is retried after the interval too so
+// that a broken kafka never slows down the sub.
+//
+// Only the syncs of the same group of a topic are serialized.
+func (this *offsetSyncer) sync(cluster, topic, group string) error {
+	key := cluster + "/" + topic + "/" + group
+
+	this.mu.Lock()
+	lock, present := this.locks[key]
+	if !present {
+		lock = &sync.Mutex{}
+		this.locks[key] = lock
+	}
+	this.mu.Unlock()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	this.mu.Lock()
+	t, present := this.synced[key]
+	if present && time.Since(t) < offsetSyncInterval {
+		this.mu.Unlock()
+		return nil
+	}
+	this.synced[key] = time.Now()
+	this.mu.Unlock()
+
+	zkcluster := meta.Default.ZkCluster(cluster)
+	storage, err := zkcluster.OffsetStorageOfGroup(group)
+	if err != nil || storage != zk.OffsetStorageKafka {
+		return err
+	}
+
+	offsetStore := this.offsetStore(cluster, zkcluster)
+	for _, partitionId := range meta.Default.TopicPartitions(cluster, topic) {
+		// the larger of kafka and zk
+		offset, err := offsetStore.FetchOffset(group, topic, partitionId)
+		if err != nil {
+			return err
+		}
+
+		zkOffset, err := zkcluster.ConsumerOffsetOfPartition(group, topic, partitionId)
+		if err != nil {
+			return err
+		}
+
+		if offset > zkOffset {
+			if err = zkcluster.CommitConsumerOffset(group, topic, partitionId, offset); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (this *offsetSyncer) offsetStore(cluster string, zkcluster *zk.ZkCluster) zk.OffsetStore {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	offsetStore, present := this.stores[cluster]
+	if !present {
+		offsetStore = zkcluster.NewOffsetStore()
+		this.stores[cluster] = offsetStore
+	}
+	return offsetStore
+}
+
+func (this *offsetSyncer) close() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for cluster, offsetStore := range this.stores {
+		offsetStore.Close()
+		delete(this.stores, cluster)
+	}
+}
+
 // For a given consumer client, it might be killed twice:
 // 1. on socket level, the socket is closed
 // 2. websocket/sub handler, conn closed or error occurs, explicitly kill the client
@@ -142,5 +253,6 @@ func (this *subManager) Stop() {
 	}
 
 	wg.Wait()
+	this.offsetSyncer.close()
 	log.Trace("all consumer offsets committed")
 }
@@ -17,7 +17,22 @@ type PubStore interface {
 	// AsyncPub pub a keyed message to a topic of a cluster asynchronously.
 	AsyncPub(cluster, topic string, key, msg []byte) (partition int32, offset int64, err error)
 
+	// SyncPubBatch pub keyed messages to a topic of a cluster synchronously in
+	// one round trip. err is the failure of the whole batch, else each message
+	// has its own result in the same order of msgs.
+	SyncPubBatch(cluster, topic string, keys, msgs [][]byte) (results []PubResult, err error)
+
+	// SyncAllPubBatch is SyncPubBatch that acks after all replicas got the messages.
+	SyncAllPubBatch(cluster, topic string, keys, msgs [][]byte) (results []PubResult, err error)
+
 	IsSystemError(error) bool
 }
 
+// PubResult is the result of a message in a batch pub.
+type PubResult struct {
+	Partition int32
+	Offset    int64
+	Err       error
+}
+
 var DefaultPubStore PubStore
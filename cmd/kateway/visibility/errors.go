@@ -0,0 +1,9 @@
+package visibility
+
+import (
+	"errors"
+)
+
+var (
+	ErrNotInflight = errors.New("message not inflight, might be acked or handed off")
+)
@@ -0,0 +1,275 @@
+// Package visibility tracks the messages delivered to consumer groups that
+// ack each message instead of acking the cursor.
+//
+// A delivered message is invisible to the group until its deadline, if not
+// acked by then it is redelivered. The offset of a partition can only be
+// committed up to the contiguous acked prefix.
+//
+//     offset:    10  11  12  13  14
+//     acked:      y   y   n   y   n
+//                     ^
+//                     committable
+package visibility
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Message is a snapshot of a delivered message.
+type Message struct {
+	Topic      string
+	Partition  int32
+	Offset     int64
+	Key, Value []byte
+	Deliveries int // how many times it has been delivered
+}
+
+func (this Message) ConsumerMessage() *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{
+		Topic:     this.Topic,
+		Partition: this.Partition,
+		Offset:    this.Offset,
+		Key:       this.Key,
+		Value:     this.Value,
+	}
+}
+
+type entry struct {
+	msg      Message
+	deadline time.Time
+	acked    bool
+}
+
+type partition struct {
+	entries   []*entry // ordered by offset, head is the lowest uncommitted
+	committed int64    // the highest committable offset ever, -1 if none
+}
+
+// find returns the index of the offset, -1 if not tracked.
+func (this *partition) find(offset int64) int {
+	i := sort.Search(len(this.entries), func(i int) bool {
+		return this.entries[i].msg.Offset >= offset
+	})
+	if i < len(this.entries) && this.entries[i].msg.Offset == offset {
+		return i
+	}
+	return -1
+}
+
+// add keeps the entries ordered and returns false if the offset is tracked
+// or already committable.
+func (this *partition) add(e *entry) bool {
+	if e.msg.Offset <= this.committed {
+		return false
+	}
+
+	n := len(this.entries)
+	if n == 0 || this.entries[n-1].msg.Offset < e.msg.Offset {
+		this.entries = append(this.entries, e)
+		return true
+	}
+
+	if this.find(e.msg.Offset) != -1 {
+		return false
+	}
+
+	i := sort.Search(n, func(i int) bool {
+		return this.entries[i].msg.Offset > e.msg.Offset
+	})
+	this.entries = append(this.entries, nil)
+	copy(this.entries[i+1:], this.entries[i:])
+	this.entries[i] = e
+	return true
+}
+
+// advance drops the acked prefix and returns the last dropped offset, -1 if
+// nothing dropped.
+func (this *partition) advance() int64 {
+	var (
+		offset int64 = -1
+		i      int
+	)
+	for i = 0; i < len(this.entries) && this.entries[i].acked; i++ {
+		offset = this.entries[i].msg.Offset
+	}
+	this.entries = this.entries[i:]
+	if offset != -1 {
+		this.committed = offset
+	}
+	return offset
+}
+
+type group struct {
+	partitions map[int32]*partition
+	inflight   int // delivered but not acked
+}
+
+// Tracker tracks the inflight messages of all groups in a kateway.
+type Tracker struct {
+	maxInflight int // of each group
+
+	mu     sync.Mutex
+	groups map[string]*group
+}
+
+func New(maxInflight int) *Tracker {
+	return &Tracker{
+		maxInflight: maxInflight,
+		groups:      make(map[string]*group),
+	}
+}
+
+// Key identifies a group of a topic.
+func Key(cluster, topic, group string) string {
+	return cluster + "/" + topic + "/" + group
+}
+
+func (this *Tracker) partition(key string, partitionId int32) (*group, *partition) {
+	g, present := this.groups[key]
+	if !present {
+		g = &group{partitions: make(map[int32]*partition)}
+		this.groups[key] = g
+	}
+
+	p, present := g.partitions[partitionId]
+	if !present {
+		p = &partition{committed: -1}
+		g.partitions[partitionId] = p
+	}
+
+	return g, p
+}
+
+// Full returns true if the group has too many inflight messages to take new ones.
+func (this *Tracker) Full(key string) bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	g, present := this.groups[key]
+	return present && g.inflight >= this.maxInflight
+}
+
+// Deliver tracks a message fetched from kafka and makes it invisible for timeout.
+// If the message is already tracked, e,g. fetched again after rebalance, it
+// is a redelivery if not acked yet, or returns false if acked.
+func (this *Tracker) Deliver(key string, msg *sarama.ConsumerMessage, timeout time.Duration) (Message, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	g, p := this.partition(key, msg.Partition)
+	e := &entry{
+		msg: Message{
+			Topic:      msg.Topic,
+			Partition:  msg.Partition,
+			Offset:     msg.Offset,
+			Key:        msg.Key,
+			Value:      msg.Value,
+			Deliveries: 1,
+		},
+		deadline: time.Now().Add(timeout),
+	}
+	if p.add(e) {
+		g.inflight++
+		return e.msg, true
+	}
+
+	i := p.find(msg.Offset)
+	if i == -1 || p.entries[i].acked {
+		// acked already
+		return e.msg, false
+	}
+
+	e = p.entries[i]
+
+	e.msg.Deliveries++
+	e.deadline = time.Now().Add(timeout)
+	return e.msg, true
+}
+
+// Skip tracks a message that needs no ack, e,g. unmatched by the sub filter,
+// so that it never blocks the committed offset. A message already delivered
+// is acked. It returns the offset committable, -1 if the committable offset
+// not advanced.
+func (this *Tracker) Skip(key string, msg *sarama.ConsumerMessage) int64 {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	g, p := this.partition(key, msg.Partition)
+	if !p.add(&entry{msg: Message{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset}, acked: true}) {
+		if i := p.find(msg.Offset); i != -1 && !p.entries[i].acked {
+			p.entries[i].acked = true
+			g.inflight--
+		}
+	}
+	return p.advance()
+}
+
+// Redeliver returns the first inflight message whose deadline passed and
+// makes it invisible for timeout again.
+func (this *Tracker) Redeliver(key string, timeout time.Duration) (Message, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	g, present := this.groups[key]
+	if !present || g.inflight == 0 {
+		return Message{}, false
+	}
+
+	now := time.Now()
+	for _, p := range g.partitions {
+		for _, e := range p.entries {
+			if e.acked || now.Before(e.deadline) {
+				continue
+			}
+
+			e.msg.Deliveries++
+			e.deadline = now.Add(timeout)
+			return e.msg, true
+		}
+	}
+
+	return Message{}, false
+}
+
+// Ack acks an inflight message and returns the offset committable of the
+// partition, -1 if the committable offset not advanced.
+func (this *Tracker) Ack(key string, partitionId int32, offset int64) (int64, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	g, present := this.groups[key]
+	if !present {
+		return -1, ErrNotInflight
+	}
+	p, present := g.partitions[partitionId]
+	if !present {
+		return -1, ErrNotInflight
+	}
+	i := p.find(offset)
+	if i == -1 {
+		return -1, ErrNotInflight
+	}
+
+	e := p.entries[i]
+	if !e.acked {
+		e.acked = true
+		g.inflight--
+	}
+
+	return p.advance(), nil
+}
+
+// Inflight returns the number of inflight messages of a group.
+func (this *Tracker) Inflight(key string) int {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if g, present := this.groups[key]; present {
+		return g.inflight
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package visibility
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/assert"
+)
+
+func consumerMessage(partition int32, offset int64) *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{Topic: "t1", Partition: partition, Offset: offset, Value: []byte("hello")}
+}
+
+func TestTrackerAckContiguousPrefix(t *testing.T) {
+	tr := New(100)
+	key := Key("c1", "t1", "g1")
+	for offset := int64(10); offset < 15; offset++ {
+		m, ok := tr.Deliver(key, consumerMessage(0, offset), time.Minute)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, 1, m.Deliveries)
+	}
+	assert.Equal(t, 5, tr.Inflight(key))
+
+	committable, err := tr.Ack(key, 0, 11)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(-1), committable) // 10 not acked yet
+
+	committable, _ = tr.Ack(key, 0, 13)
+	assert.Equal(t, int64(-1), committable)
+
+	committable, _ = tr.Ack(key, 0, 10)
+	assert.Equal(t, int64(11), committable)
+
+	committable, _ = tr.Ack(key, 0, 12)
+	assert.Equal(t, int64(13), committable)
+	assert.Equal(t, 1, tr.Inflight(key))
+
+	_, err = tr.Ack(key, 0, 12)
+	assert.Equal(t, ErrNotInflight, err)
+	_, err = tr.Ack(key, 1, 12)
+	assert.Equal(t, ErrNotInflight, err)
+}
+
+func TestTrackerRedeliver(t *testing.T) {
+	tr := New(100)
+	key := Key("c1", "t1", "g1")
+	tr.Deliver(key, consumerMessage(0, 1), time.Millisecond)
+	tr.Deliver(key, consumerMessage(0, 2), time.Hour)
+
+	_, ok := tr.Redeliver(key, time.Hour)
+	assert.Equal(t, false, ok)
+
+	time.Sleep(time.Millisecond * 5)
+	m, ok := tr.Redeliver(key, time.Hour)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, int64(1), m.Offset)
+	assert.Equal(t, 2, m.Deliveries)
+	assert.Equal(t, "hello", string(m.Value))
+
+	// invisible again
+	_, ok = tr.Redeliver(key, time.Hour)
+	assert.Equal(t, false, ok)
+
+	// fetched again after rebalance
+	m, ok = tr.Deliver(key, consumerMessage(0, 1), time.Hour)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 3, m.Deliveries)
+	assert.Equal(t, 2, tr.Inflight(key))
+
+	tr.Ack(key, 0, 1)
+	_, ok = tr.Deliver(key, consumerMessage(0, 1), time.Hour)
+	assert.Equal(t, false, ok)
+}
+
+func TestTrackerSkip(t *testing.T) {
+	tr := New(100)
+	key := Key("c1", "t1", "g1")
+	assert.Equal(t, int64(5), tr.Skip(key, consumerMessage(0, 5)))
+
+	tr.Deliver(key, consumerMessage(0, 6), time.Hour)
+	assert.Equal(t, int64(-1), tr.Skip(key, consumerMessage(0, 7)))
+	committable, _ := tr.Ack(key, 0, 6)
+	assert.Equal(t, int64(7), committable)
+}
+
+func TestTrackerDeliverThenSkip(t *testing.T) {
+	tr := New(100)
+	key := Key("c1", "t1", "g1")
+	tr.Deliver(key, consumerMessage(0, 1), time.Hour)
+	tr.Deliver(key, consumerMessage(0, 2), time.Millisecond)
+	assert.Equal(t, 2, tr.Inflight(key))
+
+	// filtered out after delivered
+	assert.Equal(t, int64(-1), tr.Skip(key, consumerMessage(0, 2)))
+	assert.Equal(t, 1, tr.Inflight(key))
+	time.Sleep(time.Millisecond * 5)
+	_, ok := tr.Redeliver(key, time.Hour)
+	assert.Equal(t, false, ok)
+
+	assert.Equal(t, int64(2), tr.Skip(key, consumerMessage(0, 1)))
+	assert.Equal(t, 0, tr.Inflight(key))
+
+	// skip again is harmless
+	assert.Equal(t, int64(-1), tr.Skip(key, consumerMessage(0, 1)))
+	assert.Equal(t, 0, tr.Inflight(key))
+}
+
+func TestTrackerFull(t *testing.T) {
+	tr := New(2)
+	key := Key("c1", "t1", "g1")
+	assert.Equal(t, false, tr.Full(key))
+	tr.Deliver(key, consumerMessage(0, 1), time.Hour)
+	tr.Deliver(key, consumerMessage(1, 1), time.Hour)
+	assert.Equal(t, true, tr.Full(key))
+	tr.Ack(key, 1, 1)
+	assert.Equal(t, false, tr.Full(key))
+}
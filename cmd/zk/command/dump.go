@@ -1,10 +1,8 @@
 package command
 
 import (
-	"encoding/binary"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"sort"
 	"strings"
@@ -24,6 +22,7 @@ type Dump struct {
 	infile  string
 	outfile string
 	outdir  string
+	tree    string
 	f       *os.File
 }
 
@@ -35,6 +34,7 @@ func (this *Dump) Run(args []string) (exitCode int) {
 	cmdFlags.StringVar(&this.infile, "in", "", "")
 	cmdFlags.StringVar(&this.path, "p", "/", "")
 	cmdFlags.StringVar(&this.outdir, "dir", "", "")
+	cmdFlags.StringVar(&this.tree, "tree", "", "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -50,6 +50,18 @@ func (this *Dump) Run(args []string) (exitCode int) {
 		return
 	}
 
+	if this.tree != "" {
+		// tree mode
+		must(os.MkdirAll(this.tree, 0755))
+
+		zkzone := gzk.NewZkZone(gzk.DefaultConfig(this.zone, ctx.ZoneZkAddrs(this.zone)))
+		defer zkzone.Close()
+
+		this.dumpTree(zkzone.Conn(), this.path)
+		this.Ui.Info(fmt.Sprintf("dumpped to %s", this.tree))
+		return
+	}
+
 	// dump mode
 	this.outfile = this.zone + "." + this.outfile
 
@@ -98,40 +110,13 @@ func (this *Dump) Run(args []string) (exitCode int) {
 func (this *Dump) diplayDumppedFile() {
 	f, err := os.Open(this.infile)
 	must(err)
+	defer f.Close()
 
-	for {
-		// read line, got the znode path
-		var buf [1]byte
-		zpath := make([]byte, 0, 8<<10)
-		for {
-			b := buf[:]
-			_, err := f.Read(b)
-			if err == io.EOF {
-				return
-			}
-			must(err)
-
-			if b[0] == '\n' {
-				break
-			}
-			zpath = append(zpath, b[0])
-		}
-
-		this.Ui.Info(string(zpath))
-
-		// read the znode data
-		// 1. data len
-		// 2. data itself
-		var dataLen int32
-		err = binary.Read(f, binary.BigEndian, &dataLen)
-		must(err)
-
-		zdata := make([]byte, dataLen)
-		_, err = io.ReadFull(f, zdata)
-		must(err)
-
-		this.Ui.Output(string(zdata))
-	}
+	must(readDumpFile(f, func(rec znodeRecord) error {
+		this.Ui.Info(rec.Path)
+		this.Ui.Output(string(rec.Data))
+		return nil
+	}))
 }
 
 func (this *Dump) dump(conn *zk.Conn, path string) {
@@ -142,7 +127,6 @@ func (this *Dump) dump(conn *zk.Conn, path string) {
 	}
 
 	sort.Strings(children)
-	var buf [4]byte
 	for _, child := range children {
 		if path == "/" {
 			path = ""
@@ -158,20 +142,39 @@ func (this *Dump) dump(conn *zk.Conn, path string) {
 			continue
 		}
 
-		_, err = this.f.Write([]byte(znode))
+		must(writeDumpFile(this.f, znodeRecord{Path: znode, Data: data}))
+
+		this.dump(conn, znode)
+	}
+}
+
+// dumpTree dumps the znode and all its descendants including ephemeral ones
+// with their ACL.
+func (this *Dump) dumpTree(conn znodeReader, path string) {
+	if path != "/" {
+		data, stat, err := conn.Get(path)
 		must(err)
-		_, err = this.f.Write([]byte{'\n'})
+		acl, _, err := conn.GetACL(path)
 		must(err)
-		v := buf[0:4]
-		binary.BigEndian.PutUint32(v, uint32(len(data)))
-		_, err = this.f.Write(v)
 
-		if len(data) > 0 {
-			_, err = this.f.Write(data)
-			must(err)
+		must(writeDumpTree(this.tree, znodeRecord{
+			Path:      path,
+			Data:      data,
+			Acl:       acl,
+			Ephemeral: stat.EphemeralOwner > 0,
+		}))
+	}
+
+	children, _, err := conn.Children(path)
+	must(err)
+
+	sort.Strings(children)
+	for _, child := range children {
+		if path == "/" {
+			path = ""
 		}
 
-		this.dump(conn, znode)
+		this.dumpTree(conn, fmt.Sprintf("%s/%s", path, child))
 	}
 }
 
@@ -198,6 +201,10 @@ Options:
       Run daily dump to this directoy. 
       zk will automatically rotate target dumps output.
 
+    -tree dir
+      Dump znodes with ACL as a directory tree instead of the binary file.
+      Ephemeral znodes are included and flagged.
+
     -in dumpped input filename
       Display dumpped file contents in text format.
 
@@ -0,0 +1,160 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gocli"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// memZk is an in memory znode tree.
+type memZk map[string]znodeRecord
+
+func (this memZk) Children(zpath string) ([]string, *zk.Stat, error) {
+	if _, present := this[zpath]; !present && zpath != "/" {
+		return nil, nil, zk.ErrNoNode
+	}
+
+	var children []string
+	for p := range this {
+		if p != zpath && path.Dir(p) == zpath {
+			children = append(children, path.Base(p))
+		}
+	}
+	return children, &zk.Stat{}, nil
+}
+
+func (this memZk) Get(zpath string) ([]byte, *zk.Stat, error) {
+	rec, present := this[zpath]
+	if !present {
+		return nil, nil, zk.ErrNoNode
+	}
+
+	stat := &zk.Stat{}
+	if rec.Ephemeral {
+		stat.EphemeralOwner = 1
+	}
+	return rec.Data, stat, nil
+}
+
+func (this memZk) GetACL(zpath string) ([]zk.ACL, *zk.Stat, error) {
+	rec, present := this[zpath]
+	if !present {
+		return nil, nil, zk.ErrNoNode
+	}
+
+	return rec.Acl, &zk.Stat{}, nil
+}
+
+func newMemZk(recs ...znodeRecord) memZk {
+	z := make(memZk)
+	for _, rec := range recs {
+		if rec.Acl == nil {
+			rec.Acl = zk.WorldACL(zk.PermAll)
+		}
+		z[rec.Path] = rec
+	}
+	return z
+}
+
+func discardUi() cli.Ui {
+	return &cli.BasicUi{Writer: ioutil.Discard, ErrorWriter: ioutil.Discard}
+}
+
+func TestDumpTreeImportRoundTrip(t *testing.T) {
+	src := newMemZk(
+		znodeRecord{Path: "/kafka", Data: []byte("root")},
+		znodeRecord{Path: "/kafka/brokers", Data: []byte("")},
+		znodeRecord{Path: "/kafka/brokers/ids", Data: []byte("1,2")},
+		znodeRecord{Path: "/kafka/brokers/ids/1", Data: []byte("host1"), Ephemeral: true},
+		znodeRecord{Path: "/kafka/config", Data: []byte("cfg"), Acl: zk.DigestACL(zk.PermRead, "gafka", "pass")},
+		znodeRecord{Path: "/other", Data: []byte("x")},
+	)
+
+	dir, err := ioutil.TempDir("", "zkdump")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	dump := &Dump{Ui: discardUi(), tree: dir}
+	dump.dumpTree(src, "/kafka")
+
+	imp := &Import{Ui: discardUi(), path: "/", tree: dir, policy: importPolicySkip, withAcl: true}
+	records := imp.loadRecords()
+	var paths []string
+	for _, rec := range records {
+		paths = append(paths, rec.Path)
+	}
+	// the start path itself is dumpped, parents ahead of children
+	assert.Equal(t, "/kafka,/kafka/brokers,/kafka/brokers/ids,/kafka/brokers/ids/1,/kafka/config",
+		strings.Join(paths, ","))
+
+	ops := imp.plan(newMemZk(), records)
+	assert.Equal(t, 4, len(ops)) // ephemeral excluded
+	for _, op := range ops {
+		assert.Equal(t, true, op.create)
+		assert.Equal(t, src[op.path].Data, op.data)
+		assert.Equal(t, src[op.path].Acl, op.acl)
+	}
+
+	// imported into another root
+	imp.path = "/clone"
+	ops = imp.plan(newMemZk(), records)
+	assert.Equal(t, "/clone/kafka", ops[0].path)
+	assert.Equal(t, []byte("root"), ops[0].data)
+
+	// nothing to do against the source itself
+	imp.path = "/"
+	assert.Equal(t, 0, len(imp.plan(src, records)))
+
+	// changed content is skipped or overwritten
+	dst := newMemZk(znodeRecord{Path: "/kafka", Data: []byte("changed")})
+	assert.Equal(t, 3, len(imp.plan(dst, records)))
+	imp.policy = importPolicyOverwrite
+	ops = imp.plan(dst, records)
+	assert.Equal(t, 4, len(ops))
+	assert.Equal(t, false, ops[0].create)
+	assert.Equal(t, true, ops[0].setData)
+}
+
+func TestDumpFileRoundTrip(t *testing.T) {
+	src := newMemZk(
+		znodeRecord{Path: "/a", Data: []byte("1")},
+		znodeRecord{Path: "/a/b", Data: nil},
+		znodeRecord{Path: "/a/b/c", Data: []byte("line1\nline2")},
+	)
+
+	var (
+		buf   bytes.Buffer
+		paths []string
+	)
+	for p := range src {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		assert.Equal(t, nil, writeDumpFile(&buf, src[p]))
+	}
+
+	imp := &Import{Ui: discardUi(), path: "/", policy: importPolicySkip}
+	var records []znodeRecord
+	assert.Equal(t, nil, readDumpFile(&buf, func(rec znodeRecord) error {
+		records = append(records, rec)
+		return nil
+	}))
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, "/a/b/c", records[2].Path)
+	assert.Equal(t, "line1\nline2", string(records[2].Data))
+
+	// binary dump has no acl
+	ops := imp.plan(newMemZk(), records)
+	assert.Equal(t, 3, len(ops))
+	assert.Equal(t, zk.WorldACL(zk.PermAll), ops[0].acl)
+	assert.Equal(t, 0, len(imp.plan(src, records)))
+}
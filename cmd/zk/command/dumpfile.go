@@ -0,0 +1,131 @@
+package command
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	treeDataFile = ".zdata"
+	treeMetaFile = ".zmeta"
+)
+
+// znodeRecord is a znode persisted by dump.
+type znodeRecord struct {
+	Path string   `json:"-"`
+	Data []byte   `json:"-"`
+	Acl  []zk.ACL `json:"acl,omitempty"` // nil means unknown
+
+	Ephemeral bool `json:"ephemeral,omitempty"`
+}
+
+// znodeReader is the read side of zk conn that dump and import need.
+type znodeReader interface {
+	Children(path string) ([]string, *zk.Stat, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	GetACL(path string) ([]zk.ACL, *zk.Stat, error)
+}
+
+// writeDumpFile appends a znode to the binary dump file.
+func writeDumpFile(w io.Writer, rec znodeRecord) error {
+	if _, err := io.WriteString(w, rec.Path+"\n"); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.BigEndian, int32(len(rec.Data))); err != nil {
+		return err
+	}
+
+	_, err := w.Write(rec.Data)
+	return err
+}
+
+// readDumpFile reads the binary dump file, where each znode is the path
+// line followed by the int32 data length and the data.
+func readDumpFile(r io.Reader, fn func(znodeRecord) error) error {
+	br := bufio.NewReader(r)
+	for {
+		zpath, err := br.ReadString('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var dataLen int32
+		if err = binary.Read(br, binary.BigEndian, &dataLen); err != nil {
+			return err
+		}
+
+		zdata := make([]byte, dataLen)
+		if _, err = io.ReadFull(br, zdata); err != nil {
+			return err
+		}
+
+		if err = fn(znodeRecord{Path: strings.TrimSuffix(zpath, "\n"), Data: zdata}); err != nil {
+			return err
+		}
+	}
+}
+
+// writeDumpTree persists a znode as a directory under root, with the data
+// and the meta(acl, ephemeral) kept in hidden files.
+func writeDumpTree(root string, rec znodeRecord) error {
+	dir := filepath.Join(root, filepath.FromSlash(rec.Path))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, treeDataFile), rec.Data, 0644); err != nil {
+		return err
+	}
+
+	meta, _ := json.Marshal(rec)
+	return ioutil.WriteFile(filepath.Join(dir, treeMetaFile), meta, 0644)
+}
+
+// readDumpTree walks the directory tree written by writeDumpTree, parent
+// znodes are visited before their children.
+func readDumpTree(root string, fn func(znodeRecord) error) error {
+	root = filepath.Clean(root)
+	return filepath.Walk(root, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || fpath == root {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, fpath)
+		if err != nil {
+			return err
+		}
+
+		rec := znodeRecord{Path: path.Join("/", filepath.ToSlash(rel))}
+		rec.Data, err = ioutil.ReadFile(filepath.Join(fpath, treeDataFile))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		meta, err := ioutil.ReadFile(filepath.Join(fpath, treeMetaFile))
+		if err == nil {
+			if err = json.Unmarshal(meta, &rec); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		return fn(rec)
+	})
+}
@@ -1,20 +1,48 @@
 package command
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"os"
+	"path"
+	"reflect"
 	"strings"
 
 	"github.com/funkygao/gafka/ctx"
+	gzk "github.com/funkygao/gafka/zk"
 	"github.com/funkygao/gocli"
+	"github.com/funkygao/golib/color"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	importPolicySkip      = "skip"
+	importPolicyOverwrite = "overwrite"
 )
 
 type Import struct {
 	Ui  cli.Ui
 	Cmd string
 
-	zone string
-	path string
+	zone    string
+	path    string
+	infile  string
+	tree    string
+	policy  string
+	dryRun  bool
+	withAcl bool
+	batch   int
+}
+
+// importOp is a planned change of a znode.
+type importOp struct {
+	path    string
+	create  bool
+	setData bool
+	setAcl  bool
+	data    []byte
+	acl     []zk.ACL
 }
 
 func (this *Import) Run(args []string) (exitCode int) {
@@ -22,6 +50,12 @@ func (this *Import) Run(args []string) (exitCode int) {
 	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
 	cmdFlags.StringVar(&this.zone, "z", ctx.ZkDefaultZone(), "")
 	cmdFlags.StringVar(&this.path, "p", "", "")
+	cmdFlags.StringVar(&this.infile, "in", "", "")
+	cmdFlags.StringVar(&this.tree, "tree", "", "")
+	cmdFlags.StringVar(&this.policy, "policy", importPolicySkip, "")
+	cmdFlags.BoolVar(&this.dryRun, "dryrun", false, "")
+	cmdFlags.BoolVar(&this.withAcl, "acl", true, "")
+	cmdFlags.IntVar(&this.batch, "batch", 100, "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -38,22 +72,220 @@ func (this *Import) Run(args []string) (exitCode int) {
 		return 2
 	}
 
+	if (this.infile == "") == (this.tree == "") {
+		this.Ui.Error("either -in or -tree required")
+		return 2
+	}
+
+	if this.policy != importPolicySkip && this.policy != importPolicyOverwrite {
+		this.Ui.Error(fmt.Sprintf("invalid policy: %s", this.policy))
+		return 2
+	}
+
+	records := this.loadRecords()
+
+	zkzone := gzk.NewZkZone(gzk.DefaultConfig(this.zone, ctx.ZoneZkAddrs(this.zone)))
+	defer zkzone.Close()
+	conn := zkzone.Conn()
+
+	ops := this.plan(conn, records)
+	if this.dryRun || len(ops) == 0 {
+		return
+	}
+
+	this.ensureParents(conn)
+	this.apply(conn, ops)
+
 	return
 }
 
+func (this *Import) loadRecords() []znodeRecord {
+	var records []znodeRecord
+	collect := func(rec znodeRecord) error {
+		records = append(records, rec)
+		return nil
+	}
+
+	if this.tree != "" {
+		must(readDumpTree(this.tree, collect))
+		return records
+	}
+
+	f, err := os.Open(this.infile)
+	must(err)
+	defer f.Close()
+
+	must(readDumpFile(f, collect))
+	return records
+}
+
+// target maps the dumpped znode path under the import root path.
+func (this *Import) target(zpath string) string {
+	return path.Join(this.path, zpath)
+}
+
+// plan diffs the records against live data and prints the diff.
+func (this *Import) plan(conn znodeReader, records []znodeRecord) []importOp {
+	var (
+		ops                             []importOp
+		creates, updates, skips, ephems int
+	)
+	for _, rec := range records {
+		if rec.Ephemeral {
+			// ephemeral znode would vanish with the importing session
+			ephems++
+			continue
+		}
+
+		op := importOp{path: this.target(rec.Path), data: rec.Data, acl: rec.Acl}
+		if !this.withAcl || op.acl == nil {
+			op.acl = zk.WorldACL(zk.PermAll)
+		}
+
+		data, _, err := conn.Get(op.path)
+		if err == zk.ErrNoNode {
+			op.create = true
+			creates++
+			ops = append(ops, op)
+			this.Ui.Output(color.Green("+ %s", op.path))
+			continue
+		}
+		must(err)
+
+		op.setData = !bytes.Equal(data, rec.Data)
+		if this.withAcl && rec.Acl != nil {
+			acl, _, err := conn.GetACL(op.path)
+			must(err)
+			op.setAcl = !reflect.DeepEqual(acl, rec.Acl)
+		}
+		if !op.setData && !op.setAcl {
+			continue
+		}
+
+		if this.policy == importPolicySkip {
+			skips++
+			this.Ui.Output(color.Yellow("! %s exists, skipped", op.path))
+			continue
+		}
+
+		updates++
+		ops = append(ops, op)
+		if op.setData {
+			this.Ui.Output(color.Cyan("~ %s", op.path))
+			this.Ui.Output(fmt.Sprintf("  - %s", string(data)))
+			this.Ui.Output(fmt.Sprintf("  + %s", string(rec.Data)))
+		}
+		if op.setAcl {
+			this.Ui.Output(color.Cyan("~ %s acl %+v", op.path, rec.Acl))
+		}
+	}
+
+	this.Ui.Info(fmt.Sprintf("%d znodes: %d create, %d update, %d skipped, %d ephemeral excluded",
+		len(records), creates, updates, skips, ephems))
+	return ops
+}
+
+// ensureParents creates the missing ancestors of the import root path.
+func (this *Import) ensureParents(conn *zk.Conn) {
+	zpath := ""
+	for _, p := range strings.Split(strings.Trim(this.path, "/"), "/") {
+		if p == "" {
+			continue
+		}
+
+		zpath += "/" + p
+		_, err := conn.Create(zpath, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			must(err)
+		}
+	}
+}
+
+// apply commits the ops in transactional batches via zk multi.
+// ACL changes are not supported by multi and are applied afterwards.
+func (this *Import) apply(conn *zk.Conn, ops []importOp) {
+	batchSize := this.batch
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	committed := 0
+	for i := 0; i < len(ops); i += batchSize {
+		end := i + batchSize
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		var reqs []interface{}
+		for _, op := range ops[i:end] {
+			switch {
+			case op.create:
+				reqs = append(reqs, &zk.CreateRequest{Path: op.path, Data: op.data, Acl: op.acl})
+			case op.setData:
+				reqs = append(reqs, &zk.SetDataRequest{Path: op.path, Data: op.data, Version: -1})
+			}
+		}
+
+		if len(reqs) > 0 {
+			if _, err := conn.Multi(reqs...); err != nil {
+				this.Ui.Error(fmt.Sprintf("batch %s..%s: %v, %d ops committed before",
+					ops[i].path, ops[end-1].path, err, committed))
+				os.Exit(1)
+			}
+		}
+		committed += end - i
+	}
+
+	for _, op := range ops {
+		if op.setAcl {
+			_, err := conn.SetACL(op.path, op.acl, -1)
+			must(err)
+		}
+	}
+
+	this.Ui.Info(fmt.Sprintf("%d ops committed", committed))
+}
+
 func (*Import) Synopsis() string {
-	return "Load directories and contents to Zookeeper TODO"
+	return "Load dumpped directories and contents to Zookeeper"
 }
 
 func (this *Import) Help() string {
 	help := fmt.Sprintf(`
-Usage: %s import [options]
+Usage: %s import -z zone -p path [options]
+
+    %s
 
-    Load directories and contents to Zookeeper
+    The inverse of 'zk dump': restores a dump file or dump tree under path.
+    e,g. clone the kafka metadata of zone prod to zone test
+    zk dump -z prod -p /kafka -tree prod.kafka
+    zk import -z test -p / -tree prod.kafka -dryrun
 
 Options:
 
+    -p path
+      Import root path, the dumpped znode paths are joined under it.
+
+    -in dumpped file
+      Binary file created by 'zk dump -o'.
+
+    -tree dir
+      Directory tree created by 'zk dump -tree'.
+
+    -policy skip|overwrite
+      How to handle znodes that already exist with different content.
+      Defaults skip.
+
+    -dryrun
+      Diff against live data without any change.
+
+    -acl
+      Restore ACL from dump tree, defaults true.
+      Binary dump file has no ACL, world:anyone is used.
+
+    -batch n
+      Ops per zk multi transaction. Defaults 100.
 
-`, this.Cmd)
+`, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
 }
@@ -65,6 +65,13 @@ func init() {
 			}, nil
 		},
 
+		"import": func() (cli.Command, error) {
+			return &command.Import{
+				Ui:  ui,
+				Cmd: cmd,
+			}, nil
+		},
+
 		"create": func() (cli.Command, error) {
 			return &command.Create{
 				Ui:  ui,
@@ -5,65 +5,126 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/funkygao/gafka/ctx"
+	"github.com/funkygao/gafka/misc/canal/command/cdc"
+	"github.com/funkygao/gafka/zk"
 	"github.com/funkygao/gocli"
-	"github.com/siddontang/go-mysql/mysql"
-	"github.com/siddontang/go-mysql/replication"
-	"golang.org/x/net/context"
+	"github.com/funkygao/golib/signal"
+	log "github.com/funkygao/log4go"
 )
 
 type Binlog struct {
 	Ui  cli.Ui
 	Cmd string
+
+	zone     string
+	confFile string
 }
 
 func (this *Binlog) Run(args []string) (exitCode int) {
 	cmdFlags := flag.NewFlagSet("binlog", flag.ContinueOnError)
 	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
+	cmdFlags.StringVar(&this.zone, "z", ctx.ZkDefaultZone(), "")
+	cmdFlags.StringVar(&this.confFile, "conf", "", "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
-	cfg := &replication.BinlogSyncerConfig{
-		ServerID: 100,
-		Flavor:   "mysql",
-		Host:     "127.0.0.1",
-		Port:     3306,
-		User:     "root",
-		Password: "",
+	if this.confFile == "" {
+		this.Ui.Error("-conf required")
+		this.Ui.Output(this.Help())
+		return 2
+	}
+
+	cf, err := cdc.LoadConfig(this.confFile)
+	if err != nil {
+		this.Ui.Error(err.Error())
+		return 1
 	}
-	syncer := replication.NewBinlogSyncer(cfg)
-	binlogFile := "mysql-bin.000080"
-	binlogPos := uint32(1)
-	stream, err := syncer.StartSync(mysql.Position{binlogFile, binlogPos})
+
+	pub, err := cdc.NewPublisher(cf)
 	if err != nil {
-		panic(err)
+		this.Ui.Error(err.Error())
+		return 1
 	}
+	defer pub.Close()
+
+	// checkpoints are saved in zk of the zone
+	zkzone := zk.NewZkZone(zk.DefaultConfig(this.zone, ctx.ZoneZkAddrs(this.zone)))
+	defer zkzone.Close()
+
+	quit := make(chan struct{})
+	signal.RegisterHandler(func(sig os.Signal) {
+		log.Info("received signal: %s", strings.ToUpper(sig.String()))
+		close(quit)
+	}, syscall.SIGINT, syscall.SIGTERM)
+
+	syncer := cdc.NewSyncer(cf, zkzone, pub)
 	for {
-		evt, err := stream.GetEvent(context.Background())
-		if err != nil {
-			panic(err)
+		err = syncer.Run(quit)
+		select {
+		case <-quit:
+			log.Info("binlog syncer stopped")
+			return
+
+		default:
 		}
 
-		evt.Dump(os.Stdout)
+		// e,g. master restarted, resume from checkpoint
+		log.Error("%v, restart after 5s", err)
+		select {
+		case <-quit:
+			return
+		case <-time.After(time.Second * 5):
+		}
 	}
-
-	return
 }
 
 func (*Binlog) Synopsis() string {
-	return "Sync binlog from MySQL master to elsewhere"
+	return "Sync binlog row changes from MySQL master to kateway topics"
 }
 
 func (this *Binlog) Help() string {
 	help := fmt.Sprintf(`
 Usage: %s binlog [options]
 
-    Sync binlog from MySQL master to elsewhere
+    %s
+
+    Works as a MySQL slave: each changed row of the configured tables is
+    published as a JSON envelope with before/after image, schema, table and
+    GTID, keyed by the primary key. The binlog position is checkpointed in zk
+    so that the syncer resumes after restart.
+
+    MySQL master requires binlog_format=ROW.
 
 Options:
 
+    -z zone
+      Where the checkpoint is saved.
+
+    -conf config file
+      e,g.
+      {
+          "name": "shop",
+          "server_id": 1001,
+          "source": {"addr": "127.0.0.1:3306", "user": "repl", "password": ""},
+          "output": "kateway",
+          "kateway": {"pub": "pub.kateway:9191", "appid": "app1", "secret": "xxx"},
+          "tables": [
+              {"match": "shop.orders_*", "appid": "app1", "topic": "orders", "ver": "v1"}
+          ],
+          "exclude": ["shop.orders_tmp"],
+          "checkpoint_interval": "5s"
+      }
+      output: kateway|kafka, for kafka output:
+      "kafka": {"zone": "prod", "cluster": "trade"}
+      and the kafka topic of a route defaults appid.topic.ver unless "kafka_topic" is set.
+      source.file and source.pos set the start position if no checkpoint, defaults
+      current master position.
 
-`, this.Cmd)
+`, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
 }
@@ -0,0 +1,70 @@
+package cdc
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestParseConfig(t *testing.T) {
+	cf, err := parseConfig([]byte(`{
+"name": "shop",
+"server_id": 1001,
+"source": {"addr": "127.0.0.1:3306", "user": "repl"},
+"kateway": {"pub": "localhost:9191", "appid": "app1", "secret": "s"},
+"tables": [
+{"match": "shop.orders_*", "appid": "app1", "topic": "orders", "ver": "v1"},
+{"match": "shop.*", "appid": "app1", "topic": "shop", "ver": "v2"}
+],
+"exclude": ["shop.orders_tmp"]
+}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "mysql", cf.Source.Flavor)
+	assert.Equal(t, OutputKateway, cf.Output)
+	assert.Equal(t, "app1.orders.v1", cf.Tables[0].KafkaTopic)
+
+	assert.Equal(t, "orders", cf.Route("shop", "orders_2017").Topic)
+	assert.Equal(t, "shop", cf.Route("shop", "users").Topic)
+	assert.Equal(t, true, cf.Route("shop", "orders_tmp") == nil)
+	assert.Equal(t, true, cf.Route("bbs", "users") == nil)
+
+	// pub to topic of another appid
+	_, err = parseConfig([]byte(`{"name": "shop", "server_id": 1, "source": {"addr": "a:1"},
+"kateway": {"pub": "localhost:9191", "appid": "app2"},
+"tables": [{"match": "shop.*", "appid": "app1", "topic": "shop", "ver": "v1"}]}`))
+	assert.NotEqual(t, nil, err)
+}
+
+func TestBuildEnvelopes(t *testing.T) {
+	info := &TableInfo{Columns: []string{"id", "name"}, PK: []int{0}}
+	pos := Position{GTID: "g:1", File: "mysql-bin.000001", Pos: 120, Ts: 100}
+
+	es, err := BuildEnvelopes(ActionUpdate, "shop", "users", info, [][]interface{}{
+		{int32(1), []byte("a")},
+		{int32(1), []byte("b")},
+	}, pos)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(es))
+	assert.Equal(t, "1", es[0].Key())
+	assert.Equal(t, `{"schema":"shop","table":"users","action":"update","pk":["id"],"gtid":"g:1","binlog":"mysql-bin.000001:120","ts":100,"before":{"id":1,"name":"a"},"after":{"id":1,"name":"b"}}`,
+		string(es[0].Bytes()))
+
+	es, err = BuildEnvelopes(ActionDelete, "shop", "users", info, [][]interface{}{
+		{int32(1), "a"},
+		{int32(2), "b"},
+	}, pos)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(es))
+	assert.Equal(t, "2", es[1].Key())
+	assert.Equal(t, true, es[1].After == nil)
+
+	_, err = BuildEnvelopes(ActionUpdate, "shop", "users", info, [][]interface{}{{int32(1), "a"}}, pos)
+	assert.NotEqual(t, nil, err)
+	_, err = BuildEnvelopes(ActionInsert, "shop", "users", info, [][]interface{}{{int32(1)}}, pos)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestFormatGTID(t *testing.T) {
+	sid := []byte{0x3e, 0x11, 0xfa, 0x47, 0x71, 0xca, 0x11, 0xe1, 0x9e, 0x33, 0xc8, 0x0a, 0xa9, 0x42, 0x95, 0x62}
+	assert.Equal(t, "3e11fa47-71ca-11e1-9e33-c80aa9429562:23", FormatGTID(sid, 23))
+}
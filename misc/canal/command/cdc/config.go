@@ -0,0 +1,179 @@
+package cdc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"time"
+)
+
+const (
+	OutputKateway = "kateway"
+	OutputKafka   = "kafka"
+)
+
+// Config of the binlog syncer, e.g.
+// {
+// "name": "shop",
+// "server_id": 1001,
+// "source": {"addr": "127.0.0.1:3306", "user": "repl", "password": ""},
+// "output": "kateway",
+// "kateway": {"pub": "pub.kateway:9191", "appid": "app1", "secret": "xxx"},
+// "tables": [{"match": "shop.orders_*", "appid": "app1", "topic": "orders", "ver": "v1"}],
+// "exclude": ["shop.orders_tmp"]
+// }
+type Config struct {
+	// Name identifies the syncer and its checkpoint in zk.
+	Name     string `json:"name"`
+	ServerId uint32 `json:"server_id"`
+
+	Source struct {
+		Addr     string `json:"addr"`
+		User     string `json:"user"`
+		Password string `json:"password"`
+		Flavor   string `json:"flavor"`
+
+		// Initial position when there is no checkpoint, defaults to the
+		// current master position.
+		File string `json:"file"`
+		Pos  uint32 `json:"pos"`
+	} `json:"source"`
+
+	Output string `json:"output"`
+
+	Kateway struct {
+		Pub    string `json:"pub"`
+		AppId  string `json:"appid"`
+		Secret string `json:"secret"`
+	} `json:"kateway"`
+
+	Kafka struct {
+		Zone    string `json:"zone"`
+		Cluster string `json:"cluster"`
+	} `json:"kafka"`
+
+	Tables  []Route  `json:"tables"`
+	Exclude []string `json:"exclude"`
+
+	CheckpointInterval string `json:"checkpoint_interval"`
+	checkpointInterval time.Duration
+}
+
+// Route maps tables matching the schema.table glob pattern to a kateway topic.
+type Route struct {
+	Match string `json:"match"`
+
+	AppId string `json:"appid"`
+	Topic string `json:"topic"`
+	Ver   string `json:"ver"`
+
+	// KafkaTopic is only used by kafka output, defaults appid.topic.ver
+	KafkaTopic string `json:"kafka_topic"`
+}
+
+func LoadConfig(fn string) (*Config, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseConfig(b)
+}
+
+func parseConfig(b []byte) (*Config, error) {
+	cf := &Config{}
+	if err := json.Unmarshal(b, cf); err != nil {
+		return nil, err
+	}
+
+	if cf.Source.Flavor == "" {
+		cf.Source.Flavor = "mysql"
+	}
+	if cf.Output == "" {
+		cf.Output = OutputKateway
+	}
+	if cf.CheckpointInterval == "" {
+		cf.CheckpointInterval = "5s"
+	}
+
+	var err error
+	if cf.checkpointInterval, err = time.ParseDuration(cf.CheckpointInterval); err != nil {
+		return nil, err
+	}
+
+	for i := range cf.Tables {
+		r := &cf.Tables[i]
+		if r.KafkaTopic == "" {
+			r.KafkaTopic = fmt.Sprintf("%s.%s.%s", r.AppId, r.Topic, r.Ver)
+		}
+	}
+
+	return cf, cf.validate()
+}
+
+func (this *Config) validate() error {
+	switch {
+	case this.Name == "":
+		return errors.New("empty name")
+
+	case this.ServerId == 0:
+		return errors.New("server_id must be unique among the master's slaves")
+
+	case this.Source.Addr == "":
+		return errors.New("empty source addr")
+
+	case len(this.Tables) == 0:
+		return errors.New("empty tables")
+	}
+
+	switch this.Output {
+	case OutputKateway:
+		if this.Kateway.Pub == "" {
+			return errors.New("empty kateway pub")
+		}
+
+	case OutputKafka:
+		if this.Kafka.Zone == "" || this.Kafka.Cluster == "" {
+			return errors.New("empty kafka zone or cluster")
+		}
+
+	default:
+		return fmt.Errorf("invalid output: %s", this.Output)
+	}
+
+	for _, r := range this.Tables {
+		if _, err := path.Match(r.Match, ""); err != nil {
+			return fmt.Errorf("%s: %v", r.Match, err)
+		}
+		if r.AppId == "" || r.Topic == "" || r.Ver == "" {
+			return fmt.Errorf("%s: empty appid/topic/ver", r.Match)
+		}
+		if this.Output == OutputKateway && r.AppId != this.Kateway.AppId {
+			// kateway only allows pub to topics of the client appid
+			return fmt.Errorf("%s: appid %s not owned by %s", r.Match, r.AppId, this.Kateway.AppId)
+		}
+	}
+
+	return nil
+}
+
+// Route returns the first route the table matches, nil if the table is
+// excluded or not configured.
+func (this *Config) Route(schema, table string) *Route {
+	name := schema + "." + table
+	for _, pattern := range this.Exclude {
+		if matched, _ := path.Match(pattern, name); matched {
+			return nil
+		}
+	}
+
+	for i, r := range this.Tables {
+		if matched, _ := path.Match(r.Match, name); matched {
+			return &this.Tables[i]
+		}
+	}
+
+	return nil
+}
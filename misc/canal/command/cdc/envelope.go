@@ -0,0 +1,141 @@
+package cdc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	ActionInsert = "insert"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Envelope is the JSON message published for each changed row.
+type Envelope struct {
+	Schema string   `json:"schema"`
+	Table  string   `json:"table"`
+	Action string   `json:"action"`
+	PK     []string `json:"pk,omitempty"`
+
+	GTID   string `json:"gtid,omitempty"`
+	Binlog string `json:"binlog"` // file:pos of the rows event
+	Ts     uint32 `json:"ts"`     // event timestamp in seconds
+
+	Before map[string]interface{} `json:"before,omitempty"`
+	After  map[string]interface{} `json:"after,omitempty"`
+}
+
+// TableInfo is the column layout of a table needed to decode its rows.
+type TableInfo struct {
+	Columns []string
+	PK      []int // index of primary key columns
+}
+
+// Position locates a rows event in the binlog.
+type Position struct {
+	GTID string
+	File string
+	Pos  uint32
+	Ts   uint32
+}
+
+// BuildEnvelopes converts the rows of a rows event into envelopes.
+// Rows of an update event come in pairs of before and after image.
+func BuildEnvelopes(action, schema, table string, info *TableInfo,
+	rows [][]interface{}, pos Position) ([]*Envelope, error) {
+	step := 1
+	if action == ActionUpdate {
+		step = 2
+		if len(rows)%2 != 0 {
+			return nil, fmt.Errorf("%s.%s: odd update rows %d", schema, table, len(rows))
+		}
+	}
+
+	pk := make([]string, 0, len(info.PK))
+	for _, idx := range info.PK {
+		pk = append(pk, info.Columns[idx])
+	}
+
+	r := make([]*Envelope, 0, len(rows)/step)
+	for i := 0; i < len(rows); i += step {
+		e := &Envelope{
+			Schema: schema,
+			Table:  table,
+			Action: action,
+			PK:     pk,
+			GTID:   pos.GTID,
+			Binlog: fmt.Sprintf("%s:%d", pos.File, pos.Pos),
+			Ts:     pos.Ts,
+		}
+
+		var err error
+		switch action {
+		case ActionInsert:
+			e.After, err = rowImage(info, rows[i])
+
+		case ActionDelete:
+			e.Before, err = rowImage(info, rows[i])
+
+		case ActionUpdate:
+			if e.Before, err = rowImage(info, rows[i]); err == nil {
+				e.After, err = rowImage(info, rows[i+1])
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", schema, table, err)
+		}
+
+		r = append(r, e)
+	}
+
+	return r, nil
+}
+
+func rowImage(info *TableInfo, row []interface{}) (map[string]interface{}, error) {
+	if len(row) != len(info.Columns) {
+		// table altered after the event was written
+		return nil, fmt.Errorf("%d values for %d columns", len(row), len(info.Columns))
+	}
+
+	r := make(map[string]interface{}, len(row))
+	for i, v := range row {
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		r[info.Columns[i]] = v
+	}
+	return r, nil
+}
+
+// Key is the primary key values joined, used as the partition key so that
+// changes of the same row are kept in order.
+func (this *Envelope) Key() string {
+	image := this.After
+	if image == nil {
+		image = this.Before
+	}
+
+	vals := make([]string, 0, len(this.PK))
+	for _, col := range this.PK {
+		vals = append(vals, fmt.Sprint(image[col]))
+	}
+	return strings.Join(vals, ",")
+}
+
+func (this *Envelope) Bytes() []byte {
+	b, _ := json.Marshal(this)
+	return b
+}
+
+// FormatGTID renders the server uuid and transaction number as uuid:gno.
+func FormatGTID(sid []byte, gno int64) string {
+	if len(sid) != 16 {
+		return fmt.Sprintf("%s:%d", hex.EncodeToString(sid), gno)
+	}
+
+	h := hex.EncodeToString(sid)
+	return fmt.Sprintf("%s-%s-%s-%s-%s:%d", h[:8], h[8:12], h[12:16], h[16:20], h[20:], gno)
+}
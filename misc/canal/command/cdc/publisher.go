@@ -0,0 +1,80 @@
+package cdc
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/api/v1"
+	"github.com/funkygao/gafka/ctx"
+	"github.com/funkygao/gafka/zk"
+)
+
+// Publisher delivers the envelopes to the target topic, keyed by primary key.
+type Publisher interface {
+	Publish(route *Route, key string, msg []byte) error
+	Close()
+}
+
+type katewayPublisher struct {
+	client *api.Client
+}
+
+func newKatewayPublisher(cf *Config) *katewayPublisher {
+	c := api.DefaultConfig(cf.Kateway.AppId, cf.Kateway.Secret)
+	c.Pub.Endpoint = cf.Kateway.Pub
+	return &katewayPublisher{client: api.NewClient(c)}
+}
+
+func (this *katewayPublisher) Publish(route *Route, key string, msg []byte) error {
+	return this.client.Pub(key, msg, api.PubOption{
+		Topic:  route.Topic,
+		Ver:    route.Ver,
+		AckAll: true,
+	})
+}
+
+func (this *katewayPublisher) Close() {
+	this.client.Close()
+}
+
+type kafkaPublisher struct {
+	producer sarama.SyncProducer
+}
+
+func newKafkaPublisher(cf *Config) (*kafkaPublisher, error) {
+	zkzone := zk.NewZkZone(zk.DefaultConfig(cf.Kafka.Zone, ctx.ZoneZkAddrs(cf.Kafka.Zone)))
+	defer zkzone.Close()
+
+	brokers := zkzone.NewCluster(cf.Kafka.Cluster).BrokerList()
+
+	kfkCf := sarama.NewConfig()
+	kfkCf.Producer.RequiredAcks = sarama.WaitForAll
+	kfkCf.Producer.Partitioner = sarama.NewHashPartitioner
+	kfkCf.Producer.Return.Successes = true
+	kfkCf.Producer.Retry.Max = 3
+	p, err := sarama.NewSyncProducer(brokers, kfkCf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafkaPublisher{producer: p}, nil
+}
+
+func (this *kafkaPublisher) Publish(route *Route, key string, msg []byte) error {
+	_, _, err := this.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: route.KafkaTopic,
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.ByteEncoder(msg),
+	})
+	return err
+}
+
+func (this *kafkaPublisher) Close() {
+	this.producer.Close()
+}
+
+func NewPublisher(cf *Config) (Publisher, error) {
+	if cf.Output == OutputKafka {
+		return newKafkaPublisher(cf)
+	}
+
+	return newKatewayPublisher(cf), nil
+}
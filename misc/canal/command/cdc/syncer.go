@@ -0,0 +1,293 @@
+package cdc
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/funkygao/gafka/zk"
+	log "github.com/funkygao/log4go"
+	"github.com/siddontang/go-mysql/client"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"github.com/siddontang/go-mysql/schema"
+	"golang.org/x/net/context"
+)
+
+// Syncer tails the binlog of a MySQL master as a slave and publishes the
+// row changes of configured tables. Delivery is at least once: the binlog
+// position is checkpointed in zk at transaction boundaries after all rows
+// of the transaction are published, so a restart might republish the
+// uncheckpointed transactions.
+type Syncer struct {
+	cf     *Config
+	zkzone *zk.ZkZone
+	pub    Publisher
+
+	conn   *client.Conn // to query table schema
+	tables map[string]*TableInfo
+
+	gtid      string // GTID of the transaction being synced
+	file      string
+	committed zk.CanalCheckpoint
+	dirty     bool
+}
+
+func NewSyncer(cf *Config, zkzone *zk.ZkZone, pub Publisher) *Syncer {
+	return &Syncer{
+		cf:     cf,
+		zkzone: zkzone,
+		pub:    pub,
+		tables: make(map[string]*TableInfo),
+	}
+}
+
+func (this *Syncer) Run(quit <-chan struct{}) error {
+	var err error
+	this.conn, err = client.Connect(this.cf.Source.Addr, this.cf.Source.User, this.cf.Source.Password, "")
+	if err != nil {
+		return err
+	}
+	defer this.conn.Close()
+
+	if err = this.loadCheckpoint(); err != nil {
+		return err
+	}
+
+	host, port, err := net.SplitHostPort(this.cf.Source.Addr)
+	if err != nil {
+		return err
+	}
+	portN, err := strconv.Atoi(port)
+	if err != nil {
+		return err
+	}
+
+	syncer := replication.NewBinlogSyncer(&replication.BinlogSyncerConfig{
+		ServerID: this.cf.ServerId,
+		Flavor:   this.cf.Source.Flavor,
+		Host:     host,
+		Port:     uint16(portN),
+		User:     this.cf.Source.User,
+		Password: this.cf.Source.Password,
+	})
+	defer syncer.Close()
+
+	log.Info("[%s] start syncing from %s:%d", this.cf.Name, this.committed.File, this.committed.Pos)
+	stream, err := syncer.StartSync(mysql.Position{Name: this.committed.File, Pos: this.committed.Pos})
+	if err != nil {
+		return err
+	}
+	this.file = this.committed.File
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-quit
+		cancel()
+	}()
+
+	defer this.saveCheckpoint()
+
+	lastCheckpoint := time.Now()
+	for {
+		ev, err := stream.GetEvent(ctx)
+		if err != nil {
+			select {
+			case <-quit:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		if err = this.handleEvent(ev, quit); err != nil {
+			return err
+		}
+
+		if time.Since(lastCheckpoint) >= this.cf.checkpointInterval {
+			this.saveCheckpoint()
+			lastCheckpoint = time.Now()
+		}
+	}
+}
+
+func (this *Syncer) handleEvent(ev *replication.BinlogEvent, quit <-chan struct{}) error {
+	switch e := ev.Event.(type) {
+	case *replication.RotateEvent:
+		this.file = string(e.NextLogName)
+		log.Info("[%s] rotate to %s:%d", this.cf.Name, this.file, e.Position)
+
+	case *replication.GTIDEvent:
+		this.gtid = FormatGTID(e.SID, e.GNO)
+
+	case *replication.XIDEvent:
+		this.commit(ev.Header.LogPos)
+
+	case *replication.QueryEvent:
+		query := strings.ToUpper(strings.TrimSpace(string(e.Query)))
+		if query == "BEGIN" {
+			return nil
+		}
+
+		// DDL is auto committed, and might change the table layout
+		if strings.HasPrefix(query, "ALTER") || strings.HasPrefix(query, "DROP") ||
+			strings.HasPrefix(query, "RENAME") || strings.HasPrefix(query, "TRUNCATE") {
+			log.Info("[%s] ddl: %s", this.cf.Name, string(e.Query))
+			this.tables = make(map[string]*TableInfo)
+		}
+		this.commit(ev.Header.LogPos)
+
+	case *replication.RowsEvent:
+		return this.handleRows(ev.Header, e, quit)
+	}
+
+	return nil
+}
+
+func (this *Syncer) handleRows(h *replication.EventHeader, e *replication.RowsEvent, quit <-chan struct{}) error {
+	var action string
+	switch h.EventType {
+	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		action = ActionInsert
+
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		action = ActionUpdate
+
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		action = ActionDelete
+
+	default:
+		return nil
+	}
+
+	db, table := string(e.Table.Schema), string(e.Table.Table)
+	route := this.cf.Route(db, table)
+	if route == nil {
+		return nil
+	}
+
+	info, err := this.tableInfo(db, table)
+	if err != nil {
+		return err
+	}
+
+	envelopes, err := BuildEnvelopes(action, db, table, info, e.Rows, Position{
+		GTID: this.gtid,
+		File: this.file,
+		Pos:  h.LogPos - h.EventSize,
+		Ts:   h.Timestamp,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, envelope := range envelopes {
+		if err = this.publish(route, envelope, quit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publish retries until success because skipping a change breaks the replica.
+func (this *Syncer) publish(route *Route, envelope *Envelope, quit <-chan struct{}) error {
+	key, msg := envelope.Key(), envelope.Bytes()
+	backoff := time.Second
+	for {
+		err := this.pub.Publish(route, key, msg)
+		if err == nil {
+			return nil
+		}
+
+		log.Error("[%s] %s.%s -> %s.%s: %v, retry after %s", this.cf.Name, envelope.Schema, envelope.Table,
+			route.Topic, route.Ver, err, backoff)
+
+		select {
+		case <-quit:
+			return err
+		case <-time.After(backoff):
+		}
+
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func (this *Syncer) tableInfo(db, table string) (*TableInfo, error) {
+	key := db + "." + table
+	if info, present := this.tables[key]; present {
+		return info, nil
+	}
+
+	t, err := schema.NewTable(this.conn, db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &TableInfo{PK: t.PKColumns}
+	for _, col := range t.Columns {
+		info.Columns = append(info.Columns, col.Name)
+	}
+	this.tables[key] = info
+	return info, nil
+}
+
+func (this *Syncer) commit(pos uint32) {
+	this.committed.File = this.file
+	this.committed.Pos = pos
+	if this.gtid != "" {
+		this.committed.GTID = this.gtid
+	}
+	this.dirty = true
+}
+
+func (this *Syncer) loadCheckpoint() error {
+	cp, err := this.zkzone.LoadCanalCheckpoint(this.cf.Name)
+	if err != nil {
+		return err
+	}
+
+	if cp != nil {
+		log.Info("[%s] checkpoint %s:%d gtid:%s saved at %s", this.cf.Name, cp.File, cp.Pos, cp.GTID, cp.Mtime)
+		this.committed = *cp
+		return nil
+	}
+
+	if this.cf.Source.File != "" {
+		this.committed.File, this.committed.Pos = this.cf.Source.File, this.cf.Source.Pos
+		return nil
+	}
+
+	// start from the current master position
+	rr, err := this.conn.Execute("SHOW MASTER STATUS")
+	if err != nil {
+		return err
+	}
+	if this.committed.File, err = rr.GetString(0, 0); err != nil {
+		return err
+	}
+	pos, err := rr.GetInt(0, 1)
+	if err != nil {
+		return err
+	}
+	this.committed.Pos = uint32(pos)
+	return nil
+}
+
+func (this *Syncer) saveCheckpoint() {
+	if !this.dirty {
+		return
+	}
+
+	if err := this.zkzone.SaveCanalCheckpoint(this.cf.Name, this.committed); err != nil {
+		log.Error("[%s] checkpoint: %v", this.cf.Name, err)
+		return
+	}
+
+	this.dirty = false
+	log.Trace("[%s] checkpoint %s:%d", this.cf.Name, this.committed.File, this.committed.Pos)
+}
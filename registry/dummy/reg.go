@@ -25,6 +25,10 @@ func (this *dummy) Deregister(id string, oldData []byte) error {
 	return nil
 }
 
+func (this *dummy) Update(id string, data []byte) error {
+	return nil
+}
+
 func (this *dummy) WatchInstances() ([]string, <-chan zklib.Event, error) {
 	return nil, nil, nil
 }
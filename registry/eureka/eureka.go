@@ -29,6 +29,10 @@ func (this *eureka) Deregister(id string, oldData []byte) error {
 	return nil
 }
 
+func (this *eureka) Update(id string, data []byte) error {
+	return nil
+}
+
 func (this *eureka) WatchInstances() ([]string, <-chan zklib.Event, error) {
 	return nil, nil, nil
 }
@@ -9,6 +9,9 @@ type Backend interface {
 
 	Deregister(id string, data []byte) error
 
+	// Update replaces the registered data of an instance in place.
+	Update(id string, data []byte) error
+
 	WatchInstances() ([]string, <-chan zk.Event, error)
 
 	// Name of the registry backend.
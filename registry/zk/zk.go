@@ -103,6 +103,15 @@ func (this *zkreg) Deregister(id string, oldData []byte) error {
 	return this.zkzone.Conn().Delete(this.mypath(id), -1)
 }
 
+func (this *zkreg) Update(id string, data []byte) error {
+	_, err := this.zkzone.Conn().Set(this.mypath(id), data, -1)
+	if err != nil {
+		return fmt.Errorf("%s %v", this.mypath(id), err)
+	}
+
+	return nil
+}
+
 func (this *zkreg) WatchInstances() ([]string, <-chan zklib.Event, error) {
 	path := fmt.Sprintf("%s/%s", zk.KatewayIdsRoot, this.zkzone.Name())
 	ids, _, ch, err := this.zkzone.Conn().ChildrenW(path)
@@ -8,14 +8,26 @@ import (
 	"github.com/funkygao/gafka/ctx"
 )
 
+const (
+	defaultSpoolMaxBytes = 256 << 20
+	defaultMaxBackoff    = time.Minute * 10
+)
+
 type config struct {
 	interval time.Duration
 	hostname string // local host name
 
-	url      url.URL
-	database string // influxdb database
-	username string // influxdb username
-	password string
+	url             url.URL
+	database        string // influxdb database
+	retentionPolicy string // empty means the db default retention policy
+	username        string // influxdb username
+	password        string
+
+	gzip bool // gzip the line protocol body on write
+
+	spoolDir      string // empty means unsent points are dropped
+	spoolMaxBytes int64
+	maxBackoff    time.Duration
 }
 
 func NewConfig(uri, db, user, pass string, interval time.Duration) (*config, error) {
@@ -35,11 +47,37 @@ func NewConfig(uri, db, user, pass string, interval time.Duration) (*config, err
 	}
 
 	return &config{
-		hostname: ctx.Hostname(),
-		url:      *u,
-		database: db,
-		username: user,
-		password: pass,
-		interval: interval,
+		hostname:      ctx.Hostname(),
+		url:           *u,
+		database:      db,
+		username:      user,
+		password:      pass,
+		interval:      interval,
+		spoolMaxBytes: defaultSpoolMaxBytes,
+		maxBackoff:    defaultMaxBackoff,
 	}, nil
 }
+
+// UseRetentionPolicy writes all points into the specified retention policy
+// instead of the db default one.
+func (this *config) UseRetentionPolicy(rp string) *config {
+	this.retentionPolicy = rp
+	return this
+}
+
+// EnableGzip compresses each write request body with gzip.
+func (this *config) EnableGzip() *config {
+	this.gzip = true
+	return this
+}
+
+// EnableSpool persists unsent batches under dir so that they can be replayed
+// in order when influxdb recovers. When the spool exceeds maxBytes, the oldest
+// batches are dropped.
+func (this *config) EnableSpool(dir string, maxBytes int64) *config {
+	this.spoolDir = dir
+	if maxBytes > 0 {
+		this.spoolMaxBytes = maxBytes
+	}
+	return this
+}
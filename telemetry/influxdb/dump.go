@@ -1,6 +1,7 @@
 package influxdb
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
 	"time"
@@ -14,26 +15,133 @@ import (
 func (this *runner) dump(pts []client.Point) {
 	log.Trace("influxdb writing[%s] %d points", this.cf.database, len(pts))
 
-	if this.client == nil {
-		log.Debug("influxdb try connecting...")
+	body := encodePoints(pts)
+	if time.Now().Before(this.nextRetry) {
+		// still backing off, influxdb not recovered yet
+		this.postpone(body)
+		return
+	}
+
+	if err := this.replay(); err != nil {
+		log.Error("influxdb replay: %v", err)
+		this.backoff()
+		this.postpone(body)
+		return
+	}
 
-		if err := this.makeClient(); err != nil {
-			log.Error("influxdb quit this tick: %v", err)
+	if len(body) == 0 {
+		return
+	}
+
+	if err := this.write(body); err != nil {
+		log.Error("influxdb: %v", err)
+		if isRejected(err) {
+			this.dropped.Inc(int64(countPoints(body)))
+			this.resetBackoff()
 			return
-		} else {
-			log.Info("influxdb connected")
 		}
+
+		this.backoff()
+		this.postpone(body)
+		return
 	}
 
-	if _, err := this.client.Write(client.BatchPoints{
-		Points:   pts,
-		Database: this.cf.database,
-	}); err != nil {
-		log.Error("influxdb: %v", err)
+	this.resetBackoff()
+}
+
+// replay sends all spooled batches to influxdb in order, the batches that
+// influxdb rejects are dropped so that they never block the later ones.
+func (this *runner) replay() error {
+	if this.spool == nil {
+		return nil
+	}
+
+	for this.spool.Len() > 0 {
+		body, err := this.spool.Peek()
+		if err != nil {
+			return err
+		}
+
+		if err = this.write(body); err != nil {
+			if !isRejected(err) {
+				return err
+			}
+
+			log.Error("influxdb replay: %v, batch dropped", err)
+			this.dropped.Inc(int64(this.spool.Pop()))
+			continue
+		}
+
+		this.replayed.Inc(int64(this.spool.Pop()))
+	}
+
+	this.spoolBatches.Update(0)
+	return nil
+}
+
+// postpone spools the batch for later replay, or drops it if spool not enabled.
+func (this *runner) postpone(body []byte) {
+	n := countPoints(body)
+	if n == 0 {
+		return
+	}
 
-		// reconnect in next round
-		this.client = nil
+	if this.spool == nil {
+		this.dropped.Inc(int64(n))
+		return
+	}
+
+	dropped, err := this.spool.Push(body)
+	if err != nil {
+		log.Error("influxdb spool: %v", err)
+		this.dropped.Inc(int64(n))
+		return
+	}
+
+	this.spooled.Inc(int64(n))
+	this.spoolBatches.Update(int64(this.spool.Len()))
+	if dropped > 0 {
+		log.Warn("influxdb spool full, %d oldest points dropped", dropped)
+		this.dropped.Inc(int64(dropped))
+	}
+}
+
+func (this *runner) backoff() {
+	if this.retryBackoff == 0 {
+		this.retryBackoff = this.cf.interval
+	} else {
+		this.retryBackoff *= 2
+	}
+	if this.retryBackoff > this.cf.maxBackoff {
+		this.retryBackoff = this.cf.maxBackoff
 	}
+
+	this.nextRetry = time.Now().Add(this.retryBackoff)
+	log.Debug("influxdb backoff %s", this.retryBackoff)
+}
+
+func (this *runner) resetBackoff() {
+	this.retryBackoff = 0
+	this.nextRetry = time.Time{}
+}
+
+// encodePoints renders the points in influxdb line protocol, one point per line.
+func encodePoints(pts []client.Point) []byte {
+	var buf bytes.Buffer
+	for i := range pts {
+		line := pts[i].MarshalString()
+		if strings.HasPrefix(line, "# ERROR") {
+			log.Warn("influxdb %s", line)
+			continue
+		}
+
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+
+	return buf.Bytes()
 }
 
 func (this *runner) export(pts *[]client.Point) {
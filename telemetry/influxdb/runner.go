@@ -2,20 +2,32 @@ package influxdb
 
 import (
 	"fmt"
+	"net/http"
 	"runtime/debug"
 	"time"
 
 	"github.com/funkygao/gafka/telemetry"
 	"github.com/funkygao/go-metrics"
+	log "github.com/funkygao/log4go"
 	"github.com/influxdata/influxdb/client"
 )
 
 var _ telemetry.Reporter = &runner{}
 
 type runner struct {
-	cf     *config
-	reg    metrics.Registry
-	client *client.Client
+	cf         *config
+	reg        metrics.Registry
+	httpClient *http.Client
+
+	spool        *spool
+	retryBackoff time.Duration
+	nextRetry    time.Time
+
+	// self metrics
+	spooled      metrics.Counter
+	replayed     metrics.Counter
+	dropped      metrics.Counter
+	spoolBatches metrics.Gauge
 
 	quiting, quit chan struct{}
 }
@@ -26,29 +38,29 @@ type runner struct {
 // CREATE CONTINUOUS QUERY cq_30m ON food_data BEGIN SELECT mean(website) AS mean_website,mean(phone) AS mean_phone INTO food_data."default".downsampled_orders FROM orders GROUP BY time(30m) END
 func New(r metrics.Registry, cf *config) telemetry.Reporter {
 	this := &runner{
-		reg:     r,
-		cf:      cf,
-		quiting: make(chan struct{}),
-		quit:    make(chan struct{}),
+		reg:          r,
+		cf:           cf,
+		httpClient:   &http.Client{Timeout: time.Second * 4},
+		spooled:      metrics.NewRegisteredCounter("influxdb.spooled", r),
+		replayed:     metrics.NewRegisteredCounter("influxdb.replayed", r),
+		dropped:      metrics.NewRegisteredCounter("influxdb.dropped", r),
+		spoolBatches: metrics.NewRegisteredGauge("influxdb.spool.batches", r),
+		quiting:      make(chan struct{}),
+		quit:         make(chan struct{}),
 	}
 
-	return this
-}
-
-func (this *runner) makeClient() (err error) {
-	this.client, err = client.NewClient(client.Config{
-		URL:      this.cf.url,
-		Username: this.cf.username,
-		Password: this.cf.password,
-		Timeout:  time.Second * 4,
-	})
-
-	_, _, err = this.client.Ping()
-	if err != nil {
-		this.client = nil // to trigger retry
+	if cf.spoolDir != "" {
+		s, err := openSpool(cf.spoolDir, cf.spoolMaxBytes)
+		if err != nil {
+			log.Error("influxdb spool disabled: %v", err)
+		} else {
+			this.spool = s
+			this.spoolBatches.Update(int64(s.Len()))
+			log.Info("influxdb spool[%s] opened with %d batches", cf.spoolDir, s.Len())
+		}
 	}
 
-	return
+	return this
 }
 
 func (*runner) Name() string {
@@ -0,0 +1,162 @@
+package influxdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const spoolFileSuffix = ".lp"
+
+var errSpoolEmpty = errors.New("spool is empty")
+
+// spool is a bounded FIFO of line protocol batches, each batch persisted as
+// a single file whose name is a monotonically increasing sequence number.
+type spool struct {
+	mu sync.Mutex
+
+	dir      string
+	maxBytes int64
+
+	seq   uint64
+	size  int64
+	files []spoolFile // oldest first
+}
+
+type spoolFile struct {
+	name   string
+	size   int64
+	points int
+}
+
+func openSpool(dir string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &spool{dir: dir, maxBytes: maxBytes}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fi := range entries {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), spoolFileSuffix) {
+			// incomplete tmp file of last crash
+			continue
+		}
+
+		var seq uint64
+		if _, err := fmt.Sscanf(fi.Name(), "%d"+spoolFileSuffix, &seq); err != nil {
+			continue
+		}
+		if seq > s.seq {
+			s.seq = seq
+		}
+
+		body, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		s.files = append(s.files, spoolFile{
+			name:   fi.Name(),
+			size:   fi.Size(),
+			points: countPoints(body),
+		})
+		s.size += fi.Size()
+	}
+
+	sort.Sort(spoolFiles(s.files))
+	return s, nil
+}
+
+// Push appends a batch to the tail of spool and returns how many points
+// were dropped from the head to keep the spool bounded.
+func (s *spool) Push(body []byte) (dropped int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	name := fmt.Sprintf("%020d%s", s.seq, spoolFileSuffix)
+	tmp := filepath.Join(s.dir, name+".tmp")
+	if err = ioutil.WriteFile(tmp, body, 0600); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		return
+	}
+
+	s.files = append(s.files, spoolFile{
+		name:   name,
+		size:   int64(len(body)),
+		points: countPoints(body),
+	})
+	s.size += int64(len(body))
+
+	for s.size > s.maxBytes && len(s.files) > 1 {
+		dropped += s.files[0].points
+		s.removeHead()
+	}
+
+	return
+}
+
+// Peek returns the oldest batch without removing it.
+func (s *spool) Peek() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.files) == 0 {
+		return nil, errSpoolEmpty
+	}
+
+	return ioutil.ReadFile(filepath.Join(s.dir, s.files[0].name))
+}
+
+// Pop removes the oldest batch and returns the number of points it holds.
+func (s *spool) Pop() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.files) == 0 {
+		return 0
+	}
+
+	n := s.files[0].points
+	s.removeHead()
+	return n
+}
+
+// Len returns the number of batches in spool.
+func (s *spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.files)
+}
+
+func (s *spool) removeHead() {
+	os.Remove(filepath.Join(s.dir, s.files[0].name))
+	s.size -= s.files[0].size
+	s.files = s.files[1:]
+}
+
+func countPoints(body []byte) int {
+	if len(body) == 0 {
+		return 0
+	}
+
+	return bytes.Count(body, []byte{'\n'}) + 1
+}
+
+type spoolFiles []spoolFile
+
+func (this spoolFiles) Len() int           { return len(this) }
+func (this spoolFiles) Less(i, j int) bool { return this[i].name < this[j].name }
+func (this spoolFiles) Swap(i, j int)      { this[i], this[j] = this[j], this[i] }
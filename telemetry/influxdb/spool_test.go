@@ -0,0 +1,46 @@
+package influxdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestSpoolFifoAndBounded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxspool")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	s, err := openSpool(dir, 20)
+	assert.Equal(t, nil, err)
+
+	dropped, err := s.Push([]byte("a v=1\na v=2"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, dropped)
+	dropped, err = s.Push([]byte("b v=1"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, dropped)
+	assert.Equal(t, 2, s.Len())
+
+	// exceeds maxBytes, the oldest batch is dropped
+	dropped, err = s.Push([]byte("c v=1"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, dropped)
+	assert.Equal(t, 2, s.Len())
+
+	// reopen keeps the order
+	s, err = openSpool(dir, 20)
+	assert.Equal(t, nil, err)
+	body, err := s.Peek()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "b v=1", string(body))
+	assert.Equal(t, 1, s.Pop())
+	body, _ = s.Peek()
+	assert.Equal(t, "c v=1", string(body))
+	s.Pop()
+
+	_, err = s.Peek()
+	assert.Equal(t, errSpoolEmpty, err)
+}
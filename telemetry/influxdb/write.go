@@ -0,0 +1,86 @@
+package influxdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// rejectedError is a write that influxdb will never accept however many
+// times it's retried, e,g. field type conflict.
+type rejectedError struct {
+	status string
+	msg    []byte
+}
+
+func (this *rejectedError) Error() string {
+	return fmt.Sprintf("influxdb rejected %s: %s", this.status, this.msg)
+}
+
+// isRejected checks if the batch of the write error should be dropped
+// instead of retried.
+func isRejected(err error) bool {
+	_, rejected := err.(*rejectedError)
+	return rejected
+}
+
+// write posts line protocol body to influxdb /write endpoint.
+func (this *runner) write(body []byte) error {
+	u := this.cf.url
+	u.Path = "/write"
+	params := url.Values{}
+	params.Set("db", this.cf.database)
+	params.Set("precision", "n")
+	if this.cf.retentionPolicy != "" {
+		params.Set("rp", this.cf.retentionPolicy)
+	}
+	if this.cf.username != "" {
+		params.Set("u", this.cf.username)
+		params.Set("p", this.cf.password)
+	}
+	u.RawQuery = params.Encode()
+
+	var r io.Reader = bytes.NewReader(body)
+	if this.cf.gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		r = &buf
+	}
+
+	req, err := http.NewRequest("POST", u.String(), r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if this.cf.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := this.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return &rejectedError{status: resp.Status, msg: bytes.TrimSpace(msg)}
+	}
+	return fmt.Errorf("influxdb write %s: %s", resp.Status, bytes.TrimSpace(msg))
+}
@@ -0,0 +1,141 @@
+package influxdb
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/go-metrics"
+	"github.com/influxdata/influxdb/client"
+)
+
+// fakeInfluxdb responds each write with status returned by fn.
+type fakeInfluxdb struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	writes []string
+	status func(body string) int
+}
+
+func newFakeInfluxdb(status func(body string) int) *fakeInfluxdb {
+	f := &fakeInfluxdb{status: status}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		f.mu.Lock()
+		f.writes = append(f.writes, string(b))
+		code := f.status(string(b))
+		f.mu.Unlock()
+		w.WriteHeader(code)
+	}))
+	return f
+}
+
+func (this *fakeInfluxdb) Writes() []string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return append([]string(nil), this.writes...)
+}
+
+func createSpoolRunner(t *testing.T, uri string) (*runner, func()) {
+	dir, err := ioutil.TempDir("", "influxspool")
+	assert.Equal(t, nil, err)
+
+	cf, err := NewConfig(uri, "db", "", "", time.Second)
+	assert.Equal(t, nil, err)
+	r := New(metrics.NewRegistry(), cf.EnableSpool(dir, 0)).(*runner)
+	assert.NotEqual(t, (*spool)(nil), r.spool)
+	return r, func() { os.RemoveAll(dir) }
+}
+
+func TestWriteRejected(t *testing.T) {
+	for code, rejected := range map[int]bool{
+		http.StatusBadRequest:          true,
+		http.StatusNotFound:            true,
+		http.StatusRequestTimeout:      false,
+		http.StatusTooManyRequests:     false,
+		http.StatusInternalServerError: false,
+		http.StatusServiceUnavailable:  false,
+	} {
+		f := newFakeInfluxdb(func(string) int { return code })
+		r, cleanup := createSpoolRunner(t, f.URL)
+		err := r.write([]byte("a v=1"))
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, rejected, isRejected(err))
+		cleanup()
+		f.Close()
+	}
+}
+
+func TestReplayDropsPoisonBatch(t *testing.T) {
+	f := newFakeInfluxdb(func(body string) int {
+		if strings.HasPrefix(body, "bad") {
+			return http.StatusBadRequest // field type conflict
+		}
+		return http.StatusNoContent
+	})
+	defer f.Close()
+	r, cleanup := createSpoolRunner(t, f.URL)
+	defer cleanup()
+
+	r.spool.Push([]byte("bad v=1\nbad v=2"))
+	r.spool.Push([]byte("good v=1"))
+	assert.Equal(t, nil, r.replay())
+	assert.Equal(t, 0, r.spool.Len())
+	assert.Equal(t, int64(2), r.dropped.Count())
+	assert.Equal(t, int64(1), r.replayed.Count())
+	assert.Equal(t, []string{"bad v=1\nbad v=2", "good v=1"}, f.Writes())
+}
+
+func TestDumpBackoffAndReplay(t *testing.T) {
+	var down = true
+	f := newFakeInfluxdb(func(string) int {
+		if down {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusNoContent
+	})
+	defer f.Close()
+	r, cleanup := createSpoolRunner(t, f.URL)
+	defer cleanup()
+
+	// influxdb down: the batch is spooled and writes back off
+	r.spool.Push([]byte("a v=1"))
+	r.dump(nil)
+	assert.Equal(t, time.Second, r.retryBackoff)
+	assert.Equal(t, true, r.nextRetry.After(time.Now()))
+	assert.Equal(t, 1, r.spool.Len())
+	writes := len(f.Writes())
+
+	// still backing off, influxdb is not bothered
+	r.dump(nil)
+	assert.Equal(t, writes, len(f.Writes()))
+
+	// backoff doubles upto max
+	r.cf.maxBackoff = time.Second * 3
+	r.backoff()
+	assert.Equal(t, time.Second*2, r.retryBackoff)
+	r.backoff()
+	assert.Equal(t, time.Second*3, r.retryBackoff)
+
+	// influxdb recovers: spooled batches are replayed in order
+	f.mu.Lock()
+	down = false
+	f.mu.Unlock()
+	r.spool.Push([]byte("b v=1"))
+	r.nextRetry = time.Time{}
+	r.dump([]client.Point{{Measurement: "c", Fields: map[string]interface{}{"value": 1}, Time: time.Now()}})
+	assert.Equal(t, 0, r.spool.Len())
+	assert.Equal(t, int64(2), r.replayed.Count())
+	writes2 := f.Writes()[writes:]
+	assert.Equal(t, 3, len(writes2))
+	assert.Equal(t, []string{"a v=1", "b v=1"}, writes2[:2])
+	assert.Equal(t, true, strings.HasPrefix(writes2[2], "c value=1"))
+	assert.Equal(t, time.Duration(0), r.retryBackoff)
+}
@@ -51,6 +51,7 @@ type ConsumerMeta struct {
 type WebhookMeta struct {
 	Cluster   string   `json:"cluster"`
 	Endpoints []string `json:"endpoints"`
+	Filter    string   `json:"filter,omitempty"` // only msgs matching the filter expression are pushed
 }
 
 func (this *WebhookMeta) From(b []byte) error {
@@ -246,11 +247,77 @@ type KatewayMeta struct {
 	SManAddr  string `json:"sman"`
 	DebugAddr string `json:"debug"`
 
+	// live load reported periodically by the kateway instance
+	PubConns int    `json:"pubconn,omitempty"`
+	SubConns int    `json:"subconn,omitempty"`
+	State    string `json:"state,omitempty"`
+
 	Ctime time.Time `json:"-"`
 }
 
+const (
+	// KatewayStateOnline is the default state of a registered kateway.
+	KatewayStateOnline = ""
+
+	// KatewayStateDraining announces that the kateway is shutting down
+	// and will accept no new requests.
+	KatewayStateDraining = "draining"
+)
+
+// Draining returns whether the kateway announced a shutdown.
+func (this *KatewayMeta) Draining() bool {
+	return this.State == KatewayStateDraining
+}
+
 type KguardMeta struct {
 	Host       string
 	Candidates int
 	Ctime      time.Time
 }
+
+// AgentMeta is the registered info of a gk agent running on a broker host.
+type AgentMeta struct {
+	Host     string   `json:"host"`
+	HttpAddr string   `json:"http"`
+	Brokers  []string `json:"brokers"` // cluster:brokerId
+
+	Ctime time.Time `json:"-"`
+}
+
+// CanalCheckpoint is the binlog position a canal binlog syncer has published up to.
+type CanalCheckpoint struct {
+	File string `json:"file"`
+	Pos  uint32 `json:"pos"`
+	GTID string `json:"gtid,omitempty"` // the last committed transaction
+
+	Mtime time.Time `json:"-"`
+}
+
+// MirrorCheckpoint maps the source offset to the target offset of the last
+// message a mirror route copied of a partition.
+type MirrorCheckpoint struct {
+	TargetTopic  string `json:"target_topic"`
+	SourceOffset int64  `json:"source_offset"`
+	TargetOffset int64  `json:"target_offset"`
+
+	// History is the source:target offset pairs of the recent checkpoints,
+	// oldest first. It is the offset map of a route whose target is also
+	// written by others, where offsets can't be translated arithmetically.
+	History [][2]int64 `json:"history,omitempty"`
+
+	Mtime time.Time `json:"-"`
+}
+
+// TopicSchemas is the avro schema versions of a pubsub topic, oldest first.
+type TopicSchemas struct {
+	Compatibility string               `json:"compatibility"`
+	Versions      []TopicSchemaVersion `json:"versions"`
+
+	Mtime time.Time `json:"-"`
+}
+
+type TopicSchemaVersion struct {
+	Id     string    `json:"id"`
+	Schema string    `json:"schema"`
+	Ctime  time.Time `json:"ctime"`
+}
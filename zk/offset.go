@@ -0,0 +1,153 @@
+package zk
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/funkygao/log4go"
+)
+
+// Where a consumer group commits offsets to.
+//
+// A group migrates from zk to kafka in 2 steps: zk -> dual -> kafka.
+// In dual mode offsets are written to both and zk is still the source of
+// truth, once kafka has caught up with all the partitions the group switches
+// to kafka.
+const (
+	OffsetStorageZk    = "zk"
+	OffsetStorageDual  = "dual"  // write zk and kafka, read zk
+	OffsetStorageKafka = "kafka" // write kafka, read the larger of kafka and zk
+)
+
+// offsetStorageTTL is how long the offset storage of a group is cached by
+// an OffsetStore, it is also the max time a storage switch takes effect.
+const offsetStorageTTL = time.Minute
+
+var ErrInvalidOffsetStorage = errors.New("invalid offset storage, expect zk|dual|kafka")
+
+func ValidOffsetStorage(storage string) bool {
+	switch storage {
+	case OffsetStorageZk, OffsetStorageDual, OffsetStorageKafka:
+		return true
+	default:
+		return false
+	}
+}
+
+// OffsetStore commits and fetches consumer group offsets of a kafka cluster.
+//
+// The offset is the last consumed offset as zk has always been storing,
+// not the next offset to consume as kafka does.
+type OffsetStore interface {
+	CommitOffset(group, topic string, partitionId int32, offset int64) error
+
+	// ResetOffset rewinds or forwards the offset on all the storages the
+	// group reads from.
+	ResetOffset(group, topic string, partitionId int32, offset int64) error
+
+	// FetchOffset returns -1 if the group never committed offset of the partition.
+	FetchOffset(group, topic string, partitionId int32) (int64, error)
+
+	Close() error
+}
+
+type cachedOffsetStorage struct {
+	storage string
+	expires time.Time
+}
+
+// offsetStore dispatches to zk or kafka by the offset storage of each group.
+type offsetStore struct {
+	cluster *ZkCluster
+	kafka   *kafkaOffsetStore
+
+	mu       sync.Mutex
+	storages map[string]cachedOffsetStorage // key is group
+}
+
+// NewOffsetStore creates an OffsetStore that honors the offset storage of
+// each group. The kafka connection is made on demand, caller must Close it.
+func (this *ZkCluster) NewOffsetStore() OffsetStore {
+	return &offsetStore{
+		cluster:  this,
+		kafka:    newKafkaOffsetStore(this),
+		storages: make(map[string]cachedOffsetStorage),
+	}
+}
+
+func (this *offsetStore) storageOf(group string) string {
+	this.mu.Lock()
+	c, present := this.storages[group]
+	this.mu.Unlock()
+	if present && time.Now().Before(c.expires) {
+		return c.storage
+	}
+
+	storage, err := this.cluster.OffsetStorageOfGroup(group)
+	if err != nil {
+		log.Error("cluster[%s] group[%s] offset storage: %v", this.cluster.Name(), group, err)
+
+		if present {
+			// stick to the last known storage
+			return c.storage
+		}
+		return OffsetStorageZk
+	}
+
+	this.mu.Lock()
+	this.storages[group] = cachedOffsetStorage{storage: storage, expires: time.Now().Add(offsetStorageTTL)}
+	this.mu.Unlock()
+	return storage
+}
+
+func (this *offsetStore) CommitOffset(group, topic string, partitionId int32, offset int64) error {
+	switch this.storageOf(group) {
+	case OffsetStorageKafka:
+		return this.kafka.CommitOffset(group, topic, partitionId, offset)
+
+	case OffsetStorageDual:
+		if err := this.kafka.CommitOffset(group, topic, partitionId, offset); err != nil {
+			// zk is still the source of truth, kafka will catch up on next commit
+			log.Warn("cluster[%s] group[%s] dual commit offset {T:%s/%d O:%d} kafka: %v",
+				this.cluster.Name(), group, topic, partitionId, offset, err)
+		}
+		return this.cluster.CommitConsumerOffset(group, topic, partitionId, offset)
+
+	default:
+		return this.cluster.CommitConsumerOffset(group, topic, partitionId, offset)
+	}
+}
+
+func (this *offsetStore) ResetOffset(group, topic string, partitionId int32, offset int64) error {
+	if this.storageOf(group) != OffsetStorageZk {
+		if err := this.kafka.CommitOffset(group, topic, partitionId, offset); err != nil {
+			return err
+		}
+	}
+
+	// kafka storage reads zk too
+	return this.cluster.CommitConsumerOffset(group, topic, partitionId, offset)
+}
+
+func (this *offsetStore) FetchOffset(group, topic string, partitionId int32) (int64, error) {
+	zkOffset, err := this.cluster.ConsumerOffsetOfPartition(group, topic, partitionId)
+	if err != nil || this.storageOf(group) != OffsetStorageKafka {
+		return zkOffset, err
+	}
+
+	// zk offset is still there for the partitions never committed to kafka,
+	// and kafka-cg commits consumed offsets to zk by itself
+	kafkaOffset, err := this.kafka.FetchOffset(group, topic, partitionId)
+	if err != nil {
+		return -1, err
+	}
+	if kafkaOffset > zkOffset {
+		return kafkaOffset, nil
+	}
+	return zkOffset, nil
+}
+
+func (this *offsetStore) Close() error {
+	return this.kafka.Close()
+}
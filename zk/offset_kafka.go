@@ -0,0 +1,123 @@
+package zk
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// kafkaOffsetStore commits offsets to __consumer_offsets through the group
+// coordinator with OffsetCommit/OffsetFetch v1 API.
+type kafkaOffsetStore struct {
+	cluster *ZkCluster
+
+	mu     sync.Mutex
+	client sarama.Client // lazy created
+}
+
+func newKafkaOffsetStore(cluster *ZkCluster) *kafkaOffsetStore {
+	return &kafkaOffsetStore{cluster: cluster}
+}
+
+func (this *kafkaOffsetStore) coordinator(group string) (sarama.Client, *sarama.Broker, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.client == nil {
+		client, err := sarama.NewClient(this.cluster.BrokerList(), sarama.NewConfig())
+		if err != nil {
+			return nil, nil, err
+		}
+		this.client = client
+	}
+
+	broker, err := this.client.Coordinator(group)
+	return this.client, broker, err
+}
+
+func (this *kafkaOffsetStore) CommitOffset(group, topic string, partitionId int32, offset int64) error {
+	client, broker, err := this.coordinator(group)
+	if err != nil {
+		return err
+	}
+
+	// generation -1 commits without group membership like a simple consumer
+	req := &sarama.OffsetCommitRequest{
+		Version:                 1,
+		ConsumerGroup:           group,
+		ConsumerGroupGeneration: -1,
+	}
+	// kafka stores the next offset to consume
+	req.AddBlock(topic, partitionId, offset+1, sarama.ReceiveTime, "")
+
+	resp, err := broker.CommitOffset(req)
+	if err != nil {
+		client.RefreshCoordinator(group)
+		return err
+	}
+
+	if errs, present := resp.Errors[topic]; present {
+		if kerr, present := errs[partitionId]; present && kerr != sarama.ErrNoError {
+			this.refreshCoordinatorOn(client, group, kerr)
+			return kerr
+		}
+	}
+
+	return nil
+}
+
+func (this *kafkaOffsetStore) FetchOffset(group, topic string, partitionId int32) (int64, error) {
+	client, broker, err := this.coordinator(group)
+	if err != nil {
+		return -1, err
+	}
+
+	// v0 reads from zk, v1 from kafka
+	req := &sarama.OffsetFetchRequest{
+		Version:       1,
+		ConsumerGroup: group,
+	}
+	req.AddPartition(topic, partitionId)
+
+	resp, err := broker.FetchOffset(req)
+	if err != nil {
+		client.RefreshCoordinator(group)
+		return -1, err
+	}
+
+	block := resp.GetBlock(topic, partitionId)
+	if block == nil {
+		return -1, sarama.ErrIncompleteResponse
+	}
+	if block.Err != sarama.ErrNoError {
+		this.refreshCoordinatorOn(client, group, block.Err)
+		return -1, block.Err
+	}
+
+	if block.Offset <= 0 {
+		// never committed
+		return -1, nil
+	}
+
+	return block.Offset - 1, nil
+}
+
+func (this *kafkaOffsetStore) refreshCoordinatorOn(client sarama.Client, group string, kerr sarama.KError) {
+	switch kerr {
+	case sarama.ErrNotCoordinatorForConsumer, sarama.ErrConsumerCoordinatorNotAvailable:
+		client.RefreshCoordinator(group)
+	}
+}
+
+func (this *kafkaOffsetStore) Close() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.client == nil {
+		return nil
+	}
+
+	err := this.client.Close()
+	this.client = nil
+	return err
+}
@@ -0,0 +1,23 @@
+package zk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func TestValidOffsetStorage(t *testing.T) {
+	assert.Equal(t, true, ValidOffsetStorage(OffsetStorageZk))
+	assert.Equal(t, true, ValidOffsetStorage(OffsetStorageDual))
+	assert.Equal(t, true, ValidOffsetStorage(OffsetStorageKafka))
+	assert.Equal(t, false, ValidOffsetStorage(""))
+	assert.Equal(t, false, ValidOffsetStorage("zookeeper"))
+}
+
+func TestOffsetStoreStorageCached(t *testing.T) {
+	store := createClusterForTest().NewOffsetStore().(*offsetStore)
+	store.storages["g1"] = cachedOffsetStorage{storage: OffsetStorageDual, expires: time.Now().Add(time.Minute)}
+	assert.Equal(t, OffsetStorageDual, store.storageOf("g1"))
+	assert.Equal(t, nil, store.Close()) // kafka client never created
+}
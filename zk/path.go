@@ -8,9 +8,12 @@ const (
 	clusterRoot     = "/_kafka_clusters"
 	clusterInfoRoot = "/_kafa_clusters_info"
 
+	clusterOffsetStorageRoot = "/_kafka_clusters_offset_storage"
+
 	KatewayIdsRoot     = "/_kateway/ids"
 	katewayMetricsRoot = "/_kateway/metrics"
 	KatewayMysqlPath   = "/_kateway/mysql"
+	KatewaySchemasRoot = "/_kateway/schemas"
 
 	PubsubJobConfig      = "/_kateway/orchestrator/jobconfig"
 	PubsubJobQueues      = "/_kateway/orchestrator/jobs"
@@ -19,10 +22,16 @@ const (
 	PubsubWebhooks       = "/_kateway/orchestrator/webhooks"
 	PubsubWebhooksOff    = "/_kateway/orchestrator/webhooks_off"
 	PubsubWebhookOwners  = "/_kateway/orchestrator/actors/webhook_owners"
+	PubsubReplicaOwners  = "/_kateway/orchestrator/actors/replica_owners"
 	//PubsubActorRebalance = "/_kateway/orchestrator/rebalance"
 
 	KguardLeaderPath = "_kguard/leader"
 
+	GkAgentsRoot            = "/_gk/agents"
+	GkMirrorCheckpointsRoot = "/_gk/mirror/checkpoints"
+
+	CanalCheckpointsRoot = "/_canal/checkpoints"
+
 	ConsumersPath           = "/consumers"
 	BrokerIdsPath           = "/brokers/ids"
 	BrokerTopicsPath        = "/brokers/topics"
@@ -61,6 +70,14 @@ func (this *ZkCluster) ClusterInfoPath() string {
 	return fmt.Sprintf("%s/%s", clusterInfoRoot, this.name)
 }
 
+// offsetStoragePath is the cluster default offset storage if group is empty.
+func (this *ZkCluster) offsetStoragePath(group string) string {
+	if group == "" {
+		return fmt.Sprintf("%s/%s", clusterOffsetStorageRoot, this.name)
+	}
+	return fmt.Sprintf("%s/%s/%s", clusterOffsetStorageRoot, this.name, group)
+}
+
 func (this *ZkCluster) controllerEpochPath() string {
 	return this.path + ControllerEpochPath
 }
@@ -109,6 +126,10 @@ func (this *ZkCluster) consumerGroupOffsetOfTopicPartitionPath(group, topic, par
 	return this.consumerGroupOffsetOfTopicPath(group, topic) + "/" + partition
 }
 
+func (this *ZkCluster) consumerGroupOwnersPath(group string) string {
+	return this.ConsumerGroupRoot(group) + "/owners"
+}
+
 func (this *ZkCluster) consumerGroupOwnerOfTopicPath(group, topic string) string {
 	return this.ConsumerGroupRoot(group) + "/owners/" + topic
 }
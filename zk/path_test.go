@@ -38,6 +38,12 @@ func TestZkClusterPath(t *testing.T) {
 		c.consumerGroupOffsetOfTopicPartitionPath("console-group", "t1", "0"))
 	assert.Equal(t, "/test/consumers/console-group/owners/t1",
 		c.consumerGroupOwnerOfTopicPath("console-group", "t1"))
+	assert.Equal(t, "/test/consumers/console-group/owners",
+		c.consumerGroupOwnersPath("console-group"))
+	assert.Equal(t, "/_kafka_clusters_offset_storage/cluster-test",
+		c.offsetStoragePath(""))
+	assert.Equal(t, "/_kafka_clusters_offset_storage/cluster-test/console-group",
+		c.offsetStoragePath("console-group"))
 
 }
 
@@ -249,7 +249,7 @@ func (this *ZkCluster) OwnersOfGroupByTopic(group, topic string) map[string]stri
 	return r
 }
 
-// Returns {topic: {partitionId: offset}}
+// Returns {topic: {partitionId: offset}} read from the offset storage of the group.
 func (this *ZkCluster) ConsumerOffsetsOfGroup(group string) map[string]map[string]int64 {
 	r := make(map[string]map[string]int64)
 	topics := this.zone.children(this.ConsumerGroupOffsetPath(group))
@@ -265,6 +265,31 @@ func (this *ZkCluster) ConsumerOffsetsOfGroup(group string) map[string]map[strin
 		}
 	}
 
+	if storage, _ := this.OffsetStorageOfGroup(group); storage != OffsetStorageKafka {
+		return r
+	}
+
+	// the group might have never committed some topics to zk
+	for _, topic := range this.zone.children(this.consumerGroupOwnersPath(group)) {
+		if _, present := r[topic]; !present {
+			r[topic] = make(map[string]int64)
+		}
+	}
+
+	store := this.NewOffsetStore()
+	defer store.Close()
+
+	for topic := range r {
+		for _, partitionId := range this.Partitions(topic) {
+			consumerOffset, err := store.FetchOffset(group, topic, partitionId)
+			if err != nil {
+				log.Error("kafka[%s] %s P:%d %v", this.name, topic, partitionId, err)
+			} else if consumerOffset != -1 {
+				r[topic][strconv.Itoa(int(partitionId))] = consumerOffset
+			}
+		}
+	}
+
 	return r
 }
 
@@ -344,13 +369,22 @@ func (this *ZkCluster) ConsumersByGroup(groupPattern string) map[string][]Consum
 	}
 	defer kfk.Close()
 
+	store := this.NewOffsetStore()
+	defer store.Close()
+
 	consumerGroups := this.ConsumerGroups()
 	for group, consumers := range consumerGroups {
 		if groupPattern != "" && !strings.Contains(group, groupPattern) {
 			continue
 		}
 
+		storage, _ := this.OffsetStorageOfGroup(group)
 		topics := this.zone.children(this.ConsumerGroupOffsetPath(group))
+		if storage == OffsetStorageKafka {
+			// the group might have never committed some topics to zk
+			topics = this.zone.children(this.consumerGroupOwnersPath(group))
+		}
+
 		for _, topic := range topics {
 			consumerInstances := this.OwnersOfGroupByTopic(group, topic)
 			if len(consumerInstances) == 0 {
@@ -358,22 +392,37 @@ func (this *ZkCluster) ConsumersByGroup(groupPattern string) map[string][]Consum
 				continue
 			}
 
+			offsets := this.zone.ChildrenWithData(this.consumerGroupOffsetOfTopicPath(group, topic))
+
 		topicLoop:
-			for partitionId, offsetData := range this.zone.ChildrenWithData(this.consumerGroupOffsetOfTopicPath(group, topic)) {
-				if _, present := consumerInstances[partitionId]; !present {
-					// found no consumer instance on this partition
-					continue
+			for partitionId := range consumerInstances {
+				pid, err := strconv.Atoi(partitionId)
+				if err != nil {
+					panic(err)
 				}
 
-				consumerOffset, err := strconv.ParseInt(string(offsetData.data), 10, 64)
-				if err != nil {
-					log.Error("kafka[%s] %s P:%s %v", this.name, topic, partitionId, err)
-					continue topicLoop
+				offsetData, present := offsets[partitionId]
+				consumerOffset := int64(-1)
+				if present {
+					consumerOffset, err = strconv.ParseInt(string(offsetData.data), 10, 64)
+					if err != nil {
+						log.Error("kafka[%s] %s P:%s %v", this.name, topic, partitionId, err)
+						continue topicLoop
+					}
 				}
 
-				pid, err := strconv.Atoi(partitionId)
-				if err != nil {
-					panic(err)
+				if storage == OffsetStorageKafka {
+					// kafka offset has no mtime, zk mtime is kept as hint
+					consumerOffset, err = store.FetchOffset(group, topic, int32(pid))
+					if err != nil {
+						log.Error("kafka[%s] %s P:%s %v", this.name, topic, partitionId, err)
+						continue topicLoop
+					}
+				}
+
+				if consumerOffset == -1 {
+					// never committed
+					continue
 				}
 
 				producerOffset, err := kfk.GetOffset(topic, int32(pid), sarama.OffsetNewest)
@@ -622,6 +671,83 @@ func (this *ZkCluster) ResetConsumerGroupOffset(topic, group, partition string,
 	return this.zone.setZnode(path, []byte(data))
 }
 
+// ConsumerOffsetOfPartition returns -1 if the group never committed offset
+// of the partition.
+func (this *ZkCluster) ConsumerOffsetOfPartition(group, topic string, partitionId int32) (int64, error) {
+	this.zone.connectIfNeccessary()
+
+	path := this.consumerGroupOffsetOfTopicPartitionPath(group, topic, strconv.Itoa(int(partitionId)))
+	data, _, err := this.zone.conn.Get(path)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return -1, nil
+		}
+		return -1, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// CommitConsumerOffset creates the offset znode on demand.
+func (this *ZkCluster) CommitConsumerOffset(group, topic string, partitionId int32, offset int64) error {
+	path := this.consumerGroupOffsetOfTopicPartitionPath(group, topic, strconv.Itoa(int(partitionId)))
+	data := []byte(strconv.FormatInt(offset, 10))
+	err := this.zone.setZnode(path, data)
+	if err == zk.ErrNoNode {
+		this.zone.ensureParentDirExists(path)
+		err = this.zone.createZnode(path, data)
+	}
+
+	return err
+}
+
+// OffsetStorageOfGroup returns where the group commits offsets to, falls back
+// to the cluster default and then zk.
+func (this *ZkCluster) OffsetStorageOfGroup(group string) (string, error) {
+	this.zone.connectIfNeccessary()
+
+	paths := []string{this.offsetStoragePath("")}
+	if group != "" {
+		paths = []string{this.offsetStoragePath(group), this.offsetStoragePath("")}
+	}
+	for _, path := range paths {
+		data, _, err := this.zone.conn.Get(path)
+		if err != nil {
+			if err == zk.ErrNoNode {
+				continue
+			}
+			return "", err
+		}
+
+		storage := strings.TrimSpace(string(data))
+		if !ValidOffsetStorage(storage) {
+			return "", ErrInvalidOffsetStorage
+		}
+		return storage, nil
+	}
+
+	return OffsetStorageZk, nil
+}
+
+// SetOffsetStorageOfGroup switches the offset storage of the group, empty
+// group for the cluster default.
+func (this *ZkCluster) SetOffsetStorageOfGroup(group, storage string) error {
+	if !ValidOffsetStorage(storage) {
+		return ErrInvalidOffsetStorage
+	}
+
+	this.zone.connectIfNeccessary()
+
+	path := this.offsetStoragePath(group)
+	err := this.zone.setZnode(path, []byte(storage))
+	if err == zk.ErrNoNode {
+		this.zone.ensureParentDirExists(path)
+		err = this.zone.createZnode(path, []byte(storage))
+	}
+
+	return err
+}
+
 func (this *ZkCluster) ListChildren(recursive bool) ([]string, error) {
 	excludedPaths := map[string]struct{}{
 		"/zookeeper": struct{}{},
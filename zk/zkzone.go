@@ -193,6 +193,175 @@ func (this *ZkZone) KatewayInfos() ([]*KatewayMeta, error) {
 	return r, nil
 }
 
+// RegisterAgent registers the gk agent of a host as ephemeral znode.
+func (this *ZkZone) RegisterAgent(meta AgentMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return this.CreateEphemeralZnode(fmt.Sprintf("%s/%s", GkAgentsRoot, meta.Host), data)
+}
+
+// AgentInfos returns the online gk agents keyed by host.
+func (this *ZkZone) AgentInfos() (map[string]*AgentMeta, error) {
+	r := make(map[string]*AgentMeta)
+	for host, data := range this.ChildrenWithData(GkAgentsRoot) {
+		var a AgentMeta
+		if err := json.Unmarshal(data.data, &a); err != nil {
+			return nil, err
+		}
+
+		a.Ctime = data.Ctime()
+		r[host] = &a
+	}
+
+	return r, nil
+}
+
+// SaveCanalCheckpoint persists the binlog position of a canal binlog syncer.
+func (this *ZkZone) SaveCanalCheckpoint(name string, cp CanalCheckpoint) error {
+	this.connectIfNeccessary()
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("%s/%s", CanalCheckpointsRoot, name)
+	this.ensureParentDirExists(path)
+
+	err = this.createZnode(path, data)
+	if err == zk.ErrNodeExists {
+		return this.setZnode(path, data)
+	}
+
+	return err
+}
+
+// LoadCanalCheckpoint returns nil checkpoint if the syncer never saved one.
+func (this *ZkZone) LoadCanalCheckpoint(name string) (*CanalCheckpoint, error) {
+	this.connectIfNeccessary()
+
+	data, stat, err := this.conn.Get(fmt.Sprintf("%s/%s", CanalCheckpointsRoot, name))
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var cp CanalCheckpoint
+	if err = json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+
+	cp.Mtime = ZkTimestamp(stat.Mtime).Time()
+	return &cp, nil
+}
+
+func mirrorCheckpointPath(route, topic string, partitionId int32) string {
+	return fmt.Sprintf("%s/%s/%s/%d", GkMirrorCheckpointsRoot, route, topic, partitionId)
+}
+
+// SaveMirrorCheckpoint persists the offset mapping of a source partition of a mirror route.
+func (this *ZkZone) SaveMirrorCheckpoint(route, topic string, partitionId int32, cp MirrorCheckpoint) error {
+	this.connectIfNeccessary()
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	path := mirrorCheckpointPath(route, topic, partitionId)
+	err = this.setZnode(path, data)
+	if err == zk.ErrNoNode {
+		this.ensureParentDirExists(path)
+		err = this.createZnode(path, data)
+	}
+
+	return err
+}
+
+// LoadMirrorCheckpoint returns nil checkpoint if the partition was never mirrored.
+func (this *ZkZone) LoadMirrorCheckpoint(route, topic string, partitionId int32) (*MirrorCheckpoint, error) {
+	this.connectIfNeccessary()
+
+	data, stat, err := this.conn.Get(mirrorCheckpointPath(route, topic, partitionId))
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var cp MirrorCheckpoint
+	if err = json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+
+	cp.Mtime = ZkTimestamp(stat.Mtime).Time()
+	return &cp, nil
+}
+
+// TopicSchemas returns the schema versions of a subject and the znode version
+// for optimistic update, nil if the subject has no schemas yet.
+func (this *ZkZone) TopicSchemas(subject string) (*TopicSchemas, int32, error) {
+	this.connectIfNeccessary()
+
+	data, stat, err := this.conn.Get(fmt.Sprintf("%s/%s", KatewaySchemasRoot, subject))
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return nil, -1, nil
+		}
+		return nil, -1, err
+	}
+
+	var s TopicSchemas
+	if err = json.Unmarshal(data, &s); err != nil {
+		return nil, -1, err
+	}
+
+	s.Mtime = ZkTimestamp(stat.Mtime).Time()
+	return &s, stat.Version, nil
+}
+
+// SaveTopicSchemas creates the subject if version is -1, otherwise updates it
+// only if nobody else changed it since the version.
+func (this *ZkZone) SaveTopicSchemas(subject string, s TopicSchemas, version int32) error {
+	this.connectIfNeccessary()
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("%s/%s", KatewaySchemasRoot, subject)
+	if version == -1 {
+		this.ensureParentDirExists(path)
+		return this.createZnode(path, data)
+	}
+
+	_, err = this.conn.Set(path, data, version)
+	return err
+}
+
+// AllTopicSchemas returns {subject: schemas}.
+func (this *ZkZone) AllTopicSchemas() map[string]*TopicSchemas {
+	r := make(map[string]*TopicSchemas)
+	for subject, data := range this.ChildrenWithData(KatewaySchemasRoot) {
+		var s TopicSchemas
+		if err := json.Unmarshal(data.data, &s); err != nil {
+			log.Error("schema[%s]: %v", subject, err)
+			continue
+		}
+
+		s.Mtime = data.Mtime()
+		r[subject] = &s
+	}
+	return r
+}
+
 func (this *ZkZone) KatewayInfoById(id string) *KatewayMeta {
 	kateways, _ := this.KatewayInfos()
 	for _, kw := range kateways {